@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// LogPut returns a commit that logs a put to the logger before the store applies it. A put with flags is logged as a
+// batch of one, as only a batch's events carry them.
+func LogPut(logger TransactionLogger) model.Commit {
+	return func(kvs []*model.KeyValue) error {
+		kv := kvs[0]
+		if kv.Flags != 0 {
+			return unavailable(logger.WriteBatch(batchEvents(kvs)))
+		}
+		value, _ := kv.Value.(string)
+		return unavailable(logger.WritePut(kv.Key, value, kv.Version, kv.Expires))
+	}
+}
+
+// LogDelete returns a commit that logs a delete to the logger before the store applies it.
+func LogDelete(logger TransactionLogger) model.Commit {
+	return func(kvs []*model.KeyValue) error {
+		return unavailable(logger.WriteDelete(kvs[0].Key))
+	}
+}
+
+// LogBatch returns a commit that logs a batch to the logger as one record before the store applies it.
+func LogBatch(logger TransactionLogger) model.Commit {
+	return func(kvs []*model.KeyValue) error {
+		return unavailable(logger.WriteBatch(batchEvents(kvs)))
+	}
+}
+
+// batchEvents returns the events that log the key values written by a batch, a key without a version was deleted.
+func batchEvents(kvs []*model.KeyValue) []store.Event {
+	events := make([]store.Event, len(kvs))
+	for i, kv := range kvs {
+		if kv.Version == 0 {
+			events[i] = store.Event{EventType: store.EventDelete, Key: kv.Key}
+			continue
+		}
+
+		value, _ := kv.Value.(string)
+		events[i] = store.Event{EventType: store.EventPut, Key: kv.Key, Value: value, Version: kv.Version, Expires: kv.Expires, Flags: kv.Flags}
+	}
+
+	return events
+}
+
+// unavailable marks a failure to log a write as the store being unavailable, unless the logger refused the write
+// itself, e.g. as too large to log.
+func unavailable(err error) error {
+	if err == nil || errors.Is(err, model.ErrInvalidArgument) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", model.ErrUnavailable, err)
+}
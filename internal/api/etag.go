@@ -0,0 +1,83 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
+)
+
+// formatETag returns the strong ETag for a key version.
+func formatETag(version uint64) string {
+	return fmt.Sprintf("%q", strconv.FormatUint(version, 10))
+}
+
+// parsePrecondition builds a precondition from the If-Match and If-None-Match request headers.
+func parsePrecondition(r *http.Request) (model.Precondition, error) {
+	return parseConditions(r.Header.Get("If-Match"), r.Header.Get("If-None-Match"))
+}
+
+// parseConditions builds a precondition from If-Match and If-None-Match values, either may be empty.
+func parseConditions(ifMatch, ifNoneMatch string) (model.Precondition, error) {
+	var (
+		pre model.Precondition
+		err error
+	)
+
+	if ifMatch != "" {
+		pre.IfMatchAny, pre.IfMatch, err = parseETags(ifMatch, false)
+		if err != nil {
+			return pre, fmt.Errorf("%w: If-Match: %v", model.ErrInvalidArgument, err)
+		}
+	}
+
+	if ifNoneMatch != "" {
+		pre.IfNoneMatchAny, pre.IfNoneMatch, err = parseETags(ifNoneMatch, true)
+		if err != nil {
+			return pre, fmt.Errorf("%w: If-None-Match: %v", model.ErrInvalidArgument, err)
+		}
+	}
+
+	return pre, nil
+}
+
+// parseETags parses a comma separated list of entity tags, or "*".
+// If-Match uses strong comparison so weak tags are only accepted when weak is true.
+func parseETags(header string, weak bool) (bool, []uint64, error) {
+	if strings.TrimSpace(header) == "*" {
+		return true, nil, nil
+	}
+
+	var versions []uint64
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				// a weak tag can never strongly match, so it is simply ignored
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		unquoted, err := strconv.Unquote(tag)
+		if err != nil {
+			return false, nil, fmt.Errorf("malformed entity tag %s", tag)
+		}
+
+		version, err := strconv.ParseUint(unquoted, 10, 64)
+		if err != nil {
+			// not one of ours, so it can't match any version
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	if len(versions) == 0 {
+		// none of the tags can match, version zero is never handed out
+		versions = append(versions, 0)
+	}
+
+	return false, versions, nil
+}
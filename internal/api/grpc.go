@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/api/pb"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer serves the key value API over gRPC from the same store and logger as RESTServer.
+type GRPCServer struct {
+	pb.UnimplementedKeyValueServiceServer
+
+	store  Store
+	logger TransactionLogger
+}
+
+func NewGRPCServer(store Store, logger TransactionLogger) *GRPCServer {
+	return &GRPCServer{
+		store:  store,
+		logger: logger,
+	}
+}
+
+// Get returns the key value, or NOT_FOUND if the key doesn't exist or has expired.
+func (s *GRPCServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	kv, err := s.store.GetKeyValue(req.GetKey())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	out, err := toProto(kv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.GetResponse{KeyValue: out}, nil
+}
+
+// Put saves the value if the precondition holds, expiring it after the optional TTL.
+func (s *GRPCServer) Put(ctx context.Context, req *pb.PutRequest) (*pb.PutResponse, error) {
+	if req.GetKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, "key must be set")
+	}
+
+	var expires time.Time
+	if req.Ttl != nil {
+		if err := req.Ttl.CheckValid(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid ttl: %v", err)
+		}
+
+		ttl := req.Ttl.AsDuration()
+		if ttl <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "ttl must be positive")
+		}
+		expires = time.Now().Add(ttl).UTC()
+	}
+
+	kv, err := s.store.PutIf(req.GetKey(), string(req.GetValue()), expires, fromProto(req.GetPrecondition()), LogPut(s.logger))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	out, err := toProto(kv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.PutResponse{KeyValue: out}, nil
+}
+
+// Delete removes the key if the precondition holds.
+func (s *GRPCServer) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	if req.GetKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, "key must be set")
+	}
+
+	if err := s.store.DeleteIf(req.GetKey(), fromProto(req.GetPrecondition()), LogDelete(s.logger)); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &pb.DeleteResponse{}, nil
+}
+
+// GetStream answers each get in order, ending the stream at the first failure.
+func (s *GRPCServer) GetStream(stream pb.KeyValueService_GetStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.Get(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// PutStream answers each put in order, ending the stream at the first failure.
+func (s *GRPCServer) PutStream(stream pb.KeyValueService_PutStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.Put(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// DeleteStream answers each delete in order, ending the stream at the first failure.
+func (s *GRPCServer) DeleteStream(stream pb.KeyValueService_DeleteStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.Delete(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// RequireReadyUnary rejects calls with UNAVAILABLE while ready returns an error, e.g. during log replay.
+func RequireReadyUnary(ready func(context.Context) error) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := ready(ctx); err != nil {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+// RequireReadyStream rejects streams with UNAVAILABLE while ready returns an error.
+func RequireReadyStream(ready func(context.Context) error) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := ready(stream.Context()); err != nil {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		return handler(srv, stream)
+	}
+}
+
+// grpcError maps a store error onto the gRPC status returned to the client.
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, model.ErrKeyNotFound):
+		code = codes.NotFound
+	case errors.Is(err, model.ErrPreconditionFailed):
+		code = codes.FailedPrecondition
+	case errors.Is(err, model.ErrInvalidArgument):
+		code = codes.InvalidArgument
+	case errors.Is(err, model.ErrUnavailable):
+		code = codes.Unavailable
+	case errors.Is(err, model.ErrReadOnly):
+		code = codes.PermissionDenied
+	}
+
+	return status.Error(code, err.Error())
+}
+
+// toProto converts the key value, returning INTERNAL if its value isn't a string as the APIs only ever store strings.
+func toProto(kv *model.KeyValue) (*pb.KeyValue, error) {
+	value, ok := kv.Value.(string)
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "key %s has a non string value %T", kv.Key, kv.Value)
+	}
+
+	out := &pb.KeyValue{
+		Key:     kv.Key,
+		Value:   []byte(value),
+		Version: kv.Version,
+	}
+	if !kv.Expires.IsZero() {
+		out.Expires = timestamppb.New(kv.Expires)
+	}
+
+	return out, nil
+}
+
+func fromProto(pre *pb.Precondition) model.Precondition {
+	return model.Precondition{
+		IfMatch:        pre.GetIfMatch(),
+		IfMatchAny:     pre.GetIfMatchAny(),
+		IfNoneMatch:    pre.GetIfNoneMatch(),
+		IfNoneMatchAny: pre.GetIfNoneMatchAny(),
+	}
+}
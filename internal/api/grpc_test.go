@@ -0,0 +1,196 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/api/pb"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func newGRPCClient(t *testing.T, s *store.Store, logger api.TransactionLogger, ready func(context.Context) error) pb.KeyValueServiceClient {
+	listener := bufconn.Listen(1 << 20)
+
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(api.RequireReadyUnary(ready)),
+		grpc.ChainStreamInterceptor(api.RequireReadyStream(ready)),
+	)
+	pb.RegisterKeyValueServiceServer(server, api.NewGRPCServer(s, logger))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewKeyValueServiceClient(conn)
+}
+
+func ready(context.Context) error { return nil }
+
+func TestGRPCServer_Put(t *testing.T) {
+	tests := map[string]struct {
+		req          *pb.PutRequest
+		loggerErr    error
+		expectedCode codes.Code
+		expectedKV   *pb.KeyValue
+	}{
+		"new key": {
+			req:        &pb.PutRequest{Key: "key2", Value: []byte("value2")},
+			expectedKV: &pb.KeyValue{Key: "key2", Value: []byte("value2"), Version: 2},
+		},
+		"if match": {
+			req:        &pb.PutRequest{Key: "key1", Value: []byte("value2"), Precondition: &pb.Precondition{IfMatch: []uint64{1}}},
+			expectedKV: &pb.KeyValue{Key: "key1", Value: []byte("value2"), Version: 2},
+		},
+		"if match failed": {
+			req:          &pb.PutRequest{Key: "key1", Value: []byte("value2"), Precondition: &pb.Precondition{IfMatch: []uint64{7}}},
+			expectedCode: codes.FailedPrecondition,
+		},
+		"if none match any on existing key": {
+			req:          &pb.PutRequest{Key: "key1", Value: []byte("value2"), Precondition: &pb.Precondition{IfNoneMatchAny: true}},
+			expectedCode: codes.FailedPrecondition,
+		},
+		"binary value": {
+			req:        &pb.PutRequest{Key: "key2", Value: []byte{0xff, 0x00, 0xfe}},
+			expectedKV: &pb.KeyValue{Key: "key2", Value: []byte{0xff, 0x00, 0xfe}, Version: 2},
+		},
+		"empty key": {
+			req:          &pb.PutRequest{Value: []byte("value2")},
+			expectedCode: codes.InvalidArgument,
+		},
+		"negative ttl": {
+			req:          &pb.PutRequest{Key: "key1", Value: []byte("value2"), Ttl: durationpb.New(-time.Second)},
+			expectedCode: codes.InvalidArgument,
+		},
+		"logger failed": {
+			req:          &pb.PutRequest{Key: "key2", Value: []byte("value2")},
+			loggerErr:    errors.New("disk full"),
+			expectedCode: codes.Unavailable,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := store.New(make(map[string]interface{}))
+			require.NoError(t, s.Put("key1", "value1"))
+
+			client := newGRPCClient(t, s, &testutil.Logger{WriteErr: test.loggerErr}, ready)
+
+			resp, err := client.Put(context.Background(), test.req)
+			if test.expectedCode != codes.OK {
+				require.Error(t, err)
+				assert.Equal(t, test.expectedCode, status.Code(err))
+
+				// a failed put leaves the store as it was
+				kvs, _ := s.List(model.ListOptions{})
+				require.Len(t, kvs, 1)
+				assert.Equal(t, "value1", kvs[0].Value)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedKV.Key, resp.KeyValue.Key)
+			assert.Equal(t, test.expectedKV.Value, resp.KeyValue.Value)
+			assert.Equal(t, test.expectedKV.Version, resp.KeyValue.Version)
+		})
+	}
+}
+
+func TestGRPCServer_GetDelete(t *testing.T) {
+	s := store.New(make(map[string]interface{}))
+	logger := &testutil.Logger{}
+	client := newGRPCClient(t, s, logger, ready)
+	ctx := context.Background()
+
+	_, err := client.Get(ctx, &pb.GetRequest{Key: "key1"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Put(ctx, &pb.PutRequest{Key: "key1", Value: []byte("value1"), Ttl: durationpb.New(time.Hour)})
+	require.NoError(t, err)
+
+	resp, err := client.Get(ctx, &pb.GetRequest{Key: "key1"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value1"), resp.KeyValue.Value)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), resp.KeyValue.Expires.AsTime(), time.Minute)
+
+	_, err = client.Delete(ctx, &pb.DeleteRequest{Key: "key1", Precondition: &pb.Precondition{IfMatch: []uint64{9}}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = client.Delete(ctx, &pb.DeleteRequest{Key: "key1"})
+	require.NoError(t, err)
+
+	_, err = client.Get(ctx, &pb.GetRequest{Key: "key1"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, []string{"key1", "key1"}, logger.Keys())
+
+	_, err = client.Delete(ctx, &pb.DeleteRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// a value only put into the store directly can't be returned as bytes
+	require.NoError(t, s.Put("number", 42))
+	_, err = client.Get(ctx, &pb.GetRequest{Key: "number"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestGRPCServer_Streams(t *testing.T) {
+	s := store.New(make(map[string]interface{}))
+	client := newGRPCClient(t, s, &testutil.Logger{}, ready)
+	ctx := context.Background()
+
+	puts, err := client.PutStream(ctx)
+	require.NoError(t, err)
+	for _, key := range []string{"key1", "key2"} {
+		require.NoError(t, puts.Send(&pb.PutRequest{Key: key, Value: []byte("value")}))
+		resp, err := puts.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, key, resp.KeyValue.Key)
+	}
+	require.NoError(t, puts.CloseSend())
+	_, err = puts.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	gets, err := client.GetStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, gets.Send(&pb.GetRequest{Key: "key2"}))
+	resp, err := gets.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "key2", resp.KeyValue.Key)
+
+	// the first failure ends the stream
+	require.NoError(t, gets.Send(&pb.GetRequest{Key: "missing"}))
+	_, err = gets.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCServer_NotReady(t *testing.T) {
+	notReady := func(context.Context) error { return errors.New("replaying the transaction log") }
+	client := newGRPCClient(t, store.New(make(map[string]interface{})), &testutil.Logger{}, notReady)
+
+	_, err := client.Get(context.Background(), &pb.GetRequest{Key: "key1"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	stream, err := client.GetStream(context.Background())
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
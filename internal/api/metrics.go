@@ -0,0 +1,62 @@
+package api
+
+import (
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/metrics"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// instrumentedLogger times every write to the wrapped transaction logger and counts the failures.
+type instrumentedLogger struct {
+	TransactionLogger
+	latency *metrics.Histogram
+	errors  *metrics.Counter
+}
+
+// InstrumentLogger registers the logger's queue depth, write latency and write errors, labelled by event type.
+func InstrumentLogger(logger TransactionLogger, registry *metrics.Registry) TransactionLogger {
+	registry.NewGaugeFunc("kvs_transaction_log_queue_depth",
+		"Writes waiting to be committed to the transaction log.",
+		func() float64 { return float64(logger.QueueDepth()) })
+
+	return &instrumentedLogger{
+		TransactionLogger: logger,
+		latency: registry.NewHistogram("kvs_transaction_log_write_duration_seconds",
+			"Time taken for a write to be committed to the transaction log.", nil, "event"),
+		errors: registry.NewCounter("kvs_transaction_log_write_errors_total",
+			"Writes that failed to be committed to the transaction log.", "event"),
+	}
+}
+
+func (l *instrumentedLogger) WritePut(key string, value string, version uint64, expires time.Time) error {
+	start := time.Now()
+	err := l.TransactionLogger.WritePut(key, value, version, expires)
+	return l.observe("put", start, err)
+}
+
+func (l *instrumentedLogger) WriteDelete(key string) error {
+	start := time.Now()
+	err := l.TransactionLogger.WriteDelete(key)
+	return l.observe("delete", start, err)
+}
+
+func (l *instrumentedLogger) WriteExpire(key string, deadline time.Time) error {
+	start := time.Now()
+	err := l.TransactionLogger.WriteExpire(key, deadline)
+	return l.observe("expire", start, err)
+}
+
+func (l *instrumentedLogger) WriteBatch(ops []store.Event) error {
+	start := time.Now()
+	err := l.TransactionLogger.WriteBatch(ops)
+	return l.observe("batch", start, err)
+}
+
+func (l *instrumentedLogger) observe(event string, start time.Time, err error) error {
+	l.latency.Observe(time.Since(start).Seconds(), event)
+	if err != nil {
+		l.errors.Inc(event)
+	}
+	return err
+}
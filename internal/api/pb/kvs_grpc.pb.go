@@ -0,0 +1,386 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: kvs.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// KeyValueServiceClient is the client API for KeyValueService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type KeyValueServiceClient interface {
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// The streaming variants answer each request in order, ending the stream with
+	// the status of the first request that fails.
+	GetStream(ctx context.Context, opts ...grpc.CallOption) (KeyValueService_GetStreamClient, error)
+	PutStream(ctx context.Context, opts ...grpc.CallOption) (KeyValueService_PutStreamClient, error)
+	DeleteStream(ctx context.Context, opts ...grpc.CallOption) (KeyValueService_DeleteStreamClient, error)
+}
+
+type keyValueServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKeyValueServiceClient(cc grpc.ClientConnInterface) KeyValueServiceClient {
+	return &keyValueServiceClient{cc}
+}
+
+func (c *keyValueServiceClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, "/kvs.v1.KeyValueService/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueServiceClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error) {
+	out := new(PutResponse)
+	err := c.cc.Invoke(ctx, "/kvs.v1.KeyValueService/Put", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueServiceClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/kvs.v1.KeyValueService/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueServiceClient) GetStream(ctx context.Context, opts ...grpc.CallOption) (KeyValueService_GetStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &KeyValueService_ServiceDesc.Streams[0], "/kvs.v1.KeyValueService/GetStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &keyValueServiceGetStreamClient{stream}
+	return x, nil
+}
+
+type KeyValueService_GetStreamClient interface {
+	Send(*GetRequest) error
+	Recv() (*GetResponse, error)
+	grpc.ClientStream
+}
+
+type keyValueServiceGetStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *keyValueServiceGetStreamClient) Send(m *GetRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *keyValueServiceGetStreamClient) Recv() (*GetResponse, error) {
+	m := new(GetResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *keyValueServiceClient) PutStream(ctx context.Context, opts ...grpc.CallOption) (KeyValueService_PutStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &KeyValueService_ServiceDesc.Streams[1], "/kvs.v1.KeyValueService/PutStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &keyValueServicePutStreamClient{stream}
+	return x, nil
+}
+
+type KeyValueService_PutStreamClient interface {
+	Send(*PutRequest) error
+	Recv() (*PutResponse, error)
+	grpc.ClientStream
+}
+
+type keyValueServicePutStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *keyValueServicePutStreamClient) Send(m *PutRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *keyValueServicePutStreamClient) Recv() (*PutResponse, error) {
+	m := new(PutResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *keyValueServiceClient) DeleteStream(ctx context.Context, opts ...grpc.CallOption) (KeyValueService_DeleteStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &KeyValueService_ServiceDesc.Streams[2], "/kvs.v1.KeyValueService/DeleteStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &keyValueServiceDeleteStreamClient{stream}
+	return x, nil
+}
+
+type KeyValueService_DeleteStreamClient interface {
+	Send(*DeleteRequest) error
+	Recv() (*DeleteResponse, error)
+	grpc.ClientStream
+}
+
+type keyValueServiceDeleteStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *keyValueServiceDeleteStreamClient) Send(m *DeleteRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *keyValueServiceDeleteStreamClient) Recv() (*DeleteResponse, error) {
+	m := new(DeleteResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// KeyValueServiceServer is the server API for KeyValueService service.
+// All implementations must embed UnimplementedKeyValueServiceServer
+// for forward compatibility
+type KeyValueServiceServer interface {
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// The streaming variants answer each request in order, ending the stream with
+	// the status of the first request that fails.
+	GetStream(KeyValueService_GetStreamServer) error
+	PutStream(KeyValueService_PutStreamServer) error
+	DeleteStream(KeyValueService_DeleteStreamServer) error
+	mustEmbedUnimplementedKeyValueServiceServer()
+}
+
+// UnimplementedKeyValueServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedKeyValueServiceServer struct {
+}
+
+func (UnimplementedKeyValueServiceServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedKeyValueServiceServer) Put(context.Context, *PutRequest) (*PutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedKeyValueServiceServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedKeyValueServiceServer) GetStream(KeyValueService_GetStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetStream not implemented")
+}
+func (UnimplementedKeyValueServiceServer) PutStream(KeyValueService_PutStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method PutStream not implemented")
+}
+func (UnimplementedKeyValueServiceServer) DeleteStream(KeyValueService_DeleteStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method DeleteStream not implemented")
+}
+func (UnimplementedKeyValueServiceServer) mustEmbedUnimplementedKeyValueServiceServer() {}
+
+// UnsafeKeyValueServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to KeyValueServiceServer will
+// result in compilation errors.
+type UnsafeKeyValueServiceServer interface {
+	mustEmbedUnimplementedKeyValueServiceServer()
+}
+
+func RegisterKeyValueServiceServer(s grpc.ServiceRegistrar, srv KeyValueServiceServer) {
+	s.RegisterService(&KeyValueService_ServiceDesc, srv)
+}
+
+func _KeyValueService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kvs.v1.KeyValueService/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueServiceServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueService_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueServiceServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kvs.v1.KeyValueService/Put",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueServiceServer).Put(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueServiceServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kvs.v1.KeyValueService/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueServiceServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueService_GetStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KeyValueServiceServer).GetStream(&keyValueServiceGetStreamServer{stream})
+}
+
+type KeyValueService_GetStreamServer interface {
+	Send(*GetResponse) error
+	Recv() (*GetRequest, error)
+	grpc.ServerStream
+}
+
+type keyValueServiceGetStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *keyValueServiceGetStreamServer) Send(m *GetResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *keyValueServiceGetStreamServer) Recv() (*GetRequest, error) {
+	m := new(GetRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _KeyValueService_PutStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KeyValueServiceServer).PutStream(&keyValueServicePutStreamServer{stream})
+}
+
+type KeyValueService_PutStreamServer interface {
+	Send(*PutResponse) error
+	Recv() (*PutRequest, error)
+	grpc.ServerStream
+}
+
+type keyValueServicePutStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *keyValueServicePutStreamServer) Send(m *PutResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *keyValueServicePutStreamServer) Recv() (*PutRequest, error) {
+	m := new(PutRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _KeyValueService_DeleteStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KeyValueServiceServer).DeleteStream(&keyValueServiceDeleteStreamServer{stream})
+}
+
+type KeyValueService_DeleteStreamServer interface {
+	Send(*DeleteResponse) error
+	Recv() (*DeleteRequest, error)
+	grpc.ServerStream
+}
+
+type keyValueServiceDeleteStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *keyValueServiceDeleteStreamServer) Send(m *DeleteResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *keyValueServiceDeleteStreamServer) Recv() (*DeleteRequest, error) {
+	m := new(DeleteRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// KeyValueService_ServiceDesc is the grpc.ServiceDesc for KeyValueService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var KeyValueService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kvs.v1.KeyValueService",
+	HandlerType: (*KeyValueServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _KeyValueService_Get_Handler,
+		},
+		{
+			MethodName: "Put",
+			Handler:    _KeyValueService_Put_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _KeyValueService_Delete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetStream",
+			Handler:       _KeyValueService_GetStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "PutStream",
+			Handler:       _KeyValueService_PutStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "DeleteStream",
+			Handler:       _KeyValueService_DeleteStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "kvs.proto",
+}
@@ -1,28 +1,61 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/warrenb95/cloud-native-go/internal/model"
 	"github.com/warrenb95/cloud-native-go/internal/store"
 )
 
+const (
+	// defaultListLimit is the page size when a listing doesn't set a limit.
+	defaultListLimit = 100
+	// maxListLimit caps the page size of a listing.
+	maxListLimit = 1000
+	// maxBatchOps caps the number of operations in a batch.
+	maxBatchOps = 1000
+)
+
+// TTLHeader is the request header used to set a time to live on a PUT, the "ttl" query parameter may be used instead.
+const TTLHeader = "X-TTL"
+
 type Store interface {
-	Put(key string, value interface{}) error
-	Get(key string) (interface{}, error)
-	Delete(key string) error
+	PutIf(key string, value interface{}, expires time.Time, pre model.Precondition, commit model.Commit) (*model.KeyValue, error)
+	GetKeyValue(key string) (*model.KeyValue, error)
+	DeleteIf(key string, pre model.Precondition, commit model.Commit) error
+	List(opts model.ListOptions) ([]*model.KeyValue, string)
+	Batch(ops []model.BatchOp, commit model.Commit) ([]*model.KeyValue, error)
 }
 
+// TransactionLogger records every change to the store.
+// The write methods return once the event is durable, or with an error if it can't be logged.
 type TransactionLogger interface {
-	WritePut(key string, value string)
-	WriteDelete(ket string)
+	WritePut(key string, value string, version uint64, expires time.Time) error
+	WriteDelete(ket string) error
+	WriteExpire(key string, deadline time.Time) error
+	// WriteBatch logs the put and delete events as one record that is replayed all together or not at all.
+	WriteBatch(ops []store.Event) error
 	Err() <-chan error
+	// OnCommit registers fn to be called with every event, sequence included, once it is committed.
+	// It must be called before Run and fn must not block.
+	OnCommit(fn func(store.Event))
+	// LastSequence returns the sequence of the last event read back or committed.
+	LastSequence() uint64
+	// QueueDepth returns how many writes are waiting to be committed.
+	QueueDepth() int
 
 	ReadEvents() (<-chan store.Event, <-chan error)
 	Run()
+	// Close drains and flushes pending events, no more events can be written after it is called.
+	Close() error
 }
 type RESTServer struct {
 	store  Store
@@ -41,10 +74,33 @@ func (s *RESTServer) IndexHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // PutKeyValueHandler expects path "/v1/{key}" and will then save that to the store.
+// An optional TTL can be provided with the X-TTL header or ttl query parameter.
+// If-Match and If-None-Match are checked against the key's ETag before writing.
 func (s *RESTServer) PutKeyValueHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["key"]
 
+	pre, err := parsePrecondition(r)
+	if err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	ttl, err := parseTTL(r)
+	if err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).UTC()
+	}
+
 	value, err := io.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
@@ -54,16 +110,15 @@ func (s *RESTServer) PutKeyValueHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = s.store.Put(key, string(value))
+	kv, err := s.store.PutIf(key, string(value), expires, pre, LogPut(s.logger))
 	if err != nil {
 		http.Error(w,
 			err.Error(),
-			http.StatusInternalServerError)
+			statusCode(err))
 		return
 	}
 
-	s.logger.WritePut(key, string(value))
-
+	w.Header().Set("ETag", formatETag(kv.Version))
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -72,7 +127,7 @@ func (s *RESTServer) GetKeyValueHandler(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	key := vars["key"]
 
-	value, err := s.store.Get(key)
+	kv, err := s.store.GetKeyValue(key)
 	if err != nil {
 		if errors.Is(err, model.ErrKeyNotFound) {
 			http.Error(w,
@@ -86,24 +141,279 @@ func (s *RESTServer) GetKeyValueHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	valueStr, ok := value.(string)
+	w.Header().Set("ETag", formatETag(kv.Version))
+
+	valueStr, ok := kv.Value.(string)
 	if ok {
 		w.Write([]byte(valueStr))
 	}
 }
 
 // DeleteKeyValueHandler expects path "v1/{key}" and will delete the key value pair from the store.
+// If-Match and If-None-Match are checked against the key's ETag before deleting.
 func (s *RESTServer) DeleteKeyValueHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["key"]
 
-	err := s.store.Delete(key)
+	pre, err := parsePrecondition(r)
 	if err != nil {
 		http.Error(w,
 			err.Error(),
-			http.StatusInternalServerError)
+			http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.DeleteIf(key, pre, LogDelete(s.logger))
+	if err != nil {
+		http.Error(w,
+			err.Error(),
+			statusCode(err))
+		return
+	}
+}
+
+// listResponse is a page of a key listing.
+type listResponse struct {
+	Keys []listItem `json:"keys"`
+	// Next is passed as start to fetch the following page, it is empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+type listItem struct {
+	Key     string     `json:"key"`
+	ETag    string     `json:"etag"`
+	Value   *string    `json:"value,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// ListHandler expects path "/v1" and lists keys in sorted order. The prefix, start (inclusive) and end (exclusive)
+// query parameters select the keys, limit sets the page size and values=true includes each key's value.
+func (s *RESTServer) ListHandler(w http.ResponseWriter, r *http.Request) {
+	opts, withValues, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	kvs, next := s.store.List(opts)
+
+	resp := listResponse{
+		Keys: make([]listItem, 0, len(kvs)),
+		Next: next,
+	}
+	for _, kv := range kvs {
+		item := listItem{
+			Key:  kv.Key,
+			ETag: formatETag(kv.Version),
+		}
+		if withValues {
+			valueStr, _ := kv.Value.(string)
+			item.Value = &valueStr
+		}
+		if !kv.Expires.IsZero() {
+			expires := kv.Expires
+			item.Expires = &expires
+		}
+		resp.Keys = append(resp.Keys, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("failed to write listing: %v", err)
+	}
+}
+
+// batchRequest is the body of a batch, every operation is applied or none of them are.
+type batchRequest struct {
+	Ops []batchRequestOp `json:"ops"`
+}
+
+// batchRequestOp is a put or delete in a batch. IfMatch and IfNoneMatch take entity tags like the headers of the same
+// name, and TTL takes a duration or a whole number of seconds like the X-TTL header.
+type batchRequestOp struct {
+	Op          string `json:"op"`
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	TTL         string `json:"ttl"`
+	IfMatch     string `json:"if_match"`
+	IfNoneMatch string `json:"if_none_match"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// batchResult is the outcome of an operation, deleted keys have no ETag.
+type batchResult struct {
+	Key  string `json:"key"`
+	ETag string `json:"etag,omitempty"`
+}
+
+// BatchHandler expects path "/v1/_batch" and applies a JSON list of put and delete operations all together.
+// If any operation's precondition fails nothing is written and 412 is returned.
+func (s *RESTServer) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	ops, err := parseBatch(r)
+	if err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusBadRequest)
 		return
 	}
 
-	s.logger.WriteDelete(key)
+	kvs, err := s.store.Batch(ops, LogBatch(s.logger))
+	if err != nil {
+		http.Error(w,
+			err.Error(),
+			statusCode(err))
+		return
+	}
+
+	resp := batchResponse{Results: make([]batchResult, len(kvs))}
+	for i, kv := range kvs {
+		resp.Results[i].Key = kv.Key
+		if ops[i].Type != model.OpDelete {
+			resp.Results[i].ETag = formatETag(kv.Version)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("failed to write batch results: %v", err)
+	}
+}
+
+// parseBatch reads the batch operations from the request body.
+func parseBatch(r *http.Request) ([]model.BatchOp, error) {
+	defer r.Body.Close()
+
+	var req batchRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, fmt.Errorf("%w: invalid batch: %v", model.ErrInvalidArgument, err)
+	}
+
+	if len(req.Ops) == 0 || len(req.Ops) > maxBatchOps {
+		return nil, fmt.Errorf("%w: a batch must have between 1 and %d operations", model.ErrInvalidArgument, maxBatchOps)
+	}
+
+	ops := make([]model.BatchOp, len(req.Ops))
+	for i, op := range req.Ops {
+		if op.Key == "" {
+			return nil, fmt.Errorf("%w: operation %d has no key", model.ErrInvalidArgument, i)
+		}
+
+		pre, err := parseConditions(op.IfMatch, op.IfNoneMatch)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+
+		ops[i] = model.BatchOp{Key: op.Key, Precondition: pre}
+
+		switch op.Op {
+		case "put":
+			ops[i].Type = model.OpPut
+			ops[i].Value = op.Value
+
+			if op.TTL != "" {
+				ttl, err := parseDuration(op.TTL)
+				if err != nil {
+					return nil, fmt.Errorf("operation %d: %w", i, err)
+				}
+				ops[i].Expires = time.Now().Add(ttl).UTC()
+			}
+		case "delete":
+			if op.Value != "" || op.TTL != "" {
+				return nil, fmt.Errorf("%w: operation %d deletes so can't have a value or ttl", model.ErrInvalidArgument, i)
+			}
+			ops[i].Type = model.OpDelete
+		default:
+			return nil, fmt.Errorf("%w: operation %d has unknown op %q, expected \"put\" or \"delete\"", model.ErrInvalidArgument, i, op.Op)
+		}
+	}
+
+	return ops, nil
+}
+
+// parseListOptions reads the listing query parameters, also reporting whether values were asked for.
+func parseListOptions(r *http.Request) (model.ListOptions, bool, error) {
+	query := r.URL.Query()
+
+	opts := model.ListOptions{
+		Prefix: query.Get("prefix"),
+		Start:  query.Get("start"),
+		End:    query.Get("end"),
+		Limit:  defaultListLimit,
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return opts, false, fmt.Errorf("%w: limit must be between 1 and %d", model.ErrInvalidArgument, maxListLimit)
+		}
+		opts.Limit = limit
+	}
+
+	var withValues bool
+	if raw := query.Get("values"); raw != "" {
+		var err error
+		withValues, err = strconv.ParseBool(raw)
+		if err != nil {
+			return opts, false, fmt.Errorf("%w: invalid values %q", model.ErrInvalidArgument, raw)
+		}
+	}
+
+	return opts, withValues, nil
+}
+
+// parseTTL reads the TTL from the request as either a duration such as "90s" or a whole number of seconds.
+// A zero duration is returned if no TTL was provided.
+func parseTTL(r *http.Request) (time.Duration, error) {
+	raw := r.Header.Get(TTLHeader)
+	if raw == "" {
+		raw = r.URL.Query().Get("ttl")
+	}
+	if raw == "" {
+		return 0, nil
+	}
+
+	return parseDuration(raw)
+}
+
+// parseDuration parses a positive TTL given as either a duration such as "90s" or a whole number of seconds.
+func parseDuration(raw string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(raw)
+	if err != nil {
+		seconds, convErr := strconv.ParseUint(raw, 10, 32)
+		if convErr != nil {
+			return 0, fmt.Errorf("%w: invalid ttl %q", model.ErrInvalidArgument, raw)
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	if ttl <= 0 {
+		return 0, fmt.Errorf("%w: ttl must be positive", model.ErrInvalidArgument)
+	}
+
+	return ttl, nil
+}
+
+// statusCode maps a store error onto the HTTP status returned to the client.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, model.ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, model.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, model.ErrInvalidArgument):
+		return http.StatusBadRequest
+	case errors.Is(err, model.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, model.ErrReadOnly):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
 }
@@ -0,0 +1,237 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/testutil"
+)
+
+type listItem struct {
+	Key     string     `json:"key"`
+	ETag    string     `json:"etag"`
+	Value   *string    `json:"value"`
+	Expires *time.Time `json:"expires"`
+}
+
+type listResponse struct {
+	Keys []listItem `json:"keys"`
+	Next string     `json:"next"`
+}
+
+func TestRESTServer_ListHandler(t *testing.T) {
+	tests := map[string]struct {
+		query          string
+		expectedStatus int
+		expectedKeys   []string
+		expectedNext   string
+	}{
+		"everything": {
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"a", "user/1", "user/2", "user/3"},
+		},
+		"prefix page": {
+			query:          "?prefix=user/&limit=2",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"user/1", "user/2"},
+			expectedNext:   "user/3",
+		},
+		"next page": {
+			query:          "?prefix=user/&limit=2&start=user/3",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"user/3"},
+		},
+		"range": {
+			query:          "?start=b&end=user/2",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"user/1"},
+		},
+		"no keys": {
+			query:          "?prefix=order/",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{},
+		},
+		"limit too large": {
+			query:          "?limit=1001",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"invalid values": {
+			query:          "?values=maybe",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := store.New(make(map[string]interface{}))
+			for _, key := range []string{"user/3", "a", "user/2", "user/1"} {
+				require.NoError(t, s.Put(key, "value"))
+			}
+
+			rec := httptest.NewRecorder()
+			api.New(s, &testutil.Logger{}).ListHandler(rec, httptest.NewRequest(http.MethodGet, "/v1"+test.query, nil))
+
+			require.Equal(t, test.expectedStatus, rec.Code)
+			if test.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp listResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+
+			keys := []string{}
+			for _, item := range resp.Keys {
+				keys = append(keys, item.Key)
+				assert.Nil(t, item.Value)
+			}
+			assert.Equal(t, test.expectedKeys, keys)
+			assert.Equal(t, test.expectedNext, resp.Next)
+		})
+	}
+}
+
+func TestRESTServer_ListHandler_Values(t *testing.T) {
+	s := store.New(make(map[string]interface{}))
+	require.NoError(t, s.Put("a", "value"))
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	require.NoError(t, s.PutWithExpiry("b", "other", expires))
+
+	rec := httptest.NewRecorder()
+	api.New(s, &testutil.Logger{}).ListHandler(rec, httptest.NewRequest(http.MethodGet, "/v1?values=true", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp listResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.Len(t, resp.Keys, 2)
+
+	assert.Equal(t, `"1"`, resp.Keys[0].ETag)
+	require.NotNil(t, resp.Keys[0].Value)
+	assert.Equal(t, "value", *resp.Keys[0].Value)
+	assert.Nil(t, resp.Keys[0].Expires)
+
+	require.NotNil(t, resp.Keys[1].Value)
+	assert.Equal(t, "other", *resp.Keys[1].Value)
+	require.NotNil(t, resp.Keys[1].Expires)
+	assert.True(t, expires.Equal(*resp.Keys[1].Expires))
+}
+
+func TestRESTServer_BatchHandler(t *testing.T) {
+	tests := map[string]struct {
+		body            string
+		loggerErr       error
+		expectedStatus  int
+		expectedETags   []string
+		expectedValues  map[string]string
+		expectedLogKeys []string
+	}{
+		"puts and deletes": {
+			body: `{"ops":[
+				{"op":"put","key":"a","value":"updated","if_match":"\"1\""},
+				{"op":"delete","key":"b"},
+				{"op":"put","key":"c","value":"new","ttl":"1h","if_none_match":"*"}
+			]}`,
+			expectedStatus:  http.StatusOK,
+			expectedETags:   []string{`"3"`, "", `"4"`},
+			expectedValues:  map[string]string{"a": "updated", "c": "new"},
+			expectedLogKeys: []string{"a", "b", "c"},
+		},
+		"failed precondition": {
+			body: `{"ops":[
+				{"op":"put","key":"a","value":"updated"},
+				{"op":"delete","key":"b","if_match":"\"1\""}
+			]}`,
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedValues: map[string]string{"a": "value", "b": "value"},
+		},
+		"repeated key": {
+			body:           `{"ops":[{"op":"put","key":"a","value":"1"},{"op":"put","key":"a","value":"2"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedValues: map[string]string{"a": "value", "b": "value"},
+		},
+		"unknown op": {
+			body:           `{"ops":[{"op":"append","key":"a","value":"1"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedValues: map[string]string{"a": "value", "b": "value"},
+		},
+		"delete with value": {
+			body:           `{"ops":[{"op":"delete","key":"a","value":"1"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedValues: map[string]string{"a": "value", "b": "value"},
+		},
+		"no ops": {
+			body:           `{"ops":[]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedValues: map[string]string{"a": "value", "b": "value"},
+		},
+		"unknown field": {
+			body:           `{"ops":[{"op":"put","key":"a","vaule":"1"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedValues: map[string]string{"a": "value", "b": "value"},
+		},
+		"logger failure": {
+			body:            `{"ops":[{"op":"put","key":"a","value":"updated"}]}`,
+			loggerErr:       errors.New("disk full"),
+			expectedStatus:  http.StatusServiceUnavailable,
+			expectedValues:  map[string]string{"a": "value", "b": "value"},
+			expectedLogKeys: []string{"a"},
+		},
+		"logger refuses the batch": {
+			body:            `{"ops":[{"op":"put","key":"a","value":"updated"}]}`,
+			loggerErr:       fmt.Errorf("%w: record too large", model.ErrInvalidArgument),
+			expectedStatus:  http.StatusBadRequest,
+			expectedValues:  map[string]string{"a": "value", "b": "value"},
+			expectedLogKeys: []string{"a"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := store.New(make(map[string]interface{}))
+			require.NoError(t, s.Put("a", "value"))
+			require.NoError(t, s.Put("b", "value"))
+			logger := &testutil.Logger{WriteErr: test.loggerErr}
+
+			rec := httptest.NewRecorder()
+			api.New(s, logger).BatchHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/_batch", strings.NewReader(test.body)))
+
+			require.Equal(t, test.expectedStatus, rec.Code, rec.Body.String())
+			assert.Equal(t, test.expectedLogKeys, logger.Keys())
+
+			values := make(map[string]string)
+			kvs, _ := s.List(model.ListOptions{})
+			for _, kv := range kvs {
+				values[kv.Key] = kv.Value.(string)
+			}
+			assert.Equal(t, test.expectedValues, values)
+
+			if test.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Results []struct {
+					Key  string `json:"key"`
+					ETag string `json:"etag"`
+				} `json:"results"`
+			}
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+
+			var etags []string
+			for _, result := range resp.Results {
+				etags = append(etags, result.ETag)
+			}
+			assert.Equal(t, test.expectedETags, etags)
+		})
+	}
+}
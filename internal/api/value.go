@@ -0,0 +1,17 @@
+package api
+
+import (
+	"log"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
+)
+
+// ValueString returns the key's value for the protocols that only carry strings, logging a value of any other type
+// and returning it as empty.
+func ValueString(kv *model.KeyValue) string {
+	value, ok := kv.Value.(string)
+	if !ok {
+		log.Printf("key %s has a non string value %T", kv.Key, kv.Value)
+	}
+	return value
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/watch"
+)
+
+// watchEventTypes names each event type in the stream.
+var watchEventTypes = map[store.EventType]string{
+	store.EventPut:    "put",
+	store.EventDelete: "delete",
+	store.EventExpire: "expire",
+	store.EventBatch:  "batch",
+}
+
+// watchEvent is the data of a change in the watch stream, a batch's changes are in Ops.
+type watchEvent struct {
+	Type    string       `json:"type"`
+	Key     string       `json:"key,omitempty"`
+	Value   *string      `json:"value,omitempty"`
+	ETag    string       `json:"etag,omitempty"`
+	Expires *time.Time   `json:"expires,omitempty"`
+	Ops     []watchEvent `json:"ops,omitempty"`
+}
+
+// WatchHandler streams changes to keys as Server-Sent Events.
+type WatchHandler struct {
+	hub       *watch.Hub
+	keepAlive time.Duration
+}
+
+// NewWatchHandler creates a handler streaming the hub's events, sending a comment every keepAlive when idle.
+func NewWatchHandler(hub *watch.Hub, keepAlive time.Duration) *WatchHandler {
+	return &WatchHandler{
+		hub:       hub,
+		keepAlive: keepAlive,
+	}
+}
+
+// ServeHTTP expects path "/v1/_watch" and streams every change to keys with the prefix query parameter.
+// Each event's id is its transaction log sequence. A client resumes after the sequence in the Last-Event-ID header,
+// as sent by an EventSource reconnecting, or the after query parameter. Without either only new changes are sent.
+// 410 is returned if the changes to resume from are no longer held.
+func (h *WatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w,
+			"streaming is not supported",
+			http.StatusInternalServerError)
+		return
+	}
+
+	after, err := parseResume(r)
+	if err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+	if after == nil {
+		last := h.hub.Last()
+		after = &last
+	}
+
+	watcher, err := h.hub.Watch(r.URL.Query().Get("prefix"), *after)
+	if err != nil {
+		http.Error(w,
+			err.Error(),
+			watchStatusCode(err))
+		return
+	}
+	defer watcher.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(h.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-watcher.Ready():
+			events, err := watcher.Next()
+			for _, e := range events {
+				if err := writeWatchEvent(w, e); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				// the client can reconnect from the last event it was sent
+				data, _ := json.Marshal(struct {
+					Error string `json:"error"`
+				}{err.Error()})
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			}
+			flusher.Flush()
+
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeWatchEvent writes the event as a Server-Sent Event with its sequence as the id.
+func writeWatchEvent(w io.Writer, e store.Event) error {
+	data, err := json.Marshal(newWatchEvent(e))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Sequence, watchEventTypes[e.EventType], data)
+	return err
+}
+
+func newWatchEvent(e store.Event) watchEvent {
+	we := watchEvent{
+		Type: watchEventTypes[e.EventType],
+		Key:  e.Key,
+	}
+
+	if e.EventType == store.EventPut {
+		value := e.Value
+		we.Value = &value
+		we.ETag = formatETag(e.Version)
+	}
+	if !e.Expires.IsZero() {
+		expires := e.Expires
+		we.Expires = &expires
+	}
+
+	for _, op := range e.Ops {
+		we.Ops = append(we.Ops, newWatchEvent(op))
+	}
+
+	return we
+}
+
+// parseResume reads the sequence to resume after from the Last-Event-ID header or after query parameter,
+// returning nil if neither is set.
+func parseResume(r *http.Request) (*uint64, error) {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("after")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	after, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid sequence %q", model.ErrInvalidArgument, raw)
+	}
+
+	return &after, nil
+}
+
+// watchStatusCode maps an error starting a watch onto the HTTP status returned to the client.
+func watchStatusCode(err error) int {
+	switch {
+	case errors.Is(err, watch.ErrCompacted):
+		return http.StatusGone
+	case errors.Is(err, watch.ErrFutureSequence):
+		return http.StatusBadRequest
+	case errors.Is(err, watch.ErrClosed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
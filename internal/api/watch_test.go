@@ -0,0 +1,143 @@
+package api_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/watch"
+)
+
+// readSSE reads the next n messages from the stream, each as its lines joined by newlines.
+// Comments, such as keep alives, are skipped.
+func readSSE(t *testing.T, r *bufio.Reader, n int) []string {
+	var (
+		messages []string
+		lines    []string
+	)
+	for len(messages) < n {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+
+		line = strings.TrimSuffix(line, "\n")
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+			continue
+		}
+		if len(lines) > 0 {
+			messages = append(messages, strings.Join(lines, "\n"))
+			lines = nil
+		}
+	}
+	return messages
+}
+
+func newWatchServer(t *testing.T) (*watch.Hub, *httptest.Server) {
+	hub := watch.NewHub(10)
+	hub.Start(4)
+	hub.Publish(store.Event{Sequence: 5, EventType: store.EventPut, Key: "config:a", Value: "1", Version: 3})
+	hub.Publish(store.Event{Sequence: 6, EventType: store.EventPut, Key: "other", Value: "1", Version: 4})
+	hub.Publish(store.Event{Sequence: 7, EventType: store.EventDelete, Key: "config:a"})
+
+	srv := httptest.NewServer(api.NewWatchHandler(hub, 10*time.Millisecond))
+	t.Cleanup(srv.Close)
+
+	return hub, srv
+}
+
+func TestWatchHandler(t *testing.T) {
+	tests := map[string]struct {
+		query          string
+		lastEventID    string
+		expectedStatus int
+		expected       []string
+	}{
+		"resume from query": {
+			query:          "?prefix=config:&after=4",
+			expectedStatus: http.StatusOK,
+			expected: []string{
+				"id: 5\nevent: put\ndata: {\"type\":\"put\",\"key\":\"config:a\",\"value\":\"1\",\"etag\":\"\\\"3\\\"\"}",
+				"id: 7\nevent: delete\ndata: {\"type\":\"delete\",\"key\":\"config:a\"}",
+			},
+		},
+		"last event id wins": {
+			query:          "?after=4",
+			lastEventID:    "6",
+			expectedStatus: http.StatusOK,
+			expected: []string{
+				"id: 7\nevent: delete\ndata: {\"type\":\"delete\",\"key\":\"config:a\"}",
+			},
+		},
+		"compacted": {
+			query:          "?after=2",
+			expectedStatus: http.StatusGone,
+		},
+		"future": {
+			query:          "?after=100",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"invalid": {
+			lastEventID:    "abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, srv := newWatchServer(t)
+
+			req, err := http.NewRequest(http.MethodGet, srv.URL+test.query, nil)
+			require.NoError(t, err)
+			if test.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", test.lastEventID)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, test.expectedStatus, resp.StatusCode)
+			if test.expectedStatus != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+			assert.Equal(t, test.expected, readSSE(t, bufio.NewReader(resp.Body), len(test.expected)))
+		})
+	}
+}
+
+func TestWatchHandler_Live(t *testing.T) {
+	hub, srv := newWatchServer(t)
+
+	resp, err := http.Get(srv.URL + "?prefix=config:")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// only the changes made after connecting are sent, a batch with just the matching operations
+	hub.Publish(store.Event{Sequence: 8, EventType: store.EventBatch, Ops: []store.Event{
+		{EventType: store.EventPut, Key: "other", Value: "2", Version: 5},
+		{EventType: store.EventPut, Key: "config:b", Value: "2", Version: 6},
+	}})
+	hub.Close()
+
+	r := bufio.NewReader(resp.Body)
+	assert.Equal(t, []string{
+		"id: 8\nevent: batch\ndata: {\"type\":\"batch\",\"ops\":[{\"type\":\"put\",\"key\":\"config:b\",\"value\":\"2\",\"etag\":\"\\\"6\\\"\"}]}",
+		"event: error\ndata: {\"error\":\"watch hub closed\"}",
+	}, readSSE(t, r, 2))
+
+	_, err = r.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -5,14 +5,24 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/store"
 )
 
 type Store interface {
 	Put(key string, value interface{}) error
+	PutWithExpiry(key string, value interface{}, expires time.Time) error
+	PutIf(key string, value interface{}, expires time.Time, pre model.Precondition, commit model.Commit) (*model.KeyValue, error)
 	Get(key string) (interface{}, error)
+	GetKeyValue(key string) (*model.KeyValue, error)
+	Keys() []string
+	List(opts model.ListOptions) ([]*model.KeyValue, string)
 	Delete(key string) error
+	DeleteIf(key string, pre model.Precondition, commit model.Commit) error
+	Batch(ops []model.BatchOp, commit model.Commit) ([]*model.KeyValue, error)
+	Apply(e store.Event) error
 }
 
 type lru struct {
@@ -21,9 +31,21 @@ type lru struct {
 	list           *list.List
 	size, capacity int
 
+	// hits, misses and evictions are guarded by the lock like the rest of the cache.
+	hits, misses, evictions uint64
+
 	store Store
 }
 
+// Stats is a point in time view of the cache's effectiveness.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+	Capacity  int
+}
+
 // NewLRUCache will create and return a LRU cache with the provided capacity.
 func NewLRUCache(capacity int, store Store) (*lru, error) {
 	if capacity == 0 {
@@ -43,28 +65,41 @@ func NewLRUCache(capacity int, store Store) (*lru, error) {
 
 // Put will updated/create the key value to the cache and will return true if the request has replaced an old value.
 func (l *lru) Put(key string, value interface{}) error {
-	l.Lock()
-	defer l.Unlock()
+	return l.PutWithExpiry(key, value, time.Time{})
+}
+
+// PutWithExpiry will update/create the key value in the cache and store, expiring it at the deadline.
+func (l *lru) PutWithExpiry(key string, value interface{}, expires time.Time) error {
+	_, err := l.PutIf(key, value, expires, model.Precondition{}, nil)
+	return err
+}
+
+// PutIf will update/create the key value in the store if the precondition holds and commit accepts it, and then
+// cache it.
+func (l *lru) PutIf(key string, value interface{}, expires time.Time, pre model.Precondition, commit model.Commit) (*model.KeyValue, error) {
+	commit, unlock := l.lockAfter(commit)
+	defer unlock()
+
+	kv, err := l.store.PutIf(key, value, expires, pre, commit)
+	if err != nil {
+		return nil, err
+	}
 
 	// check if the key is in the map already
 	if elem, ok := l.elementMap[key]; ok {
 		// replace the value
-		elem.Value = value
+		elem.Value = kv
 
 		// push to front of list as this was recently used
 		l.list.MoveToFront(elem)
-		return nil
+		return kv, nil
 	}
 
-	err := l.addToCache(&model.KeyValue{
-		Key:   key,
-		Value: value,
-	})
-	if err != nil {
-		return fmt.Errorf("cannot add to cache: %v", err)
+	if err := l.addToCache(kv); err != nil {
+		return nil, fmt.Errorf("cannot add to cache: %v", err)
 	}
 
-	return l.store.Put(key, value)
+	return kv, nil
 }
 
 func (l *lru) addToCache(value *model.KeyValue) error {
@@ -89,39 +124,62 @@ func (l *lru) addToCache(value *model.KeyValue) error {
 		delete(l.elementMap, kv.Key)
 	}
 	l.list.Remove(elem)
+	l.evictions++
 
-	elem.Value = value
-	l.list.PushFront(elem)
-
-	l.elementMap[kv.Key] = elem
+	l.elementMap[value.Key] = l.list.PushFront(value)
 
 	return nil
 }
 
 // Get will get the value from the cache if it exists.
 func (l *lru) Get(key string) (interface{}, error) {
+	kv, err := l.GetKeyValue(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return kv, nil
+}
+
+// GetKeyValue will get the key value from the cache, falling back to the store on a miss.
+func (l *lru) GetKeyValue(key string) (*model.KeyValue, error) {
 	l.Lock()
 	defer l.Unlock()
 
-	elem, ok := l.elementMap[key]
-	if !ok {
-		// add to cache
-		val, err := l.store.Get(key)
-		if err != nil {
-			return nil, err
+	if elem, ok := l.elementMap[key]; ok {
+		kv := elem.Value.(*model.KeyValue)
+		if !kv.Expired(time.Now()) {
+			l.hits++
+			l.list.MoveToFront(elem)
+			return kv, nil
 		}
 
-		err = l.addToCache(&model.KeyValue{
-			Key:   key,
-			Value: val,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to add key value to cache: %v", err)
-		}
+		// expired, the store is the source of truth for whether it has been replaced
+		l.remove(key)
+	}
+	l.misses++
+
+	kv, err := l.store.GetKeyValue(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.addToCache(kv); err != nil {
+		return nil, fmt.Errorf("failed to add key value to cache: %v", err)
 	}
 
-	l.list.MoveToFront(elem)
-	return elem.Value, nil
+	return kv, nil
+}
+
+// Keys lists the keys from the store, the cache only holds recently used ones.
+func (l *lru) Keys() []string {
+	return l.store.Keys()
+}
+
+// List reads straight from the store. Writes go through to the store before the cache, so it is always current,
+// and the listed keys aren't cached so a scan doesn't evict the recently used ones.
+func (l *lru) List(opts model.ListOptions) ([]*model.KeyValue, string) {
+	return l.store.List(opts)
 }
 
 func (l *lru) Size() int {
@@ -130,14 +188,125 @@ func (l *lru) Size() int {
 	return l.size
 }
 
-// Delete will delete the value if the key exists.
-func (l *lru) Delete(key string) error {
+// Stats returns the hit, miss and eviction counts since the cache was created along with its size.
+func (l *lru) Stats() Stats {
 	l.Lock()
 	defer l.Unlock()
 
+	return Stats{
+		Hits:      l.hits,
+		Misses:    l.misses,
+		Evictions: l.evictions,
+		Size:      l.size,
+		Capacity:  l.capacity,
+	}
+}
+
+// Delete will delete the value if the key exists.
+func (l *lru) Delete(key string) error {
+	commit, unlock := l.lockAfter(nil)
+	defer unlock()
+
+	if err := l.store.DeleteIf(key, model.Precondition{}, commit); err != nil {
+		return err
+	}
+
+	if elem, ok := l.elementMap[key]; ok {
+		l.list.Remove(elem)
+	}
 	delete(l.elementMap, key)
 
 	l.size--
 
-	return l.store.Delete(key)
+	return nil
+}
+
+// DeleteIf will delete the key from the store if the precondition holds and commit accepts it, and drop it from the
+// cache.
+func (l *lru) DeleteIf(key string, pre model.Precondition, commit model.Commit) error {
+	commit, unlock := l.lockAfter(commit)
+	defer unlock()
+
+	if err := l.store.DeleteIf(key, pre, commit); err != nil {
+		return err
+	}
+
+	l.remove(key)
+
+	return nil
+}
+
+// Batch will apply the operations to the store all together if their preconditions hold and commit accepts them,
+// and drop their keys from the cache, so the batch's keys are next read from the store.
+func (l *lru) Batch(ops []model.BatchOp, commit model.Commit) ([]*model.KeyValue, error) {
+	commit, unlock := l.lockAfter(commit)
+	defer unlock()
+
+	kvs, err := l.store.Batch(ops, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range ops {
+		l.remove(op.Key)
+	}
+
+	return kvs, nil
+}
+
+// lockAfter returns a commit that takes the lock once commit, if not nil, accepts a write, so the store applies it
+// and the cache is updated without a read in between, and a func that releases the lock if it was taken. The lock
+// isn't held while the write is committed, which can wait on the disk.
+func (l *lru) lockAfter(commit model.Commit) (model.Commit, func()) {
+	locked := false
+	locking := func(kvs []*model.KeyValue) error {
+		if commit != nil {
+			if err := commit(kvs); err != nil {
+				return err
+			}
+		}
+
+		l.Lock()
+		locked = true
+		return nil
+	}
+
+	return locking, func() {
+		if locked {
+			l.Unlock()
+		}
+	}
+}
+
+// Apply will apply the logged event to the store and drop the key, or a batch's keys, from the cache.
+func (l *lru) Apply(e store.Event) error {
+	l.Lock()
+	defer l.Unlock()
+
+	l.remove(e.Key)
+	for _, op := range e.Ops {
+		l.remove(op.Key)
+	}
+
+	return l.store.Apply(e)
+}
+
+// Evict will drop the key from the cache without touching the store.
+func (l *lru) Evict(key string) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.remove(key)
+}
+
+// remove drops the key from the cache if it is present, the caller must hold the lock.
+func (l *lru) remove(key string) {
+	elem, ok := l.elementMap[key]
+	if !ok {
+		return
+	}
+
+	l.list.Remove(elem)
+	delete(l.elementMap, key)
+	l.size--
 }
@@ -3,6 +3,7 @@ package cache_test
 import (
 	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -90,8 +91,9 @@ func Test_lru_Get(t *testing.T) {
 			},
 			key: "key1",
 			want: &model.KeyValue{
-				Key:   "key1",
-				Value: "value1",
+				Key:     "key1",
+				Value:   "value1",
+				Version: 1,
 			},
 		},
 	}
@@ -163,3 +165,114 @@ func Test_lru_Delete(t *testing.T) {
 		})
 	}
 }
+
+func Test_lru_GetKeyValue_Expiry(t *testing.T) {
+	tests := map[string]struct {
+		expires     time.Time
+		errContains string
+	}{
+		"not expired": {
+			expires: time.Now().Add(time.Hour),
+		},
+		"expired": {
+			expires:     time.Now().Add(-time.Second),
+			errContains: "not found",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			lru, err := cache.NewLRUCache(1, store.New(make(map[string]interface{})))
+			require.NoError(t, err)
+
+			err = lru.PutWithExpiry("key", "value", test.expires)
+			require.NoError(t, err)
+
+			got, err := lru.GetKeyValue("key")
+			if test.errContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.errContains)
+				assert.Equal(t, 0, lru.Size())
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "value", got.Value)
+			assert.Equal(t, test.expires, got.Expires)
+		})
+	}
+}
+
+func Test_lru_Stats(t *testing.T) {
+	s := store.New(make(map[string]interface{}))
+	lru, err := cache.NewLRUCache(2, s)
+	require.NoError(t, err)
+
+	require.NoError(t, lru.Put("key1", "value1"))
+	require.NoError(t, lru.Put("key2", "value2"))
+	// evicts key1
+	require.NoError(t, lru.Put("key3", "value3"))
+
+	_, err = lru.GetKeyValue("key3")
+	require.NoError(t, err)
+	// refilled from the store, evicting key2
+	_, err = lru.GetKeyValue("key1")
+	require.NoError(t, err)
+	_, err = lru.GetKeyValue("missing")
+	require.Error(t, err)
+
+	assert.Equal(t, cache.Stats{Hits: 1, Misses: 2, Evictions: 2, Size: 2, Capacity: 2}, lru.Stats())
+}
+
+func Test_lru_List(t *testing.T) {
+	s := store.New(make(map[string]interface{}))
+	lru, err := cache.NewLRUCache(1, s)
+	require.NoError(t, err)
+
+	require.NoError(t, lru.Put("key1", "value1"))
+	require.NoError(t, lru.Put("key2", "value2"))
+	require.NoError(t, lru.Put("key1", "updated"))
+
+	kvs, next := lru.List(model.ListOptions{})
+	require.Len(t, kvs, 2)
+	assert.Equal(t, "updated", kvs[0].Value)
+	assert.Equal(t, "value2", kvs[1].Value)
+	assert.Empty(t, next)
+
+	// listing doesn't fill the cache
+	assert.Equal(t, 1, lru.Size())
+	assert.Equal(t, uint64(0), lru.Stats().Misses)
+}
+
+func Test_lru_Batch(t *testing.T) {
+	lru, err := cache.NewLRUCache(2, store.New(make(map[string]interface{})))
+	require.NoError(t, err)
+
+	require.NoError(t, lru.Put("key1", "value1"))
+	require.NoError(t, lru.Put("key2", "value2"))
+
+	_, err = lru.Batch([]model.BatchOp{
+		{Type: model.OpPut, Key: "key1", Value: "updated"},
+		{Type: model.OpDelete, Key: "key2", Precondition: model.Precondition{IfMatch: []uint64{1}}},
+	}, nil)
+	require.ErrorIs(t, err, model.ErrPreconditionFailed)
+
+	// nothing was applied so the cached values still stand
+	assert.Equal(t, 2, lru.Size())
+	kv, err := lru.GetKeyValue("key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", kv.Value)
+
+	kvs, err := lru.Batch([]model.BatchOp{
+		{Type: model.OpPut, Key: "key1", Value: "updated"},
+		{Type: model.OpDelete, Key: "key2", Precondition: model.Precondition{IfMatch: []uint64{2}}},
+	}, nil)
+	require.NoError(t, err)
+	require.Len(t, kvs, 2)
+
+	assert.Equal(t, 0, lru.Size())
+	kv, err = lru.GetKeyValue("key1")
+	require.NoError(t, err)
+	assert.Equal(t, "updated", kv.Value)
+	_, err = lru.GetKeyValue("key2")
+	assert.ErrorIs(t, err, model.ErrKeyNotFound)
+}
@@ -0,0 +1,340 @@
+package clusterkv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
+)
+
+const (
+	writePath   = "/clusterkv/write"
+	getPath     = "/clusterkv/get"
+	listPath    = "/clusterkv/list"
+	keysPath    = "/clusterkv/keys"
+	membersPath = "/clusterkv/members"
+
+	// maxCommandSize caps the size of a forwarded command or membership read by the handler.
+	maxCommandSize = 64 << 20
+)
+
+// forwardErrors names the store errors that keep their meaning when returned from another node.
+var forwardErrors = map[string]error{
+	"key_not_found":       model.ErrKeyNotFound,
+	"invalid_argument":    model.ErrInvalidArgument,
+	"precondition_failed": model.ErrPreconditionFailed,
+	"unavailable":         model.ErrUnavailable,
+}
+
+// forwardResponse is another node's result for a forwarded request.
+type forwardResponse struct {
+	KeyValues []*model.KeyValue `json:"key_values,omitempty"`
+	// Next is where a listing continues from, and Keys the node's keys.
+	Next  string   `json:"next,omitempty"`
+	Keys  []string `json:"keys,omitempty"`
+	Error string   `json:"error,omitempty"`
+	// Kind is the name of the store error in forwardErrors the error wraps, if any.
+	Kind string `json:"kind,omitempty"`
+}
+
+// forwardedError is an error returned by another node, wrapping the store error it was made from.
+type forwardedError struct {
+	msg string
+	err error
+}
+
+func (e *forwardedError) Error() string { return e.msg }
+
+func (e *forwardedError) Unwrap() error { return e.err }
+
+// Handler serves requests forwarded from other nodes, keys moved from them and changes to the membership, on the
+// address the node is known by to its peers. It must only be reachable by the cluster and its operators.
+func (s *Store) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(writePath, s.writeHandler)
+	mux.HandleFunc(getPath, s.getHandler)
+	mux.HandleFunc(listPath, s.listHandler)
+	mux.HandleFunc(keysPath, s.keysHandler)
+	mux.HandleFunc(transferPath, s.transferHandler)
+	mux.HandleFunc(membersPath, s.membersHandler)
+
+	return mux
+}
+
+// writeHandler applies a command forwarded by another node, without forwarding it on if this node doesn't think it
+// owns the keys.
+func (s *Store) writeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w,
+			"method not allowed",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	var cmd command
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandSize)).Decode(&cmd); err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+	if len(cmd.Ops) == 0 {
+		http.Error(w,
+			"command has no operations",
+			http.StatusBadRequest)
+		return
+	}
+
+	kvs, err := s.write(cmd, false)
+	writeResponse(w, forwardResponse{KeyValues: kvs}, err)
+}
+
+// getHandler reads a key for another node. With local set only this node's store is read, otherwise the key's
+// previous owner is asked too if it hasn't been moved here yet.
+func (s *Store) getHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w,
+			"method not allowed",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	var (
+		key = r.URL.Query().Get("key")
+		kv  *model.KeyValue
+		err error
+	)
+	if r.URL.Query().Get("local") != "" {
+		kv, err = s.store.GetKeyValue(key)
+	} else {
+		kv, err = s.getOwned(key)
+	}
+
+	var resp forwardResponse
+	if err == nil {
+		resp.KeyValues = []*model.KeyValue{kv}
+	}
+	writeResponse(w, resp, err)
+}
+
+// listHandler lists this node's store for another node merging the listing of every node.
+func (s *Store) listHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w,
+			"method not allowed",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	var opts model.ListOptions
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandSize)).Decode(&opts); err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	kvs, next := s.store.List(opts)
+	writeResponse(w, forwardResponse{KeyValues: kvs, Next: next}, nil)
+}
+
+// keysHandler returns this node's keys.
+func (s *Store) keysHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w,
+			"method not allowed",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeResponse(w, forwardResponse{Keys: s.store.Keys()}, nil)
+}
+
+// membersHandler returns the cluster's members, or changes them. A change is sent on to every node in the cluster
+// before or after it, with local set so they don't send it on again, then applied here.
+func (s *Store) membersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Members())
+		return
+	case http.MethodPut:
+	default:
+		http.Error(w,
+			"method not allowed",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	var peers map[string]string
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandSize)).Decode(&peers); err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+	if len(peers) == 0 {
+		http.Error(w,
+			"members cannot be empty",
+			http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("local") == "" {
+		nodes := s.Members()
+		for id, addr := range peers {
+			nodes[id] = addr
+		}
+		for id, addr := range nodes {
+			if id == s.id {
+				continue
+			}
+			if err := s.putMembers(id, addr, peers); err != nil {
+				http.Error(w,
+					err.Error(),
+					http.StatusBadGateway)
+				return
+			}
+		}
+	}
+
+	s.SetMembers(peers)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeResponse writes the response with the error, if any, named by the store error it wraps.
+func writeResponse(w http.ResponseWriter, resp forwardResponse, err error) {
+	if err != nil {
+		resp.Error = err.Error()
+		for kind, target := range forwardErrors {
+			if errors.Is(err, target) {
+				resp.Kind = kind
+				break
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// forwardWrite sends the command to the node owning its keys to apply, returning its result.
+func (s *Store) forwardWrite(owner string, cmd command) ([]*model.KeyValue, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.forward(owner, http.MethodPost, writePath, data)
+	if err != nil {
+		return nil, err
+	}
+	return resp.KeyValues, nil
+}
+
+// forwardGet reads the key from the node, only from its store if local is set.
+func (s *Store) forwardGet(node, key string, local bool) (*model.KeyValue, error) {
+	query := url.Values{"key": {key}}
+	if local {
+		query.Set("local", "1")
+	}
+
+	resp, err := s.forward(node, http.MethodGet, getPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.KeyValues) != 1 {
+		return nil, fmt.Errorf("%w: node %s returned %d keys for %s", model.ErrUnavailable, node, len(resp.KeyValues), key)
+	}
+	return resp.KeyValues[0], nil
+}
+
+// forwardList lists the node's store.
+func (s *Store) forwardList(node string, opts model.ListOptions) ([]*model.KeyValue, string, error) {
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := s.forward(node, http.MethodPost, listPath, data)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.KeyValues, resp.Next, nil
+}
+
+// forwardKeys returns the node's keys.
+func (s *Store) forwardKeys(node string) ([]string, error) {
+	resp, err := s.forward(node, http.MethodGet, keysPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Keys, nil
+}
+
+// forward sends a request to the node, returning its response or the error it returned.
+func (s *Store) forward(node, method, path string, data []byte) (*forwardResponse, error) {
+	addr, ok := s.Members()[node]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown cluster node %q", model.ErrUnavailable, node)
+	}
+	atomic.AddUint64(&s.forwarded, 1)
+
+	req, err := http.NewRequestWithContext(s.ctx, method, "http://"+addr+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to forward to cluster node %s: %v", model.ErrUnavailable, node, err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: cluster node %s returned status %d", model.ErrUnavailable, node, httpResp.StatusCode)
+	}
+
+	var resp forwardResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("invalid response from cluster node %s: %w", node, err)
+	}
+	if resp.Error != "" {
+		return nil, &forwardedError{msg: resp.Error, err: forwardErrors[resp.Kind]}
+	}
+
+	return &resp, nil
+}
+
+// putMembers sends the members to the node to apply without sending them on.
+func (s *Store) putMembers(node, addr string, peers map[string]string) error {
+	data, err := json.Marshal(peers)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPut, "http://"+addr+membersPath+"?local=1", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send members to cluster node %s: %w", node, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("cluster node %s returned status %d for members", node, resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package clusterkv
+
+import (
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// Logger takes the place of the transaction logger in front of a partitioned store. A write is logged by the node
+// owning the key as it is applied, so the write methods have nothing left to do, and everything else is left to the
+// node's own logger.
+type Logger struct {
+	api.TransactionLogger
+	s *Store
+}
+
+func (l *Logger) WritePut(key string, value string, version uint64, expires time.Time) error {
+	return nil
+}
+
+func (l *Logger) WriteDelete(key string) error {
+	return nil
+}
+
+func (l *Logger) WriteBatch(ops []store.Event) error {
+	return nil
+}
+
+// Close stops moving keys to other nodes, then drains the node's own logger.
+func (l *Logger) Close() error {
+	l.s.stop()
+
+	return l.TransactionLogger.Close()
+}
@@ -0,0 +1,87 @@
+package clusterkv
+
+import (
+	"github.com/warrenb95/cloud-native-go/internal/gossip"
+)
+
+// MemberTag is the gossip tag a node advertises the address its Handler is served on in.
+const MemberTag = "cluster"
+
+// Follow has the cluster's members follow gossip once Run is called, moving keys like SetMembers. A member that is
+// alive and advertises its address in MemberTag is added, and one that is dead or has left is removed. A suspected
+// member is kept until it is declared dead, so a slow node doesn't have its keys moved away and back. It must be
+// called before members.Join.
+func (s *Store) Follow(members *gossip.Memberlist) {
+	s.gossip = members
+	s.gossipChanged = make(chan struct{}, 1)
+
+	members.OnChange(func(m gossip.Member) {
+		s.gossipMu.Lock()
+		s.gossipChanges = append(s.gossipChanges, m)
+		s.gossipMu.Unlock()
+
+		select {
+		case s.gossipChanged <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// follow applies the gossiped member changes one at a time until the store is stopped. The changes queued before it
+// started are replaced by the members as gossip knows them now, this node included.
+func (s *Store) follow() {
+	s.gossipMu.Lock()
+	s.gossipChanges = nil
+	s.gossipMu.Unlock()
+
+	for _, m := range s.gossip.Members() {
+		s.followMember(m)
+	}
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.gossipChanged:
+		}
+
+		s.gossipMu.Lock()
+		changes := s.gossipChanges
+		s.gossipChanges = nil
+		s.gossipMu.Unlock()
+
+		for _, m := range changes {
+			s.followMember(m)
+		}
+	}
+}
+
+// followMember adds or removes the member as its gossiped state says.
+func (s *Store) followMember(m gossip.Member) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := make(map[string]string, len(s.peers)+1)
+	for id, addr := range s.peers {
+		peers[id] = addr
+	}
+
+	switch m.State {
+	case gossip.Alive:
+		addr, ok := m.Tags[MemberTag]
+		if !ok || peers[m.Name] == addr {
+			return
+		}
+		peers[m.Name] = addr
+	case gossip.Dead, gossip.Left:
+		// this node stays a member of its own ring, even once it leaves as it shuts down
+		if _, ok := peers[m.Name]; !ok || m.Name == s.id {
+			return
+		}
+		delete(peers, m.Name)
+	default:
+		return
+	}
+
+	s.setMembers(peers)
+}
@@ -0,0 +1,350 @@
+package clusterkv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/ring"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+const (
+	transferPath = "/clusterkv/transfer"
+
+	// transferChunk is how many keys are read at a time to find those to move, and how many moved keys are applied,
+	// and logged, at a time.
+	transferChunk = 128
+
+	// retryInterval is how long to wait before moving keys to a node that couldn't be reached again.
+	retryInterval = time.Second
+)
+
+// transferred is a key moved to its new owner, with the version and expiry it had on the old one.
+type transferred struct {
+	Key     string    `json:"key"`
+	Value   string    `json:"value"`
+	Version uint64    `json:"version"`
+	Expires time.Time `json:"expires,omitempty"`
+	Flags   uint32    `json:"flags,omitempty"`
+}
+
+// SetMembers changes the cluster's members to the peers, moving the keys in the ranges this node no longer owns to
+// their new owners. Until every node has moved its keys here, a key not found is read from its previous owner.
+func (s *Store) SetMembers(peers map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setMembers(peers)
+}
+
+// setMembers changes the cluster's members like SetMembers, the caller must hold mu.
+func (s *Store) setMembers(peers map[string]string) {
+	next := ring.New(nodeIDs(peers), s.virtualNodes)
+	pending := make(map[string]*moving)
+	expecting := make(map[string]bool)
+	for _, m := range s.ring.Moves(next) {
+		if m.From == s.id {
+			if pending[m.To] == nil {
+				pending[m.To] = &moving{}
+			}
+			pending[m.To].ranges = append(pending[m.To].ranges, m.Range)
+		}
+		if m.To == s.id {
+			expecting[m.From] = true
+		}
+	}
+
+	s.peers = make(map[string]string, len(peers))
+	for id, addr := range peers {
+		s.peers[id] = addr
+	}
+	s.previous, s.ring = s.ring, next
+	s.expecting = expecting
+	if len(expecting) == 0 {
+		s.previous, s.touched = nil, nil
+	} else if s.touched == nil {
+		s.touched = make(map[string]bool)
+	}
+
+	s.startRebalance(pending)
+}
+
+// moving selects the keys to move to a node, either every key in the ranges of hashes or just the keys.
+type moving struct {
+	ranges []ring.Range
+	keys   []string
+}
+
+// each calls fn with every key value the store holds that is being moved, stopping at the first error.
+// The store is ordered by key rather than hash, so the keys in the ranges are found a page at a time.
+func (m *moving) each(st *store.Store, fn func(kv *model.KeyValue) error) error {
+	for _, key := range m.keys {
+		kv, err := st.GetKeyValue(key)
+		if errors.Is(err, model.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(kv); err != nil {
+			return err
+		}
+	}
+	if len(m.ranges) == 0 {
+		return nil
+	}
+
+	opts := model.ListOptions{Limit: transferChunk}
+	for {
+		kvs, next := st.List(opts)
+		for _, kv := range kvs {
+			if !m.contains(kv.Key) {
+				continue
+			}
+			if err := fn(kv); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		opts.Start = next
+	}
+}
+
+func (m *moving) contains(key string) bool {
+	hash := ring.Hash(key)
+	for _, rg := range m.ranges {
+		if rg.Contains(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// startRebalance stops moving keys for the last membership change and starts moving the pending keys to their
+// nodes, telling every one of them once it has all of its keys even if there were none. The caller must hold mu.
+func (s *Store) startRebalance(pending map[string]*moving) {
+	if s.ctx.Err() != nil {
+		// stopped, nothing more is moved
+		return
+	}
+	if s.cancelRebalance != nil {
+		s.cancelRebalance()
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.cancelRebalance = cancel
+
+	s.rebalancing.Add(1)
+	go func() {
+		defer s.rebalancing.Done()
+		s.rebalance(ctx, pending)
+	}()
+}
+
+// misplaced returns the keys the store holds that the ring places on other nodes, by the node they belong on.
+func (s *Store) misplaced(r *ring.Ring) map[string]*moving {
+	pending := make(map[string]*moving)
+	opts := model.ListOptions{Limit: transferChunk}
+	for {
+		kvs, next := s.store.List(opts)
+		for _, kv := range kvs {
+			owner := r.Owner(kv.Key)
+			if owner == s.id {
+				continue
+			}
+			if pending[owner] == nil {
+				pending[owner] = &moving{}
+			}
+			pending[owner].keys = append(pending[owner].keys, kv.Key)
+		}
+		if next == "" {
+			return pending
+		}
+		opts.Start = next
+	}
+}
+
+// rebalance moves the pending keys to their nodes, retrying nodes that can't be reached until ctx is cancelled.
+// A key written again after it was sent is sent again until it is moved unchanged, as nothing else would move it.
+func (s *Store) rebalance(ctx context.Context, pending map[string]*moving) {
+	for {
+		for id, m := range pending {
+			rewritten, err := s.transfer(ctx, id, m)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("cluster: failed to move keys to node %s, retrying: %v", id, err)
+				continue
+			}
+			if len(rewritten) > 0 {
+				pending[id] = &moving{keys: rewritten}
+				continue
+			}
+			delete(pending, id)
+		}
+		if len(pending) == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// transfer streams the keys being moved to the node then deletes them here, returning those written again in the
+// meantime.
+func (s *Store) transfer(ctx context.Context, node string, m *moving) ([]string, error) {
+	addr, ok := s.Members()[node]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster node %q", node)
+	}
+
+	// sent is only read once the request is done, and with it the writer
+	var sent []model.KeyValue
+	body, w := io.Pipe()
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		enc := json.NewEncoder(w)
+		w.CloseWithError(m.each(s.store, func(kv *model.KeyValue) error {
+			value, _ := kv.Value.(string)
+			if err := enc.Encode(transferred{Key: kv.Key, Value: value, Version: kv.Version, Expires: kv.Expires, Flags: kv.Flags}); err != nil {
+				return err
+			}
+			sent = append(sent, model.KeyValue{Key: kv.Key, Version: kv.Version})
+			return nil
+		}))
+	}()
+	defer func() {
+		// the node may answer without reading everything
+		body.Close()
+		<-written
+	}()
+
+	query := url.Values{"from": {s.id}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+transferPath+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := s.streams.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("node %s returned status %d: %s", node, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	body.Close()
+	<-written
+	atomic.AddUint64(&s.transferred, uint64(len(sent)))
+
+	var rewritten []string
+	for _, kv := range sent {
+		err := s.store.DeleteIf(kv.Key, model.Precondition{IfMatch: []uint64{kv.Version}}, api.LogDelete(s.logger))
+		if errors.Is(err, model.ErrPreconditionFailed) {
+			rewritten = append(rewritten, kv.Key)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rewritten, nil
+}
+
+// transferHandler applies the keys moved here by another node. A key written here since the last membership change,
+// or held at a later version, is kept.
+func (s *Store) transferHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w,
+			"method not allowed",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	from := r.URL.Query().Get("from")
+	dec := json.NewDecoder(bufio.NewReader(r.Body))
+	chunk := make([]transferred, 0, transferChunk)
+	for {
+		var t transferred
+		err := dec.Decode(&t)
+		if err == nil {
+			chunk = append(chunk, t)
+		} else if !errors.Is(err, io.EOF) {
+			http.Error(w,
+				err.Error(),
+				http.StatusBadRequest)
+			return
+		}
+
+		if len(chunk) == transferChunk || (err != nil && len(chunk) > 0) {
+			if err := s.applyTransferred(chunk); err != nil {
+				http.Error(w,
+					err.Error(),
+					http.StatusServiceUnavailable)
+				return
+			}
+			chunk = chunk[:0]
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.expecting, from)
+	if len(s.expecting) == 0 {
+		s.previous, s.touched = nil, nil
+	}
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyTransferred logs and applies the moved keys as one batch. mu is held throughout so a write to one of them
+// either comes first, and keeps it from being applied, or is logged and applied after it.
+func (s *Store) applyTransferred(chunk []transferred) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ops []store.Event
+	for _, t := range chunk {
+		if s.touched[t.Key] {
+			continue
+		}
+		if kv, err := s.store.GetKeyValue(t.Key); err == nil && kv.Version >= t.Version {
+			continue
+		}
+		ops = append(ops, store.Event{EventType: store.EventPut, Key: t.Key, Value: t.Value, Version: t.Version, Expires: t.Expires, Flags: t.Flags})
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+
+	return s.store.ApplyCommitted(store.Event{EventType: store.EventBatch, Ops: ops}, func() error {
+		return s.logger.WriteBatch(ops)
+	})
+}
@@ -0,0 +1,450 @@
+// Package clusterkv partitions the key value store across a cluster with a consistent hash ring. Each key is owned
+// by one node, which holds it in its store and logs its writes, and any node forwards a request for a key to its
+// owner. When the membership changes each node moves the keys it no longer owns to their new owners.
+package clusterkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/gossip"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/ring"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// Config configures the node a Store partitions keys across.
+type Config struct {
+	// ID is this node's ID.
+	ID string
+	// Peers maps the ID of every node in the cluster to the address its Handler is served on. A node joining the
+	// cluster isn't one of them until it is added with SetMembers.
+	Peers map[string]string
+	// VirtualNodes is how many points each node has on the ring.
+	VirtualNodes int
+	// ForwardTimeout bounds how long a request forwarded to another node may take.
+	ForwardTimeout time.Duration
+}
+
+// Store serves the keys this node owns from its store and forwards requests for every other key to its owner.
+type Store struct {
+	// forwarded counts requests sent to other nodes and transferred the keys moved to them, both accessed
+	// atomically and kept first for 64 bit alignment.
+	forwarded   uint64
+	transferred uint64
+
+	id           string
+	store        *store.Store
+	logger       api.TransactionLogger
+	virtualNodes int
+	client       *http.Client
+	// streams carries keys being moved, which can take longer than a forwarded request.
+	streams *http.Client
+
+	mu    sync.RWMutex
+	peers map[string]string
+	ring  *ring.Ring
+	// previous is the ring before the last membership change. A key not found here may still be on its previous
+	// owner while that node is in expecting.
+	previous *ring.Ring
+	// expecting holds the nodes that haven't finished moving keys here since the last membership change.
+	expecting map[string]bool
+	// touched holds the keys written here since the last membership change, which a copy moved here mustn't replace.
+	touched map[string]bool
+
+	// ctx is cancelled when the logger is closed, cancelRebalance stops moving keys for the last membership change.
+	ctx             context.Context
+	cancel          context.CancelFunc
+	cancelRebalance context.CancelFunc
+	rebalancing     sync.WaitGroup
+
+	apiLogger *Logger
+
+	// gossip is followed for membership changes once running if set, see Follow. gossipChanges queues the changes
+	// reported since they were last applied and gossipChanged signals them.
+	gossip        *gossip.Memberlist
+	gossipMu      sync.Mutex
+	gossipChanges []gossip.Member
+	gossipChanged chan struct{}
+}
+
+// command is a write, either a single put or delete or a batch of them, for keys owned by the same node.
+type command struct {
+	Ops []op `json:"ops"`
+}
+
+type op struct {
+	Type         model.OpType       `json:"type"`
+	Key          string             `json:"key"`
+	Value        interface{}        `json:"value,omitempty"`
+	Expires      time.Time          `json:"expires,omitempty"`
+	Flags        uint32             `json:"flags,omitempty"`
+	Precondition model.Precondition `json:"precondition"`
+}
+
+// New creates a store partitioning keys across the peers, holding the keys this node owns in s and logging their
+// writes to logger. Run must be called once the log has been replayed.
+func New(config Config, s *store.Store, logger api.TransactionLogger) (*Store, error) {
+	if len(config.Peers) == 0 {
+		return nil, errors.New("the cluster must have at least one peer")
+	}
+	if config.VirtualNodes < 1 {
+		return nil, fmt.Errorf("invalid number of virtual nodes %d", config.VirtualNodes)
+	}
+
+	peers := make(map[string]string, len(config.Peers))
+	for id, addr := range config.Peers {
+		peers[id] = addr
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	kv := &Store{
+		id:           config.ID,
+		store:        s,
+		logger:       logger,
+		virtualNodes: config.VirtualNodes,
+		client:       &http.Client{Timeout: config.ForwardTimeout},
+		streams:      &http.Client{},
+		peers:        peers,
+		ring:         ring.New(nodeIDs(peers), config.VirtualNodes),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	kv.apiLogger = &Logger{TransactionLogger: logger, s: kv}
+
+	return kv, nil
+}
+
+// PutIf will write the key on its owner if the precondition holds against its version there. The owner logs the
+// write itself, so commit is ignored.
+func (s *Store) PutIf(key string, value interface{}, expires time.Time, pre model.Precondition, commit model.Commit) (*model.KeyValue, error) {
+	kvs, err := s.write(command{Ops: []op{{
+		Type:         model.OpPut,
+		Key:          key,
+		Value:        value,
+		Expires:      expires,
+		Precondition: pre,
+	}}}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return kvs[0], nil
+}
+
+// DeleteIf will delete the key on its owner if the precondition holds against its version there. Like PutIf, commit
+// is ignored.
+func (s *Store) DeleteIf(key string, pre model.Precondition, commit model.Commit) error {
+	_, err := s.write(command{Ops: []op{{
+		Type:         model.OpDelete,
+		Key:          key,
+		Precondition: pre,
+	}}}, true)
+
+	return err
+}
+
+// Batch will apply every operation on the node owning the keys, all together or not at all. Every key must be owned
+// by the same node. Like PutIf, commit is ignored.
+func (s *Store) Batch(ops []model.BatchOp, commit model.Commit) ([]*model.KeyValue, error) {
+	cmd := command{Ops: make([]op, len(ops))}
+	for i, o := range ops {
+		cmd.Ops[i] = op{
+			Type:         o.Type,
+			Key:          o.Key,
+			Value:        o.Value,
+			Expires:      o.Expires,
+			Flags:        o.Flags,
+			Precondition: o.Precondition,
+		}
+	}
+
+	return s.write(cmd, true)
+}
+
+// GetKeyValue reads the key from its owner.
+func (s *Store) GetKeyValue(key string) (*model.KeyValue, error) {
+	if owner := s.owner(key); owner != s.id {
+		return s.forwardGet(owner, key, false)
+	}
+	return s.getOwned(key)
+}
+
+// List merges a page of the listing from every node.
+// A node that can't be reached is left out of the listing.
+func (s *Store) List(opts model.ListOptions) ([]*model.KeyValue, string) {
+	type page struct {
+		kvs  []*model.KeyValue
+		next string
+	}
+
+	nodes := s.Members()
+	pages := make([]page, 0, len(nodes))
+	for id := range nodes {
+		if id == s.id {
+			kvs, next := s.store.List(opts)
+			pages = append(pages, page{kvs: kvs, next: next})
+			continue
+		}
+
+		kvs, next, err := s.forwardList(id, opts)
+		if err != nil {
+			log.Printf("cluster: leaving node %s out of the listing: %v", id, err)
+			continue
+		}
+		pages = append(pages, page{kvs: kvs, next: next})
+	}
+
+	var (
+		kvs  []*model.KeyValue
+		next string
+	)
+	for _, p := range pages {
+		kvs = append(kvs, p.kvs...)
+		if p.next != "" && (next == "" || p.next < next) {
+			next = p.next
+		}
+	}
+	sort.SliceStable(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+
+	// a key moving between nodes can be listed by both
+	merged := kvs[:0]
+	for _, kv := range kvs {
+		if len(merged) > 0 && merged[len(merged)-1].Key == kv.Key {
+			continue
+		}
+		// a node with more to list may have keys before the others' later ones
+		if next != "" && kv.Key >= next {
+			break
+		}
+		merged = append(merged, kv)
+	}
+
+	if opts.Limit > 0 && len(merged) > opts.Limit {
+		next = merged[opts.Limit].Key
+		merged = merged[:opts.Limit]
+	}
+
+	return merged, next
+}
+
+// Keys returns the keys on every node in order.
+// A node that can't be reached is left out.
+func (s *Store) Keys() []string {
+	var keys []string
+	for id := range s.Members() {
+		if id == s.id {
+			keys = append(keys, s.store.Keys()...)
+			continue
+		}
+
+		nodeKeys, err := s.forwardKeys(id)
+		if err != nil {
+			log.Printf("cluster: leaving node %s out of the keys: %v", id, err)
+			continue
+		}
+		keys = append(keys, nodeKeys...)
+	}
+	sort.Strings(keys)
+
+	unique := keys[:0]
+	for _, key := range keys {
+		if len(unique) == 0 || unique[len(unique)-1] != key {
+			unique = append(unique, key)
+		}
+	}
+
+	return unique
+}
+
+// Members returns the address of every node in the cluster by its ID.
+func (s *Store) Members() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members := make(map[string]string, len(s.peers))
+	for id, addr := range s.peers {
+		members[id] = addr
+	}
+	return members
+}
+
+// Owner returns the ID of the node owning the key.
+func (s *Store) Owner(key string) string {
+	return s.owner(key)
+}
+
+// Forwarded returns how many requests have been forwarded to other nodes.
+func (s *Store) Forwarded() uint64 {
+	return atomic.LoadUint64(&s.forwarded)
+}
+
+// Transferred returns how many keys have been moved to other nodes.
+func (s *Store) Transferred() uint64 {
+	return atomic.LoadUint64(&s.transferred)
+}
+
+// Logger returns the stand in for the transaction logger in front of the store, see Logger.
+func (s *Store) Logger() *Logger {
+	return s.apiLogger
+}
+
+// Run moves any keys this node holds but doesn't own to their owners, e.g. after the peers were changed while it
+// was down, then starts following gossip if it was asked to. It must be called once the log has been replayed into
+// the store.
+func (s *Store) Run() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.startRebalance(s.misplaced(s.ring))
+	if s.gossip != nil {
+		go s.follow()
+	}
+}
+
+// stop stops moving keys to other nodes, waiting for a move in progress to stop.
+func (s *Store) stop() {
+	s.cancel()
+	s.rebalancing.Wait()
+}
+
+func (s *Store) owner(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.ring.Owner(key)
+}
+
+// write applies the command on the node owning its keys. A command forwarded to this node is applied here even if
+// this node doesn't think it is the owner, so it is never forwarded again.
+func (s *Store) write(cmd command, forward bool) ([]*model.KeyValue, error) {
+	owner := s.owner(cmd.Ops[0].Key)
+	for _, o := range cmd.Ops[1:] {
+		if s.owner(o.Key) != owner {
+			return nil, fmt.Errorf("%w: every key in a batch must be owned by the same node, %q and %q aren't",
+				model.ErrInvalidArgument, cmd.Ops[0].Key, o.Key)
+		}
+	}
+
+	if forward && owner != s.id {
+		return s.forwardWrite(owner, cmd)
+	}
+	return s.writeOwned(cmd)
+}
+
+// writeOwned logs the command then applies it to the store, like the APIs do in front of a single node. A conditional
+// write to a key that may not have been moved here yet is rejected, as its precondition can't be checked until then.
+func (s *Store) writeOwned(cmd command) ([]*model.KeyValue, error) {
+	keys := make([]string, len(cmd.Ops))
+	for i, o := range cmd.Ops {
+		if conditional(o.Precondition) && s.moving(o.Key) {
+			return nil, fmt.Errorf("%w: key %q is still being moved to this node", model.ErrUnavailable, o.Key)
+		}
+		keys[i] = o.Key
+	}
+	s.touch(keys)
+
+	// PutIf can't keep flags, so a put with them is written as a batch
+	if len(cmd.Ops) == 1 && cmd.Ops[0].Flags == 0 {
+		o := cmd.Ops[0]
+		if o.Type == model.OpDelete {
+			if err := s.store.DeleteIf(o.Key, o.Precondition, api.LogDelete(s.logger)); err != nil {
+				return nil, err
+			}
+			return []*model.KeyValue{{Key: o.Key}}, nil
+		}
+
+		kv, err := s.store.PutIf(o.Key, o.Value, o.Expires, o.Precondition, api.LogPut(s.logger))
+		if err != nil {
+			return nil, err
+		}
+		return []*model.KeyValue{kv}, nil
+	}
+
+	ops := make([]model.BatchOp, len(cmd.Ops))
+	for i, o := range cmd.Ops {
+		ops[i] = model.BatchOp{
+			Type:         o.Type,
+			Key:          o.Key,
+			Value:        o.Value,
+			Expires:      o.Expires,
+			Flags:        o.Flags,
+			Precondition: o.Precondition,
+		}
+	}
+
+	return s.store.Batch(ops, api.LogBatch(s.logger))
+}
+
+// getOwned reads a key this node owns, asking its previous owner if it hasn't been moved here yet.
+func (s *Store) getOwned(key string) (*model.KeyValue, error) {
+	kv, err := s.store.GetKeyValue(key)
+	if !errors.Is(err, model.ErrKeyNotFound) {
+		return kv, err
+	}
+
+	previous := s.movingFrom(key)
+	if previous == "" {
+		return nil, err
+	}
+	return s.forwardGet(previous, key, true)
+}
+
+// movingFrom returns the previous owner of the key if it hasn't finished moving keys here, otherwise "".
+func (s *Store) movingFrom(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.previous == nil {
+		return ""
+	}
+	previous := s.previous.Owner(key)
+	if previous == s.id || !s.expecting[previous] {
+		return ""
+	}
+	return previous
+}
+
+// moving reports whether the key may still be on its previous owner, it isn't here and that node hasn't finished
+// moving keys here.
+func (s *Store) moving(key string) bool {
+	if _, err := s.store.GetKeyValue(key); !errors.Is(err, model.ErrKeyNotFound) {
+		return false
+	}
+	return s.movingFrom(key) != ""
+}
+
+// conditional reports whether the precondition restricts the key's version or existence.
+func conditional(pre model.Precondition) bool {
+	return len(pre.IfMatch) > 0 || pre.IfMatchAny || len(pre.IfNoneMatch) > 0 || pre.IfNoneMatchAny
+}
+
+// touch records the keys as written here since the last membership change.
+func (s *Store) touch(keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.touched == nil {
+		return
+	}
+	for _, key := range keys {
+		s.touched[key] = true
+	}
+}
+
+// nodeIDs returns the IDs of the peers.
+func nodeIDs(peers map[string]string) []string {
+	ids := make([]string, 0, len(peers))
+	for id := range peers {
+		ids = append(ids, id)
+	}
+	return ids
+}
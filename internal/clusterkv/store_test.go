@@ -0,0 +1,387 @@
+package clusterkv_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/clusterkv"
+	"github.com/warrenb95/cloud-native-go/internal/gossip"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/testutil"
+)
+
+const (
+	waitTimeout  = 5 * time.Second
+	pollInterval = 10 * time.Millisecond
+)
+
+type node struct {
+	id     string
+	kv     *clusterkv.Store
+	store  *store.Store
+	logger *testutil.Logger
+	addr   string
+	// members is the node's gossip, if the cluster follows it.
+	members *gossip.Memberlist
+}
+
+// cluster runs partitioned stores over loopback HTTP.
+type cluster struct {
+	t     *testing.T
+	nodes map[string]*node
+	peers map[string]string
+	// follow has the nodes' members follow gossip, joining through seed, the first node's gossip address.
+	follow bool
+	seed   string
+}
+
+func newCluster(t *testing.T, size int) *cluster {
+	return startCluster(&cluster{t: t}, size)
+}
+
+// newGossipCluster starts a cluster whose members follow gossip.
+func newGossipCluster(t *testing.T, size int) *cluster {
+	return startCluster(&cluster{t: t, follow: true}, size)
+}
+
+func startCluster(c *cluster, size int) *cluster {
+	c.nodes, c.peers = make(map[string]*node), make(map[string]string)
+
+	servers := make(map[string]*httptest.Server)
+	for i := 1; i <= size; i++ {
+		id := "n" + strconv.Itoa(i)
+		srv := httptest.NewUnstartedServer(nil)
+		servers[id] = srv
+		c.peers[id] = srv.Listener.Addr().String()
+	}
+	for id, srv := range servers {
+		c.start(id, srv)
+	}
+
+	return c
+}
+
+// join starts a node knowing the cluster's members but not yet one of them.
+func (c *cluster) join(id string) *node {
+	return c.start(id, httptest.NewUnstartedServer(nil))
+}
+
+func (c *cluster) start(id string, srv *httptest.Server) *node {
+	n := &node{
+		id:     id,
+		store:  store.New(map[string]interface{}{}),
+		logger: &testutil.Logger{},
+		addr:   srv.Listener.Addr().String(),
+	}
+
+	kv, err := clusterkv.New(clusterkv.Config{
+		ID:             id,
+		Peers:          c.peers,
+		VirtualNodes:   64,
+		ForwardTimeout: waitTimeout,
+	}, n.store, n.logger)
+	require.NoError(c.t, err)
+	n.kv = kv
+
+	srv.Config.Handler = kv.Handler()
+	srv.Start()
+	c.t.Cleanup(srv.Close)
+
+	if c.follow {
+		n.members = c.gossip(id, n.addr)
+		kv.Follow(n.members)
+	}
+
+	kv.Run()
+	logger := kv.Logger()
+	c.t.Cleanup(func() { logger.Close() })
+
+	if n.members != nil {
+		n.members.Join()
+		c.t.Cleanup(n.members.Leave)
+	}
+
+	c.nodes[id] = n
+	return n
+}
+
+// gossip creates the node's memberlist, advertising its cluster address. The first node's is the seed.
+func (c *cluster) gossip(id, addr string) *gossip.Memberlist {
+	// the port is picked before listening, as it is advertised
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(c.t, err)
+	gossipAddr := conn.LocalAddr().String()
+	conn.Close()
+
+	var seeds []string
+	if c.seed == "" {
+		c.seed = gossipAddr
+	} else {
+		seeds = []string{c.seed}
+	}
+
+	m, err := gossip.New(gossip.Config{
+		Name:             id,
+		Addr:             gossipAddr,
+		AdvertiseAddr:    gossipAddr,
+		Seeds:            seeds,
+		Tags:             map[string]string{clusterkv.MemberTag: addr},
+		ProbeInterval:    50 * time.Millisecond,
+		ProbeTimeout:     20 * time.Millisecond,
+		IndirectProbes:   1,
+		SuspicionTimeout: 250 * time.Millisecond,
+		SyncInterval:     time.Second,
+	})
+	require.NoError(c.t, err)
+
+	return m
+}
+
+// setMembers changes the members through the node's handler.
+func (c *cluster) setMembers(via string, peers map[string]string) {
+	data, err := json.Marshal(peers)
+	require.NoError(c.t, err)
+
+	req, err := http.NewRequest(http.MethodPut, "http://"+c.nodes[via].addr+"/clusterkv/members", bytes.NewReader(data))
+	require.NoError(c.t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(c.t, err)
+	resp.Body.Close()
+	require.Equal(c.t, http.StatusNoContent, resp.StatusCode)
+
+	c.peers = peers
+}
+
+// placed reports whether every key is held, and logged, only by its owner.
+func (c *cluster) placed(keys []string) bool {
+	for _, key := range keys {
+		owner := c.nodes["n1"].kv.Owner(key)
+		for id, n := range c.nodes {
+			_, err := n.store.Get(key)
+			if (err == nil) != (id == owner) {
+				return false
+			}
+		}
+	}
+	for _, n := range c.nodes {
+		for _, key := range n.logger.Live() {
+			if n.kv.Owner(key) != n.id {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func putKeys(t *testing.T, kv *clusterkv.Store, count int) []string {
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = "user:" + strconv.Itoa(i)
+		_, err := kv.PutIf(keys[i], "value of "+keys[i], time.Time{}, model.Precondition{}, nil)
+		require.NoError(t, err)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestStore_Routing(t *testing.T) {
+	c := newCluster(t, 3)
+	keys := putKeys(t, c.nodes["n1"].kv, 100)
+
+	// every key is held and logged by its owner alone, and can be read from any node
+	assert.True(t, c.placed(keys))
+	for id, n := range c.nodes {
+		assert.NotEmpty(t, n.store.Keys(), "keys owned by %s", id)
+		for _, key := range keys {
+			kv, err := n.kv.GetKeyValue(key)
+			require.NoError(t, err, key)
+			assert.Equal(t, "value of "+key, kv.Value)
+		}
+	}
+	assert.NotZero(t, c.nodes["n1"].kv.Forwarded())
+
+	// a precondition is checked against the owner's version
+	_, err := c.nodes["n2"].kv.PutIf(keys[0], "changed", time.Time{}, model.Precondition{IfNoneMatchAny: true}, nil)
+	assert.ErrorIs(t, err, model.ErrPreconditionFailed)
+
+	require.NoError(t, c.nodes["n3"].kv.DeleteIf(keys[0], model.Precondition{}, nil))
+	for _, n := range c.nodes {
+		_, err := n.kv.GetKeyValue(keys[0])
+		assert.ErrorIs(t, err, model.ErrKeyNotFound)
+	}
+}
+
+func TestStore_Batch(t *testing.T) {
+	c := newCluster(t, 3)
+	kv := c.nodes["n1"].kv
+
+	// find keys owned by the same node and one owned by another
+	var same []string
+	other := ""
+	for i := 0; len(same) < 2 || other == ""; i++ {
+		key := "user:" + strconv.Itoa(i)
+		switch {
+		case kv.Owner(key) == "n2" && len(same) < 2:
+			same = append(same, key)
+		case kv.Owner(key) != "n2" && other == "":
+			other = key
+		}
+	}
+
+	tests := map[string]struct {
+		keys        []string
+		expectedErr error
+	}{
+		"same owner": {
+			keys: same,
+		},
+		"different owners": {
+			keys:        []string{same[0], other},
+			expectedErr: model.ErrInvalidArgument,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ops := make([]model.BatchOp, len(test.keys))
+			for i, key := range test.keys {
+				ops[i] = model.BatchOp{Type: model.OpPut, Key: key, Value: name}
+			}
+
+			kvs, err := kv.Batch(ops, nil)
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, kvs, len(test.keys))
+			assert.True(t, c.placed(test.keys))
+		})
+	}
+}
+
+func TestStore_List(t *testing.T) {
+	c := newCluster(t, 3)
+	keys := putKeys(t, c.nodes["n1"].kv, 50)
+	kv := c.nodes["n2"].kv
+
+	assert.Equal(t, keys, kv.Keys())
+
+	// paging through the merged listing returns every key once, in order
+	var listed []string
+	opts := model.ListOptions{Prefix: "user:", Limit: 7}
+	for {
+		kvs, next := kv.List(opts)
+		assert.LessOrEqual(t, len(kvs), opts.Limit)
+		for _, kv := range kvs {
+			listed = append(listed, kv.Key)
+		}
+		if next == "" {
+			break
+		}
+		opts.Start = next
+	}
+	assert.Equal(t, keys, listed)
+}
+
+func TestStore_SetMembers(t *testing.T) {
+	tests := map[string]struct {
+		change func(c *cluster) map[string]string
+	}{
+		"node added": {
+			change: func(c *cluster) map[string]string {
+				n4 := c.join("n4")
+				peers := map[string]string{"n4": n4.addr}
+				for id, addr := range c.peers {
+					peers[id] = addr
+				}
+				return peers
+			},
+		},
+		"node removed": {
+			change: func(c *cluster) map[string]string {
+				return map[string]string{"n1": c.peers["n1"], "n2": c.peers["n2"]}
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newCluster(t, 3)
+			keys := putKeys(t, c.nodes["n1"].kv, 200)
+
+			before := make(map[string]string)
+			for _, key := range keys {
+				before[key] = c.nodes["n1"].kv.Owner(key)
+			}
+
+			c.setMembers("n1", test.change(c))
+
+			// every key ends up on its new owner, only the keys changing owner are moved
+			require.Eventually(t, func() bool {
+				return c.placed(keys)
+			}, waitTimeout, pollInterval)
+
+			moved := 0
+			for _, key := range keys {
+				if c.nodes["n1"].kv.Owner(key) != before[key] {
+					moved++
+				}
+			}
+			assert.NotZero(t, moved)
+			var transferred uint64
+			for _, n := range c.nodes {
+				transferred += n.kv.Transferred()
+			}
+			assert.EqualValues(t, moved, transferred)
+
+			for id, n := range c.nodes {
+				assert.Equal(t, c.peers, n.kv.Members(), id)
+				for _, key := range keys {
+					_, err := n.kv.GetKeyValue(key)
+					require.NoError(t, err, key)
+				}
+			}
+		})
+	}
+}
+
+func TestStore_Follow(t *testing.T) {
+	c := newGossipCluster(t, 3)
+	keys := putKeys(t, c.nodes["n1"].kv, 200)
+
+	// a node joining adds itself, and is added by every other member once they hear of it
+	n4 := c.join("n4")
+	peers := map[string]string{"n4": n4.addr}
+	for id, addr := range c.peers {
+		peers[id] = addr
+	}
+	c.peers = peers
+
+	require.Eventually(t, func() bool {
+		for _, n := range c.nodes {
+			if !assert.ObjectsAreEqual(c.peers, n.kv.Members()) {
+				return false
+			}
+		}
+		return c.placed(keys)
+	}, waitTimeout, pollInterval)
+
+	// a node leaving is removed by the others
+	n4.members.Leave()
+	delete(c.peers, "n4")
+	for _, id := range []string{"n1", "n2", "n3"} {
+		n := c.nodes[id]
+		require.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual(c.peers, n.kv.Members())
+		}, waitTimeout, pollInterval, id)
+	}
+}
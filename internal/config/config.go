@@ -0,0 +1,588 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/merkle"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/tlsconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// Logger backends.
+const (
+	BackendFile     = "file"
+	BackendPostgres = "postgres"
+)
+
+// Config holds every setting for the server. Each field can be set in the YAML file,
+// then overridden by an environment variable, see Load.
+type Config struct {
+	Listener    ListenerConfig    `yaml:"listener"`
+	TLS         TLSConfig         `yaml:"tls"`
+	Store       StoreConfig       `yaml:"store"`
+	Cache       CacheConfig       `yaml:"cache"`
+	Throttle    ThrottleConfig    `yaml:"throttle"`
+	Watch       WatchConfig       `yaml:"watch"`
+	Logger      LoggerConfig      `yaml:"logger"`
+	Raft        RaftConfig        `yaml:"raft"`
+	Replication ReplicationConfig `yaml:"replication"`
+	Cluster     ClusterConfig     `yaml:"cluster"`
+	Gossip      GossipConfig      `yaml:"gossip"`
+	Repair      RepairConfig      `yaml:"repair"`
+}
+
+type ListenerConfig struct {
+	Addr string `yaml:"addr"`
+	// GRPCAddr serves the gRPC API, which is disabled if it is empty.
+	GRPCAddr string `yaml:"grpc_addr"`
+	// RedisAddr serves a subset of the Redis protocol, which is disabled if it is empty.
+	RedisAddr string `yaml:"redis_addr"`
+	// MemcachedAddr serves the memcached text protocol, which is disabled if it is empty.
+	MemcachedAddr string `yaml:"memcached_addr"`
+	// ShutdownTimeout is how long in-flight requests get to finish once a shutdown signal is received.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// TLSConfig enables TLS when a cert and key file are set.
+type TLSConfig struct {
+	CertFile     string   `yaml:"cert_file"`
+	KeyFile      string   `yaml:"key_file"`
+	ClientCAFile string   `yaml:"client_ca_file"`
+	ClientAuth   string   `yaml:"client_auth"`
+	MinVersion   string   `yaml:"min_version"`
+	CipherSuites []string `yaml:"cipher_suites"`
+	// ReloadInterval is how often the certificates are checked for rotation.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+type StoreConfig struct {
+	// Engine is either "map" or "skiplist", see store.Engine.
+	Engine string `yaml:"engine"`
+	// Shards is how many independently locked parts the keys are spread over.
+	Shards int `yaml:"shards"`
+	// ReapInterval is how often expired keys are removed.
+	ReapInterval time.Duration `yaml:"reap_interval"`
+}
+
+type CacheConfig struct {
+	Capacity int `yaml:"capacity"`
+}
+
+type ThrottleConfig struct {
+	Max      uint          `yaml:"max"`
+	Refill   uint          `yaml:"refill"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+type WatchConfig struct {
+	// History is how many of the most recent events are kept for watchers to resume from.
+	History int `yaml:"history"`
+	// KeepAlive is how often an idle watch stream is sent a comment so proxies don't close it.
+	KeepAlive time.Duration `yaml:"keep_alive"`
+}
+
+// RaftConfig replicates the store across a cluster when a node ID is set, in place of the transaction logger.
+type RaftConfig struct {
+	NodeID string `yaml:"node_id"`
+	// Addr serves Raft traffic and writes forwarded to the leader, it must only be reachable by the peers.
+	Addr string `yaml:"addr"`
+	// Peers lists every node in the cluster, this one included, as the node's ID and its Addr, e.g. "n1=10.0.0.1:7000".
+	Peers []string `yaml:"peers"`
+	// Dir holds the node's Raft log and vote.
+	Dir               string        `yaml:"dir"`
+	ElectionTimeout   time.Duration `yaml:"election_timeout"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	// CommitTimeout is how long a write waits to be committed by the cluster.
+	CommitTimeout time.Duration `yaml:"commit_timeout"`
+}
+
+// ReplicationConfig streams the store's changes to read replicas. A node given a primary is a replica, serving reads
+// of the primary's store in place of its own transaction logger.
+type ReplicationConfig struct {
+	// Primary is the base URL of the primary's REST API, e.g. "http://10.0.0.1:8080".
+	Primary string `yaml:"primary"`
+	// HeartbeatInterval is how often the primary tells its replicas its last sequence.
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	// Timeout is how long a replica waits to hear from the primary before reconnecting.
+	Timeout       time.Duration `yaml:"timeout"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+// ClusterConfig partitions the keys across a cluster of nodes when a node ID is set, each logging the writes to the
+// keys it owns with its own transaction logger.
+type ClusterConfig struct {
+	NodeID string `yaml:"node_id"`
+	// Addr serves requests forwarded by the peers and membership changes, it must only be reachable by the cluster.
+	Addr string `yaml:"addr"`
+	// Peers lists every node in the cluster as the node's ID and its Addr, e.g. "n1=10.0.0.1:7100". A node joining
+	// the cluster is started with the current members, then added to them.
+	Peers []string `yaml:"peers"`
+	// VirtualNodes is how many points each node has on the hash ring.
+	VirtualNodes int `yaml:"virtual_nodes"`
+	// ForwardTimeout is how long a request forwarded to the node owning the key may take.
+	ForwardTimeout time.Duration `yaml:"forward_timeout"`
+}
+
+// GossipConfig tracks the cluster's members and detects failed nodes over UDP when a node name is set. A partitioned
+// cluster's members follow it, so the node name must be the cluster node ID and a "cluster" tag its cluster address.
+type GossipConfig struct {
+	NodeName string `yaml:"node_name"`
+	// Addr is the UDP address gossip is received on.
+	Addr string `yaml:"addr"`
+	// AdvertiseAddr is the host:port the other members send gossip to, Addr if it is empty.
+	AdvertiseAddr string `yaml:"advertise_addr"`
+	// Seeds are the gossip addresses of members to join through.
+	Seeds []string `yaml:"seeds"`
+	// Tags are advertised to the other members as key=value, e.g. "api=10.0.0.1:8080".
+	Tags []string `yaml:"tags"`
+	// ProbeInterval is how often a member is probed, ProbeTimeout how long it has to answer before it is probed
+	// through others.
+	ProbeInterval  time.Duration `yaml:"probe_interval"`
+	ProbeTimeout   time.Duration `yaml:"probe_timeout"`
+	IndirectProbes int           `yaml:"indirect_probes"`
+	// SuspicionTimeout is how long a suspected member has to refute it before it is declared dead.
+	SuspicionTimeout time.Duration `yaml:"suspicion_timeout"`
+	// SyncInterval is how often the whole member list is exchanged with a member or seed.
+	SyncInterval time.Duration `yaml:"sync_interval"`
+}
+
+// RepairConfig compares the store with each peer's in the background and copies over the later version of any key
+// that differs, when peers are set. Every peer must hold the same keys and use the same depth.
+type RepairConfig struct {
+	// Peers are the base URLs of the peers' REST APIs, e.g. "http://10.0.0.2:8080".
+	Peers    []string      `yaml:"peers"`
+	Interval time.Duration `yaml:"interval"`
+	// Depth is how many levels the Merkle tree has below its root, splitting the keys into 2^depth buckets.
+	Depth int `yaml:"depth"`
+	// TombstoneTTL is how long a deleted key is remembered, a peer that hasn't repaired within it gets the key back.
+	TombstoneTTL time.Duration `yaml:"tombstone_ttl"`
+	// Timeout bounds each repair from a peer.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type LoggerConfig struct {
+	// Backend is either "file" or "postgres".
+	Backend  string               `yaml:"backend"`
+	File     FileLoggerConfig     `yaml:"file"`
+	Postgres PostgresLoggerConfig `yaml:"postgres"`
+}
+
+type FileLoggerConfig struct {
+	Dir                string        `yaml:"dir"`
+	LegacyFilename     string        `yaml:"legacy_filename"`
+	MaxSegmentSize     int64         `yaml:"max_segment_size"`
+	MaxSegmentAge      time.Duration `yaml:"max_segment_age"`
+	Durability         string        `yaml:"durability"`
+	SyncInterval       time.Duration `yaml:"sync_interval"`
+	CompactionInterval time.Duration `yaml:"compaction_interval"`
+}
+
+type PostgresLoggerConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	DBName   string `yaml:"dbname"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// Default returns the settings used for anything missing from the file and environment.
+func Default() Config {
+	return Config{
+		Listener: ListenerConfig{
+			Addr:            ":8080",
+			GRPCAddr:        ":9090",
+			ShutdownTimeout: 20 * time.Second,
+		},
+		TLS: TLSConfig{
+			ReloadInterval: 30 * time.Second,
+		},
+		Store: StoreConfig{
+			Engine:       string(store.EngineMap),
+			Shards:       1,
+			ReapInterval: time.Second,
+		},
+		Cache: CacheConfig{
+			Capacity: 25,
+		},
+		Throttle: ThrottleConfig{
+			Max:      20,
+			Refill:   1,
+			Interval: time.Second,
+		},
+		Watch: WatchConfig{
+			History:   1000,
+			KeepAlive: 15 * time.Second,
+		},
+		Logger: LoggerConfig{
+			Backend: BackendFile,
+			File: FileLoggerConfig{
+				Dir:                "transaction-log",
+				LegacyFilename:     "transaction.log",
+				MaxSegmentSize:     64 << 20,
+				MaxSegmentAge:      24 * time.Hour,
+				Durability:         string(store.DurabilityEveryWrite),
+				SyncInterval:       time.Second,
+				CompactionInterval: 5 * time.Minute,
+			},
+			Postgres: PostgresLoggerConfig{
+				Host: "localhost",
+				Port: "5432",
+			},
+		},
+		Raft: RaftConfig{
+			Addr:              ":7000",
+			Dir:               "raft",
+			ElectionTimeout:   time.Second,
+			HeartbeatInterval: 100 * time.Millisecond,
+			CommitTimeout:     5 * time.Second,
+		},
+		Replication: ReplicationConfig{
+			HeartbeatInterval: time.Second,
+			Timeout:           5 * time.Second,
+			RetryInterval:     time.Second,
+		},
+		Cluster: ClusterConfig{
+			Addr:           ":7100",
+			VirtualNodes:   128,
+			ForwardTimeout: 5 * time.Second,
+		},
+		Gossip: GossipConfig{
+			Addr:             ":7946",
+			ProbeInterval:    time.Second,
+			ProbeTimeout:     500 * time.Millisecond,
+			IndirectProbes:   3,
+			SuspicionTimeout: 5 * time.Second,
+			SyncInterval:     30 * time.Second,
+		},
+		Repair: RepairConfig{
+			Interval:     time.Minute,
+			Depth:        10,
+			TombstoneTTL: 24 * time.Hour,
+			Timeout:      10 * time.Second,
+		},
+	}
+}
+
+// Load reads the config from the YAML file, if filename isn't empty, on top of the defaults.
+// Environment variables then override individual settings, named by KVS_ followed by the
+// upper-cased YAML path, e.g. KVS_LISTENER_ADDR or KVS_LOGGER_POSTGRES_PASSWORD.
+// List values in the environment are comma separated. The result is validated before it is returned.
+func Load(filename string) (Config, error) {
+	config := Default()
+
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read config: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		// typos in the file would otherwise silently fall back to the defaults
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("failed to parse config %s: %w", filename, err)
+		}
+	}
+
+	if err := applyEnv(&config, os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Listener.Addr != "", "listener.addr must be set")
+	// the optional listeners can't share a port with each other or the REST API
+	addrs := []struct{ name, addr string }{
+		{"listener.addr", c.Listener.Addr},
+		{"listener.grpc_addr", c.Listener.GRPCAddr},
+		{"listener.redis_addr", c.Listener.RedisAddr},
+		{"listener.memcached_addr", c.Listener.MemcachedAddr},
+	}
+	if c.RaftEnabled() {
+		addrs = append(addrs, struct{ name, addr string }{"raft.addr", c.Raft.Addr})
+	}
+	if c.ClusterEnabled() {
+		addrs = append(addrs, struct{ name, addr string }{"cluster.addr", c.Cluster.Addr})
+	}
+	for i, a := range addrs {
+		for _, b := range addrs[:i] {
+			check(a.addr == "" || a.addr != b.addr, "%s must differ from %s", a.name, b.name)
+		}
+	}
+	check(c.Listener.ShutdownTimeout > 0, "listener.shutdown_timeout must be positive")
+
+	if c.TLSEnabled() {
+		if err := c.TLSConfig().Validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+		check(c.TLS.ReloadInterval > 0, "tls.reload_interval must be positive")
+	} else {
+		check(c.TLS.CertFile == "" && c.TLS.KeyFile == "", "tls.cert_file and tls.key_file must be set together")
+	}
+
+	if _, err := store.ParseEngine(c.Store.Engine); err != nil {
+		problems = append(problems, "store.engine: "+err.Error())
+	}
+	check(c.Store.Shards > 0, "store.shards must be positive")
+	check(c.Store.ReapInterval > 0, "store.reap_interval must be positive")
+	check(c.Cache.Capacity > 0, "cache.capacity must be positive")
+
+	check(c.Throttle.Max > 0, "throttle.max must be positive")
+	check(c.Throttle.Refill > 0, "throttle.refill must be positive")
+	check(c.Throttle.Interval > 0, "throttle.interval must be positive")
+
+	check(c.Watch.History > 0, "watch.history must be positive")
+	check(c.Watch.KeepAlive > 0, "watch.keep_alive must be positive")
+
+	switch c.Logger.Backend {
+	case BackendFile:
+		file := c.Logger.File
+		check(file.Dir != "", "logger.file.dir must be set")
+		check(file.MaxSegmentSize >= 0, "logger.file.max_segment_size must not be negative")
+		check(file.MaxSegmentAge >= 0, "logger.file.max_segment_age must not be negative")
+		check(file.CompactionInterval > 0, "logger.file.compaction_interval must be positive")
+
+		durability, err := store.ParseDurability(file.Durability)
+		if err != nil {
+			problems = append(problems, "logger.file.durability: "+err.Error())
+		}
+		check(durability != store.DurabilityInterval || file.SyncInterval > 0,
+			"logger.file.sync_interval must be positive when durability is %q", store.DurabilityInterval)
+	case BackendPostgres:
+		postgres := c.Logger.Postgres
+		check(postgres.Host != "", "logger.postgres.host must be set")
+		check(postgres.Port != "", "logger.postgres.port must be set")
+		check(postgres.DBName != "", "logger.postgres.dbname must be set")
+		check(postgres.User != "", "logger.postgres.user must be set")
+	default:
+		problems = append(problems, fmt.Sprintf("logger.backend %q must be %q or %q",
+			c.Logger.Backend, BackendFile, BackendPostgres))
+	}
+
+	if c.RaftEnabled() {
+		raft := c.Raft
+		check(raft.Addr != "", "raft.addr must be set")
+		check(raft.Dir != "", "raft.dir must be set")
+		check(raft.ElectionTimeout > 0, "raft.election_timeout must be positive")
+		check(raft.HeartbeatInterval > 0 && raft.HeartbeatInterval < raft.ElectionTimeout,
+			"raft.heartbeat_interval must be positive and less than raft.election_timeout")
+		check(raft.CommitTimeout > 0, "raft.commit_timeout must be positive")
+
+		peers, err := c.RaftPeers()
+		if err != nil {
+			problems = append(problems, "raft.peers: "+err.Error())
+		} else {
+			_, ok := peers[raft.NodeID]
+			check(ok, "raft.peers must include raft.node_id %q", raft.NodeID)
+		}
+	}
+
+	replication := c.Replication
+	check(replication.HeartbeatInterval > 0, "replication.heartbeat_interval must be positive")
+	if c.ReplicaEnabled() {
+		primary, err := url.Parse(replication.Primary)
+		check(err == nil && (primary.Scheme == "http" || primary.Scheme == "https") && primary.Host != "",
+			"replication.primary %q must be an http or https URL", replication.Primary)
+		check(!c.RaftEnabled(), "replication.primary and raft.node_id cannot both be set")
+		check(replication.Timeout > 0, "replication.timeout must be positive")
+		check(replication.RetryInterval > 0, "replication.retry_interval must be positive")
+	}
+
+	if c.ClusterEnabled() {
+		cluster := c.Cluster
+		check(cluster.Addr != "", "cluster.addr must be set")
+		check(cluster.VirtualNodes > 0, "cluster.virtual_nodes must be positive")
+		check(cluster.ForwardTimeout > 0, "cluster.forward_timeout must be positive")
+		check(!c.RaftEnabled(), "cluster.node_id and raft.node_id cannot both be set")
+		check(!c.ReplicaEnabled(), "cluster.node_id and replication.primary cannot both be set")
+
+		peers, err := c.ClusterPeers()
+		if err != nil {
+			problems = append(problems, "cluster.peers: "+err.Error())
+		} else {
+			check(len(peers) > 0, "cluster.peers must be set")
+		}
+	}
+
+	if c.GossipEnabled() {
+		gossip := c.Gossip
+		check(gossip.Addr != "", "gossip.addr must be set")
+		host, port, err := net.SplitHostPort(c.GossipAdvertiseAddr())
+		check(err == nil && host != "" && port != "",
+			"gossip.advertise_addr %q must be host:port, it must be set if gossip.addr has no host", c.GossipAdvertiseAddr())
+		check(gossip.ProbeInterval > 0, "gossip.probe_interval must be positive")
+		check(gossip.ProbeTimeout > 0 && gossip.ProbeTimeout < gossip.ProbeInterval,
+			"gossip.probe_timeout must be positive and less than gossip.probe_interval")
+		check(gossip.IndirectProbes >= 0, "gossip.indirect_probes must not be negative")
+		check(gossip.SuspicionTimeout > 0, "gossip.suspicion_timeout must be positive")
+		check(gossip.SyncInterval > 0, "gossip.sync_interval must be positive")
+
+		tags, err := c.GossipTags()
+		if err != nil {
+			problems = append(problems, "gossip.tags: "+err.Error())
+		}
+		// the cluster's members follow gossip, which names them and carries their addresses
+		if c.ClusterEnabled() {
+			check(gossip.NodeName == c.Cluster.NodeID, "gossip.node_name must match cluster.node_id")
+			check(err != nil || tags["cluster"] != "", "gossip.tags must include cluster=<cluster address>")
+		}
+	}
+
+	if c.RepairEnabled() {
+		repair := c.Repair
+		for _, peer := range repair.Peers {
+			u, err := url.Parse(peer)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+				"repair.peers %q must be an http or https URL", peer)
+		}
+		check(repair.Interval > 0, "repair.interval must be positive")
+		check(repair.Depth > 0 && repair.Depth <= merkle.MaxDepth, "repair.depth must be between 1 and %d", merkle.MaxDepth)
+		check(repair.TombstoneTTL > 0, "repair.tombstone_ttl must be positive")
+		check(repair.Timeout > 0, "repair.timeout must be positive")
+		check(!c.RaftEnabled(), "repair.peers and raft.node_id cannot both be set")
+		check(!c.ReplicaEnabled(), "repair.peers and replication.primary cannot both be set")
+		check(!c.ClusterEnabled(), "repair.peers and cluster.node_id cannot both be set")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// TLSEnabled reports whether the server should serve TLS.
+func (c Config) TLSEnabled() bool {
+	return c.TLS.CertFile != "" && c.TLS.KeyFile != ""
+}
+
+// RaftEnabled reports whether the store is replicated through Raft.
+func (c Config) RaftEnabled() bool {
+	return c.Raft.NodeID != ""
+}
+
+// ReplicaEnabled reports whether the store is a read replica of a primary.
+func (c Config) ReplicaEnabled() bool {
+	return c.Replication.Primary != ""
+}
+
+// ClusterEnabled reports whether the keys are partitioned across a cluster.
+func (c Config) ClusterEnabled() bool {
+	return c.Cluster.NodeID != ""
+}
+
+// GossipEnabled reports whether the node tracks the cluster's members by gossip.
+func (c Config) GossipEnabled() bool {
+	return c.Gossip.NodeName != ""
+}
+
+// RepairEnabled reports whether the store is repaired from peers in the background.
+func (c Config) RepairEnabled() bool {
+	return len(c.Repair.Peers) > 0
+}
+
+// GossipAdvertiseAddr returns the address the other members send gossip to.
+func (c Config) GossipAdvertiseAddr() string {
+	if c.Gossip.AdvertiseAddr != "" {
+		return c.Gossip.AdvertiseAddr
+	}
+	return c.Gossip.Addr
+}
+
+// GossipTags returns the tags advertised to the other members by key.
+func (c Config) GossipTags() (map[string]string, error) {
+	tags := make(map[string]string, len(c.Gossip.Tags))
+	for _, tag := range c.Gossip.Tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("tag %q must be key=value", tag)
+		}
+		tags[parts[0]] = parts[1]
+	}
+
+	return tags, nil
+}
+
+// RaftPeers returns the address of every node in the cluster by its ID.
+func (c Config) RaftPeers() (map[string]string, error) {
+	return parsePeers(c.Raft.Peers)
+}
+
+// ClusterPeers returns the address of every node in the cluster by its ID.
+func (c Config) ClusterPeers() (map[string]string, error) {
+	return parsePeers(c.Cluster.Peers)
+}
+
+// parsePeers parses peers listed as "id=host:port".
+func parsePeers(list []string) (map[string]string, error) {
+	peers := make(map[string]string, len(list))
+	for _, peer := range list {
+		parts := strings.SplitN(peer, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("peer %q must be id=host:port", peer)
+		}
+		if _, ok := peers[parts[0]]; ok {
+			return nil, fmt.Errorf("peer %q is listed more than once", parts[0])
+		}
+		peers[parts[0]] = parts[1]
+	}
+
+	return peers, nil
+}
+
+func (c Config) TLSConfig() tlsconfig.Config {
+	return tlsconfig.Config{
+		CertFile:     c.TLS.CertFile,
+		KeyFile:      c.TLS.KeyFile,
+		ClientCAFile: c.TLS.ClientCAFile,
+		ClientAuth:   tlsconfig.ClientAuth(c.TLS.ClientAuth),
+		MinVersion:   c.TLS.MinVersion,
+		CipherSuites: c.TLS.CipherSuites,
+	}
+}
+
+func (c Config) FileConfig() store.FileConfig {
+	file := c.Logger.File
+	return store.FileConfig{
+		Dir:            file.Dir,
+		LegacyFilename: file.LegacyFilename,
+		MaxSegmentSize: file.MaxSegmentSize,
+		MaxSegmentAge:  file.MaxSegmentAge,
+		Durability:     store.Durability(file.Durability),
+		SyncInterval:   file.SyncInterval,
+	}
+}
+
+func (c Config) PostgresConfig() store.PostgresConfig {
+	postgres := c.Logger.Postgres
+	return store.PostgresConfig{
+		Host:     postgres.Host,
+		Port:     postgres.Port,
+		DBName:   postgres.DBName,
+		User:     postgres.User,
+		Password: postgres.Password,
+	}
+}
@@ -0,0 +1,299 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	tests := map[string]struct {
+		file        string
+		env         map[string]string
+		expected    func(c *Config)
+		errContains []string
+	}{
+		"defaults": {
+			expected: func(c *Config) {},
+		},
+		"nothing set in file": {
+			file:     "# comments only\n",
+			expected: func(c *Config) {},
+		},
+		"file overrides defaults": {
+			file: `
+listener:
+  addr: ":8081"
+cache:
+  capacity: 100
+throttle:
+  interval: 500ms
+logger:
+  backend: postgres
+  postgres:
+    dbname: kvs
+    user: kvs
+`,
+			expected: func(c *Config) {
+				c.Listener.Addr = ":8081"
+				c.Cache.Capacity = 100
+				c.Throttle.Interval = 500 * time.Millisecond
+				c.Logger.Backend = BackendPostgres
+				c.Logger.Postgres.DBName = "kvs"
+				c.Logger.Postgres.User = "kvs"
+			},
+		},
+		"env overrides file": {
+			file: `
+listener:
+  addr: ":9090"
+`,
+			env: map[string]string{
+				"KVS_LISTENER_ADDR":                   ":7070",
+				"KVS_THROTTLE_MAX":                    "5",
+				"KVS_WATCH_KEEP_ALIVE":                "30s",
+				"KVS_LOGGER_FILE_MAX_SEGMENT_SIZE":    "1024",
+				"KVS_LOGGER_FILE_COMPACTION_INTERVAL": "1m",
+				"KVS_TLS_CERT_FILE":                   "server.pem",
+				"KVS_TLS_KEY_FILE":                    "server.key",
+				"KVS_TLS_CIPHER_SUITES":               "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+				"KVS_RAFT_NODE_ID":                    "n1",
+				"KVS_RAFT_PEERS":                      "n1=10.0.0.1:7000, n2=10.0.0.2:7000, n3=10.0.0.3:7000",
+				"KVS_REPLICATION_HEARTBEAT_INTERVAL":  "2s",
+			},
+			expected: func(c *Config) {
+				c.Listener.Addr = ":7070"
+				c.Throttle.Max = 5
+				c.Watch.KeepAlive = 30 * time.Second
+				c.Logger.File.MaxSegmentSize = 1024
+				c.Logger.File.CompactionInterval = time.Minute
+				c.TLS.CertFile = "server.pem"
+				c.TLS.KeyFile = "server.key"
+				c.TLS.CipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}
+				c.Raft.NodeID = "n1"
+				c.Raft.Peers = []string{"n1=10.0.0.1:7000", "n2=10.0.0.2:7000", "n3=10.0.0.3:7000"}
+				c.Replication.HeartbeatInterval = 2 * time.Second
+			},
+		},
+		"unknown field": {
+			file: `
+cache:
+  capacty: 100
+`,
+			errContains: []string{"field capacty not found"},
+		},
+		"invalid env value": {
+			env:         map[string]string{"KVS_CACHE_CAPACITY": "lots"},
+			errContains: []string{"invalid KVS_CACHE_CAPACITY"},
+		},
+		"every problem is reported": {
+			file: `
+listener:
+  addr: ""
+tls:
+  cert_file: server.pem
+store:
+  engine: btree
+  shards: 0
+cache:
+  capacity: 0
+watch:
+  history: 0
+logger:
+  file:
+    durability: sometimes
+raft:
+  node_id: n1
+  heartbeat_interval: 2s
+  peers: ["n2=10.0.0.2:7000"]
+replication:
+  primary: 10.0.0.1:8080
+  timeout: 0s
+cluster:
+  node_id: n1
+  virtual_nodes: 0
+  peers: ["n1"]
+gossip:
+  node_name: n1
+  probe_timeout: 2s
+  tags: ["api"]
+repair:
+  peers: ["10.0.0.2:8080"]
+  depth: 21
+`,
+			errContains: []string{
+				"listener.addr must be set",
+				"tls.cert_file and tls.key_file must be set together",
+				`store.engine: unknown engine "btree"`,
+				"store.shards must be positive",
+				"cache.capacity must be positive",
+				"watch.history must be positive",
+				`logger.file.durability: unknown durability "sometimes"`,
+				"raft.heartbeat_interval must be positive and less than raft.election_timeout",
+				`raft.peers must include raft.node_id "n1"`,
+				`replication.primary "10.0.0.1:8080" must be an http or https URL`,
+				"replication.primary and raft.node_id cannot both be set",
+				"replication.timeout must be positive",
+				"cluster.virtual_nodes must be positive",
+				"cluster.node_id and raft.node_id cannot both be set",
+				"cluster.node_id and replication.primary cannot both be set",
+				`cluster.peers: peer "n1" must be id=host:port`,
+				`gossip.advertise_addr ":7946" must be host:port, it must be set if gossip.addr has no host`,
+				"gossip.probe_timeout must be positive and less than gossip.probe_interval",
+				`gossip.tags: tag "api" must be key=value`,
+				`repair.peers "10.0.0.2:8080" must be an http or https URL`,
+				"repair.depth must be between 1 and 20",
+				"repair.peers and raft.node_id cannot both be set",
+				"repair.peers and replication.primary cannot both be set",
+				"repair.peers and cluster.node_id cannot both be set",
+			},
+		},
+		"invalid tls policy": {
+			env: map[string]string{
+				"KVS_TLS_CERT_FILE":   "server.pem",
+				"KVS_TLS_KEY_FILE":    "server.key",
+				"KVS_TLS_CLIENT_AUTH": "require",
+			},
+			errContains: []string{"needs a client CA file"},
+		},
+		"gossip names a different cluster node": {
+			env: map[string]string{
+				"KVS_CLUSTER_NODE_ID":  "n1",
+				"KVS_CLUSTER_PEERS":    "n1=10.0.0.1:7100",
+				"KVS_GOSSIP_NODE_NAME": "n2",
+				"KVS_GOSSIP_ADDR":      "10.0.0.2:7946",
+				"KVS_GOSSIP_TAGS":      "api=10.0.0.2:8080",
+			},
+			errContains: []string{
+				"gossip.node_name must match cluster.node_id",
+				"gossip.tags must include cluster=<cluster address>",
+			},
+		},
+		"redis listener clashes with grpc": {
+			env:         map[string]string{"KVS_LISTENER_REDIS_ADDR": ":9090"},
+			errContains: []string{"listener.redis_addr must differ from listener.grpc_addr"},
+		},
+		"memcached listener clashes with rest": {
+			env:         map[string]string{"KVS_LISTENER_MEMCACHED_ADDR": ":8080"},
+			errContains: []string{"listener.memcached_addr must differ from listener.addr"},
+		},
+		"raft listener clashes with rest": {
+			env: map[string]string{
+				"KVS_RAFT_NODE_ID": "n1",
+				"KVS_RAFT_ADDR":    ":8080",
+				"KVS_RAFT_PEERS":   "n1=localhost:8080",
+			},
+			errContains: []string{"raft.addr must differ from listener.addr"},
+		},
+		"invalid raft peer": {
+			env: map[string]string{
+				"KVS_RAFT_NODE_ID": "n1",
+				"KVS_RAFT_PEERS":   "n1=10.0.0.1:7000,n1=10.0.0.2:7000",
+			},
+			errContains: []string{`raft.peers: peer "n1" is listed more than once`},
+		},
+		"replica": {
+			env: map[string]string{
+				"KVS_REPLICATION_PRIMARY": "https://primary.internal:8080",
+				"KVS_REPLICATION_TIMEOUT": "10s",
+			},
+			expected: func(c *Config) {
+				c.Replication.Primary = "https://primary.internal:8080"
+				c.Replication.Timeout = 10 * time.Second
+			},
+		},
+		"cluster": {
+			env: map[string]string{
+				"KVS_CLUSTER_NODE_ID":       "n1",
+				"KVS_CLUSTER_PEERS":         "n1=10.0.0.1:7100, n2=10.0.0.2:7100",
+				"KVS_CLUSTER_VIRTUAL_NODES": "64",
+			},
+			expected: func(c *Config) {
+				c.Cluster.NodeID = "n1"
+				c.Cluster.Peers = []string{"n1=10.0.0.1:7100", "n2=10.0.0.2:7100"}
+				c.Cluster.VirtualNodes = 64
+			},
+		},
+		"cluster listener clashes with raft": {
+			env: map[string]string{
+				"KVS_RAFT_NODE_ID":    "n1",
+				"KVS_RAFT_PEERS":      "n1=localhost:7000",
+				"KVS_CLUSTER_NODE_ID": "n1",
+				"KVS_CLUSTER_ADDR":    ":7000",
+				"KVS_CLUSTER_PEERS":   "n1=localhost:7000",
+			},
+			errContains: []string{"cluster.addr must differ from raft.addr"},
+		},
+		"gossip": {
+			env: map[string]string{
+				"KVS_GOSSIP_NODE_NAME":      "n1",
+				"KVS_GOSSIP_ADVERTISE_ADDR": "10.0.0.1:7946",
+				"KVS_GOSSIP_SEEDS":          "10.0.0.2:7946, 10.0.0.3:7946",
+				"KVS_GOSSIP_TAGS":           "api=10.0.0.1:8080",
+			},
+			expected: func(c *Config) {
+				c.Gossip.NodeName = "n1"
+				c.Gossip.AdvertiseAddr = "10.0.0.1:7946"
+				c.Gossip.Seeds = []string{"10.0.0.2:7946", "10.0.0.3:7946"}
+				c.Gossip.Tags = []string{"api=10.0.0.1:8080"}
+			},
+		},
+		"repair": {
+			env: map[string]string{
+				"KVS_REPAIR_PEERS":         "http://10.0.0.2:8080, http://10.0.0.3:8080",
+				"KVS_REPAIR_DEPTH":         "12",
+				"KVS_REPAIR_TOMBSTONE_TTL": "1h",
+			},
+			expected: func(c *Config) {
+				c.Repair.Peers = []string{"http://10.0.0.2:8080", "http://10.0.0.3:8080"}
+				c.Repair.Depth = 12
+				c.Repair.TombstoneTTL = time.Hour
+			},
+		},
+		"unknown logger backend": {
+			env:         map[string]string{"KVS_LOGGER_BACKEND": "redis"},
+			errContains: []string{`logger.backend "redis" must be "file" or "postgres"`},
+		},
+		"postgres without database": {
+			env:         map[string]string{"KVS_LOGGER_BACKEND": "postgres"},
+			errContains: []string{"logger.postgres.dbname must be set", "logger.postgres.user must be set"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			var filename string
+			if test.file != "" {
+				filename = filepath.Join(t.TempDir(), "config.yaml")
+				require.NoError(t, os.WriteFile(filename, []byte(test.file), 0644))
+			}
+
+			config, err := Load(filename)
+			if len(test.errContains) > 0 {
+				require.Error(t, err)
+				for _, contains := range test.errContains {
+					assert.Contains(t, err.Error(), contains)
+				}
+				return
+			}
+			require.NoError(t, err)
+
+			expected := Default()
+			test.expected(&expected)
+			assert.Equal(t, expected, config)
+		})
+	}
+}
+
+func TestLoad_Example(t *testing.T) {
+	_, err := Load(filepath.Join("..", "..", "config.example.yaml"))
+	require.NoError(t, err)
+}
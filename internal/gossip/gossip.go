@@ -0,0 +1,315 @@
+// Package gossip keeps track of the nodes in a cluster with the SWIM protocol over UDP. Each node probes one member
+// at a time, asking others to probe it indirectly if it doesn't answer, and suspects a member that neither it nor
+// they can reach. A suspected member that doesn't refute the suspicion in time is declared dead. Changes in
+// membership are piggybacked on the probes, so they spread through the cluster without any extra messages.
+package gossip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxPacketSize caps the size of a message, the most a UDP datagram can carry.
+const maxPacketSize = 65507
+
+// State is what a node knows of a member's health.
+type State int
+
+const (
+	Alive State = iota
+	// Suspect members didn't answer a probe, directly or indirectly, and are declared dead unless they refute it.
+	Suspect
+	Dead
+	// Left members said they were leaving.
+	Left
+)
+
+func (s State) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	case Left:
+		return "left"
+	default:
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	for _, state := range []State{Alive, Suspect, Dead, Left} {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown member state %q", text)
+}
+
+// Member is a node in the cluster as this node knows it.
+type Member struct {
+	Name string `json:"name"`
+	// Addr is the address the member's gossip is sent to.
+	Addr  string `json:"addr"`
+	State State  `json:"state"`
+	// Incarnation is raised by the member to refute a suspicion, only news of a later incarnation replaces what is
+	// known of it.
+	Incarnation uint64 `json:"incarnation"`
+	// Tags are what the member advertises about itself, e.g. the addresses of its APIs.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Config configures a node's membership.
+type Config struct {
+	// Name identifies the node, it must be unique in the cluster.
+	Name string
+	// Addr is the UDP address to listen on.
+	Addr string
+	// AdvertiseAddr is the address the other members reach this node at.
+	AdvertiseAddr string
+	// Seeds are addresses of members to join the cluster through.
+	Seeds []string
+	Tags  map[string]string
+
+	// ProbeInterval is how often a member is probed, and ProbeTimeout how long it gets to answer before others are
+	// asked to probe it, which is to say how long they have to answer is the rest of the interval.
+	ProbeInterval time.Duration
+	ProbeTimeout  time.Duration
+	// IndirectProbes is how many members are asked to probe a member that didn't answer.
+	IndirectProbes int
+	// SuspicionTimeout is how long a suspected member has to refute it before it is declared dead.
+	SuspicionTimeout time.Duration
+	// SyncInterval is how often the whole member list is exchanged with a member or seed, healing partitions that
+	// the piggybacked changes can't.
+	SyncInterval time.Duration
+}
+
+// Memberlist is this node's view of the cluster.
+type Memberlist struct {
+	config Config
+	conn   net.PacketConn
+	// send writes a message to an address, over conn unless replaced by tests.
+	send func(addr string, data []byte) error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	members map[string]*Member
+	// probeOrder is the members left to probe this round, in a random order.
+	probeOrder []string
+	// suspicions declare a suspected member dead when they fire.
+	suspicions map[string]*time.Timer
+	queue      []*broadcast
+	seq        uint64
+	// acks are called with the sequence of probes as they are answered.
+	acks     map[uint64]func()
+	onChange func(Member)
+}
+
+// New listens on the configured address. Join must be called to start taking part in the cluster.
+func New(config Config) (*Memberlist, error) {
+	conn, err := net.ListenPacket("udp", config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for gossip: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Memberlist{
+		config:     config,
+		conn:       conn,
+		ctx:        ctx,
+		cancel:     cancel,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		members:    make(map[string]*Member),
+		suspicions: make(map[string]*time.Timer),
+		acks:       make(map[uint64]func()),
+	}
+	m.send = m.sendUDP
+
+	m.members[config.Name] = &Member{
+		Name:  config.Name,
+		Addr:  config.AdvertiseAddr,
+		State: Alive,
+		Tags:  config.Tags,
+	}
+
+	return m, nil
+}
+
+// OnChange registers fn to be called with every member whose state changes, including members joining. It must be
+// called before Join and fn must not block.
+func (m *Memberlist) OnChange(fn func(Member)) {
+	m.onChange = fn
+}
+
+// Join starts probing the members and exchanging the member list with the seeds, until one of them is reached.
+func (m *Memberlist) Join() {
+	m.wg.Add(2)
+	go func() {
+		defer m.wg.Done()
+		m.receive()
+	}()
+	go func() {
+		defer m.wg.Done()
+		m.run()
+	}()
+}
+
+// Leave tells some of the members this node is leaving, then stops taking part in the cluster.
+func (m *Memberlist) Leave() {
+	m.mu.Lock()
+	self := m.members[m.config.Name]
+	self.Incarnation++
+	self.State = Left
+	data, err := encode(message{Type: gossipMessage, From: m.config.Name, Updates: []Member{*self}})
+	targets := m.randomMembers(m.config.IndirectProbes+1, "")
+	m.mu.Unlock()
+
+	if err == nil {
+		for _, target := range targets {
+			if err := m.send(target.Addr, data); err != nil {
+				log.Printf("gossip: failed to tell %s this node is leaving: %v", target.Name, err)
+			}
+		}
+	}
+
+	m.cancel()
+	m.conn.Close()
+	m.wg.Wait()
+
+	m.mu.Lock()
+	for _, timer := range m.suspicions {
+		timer.Stop()
+	}
+	m.mu.Unlock()
+}
+
+// Members returns every member this node knows of, itself included and those that are dead or left, by name.
+func (m *Memberlist) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := make([]Member, 0, len(m.members))
+	for _, member := range m.members {
+		members = append(members, *member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+
+	return members
+}
+
+// Handler serves the member list as JSON.
+func (m *Memberlist) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Members []Member `json:"members"`
+		}{m.Members()})
+	})
+}
+
+// run probes a member every probe interval and syncs the member list every sync interval, syncing with the seeds
+// every probe interval while no other member is known.
+func (m *Memberlist) run() {
+	probe := time.NewTicker(m.config.ProbeInterval)
+	defer probe.Stop()
+	syncs := time.NewTicker(m.config.SyncInterval)
+	defer syncs.Stop()
+
+	m.sync()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-probe.C:
+			if m.alone() {
+				m.sync()
+				continue
+			}
+			m.probe()
+		case <-syncs.C:
+			m.sync()
+		}
+	}
+}
+
+// alone reports whether no other member is alive or suspected.
+func (m *Memberlist) alone() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, member := range m.members {
+		if name != m.config.Name && (member.State == Alive || member.State == Suspect) {
+			return false
+		}
+	}
+	return true
+}
+
+// receive handles every message read from the connection until it is closed.
+func (m *Memberlist) receive() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := m.conn.ReadFrom(buf)
+		if err != nil {
+			if m.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("gossip: failed to read: %v", err)
+			continue
+		}
+
+		var msg message
+		if err := json.Unmarshal(buf[:n], &msg); err != nil {
+			log.Printf("gossip: invalid message from %s: %v", from, err)
+			continue
+		}
+		m.handle(msg, from.String())
+	}
+}
+
+func (m *Memberlist) sendUDP(addr string, data []byte) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.conn.WriteTo(data, udpAddr)
+	return err
+}
+
+// randomMembers returns up to n alive members other than this node and the excluded one, chosen at random. The
+// caller must hold mu.
+func (m *Memberlist) randomMembers(n int, exclude string) []Member {
+	var candidates []Member
+	for name, member := range m.members {
+		if name != m.config.Name && name != exclude && member.State == Alive {
+			candidates = append(candidates, *member)
+		}
+	}
+	m.rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
@@ -0,0 +1,242 @@
+package gossip
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	waitTimeout  = 5 * time.Second
+	pollInterval = 10 * time.Millisecond
+)
+
+// node is a member of a test cluster recording the changes it sees.
+type node struct {
+	*Memberlist
+
+	mu      sync.Mutex
+	changes []Member
+	// blocked are addresses messages from this node are dropped to.
+	blocked map[string]bool
+}
+
+func (n *node) Changes() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]Member(nil), n.changes...)
+}
+
+func (n *node) block(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.blocked[addr] = true
+}
+
+// state returns the state of the named member as the node knows it.
+func (n *node) state(name string) (State, bool) {
+	for _, member := range n.Members() {
+		if member.Name == name {
+			return member.State, true
+		}
+	}
+	return 0, false
+}
+
+// crash stops the node without telling anyone.
+func (n *node) crash() {
+	n.cancel()
+	n.conn.Close()
+	n.wg.Wait()
+}
+
+func newNode(t *testing.T, name string, indirectProbes int, seeds ...string) *node {
+	m, err := New(Config{
+		Name:             name,
+		Addr:             "127.0.0.1:0",
+		Seeds:            seeds,
+		Tags:             map[string]string{"api": name + ":8080"},
+		ProbeInterval:    50 * time.Millisecond,
+		ProbeTimeout:     20 * time.Millisecond,
+		IndirectProbes:   indirectProbes,
+		SuspicionTimeout: 250 * time.Millisecond,
+		SyncInterval:     time.Second,
+	})
+	require.NoError(t, err)
+
+	// the port is only known once listening
+	m.config.AdvertiseAddr = m.conn.LocalAddr().String()
+	m.members[name].Addr = m.config.AdvertiseAddr
+
+	n := &node{Memberlist: m, blocked: make(map[string]bool)}
+	m.send = func(addr string, data []byte) error {
+		n.mu.Lock()
+		blocked := n.blocked[addr]
+		n.mu.Unlock()
+
+		if blocked {
+			return nil
+		}
+		return m.sendUDP(addr, data)
+	}
+	m.OnChange(func(member Member) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		n.changes = append(n.changes, member)
+	})
+
+	m.Join()
+	t.Cleanup(func() {
+		if m.ctx.Err() == nil {
+			m.Leave()
+		}
+	})
+
+	return n
+}
+
+func newCluster(t *testing.T, size, indirectProbes int) []*node {
+	nodes := []*node{newNode(t, "n1", indirectProbes)}
+	for i := 2; i <= size; i++ {
+		nodes = append(nodes, newNode(t, "n"+strconv.Itoa(i), indirectProbes, nodes[0].config.AdvertiseAddr))
+	}
+
+	// every node learns of every other from the seed and the piggybacked changes
+	require.Eventually(t, func() bool {
+		for _, n := range nodes {
+			alive := 0
+			for _, member := range n.Members() {
+				if member.State == Alive {
+					alive++
+				}
+			}
+			if alive != size {
+				return false
+			}
+		}
+		return true
+	}, waitTimeout, pollInterval)
+
+	return nodes
+}
+
+func TestMemberlist_Join(t *testing.T) {
+	nodes := newCluster(t, 4, 2)
+
+	rec := httptest.NewRecorder()
+	nodes[3].Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/v1/_members", nil))
+
+	var body struct {
+		Members []Member `json:"members"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(t, body.Members, 4)
+	for i, member := range body.Members {
+		n := nodes[i]
+		assert.Equal(t, n.config.Name, member.Name)
+		assert.Equal(t, n.config.AdvertiseAddr, member.Addr)
+		assert.Equal(t, Alive, member.State)
+		assert.Equal(t, n.config.Name+":8080", member.Tags["api"])
+	}
+}
+
+func TestMemberlist_Failure(t *testing.T) {
+	nodes := newCluster(t, 3, 2)
+	nodes[2].crash()
+
+	// a member that stops answering is suspected, then declared dead
+	for _, n := range nodes[:2] {
+		require.Eventually(t, func() bool {
+			state, _ := n.state("n3")
+			return state == Dead
+		}, waitTimeout, pollInterval)
+
+		var states []State
+		for _, change := range n.Changes() {
+			if change.Name == "n3" {
+				states = append(states, change.State)
+			}
+		}
+		assert.Equal(t, []State{Alive, Suspect, Dead}, states[len(states)-3:])
+	}
+}
+
+func TestMemberlist_IndirectProbes(t *testing.T) {
+	tests := map[string]struct {
+		indirectProbes int
+		suspected      bool
+	}{
+		"probed through another member": {
+			indirectProbes: 1,
+		},
+		"without indirect probes": {
+			indirectProbes: 0,
+			// suspected by n1, then refuted by n3 once it hears of it through n2
+			suspected: true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			nodes := newCluster(t, 3, test.indirectProbes)
+
+			// n1 and n3 can't reach each other directly
+			nodes[0].block(nodes[2].config.AdvertiseAddr)
+			nodes[2].block(nodes[0].config.AdvertiseAddr)
+			time.Sleep(time.Second)
+
+			suspected := false
+			for _, change := range nodes[0].Changes() {
+				if change.Name == "n3" && change.State == Suspect {
+					suspected = true
+				}
+			}
+			assert.Equal(t, test.suspected, suspected)
+
+			// a suspicion refuted in time never leads to the member being declared dead
+			for _, n := range nodes {
+				for _, member := range n.Members() {
+					assert.NotEqual(t, Dead, member.State, "%s as seen by %s", member.Name, n.config.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestMemberlist_Leave(t *testing.T) {
+	nodes := newCluster(t, 3, 2)
+	nodes[2].Leave()
+
+	for _, n := range nodes[:2] {
+		require.Eventually(t, func() bool {
+			state, _ := n.state("n3")
+			return state == Left
+		}, waitTimeout, pollInterval)
+	}
+}
+
+func TestMemberlist_Rejoin(t *testing.T) {
+	nodes := newCluster(t, 3, 2)
+	nodes[2].crash()
+	require.Eventually(t, func() bool {
+		state, _ := nodes[0].state("n3")
+		return state == Dead
+	}, waitTimeout, pollInterval)
+
+	// a node restarted under the same name refutes its death with a later incarnation
+	newNode(t, "n3", 2, nodes[0].config.AdvertiseAddr)
+	for _, n := range nodes[:2] {
+		require.Eventually(t, func() bool {
+			state, _ := n.state("n3")
+			return state == Alive
+		}, waitTimeout, pollInterval)
+	}
+}
@@ -0,0 +1,247 @@
+package gossip
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// maxPiggyback caps how many changes in membership are piggybacked on a message.
+	maxPiggyback = 8
+	// retransmitMult scales how many times a change is piggybacked, by the log of the cluster's size, so it reaches
+	// every member with high probability.
+	retransmitMult = 4
+)
+
+type messageType string
+
+const (
+	// ping probes a member, which acks it.
+	pingMessage messageType = "ping"
+	ackMessage  messageType = "ack"
+	// pingReq asks a member to probe Target for the sender, acking it if Target acks.
+	pingReqMessage messageType = "ping_req"
+	// sync sends the whole member list, answered with syncReply carrying the receiver's.
+	syncMessage      messageType = "sync"
+	syncReplyMessage messageType = "sync_reply"
+	// gossip only carries changes in membership.
+	gossipMessage messageType = "gossip"
+)
+
+// message is the JSON sent in every datagram.
+type message struct {
+	Type messageType `json:"type"`
+	Seq  uint64      `json:"seq,omitempty"`
+	From string      `json:"from"`
+	// Target is the member a ping is meant for, or a ping_req asks to be probed.
+	Target     string `json:"target,omitempty"`
+	TargetAddr string `json:"target_addr,omitempty"`
+	// Updates are changes in membership, or the whole member list of a sync.
+	Updates []Member `json:"updates,omitempty"`
+}
+
+func encode(msg message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+// broadcast is a change in membership waiting to be piggybacked.
+type broadcast struct {
+	member    Member
+	transmits int
+}
+
+// handle merges the message's updates then answers it.
+func (m *Memberlist) handle(msg message, from string) {
+	m.merge(msg.Updates)
+
+	switch msg.Type {
+	case pingMessage:
+		// a ping meant for a previous node at this address goes unanswered
+		if msg.Target != "" && msg.Target != m.config.Name {
+			return
+		}
+		m.sendMessage(from, message{Type: ackMessage, Seq: msg.Seq})
+	case ackMessage:
+		m.mu.Lock()
+		ack, ok := m.acks[msg.Seq]
+		delete(m.acks, msg.Seq)
+		m.mu.Unlock()
+
+		if ok {
+			ack()
+		}
+	case pingReqMessage:
+		m.probeFor(msg, from)
+	case syncMessage:
+		m.sendMessage(from, message{Type: syncReplyMessage, Updates: m.Members()})
+	case syncReplyMessage, gossipMessage:
+	default:
+		log.Printf("gossip: unknown message type %q from %s", msg.Type, from)
+	}
+}
+
+// sendMessage piggybacks the changes waiting to be broadcast on the message and sends it.
+func (m *Memberlist) sendMessage(addr string, msg message) {
+	m.mu.Lock()
+	msg.From = m.config.Name
+	if msg.Type != syncMessage && msg.Type != syncReplyMessage {
+		msg.Updates = m.piggyback()
+	}
+	m.mu.Unlock()
+
+	data, err := encode(msg)
+	if err != nil {
+		log.Printf("gossip: failed to encode %s: %v", msg.Type, err)
+		return
+	}
+	if err := m.send(addr, data); err != nil {
+		log.Printf("gossip: failed to send %s to %s: %v", msg.Type, addr, err)
+	}
+}
+
+// piggyback returns the changes sent the fewest times, dropping those sent enough times to have reached every
+// member. The caller must hold mu.
+func (m *Memberlist) piggyback() []Member {
+	limit := retransmitMult * int(math.Ceil(math.Log10(float64(len(m.members)+1))))
+
+	sort.SliceStable(m.queue, func(i, j int) bool { return m.queue[i].transmits < m.queue[j].transmits })
+
+	var updates []Member
+	kept := m.queue[:0]
+	for _, b := range m.queue {
+		if len(updates) < maxPiggyback {
+			updates = append(updates, b.member)
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	m.queue = kept
+
+	return updates
+}
+
+// broadcast queues the member's new state to be piggybacked, replacing any older news of it. The caller must hold
+// mu.
+func (m *Memberlist) broadcast(member Member) {
+	for i, b := range m.queue {
+		if b.member.Name == member.Name {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			break
+		}
+	}
+	m.queue = append(m.queue, &broadcast{member: member})
+}
+
+// merge applies the updates that are news, broadcasting them on and reporting them to onChange.
+func (m *Memberlist) merge(updates []Member) {
+	var changed []Member
+
+	m.mu.Lock()
+	for _, u := range updates {
+		if member, ok := m.apply(u); ok {
+			m.broadcast(member)
+			changed = append(changed, member)
+		}
+	}
+	m.mu.Unlock()
+
+	m.notify(changed)
+}
+
+// apply updates what is known of the member if the update is news, returning its new state. An update suspecting
+// or declaring this node dead is refuted with a later incarnation instead. The caller must hold mu.
+func (m *Memberlist) apply(u Member) (Member, bool) {
+	if u.Name == m.config.Name {
+		self := m.members[u.Name]
+		if (u.State == Suspect || u.State == Dead) && u.Incarnation >= self.Incarnation && self.State == Alive {
+			self.Incarnation = u.Incarnation + 1
+			return *self, true
+		}
+		return Member{}, false
+	}
+
+	member, ok := m.members[u.Name]
+	if !ok {
+		// news of a member that is already gone isn't worth keeping
+		if u.State != Alive && u.State != Suspect {
+			return Member{}, false
+		}
+		member = &Member{Name: u.Name}
+		m.members[u.Name] = member
+		*member = u
+		if u.State == Suspect {
+			m.suspect(member)
+		}
+		return *member, true
+	}
+
+	gone := member.State == Dead || member.State == Left
+	switch u.State {
+	case Alive:
+		// only a later incarnation refutes a suspicion or brings a member back
+		if u.Incarnation <= member.Incarnation {
+			return Member{}, false
+		}
+	case Suspect:
+		if gone || u.Incarnation < member.Incarnation ||
+			(member.State == Suspect && u.Incarnation == member.Incarnation) {
+			return Member{}, false
+		}
+	case Dead, Left:
+		if gone || u.Incarnation < member.Incarnation {
+			return Member{}, false
+		}
+	default:
+		return Member{}, false
+	}
+
+	if timer, ok := m.suspicions[u.Name]; ok && u.State != Suspect {
+		timer.Stop()
+		delete(m.suspicions, u.Name)
+	}
+	*member = u
+	if u.State == Suspect {
+		m.suspect(member)
+	}
+
+	return *member, true
+}
+
+// suspect declares the member dead unless it refutes the suspicion within the timeout. The caller must hold mu.
+func (m *Memberlist) suspect(member *Member) {
+	if timer, ok := m.suspicions[member.Name]; ok {
+		timer.Stop()
+	}
+
+	name, incarnation := member.Name, member.Incarnation
+	m.suspicions[name] = time.AfterFunc(m.config.SuspicionTimeout, func() {
+		m.mu.Lock()
+		member, ok := m.members[name]
+		if !ok || member.State != Suspect || member.Incarnation != incarnation {
+			m.mu.Unlock()
+			return
+		}
+		delete(m.suspicions, name)
+		member.State = Dead
+		dead := *member
+		m.broadcast(dead)
+		m.mu.Unlock()
+
+		m.notify([]Member{dead})
+	})
+}
+
+// notify reports the changed members to onChange.
+func (m *Memberlist) notify(changed []Member) {
+	if m.onChange == nil {
+		return
+	}
+	for _, member := range changed {
+		m.onChange(member)
+	}
+}
@@ -0,0 +1,139 @@
+package gossip
+
+import (
+	"time"
+)
+
+// probe pings the next member in the round. If it doesn't ack within the probe timeout, others are asked to ping it,
+// and it is suspected if no ack arrives by the end of the probe interval.
+func (m *Memberlist) probe() {
+	target, ok := m.nextTarget()
+	if !ok {
+		return
+	}
+
+	acked := make(chan struct{})
+	seq := m.expectAck(func() { close(acked) })
+	defer m.forgetAck(seq)
+
+	deadline := time.NewTimer(m.config.ProbeInterval)
+	defer deadline.Stop()
+	timeout := time.NewTimer(m.config.ProbeTimeout)
+	defer timeout.Stop()
+
+	m.sendMessage(target.Addr, message{Type: pingMessage, Seq: seq, Target: target.Name})
+
+	select {
+	case <-acked:
+		return
+	case <-m.ctx.Done():
+		return
+	case <-timeout.C:
+	}
+
+	m.mu.Lock()
+	helpers := m.randomMembers(m.config.IndirectProbes, target.Name)
+	m.mu.Unlock()
+	for _, helper := range helpers {
+		m.sendMessage(helper.Addr, message{Type: pingReqMessage, Seq: seq, Target: target.Name, TargetAddr: target.Addr})
+	}
+
+	select {
+	case <-acked:
+		return
+	case <-m.ctx.Done():
+		return
+	case <-deadline.C:
+	}
+
+	m.mu.Lock()
+	member, ok := m.members[target.Name]
+	var changed []Member
+	if ok && member.State == Alive && member.Incarnation == target.Incarnation {
+		member.State = Suspect
+		m.suspect(member)
+		m.broadcast(*member)
+		changed = append(changed, *member)
+	}
+	m.mu.Unlock()
+
+	m.notify(changed)
+}
+
+// probeFor pings the target of a ping_req, acking the requester if the target acks within the probe timeout.
+func (m *Memberlist) probeFor(req message, from string) {
+	seq := m.expectAck(func() {
+		m.sendMessage(from, message{Type: ackMessage, Seq: req.Seq})
+	})
+	time.AfterFunc(m.config.ProbeTimeout, func() { m.forgetAck(seq) })
+
+	m.sendMessage(req.TargetAddr, message{Type: pingMessage, Seq: seq, Target: req.Target})
+}
+
+// sync sends this node's member list to a random member or seed, which replies with its own.
+func (m *Memberlist) sync() {
+	m.mu.Lock()
+	var addrs []string
+	for _, member := range m.randomMembers(1, "") {
+		addrs = append(addrs, member.Addr)
+	}
+	for _, seed := range m.config.Seeds {
+		if seed != m.config.AdvertiseAddr {
+			addrs = append(addrs, seed)
+		}
+	}
+	if len(addrs) == 0 {
+		m.mu.Unlock()
+		return
+	}
+	addr := addrs[m.rand.Intn(len(addrs))]
+	m.mu.Unlock()
+
+	m.sendMessage(addr, message{Type: syncMessage, Updates: m.Members()})
+}
+
+// nextTarget returns the next alive or suspected member to probe, starting a new round in a random order once every
+// member has been probed.
+func (m *Memberlist) nextTarget() (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		for len(m.probeOrder) > 0 {
+			name := m.probeOrder[0]
+			m.probeOrder = m.probeOrder[1:]
+
+			if member, ok := m.members[name]; ok && (member.State == Alive || member.State == Suspect) {
+				return *member, true
+			}
+		}
+
+		for name := range m.members {
+			if name != m.config.Name {
+				m.probeOrder = append(m.probeOrder, name)
+			}
+		}
+		m.rand.Shuffle(len(m.probeOrder), func(i, j int) {
+			m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+		})
+	}
+
+	return Member{}, false
+}
+
+// expectAck registers fn to be called when an ack with the returned sequence arrives.
+func (m *Memberlist) expectAck(fn func()) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seq++
+	m.acks[m.seq] = fn
+	return m.seq
+}
+
+func (m *Memberlist) forgetAck(seq uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.acks, seq)
+}
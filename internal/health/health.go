@@ -0,0 +1,142 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports why a dependency is unhealthy, or nil if it is healthy.
+type Check func(ctx context.Context) error
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Report is the JSON body served by a Checker.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckReport `json:"checks,omitempty"`
+}
+
+type CheckReport struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Checker runs a set of named checks, serving 200 when all of them pass and 503 otherwise.
+type Checker struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+// New creates a Checker that gives each check up to timeout to complete.
+func New(timeout time.Duration) *Checker {
+	return &Checker{
+		timeout: timeout,
+		checks:  make(map[string]Check),
+	}
+}
+
+// Add registers the check, replacing any check with the same name.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks[name] = check
+}
+
+// Run runs every check concurrently, reporting whether they all passed.
+func (c *Checker) Run(ctx context.Context) (Report, bool) {
+	c.mu.RLock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckReport, len(checks))}
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			result := CheckReport{Status: StatusOK}
+			if err := check(ctx); err != nil {
+				result = CheckReport{Status: StatusFail, Error: err.Error()}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			report.Checks[name] = result
+			if result.Status == StatusFail {
+				report.Status = StatusFail
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return report, report.Status == StatusOK
+}
+
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report, ok := c.Run(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// Flag is a check whose result is set by the caller, e.g. when startup work finishes or a failure is reported.
+type Flag struct {
+	mu  sync.RWMutex
+	err error
+}
+
+// NewFlag creates a Flag that fails with err, or passes if err is nil, until Set is called.
+func NewFlag(err error) *Flag {
+	return &Flag{err: err}
+}
+
+// Set makes the flag fail with err, or pass if err is nil.
+func (f *Flag) Set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+}
+
+func (f *Flag) Check(ctx context.Context) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.err
+}
+
+// Require responds 503 to every request until the flag passes.
+func (f *Flag) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := f.Check(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,108 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/health"
+)
+
+func TestChecker_ServeHTTP(t *testing.T) {
+	tests := map[string]struct {
+		checks         map[string]health.Check
+		expectedCode   int
+		expectedReport health.Report
+	}{
+		"no checks": {
+			expectedCode:   http.StatusOK,
+			expectedReport: health.Report{Status: health.StatusOK},
+		},
+		"all passing": {
+			checks: map[string]health.Check{
+				"replay":   health.NewFlag(nil).Check,
+				"postgres": func(ctx context.Context) error { return nil },
+			},
+			expectedCode: http.StatusOK,
+			expectedReport: health.Report{
+				Status: health.StatusOK,
+				Checks: map[string]health.CheckReport{
+					"replay":   {Status: health.StatusOK},
+					"postgres": {Status: health.StatusOK},
+				},
+			},
+		},
+		"one failing": {
+			checks: map[string]health.Check{
+				"replay":   health.NewFlag(errors.New("replaying the transaction log")).Check,
+				"postgres": func(ctx context.Context) error { return nil },
+			},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedReport: health.Report{
+				Status: health.StatusFail,
+				Checks: map[string]health.CheckReport{
+					"replay":   {Status: health.StatusFail, Error: "replaying the transaction log"},
+					"postgres": {Status: health.StatusOK},
+				},
+			},
+		},
+		"check times out": {
+			checks: map[string]health.Check{
+				"postgres": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedReport: health.Report{
+				Status: health.StatusFail,
+				Checks: map[string]health.CheckReport{
+					"postgres": {Status: health.StatusFail, Error: context.DeadlineExceeded.Error()},
+				},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			checker := health.New(10 * time.Millisecond)
+			for name, check := range test.checks {
+				checker.Add(name, check)
+			}
+
+			rec := httptest.NewRecorder()
+			checker.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, test.expectedCode, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			var report health.Report
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+			assert.Equal(t, test.expectedReport, report)
+		})
+	}
+}
+
+func TestFlag_Require(t *testing.T) {
+	flag := health.NewFlag(errors.New("replaying the transaction log"))
+	handler := flag.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/key", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "replaying the transaction log")
+
+	flag.Set(nil)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/key", nil))
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+}
@@ -0,0 +1,101 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxKeyLength is memcached's own key length limit.
+	maxKeyLength = 250
+	// maxValueLength matches memcached's default item size limit.
+	maxValueLength = 1 << 20
+	// maxLineLength caps the length of a command line, long enough for a get of many keys.
+	maxLineLength = 64 << 10
+	// maxRelativeExpiry is the largest exptime taken as seconds from now, larger ones are unix timestamps.
+	maxRelativeExpiry = 60 * 60 * 24 * 30
+)
+
+var (
+	// errLineTooLong is returned for a command line over maxLineLength.
+	errLineTooLong = errors.New("line too long")
+	// errBadDataChunk is returned when a data block isn't followed by CRLF, after which the connection is out of sync.
+	errBadDataChunk = errors.New("bad data chunk")
+)
+
+// readLine reads up to the next newline, dropping the CRLF or bare LF.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+
+		line = append(line, chunk...)
+		if len(line) > maxLineLength {
+			return "", errLineTooLong
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// readData reads the n byte data block of a storage command and its trailing CRLF.
+func readData(r *bufio.Reader, n int) (string, error) {
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return "", errBadDataChunk
+	}
+
+	return string(buf[:n]), nil
+}
+
+// discardData skips a data block that is rejected before being read.
+func discardData(r *bufio.Reader, n int) error {
+	_, err := io.CopyN(io.Discard, r, int64(n)+2)
+	return err
+}
+
+// parseExptime converts a memcached expiration time into a deadline, the zero time if it never expires.
+// Up to 30 days it is seconds from now, beyond that a unix timestamp, and a negative value has already expired.
+func parseExptime(s string, now time.Time) (time.Time, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("invalid exptime argument")
+	}
+
+	switch {
+	case n == 0:
+		return time.Time{}, nil
+	case n < 0:
+		return now.UTC(), nil
+	case n <= maxRelativeExpiry:
+		return now.Add(time.Duration(n) * time.Second).UTC(), nil
+	default:
+		return time.Unix(n, 0).UTC(), nil
+	}
+}
+
+// validKey reports whether key can be sent back in a VALUE line, without spaces or control characters.
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
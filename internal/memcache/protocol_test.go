@@ -0,0 +1,38 @@
+package memcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExptime(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		exptime  string
+		expected time.Time
+		err      bool
+	}{
+		"never":           {exptime: "0"},
+		"relative":        {exptime: "60", expected: now.Add(time.Minute)},
+		"thirty days":     {exptime: "2592000", expected: now.Add(30 * 24 * time.Hour)},
+		"unix timestamp":  {exptime: "2592001", expected: time.Unix(2592001, 0).UTC()},
+		"already expired": {exptime: "-1", expected: now},
+		"not a number":    {exptime: "soon", err: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			expires, err := parseExptime(test.exptime, now)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, expires)
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/tcpserver"
+)
+
+// serverVersion is reported to clients that check which protocol features the server has.
+const serverVersion = "1.6.0"
+
+// Server speaks the memcached text protocol, so memcached clients can read and write the store.
+// Item flags are kept with the value as the key's flags, so the value is the same over every API.
+type Server struct {
+	*tcpserver.Server
+
+	store  api.Store
+	logger api.TransactionLogger
+	// ready rejects data commands while it returns an error, e.g. during log replay.
+	ready func(context.Context) error
+}
+
+func NewServer(store api.Store, logger api.TransactionLogger, ready func(context.Context) error) *Server {
+	s := &Server{
+		store:  store,
+		logger: logger,
+		ready:  ready,
+	}
+	s.Server = tcpserver.New(s.serveConn)
+
+	return s
+}
+
+// session is the state of one client connection.
+type session struct {
+	r *bufio.Reader
+	w *bufio.Writer
+	// noreply is set for the current command when the client doesn't want an answer.
+	noreply bool
+}
+
+// reply writes a line unless the current command asked for no reply.
+// Error replies are dropped too, as a client using noreply isn't reading them.
+func (c *session) reply(line string) {
+	if c.noreply {
+		return
+	}
+	c.w.WriteString(line + "\r\n")
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	c := &session{r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	for {
+		line, err := readLine(c.r)
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				c.w.WriteString("CLIENT_ERROR line too long\r\n")
+				c.w.Flush()
+			}
+			return
+		}
+
+		c.noreply = false
+		quit := s.execute(c, strings.Fields(line))
+
+		// pipelined commands are answered together
+		if c.r.Buffered() == 0 || quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit || s.Closing() {
+			c.w.Flush()
+			return
+		}
+	}
+}
+
+// commands maps each command to its handler, which returns true if the connection should be closed.
+// Handlers check readiness themselves as storage commands must consume their data block first.
+var commands = map[string]func(s *Server, c *session, args []string) bool{
+	"get":     (*Server).get,
+	"gets":    (*Server).get,
+	"set":     (*Server).storage,
+	"add":     (*Server).storage,
+	"replace": (*Server).storage,
+	"cas":     (*Server).storage,
+	"delete":  (*Server).delete,
+	"incr":    (*Server).incrDecr,
+	"decr":    (*Server).incrDecr,
+	"touch":   (*Server).touch,
+	"version": (*Server).version,
+	"quit":    func(*Server, *session, []string) bool { return true },
+}
+
+// execute runs the command, reporting whether the connection should be closed.
+func (s *Server) execute(c *session, args []string) bool {
+	if len(args) == 0 {
+		c.reply("ERROR")
+		return false
+	}
+
+	run, ok := commands[args[0]]
+	if !ok {
+		c.reply("ERROR")
+		return false
+	}
+
+	return run(s, c, args)
+}
+
+// checkReady replies with a server error if data commands can't be served yet.
+func (s *Server) checkReady(c *session) bool {
+	if err := s.ready(context.Background()); err != nil {
+		c.reply("SERVER_ERROR " + err.Error())
+		return false
+	}
+	return true
+}
+
+// get handles get and gets <key>*, gets also returns the key's version as its cas unique.
+func (s *Server) get(c *session, args []string) bool {
+	if len(args) < 2 {
+		c.reply("ERROR")
+		return false
+	}
+	if !s.checkReady(c) {
+		return false
+	}
+
+	for _, key := range args[1:] {
+		kv, err := s.store.GetKeyValue(key)
+		if errors.Is(err, model.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			c.reply("SERVER_ERROR " + err.Error())
+			return false
+		}
+
+		value := api.ValueString(kv)
+		line := "VALUE " + key + " " + strconv.FormatUint(uint64(kv.Flags), 10) + " " + strconv.Itoa(len(value))
+		if args[0] == "gets" {
+			line += " " + strconv.FormatUint(kv.Version, 10)
+		}
+		c.reply(line)
+		c.reply(value)
+	}
+
+	c.reply("END")
+	return false
+}
+
+// storage handles set, add, replace and cas:
+//
+//	<command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+//	<data block>
+func (s *Server) storage(c *session, args []string) bool {
+	n := 5
+	if args[0] == "cas" {
+		n = 6
+	}
+	if len(args) != n && !(len(args) == n+1 && args[n] == "noreply") {
+		c.reply("ERROR")
+		return false
+	}
+	c.noreply = len(args) == n+1
+
+	length, err := strconv.Atoi(args[4])
+	if err != nil || length < 0 {
+		c.reply("CLIENT_ERROR bad command line format")
+		return false
+	}
+
+	// the data block is always consumed so the connection stays in sync with the client
+	if length > maxValueLength {
+		if err := discardData(c.r, length); err != nil {
+			return true
+		}
+		c.reply("SERVER_ERROR object too large for cache")
+		return false
+	}
+
+	value, err := readData(c.r, length)
+	if err != nil {
+		if errors.Is(err, errBadDataChunk) {
+			c.reply("CLIENT_ERROR bad data chunk")
+		}
+		return true
+	}
+
+	key := args[1]
+	if !validKey(key) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return false
+	}
+
+	flags, err := strconv.ParseUint(args[2], 10, 32)
+	if err != nil {
+		c.reply("CLIENT_ERROR bad command line format")
+		return false
+	}
+
+	expires, err := parseExptime(args[3], time.Now())
+	if err != nil {
+		c.reply("CLIENT_ERROR " + err.Error())
+		return false
+	}
+
+	var pre model.Precondition
+	switch args[0] {
+	case "add":
+		pre.IfNoneMatchAny = true
+	case "replace":
+		pre.IfMatchAny = true
+	case "cas":
+		unique, err := strconv.ParseUint(args[5], 10, 64)
+		if err != nil {
+			c.reply("CLIENT_ERROR bad command line format")
+			return false
+		}
+		pre.IfMatch = []uint64{unique}
+	}
+
+	if !s.checkReady(c) {
+		return false
+	}
+
+	_, err = s.put(key, value, uint32(flags), expires, pre)
+	if errors.Is(err, model.ErrPreconditionFailed) {
+		switch {
+		case args[0] != "cas":
+			c.reply("NOT_STORED")
+		case s.exists(key):
+			c.reply("EXISTS")
+		default:
+			c.reply("NOT_FOUND")
+		}
+		return false
+	}
+	if err != nil {
+		c.reply("SERVER_ERROR " + err.Error())
+		return false
+	}
+
+	c.reply("STORED")
+	return false
+}
+
+// delete handles delete <key> [noreply].
+func (s *Server) delete(c *session, args []string) bool {
+	if len(args) != 2 && !(len(args) == 3 && args[2] == "noreply") {
+		c.reply("CLIENT_ERROR bad command line format")
+		return false
+	}
+	c.noreply = len(args) == 3
+
+	if !s.checkReady(c) {
+		return false
+	}
+
+	key := args[1]
+	err := s.store.DeleteIf(key, model.Precondition{IfMatchAny: true}, api.LogDelete(s.logger))
+	if errors.Is(err, model.ErrPreconditionFailed) {
+		c.reply("NOT_FOUND")
+		return false
+	}
+	if err != nil {
+		c.reply("SERVER_ERROR " + err.Error())
+		return false
+	}
+
+	c.reply("DELETED")
+	return false
+}
+
+// incrDecr handles incr and decr <key> <delta> [noreply]. The value must be a 64 bit unsigned integer,
+// incr wraps around on overflow and decr stops at 0, as memcached does.
+func (s *Server) incrDecr(c *session, args []string) bool {
+	if len(args) != 3 && !(len(args) == 4 && args[3] == "noreply") {
+		c.reply("ERROR")
+		return false
+	}
+	c.noreply = len(args) == 4
+
+	delta, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid numeric delta argument")
+		return false
+	}
+
+	if !s.checkReady(c) {
+		return false
+	}
+
+	key := args[1]
+	kv, err := s.update(key, func(kv *model.KeyValue) (string, time.Time, error) {
+		current, err := strconv.ParseUint(api.ValueString(kv), 10, 64)
+		if err != nil {
+			return "", time.Time{}, errors.New("cannot increment or decrement non-numeric value")
+		}
+
+		switch {
+		case args[0] == "incr":
+			current += delta
+		case delta > current:
+			current = 0
+		default:
+			current -= delta
+		}
+
+		return strconv.FormatUint(current, 10), kv.Expires, nil
+	})
+	if err != nil {
+		s.replyUpdateError(c, err)
+		return false
+	}
+
+	c.reply(api.ValueString(kv))
+	return false
+}
+
+// touch handles touch <key> <exptime> [noreply]. The key is rewritten with its new expiry, so unlike
+// memcached its cas unique changes.
+func (s *Server) touch(c *session, args []string) bool {
+	if len(args) != 3 && !(len(args) == 4 && args[3] == "noreply") {
+		c.reply("ERROR")
+		return false
+	}
+	c.noreply = len(args) == 4
+
+	expires, err := parseExptime(args[2], time.Now())
+	if err != nil {
+		c.reply("CLIENT_ERROR " + err.Error())
+		return false
+	}
+
+	if !s.checkReady(c) {
+		return false
+	}
+
+	_, err = s.update(args[1], func(kv *model.KeyValue) (string, time.Time, error) {
+		return api.ValueString(kv), expires, nil
+	})
+	if err != nil {
+		s.replyUpdateError(c, err)
+		return false
+	}
+
+	c.reply("TOUCHED")
+	return false
+}
+
+func (s *Server) version(c *session, args []string) bool {
+	c.reply("VERSION " + serverVersion)
+	return false
+}
+
+// errClient wraps errors caused by the client's request rather than the server.
+type errClient struct {
+	error
+}
+
+// update rewrites the key with the value and expiry returned by fn, keeping its flags and retrying if the key is
+// written concurrently. Errors returned by fn are reported to the client as client errors.
+func (s *Server) update(key string, fn func(kv *model.KeyValue) (string, time.Time, error)) (*model.KeyValue, error) {
+	for {
+		kv, err := s.store.GetKeyValue(key)
+		if err != nil {
+			return nil, err
+		}
+
+		value, expires, err := fn(kv)
+		if err != nil {
+			return nil, errClient{err}
+		}
+
+		updated, err := s.put(key, value, kv.Flags, expires, model.Precondition{IfMatch: []uint64{kv.Version}})
+		if errors.Is(err, model.ErrPreconditionFailed) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return updated, nil
+	}
+}
+
+// put writes the item with its flags. PutIf can't keep flags, so it is written as a batch of one.
+func (s *Server) put(key, value string, flags uint32, expires time.Time, pre model.Precondition) (*model.KeyValue, error) {
+	kvs, err := s.store.Batch([]model.BatchOp{{
+		Type:         model.OpPut,
+		Key:          key,
+		Value:        value,
+		Expires:      expires,
+		Flags:        flags,
+		Precondition: pre,
+	}}, api.LogPut(s.logger))
+	if err != nil {
+		return nil, err
+	}
+
+	return kvs[0], nil
+}
+
+func (s *Server) replyUpdateError(c *session, err error) {
+	var clientErr errClient
+	switch {
+	case errors.Is(err, model.ErrKeyNotFound):
+		c.reply("NOT_FOUND")
+	case errors.As(err, &clientErr):
+		c.reply("CLIENT_ERROR " + err.Error())
+	default:
+		c.reply("SERVER_ERROR " + err.Error())
+	}
+}
+
+func (s *Server) exists(key string) bool {
+	_, err := s.store.GetKeyValue(key)
+	return err == nil
+}
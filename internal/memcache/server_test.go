@@ -0,0 +1,185 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/testutil"
+)
+
+func TestServer_Commands(t *testing.T) {
+	tests := map[string]struct {
+		request  string
+		expected string
+	}{
+		"get missing": {
+			request:  "get key\r\n",
+			expected: "END\r\n",
+		},
+		"get existing and missing": {
+			request:  "get key1 missing\r\n",
+			expected: "VALUE key1 0 6\r\nvalue1\r\nEND\r\n",
+		},
+		"gets returns the version": {
+			request:  "gets key1\r\n",
+			expected: "VALUE key1 0 6 1\r\nvalue1\r\nEND\r\n",
+		},
+		"set then get binary value": {
+			request:  "set key 0 0 4\r\na\r\nb\r\nget key\r\n",
+			expected: "STORED\r\nVALUE key 0 4\r\na\r\nb\r\nEND\r\n",
+		},
+		"set already expired": {
+			request:  "set key 0 -1 5\r\nvalue\r\nget key\r\n",
+			expected: "STORED\r\nEND\r\n",
+		},
+		"set with flags": {
+			request:  "set key 16 0 5\r\nvalue\r\nget key\r\n",
+			expected: "STORED\r\nVALUE key 16 5\r\nvalue\r\nEND\r\n",
+		},
+		"incr keeps flags": {
+			request:  "set n 3 0 1\r\n1\r\nincr n 1\r\nget n\r\n",
+			expected: "STORED\r\n2\r\nVALUE n 3 1\r\n2\r\nEND\r\n",
+		},
+		"flags too large": {
+			request:  "set key 4294967296 0 5\r\nvalue\r\n",
+			expected: "CLIENT_ERROR bad command line format\r\n",
+		},
+		"set noreply": {
+			request:  "set key 0 0 5 noreply\r\nvalue\r\nget key\r\n",
+			expected: "VALUE key 0 5\r\nvalue\r\nEND\r\n",
+		},
+		"set too large is skipped": {
+			request:  "set key 0 0 1048577\r\n" + string(make([]byte, 1048577)) + "\r\nversion\r\n",
+			expected: "SERVER_ERROR object too large for cache\r\nVERSION 1.6.0\r\n",
+		},
+		"bad data chunk": {
+			request:  "set key 0 0 1\r\nvalue\r\n",
+			expected: "CLIENT_ERROR bad data chunk\r\n",
+		},
+		"add existing": {
+			request:  "add key1 0 0 1\r\nx\r\n",
+			expected: "NOT_STORED\r\n",
+		},
+		"add new": {
+			request:  "add key 0 0 1\r\nx\r\n",
+			expected: "STORED\r\n",
+		},
+		"replace missing": {
+			request:  "replace key 0 0 1\r\nx\r\n",
+			expected: "NOT_STORED\r\n",
+		},
+		"cas": {
+			request:  "cas key1 0 0 1 1\r\nx\r\ncas key1 0 0 1 1\r\ny\r\ncas key 0 0 1 1\r\nz\r\n",
+			expected: "STORED\r\nEXISTS\r\nNOT_FOUND\r\n",
+		},
+		"delete": {
+			request:  "delete key1\r\ndelete key1\r\n",
+			expected: "DELETED\r\nNOT_FOUND\r\n",
+		},
+		"incr and decr": {
+			request:  "set n 0 0 2\r\n10\r\nincr n 5\r\ndecr n 20\r\nincr missing 1\r\n",
+			expected: "STORED\r\n15\r\n0\r\nNOT_FOUND\r\n",
+		},
+		"incr wraps": {
+			request:  "set n 0 0 20\r\n18446744073709551615\r\nincr n 2\r\n",
+			expected: "STORED\r\n1\r\n",
+		},
+		"incr non numeric": {
+			request:  "incr key1 1\r\n",
+			expected: "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n",
+		},
+		"incr invalid delta": {
+			request:  "incr key1 -1\r\n",
+			expected: "CLIENT_ERROR invalid numeric delta argument\r\n",
+		},
+		"touch": {
+			request:  "touch key1 -1\r\nget key1\r\ntouch key1 60\r\n",
+			expected: "TOUCHED\r\nEND\r\nNOT_FOUND\r\n",
+		},
+		"unknown command": {
+			request:  "flush_all\r\n",
+			expected: "ERROR\r\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := store.New(make(map[string]interface{}))
+			require.NoError(t, s.Put("key1", "value1"))
+
+			conn := testutil.Dial(t, NewServer(s, &testutil.Logger{}, testutil.Ready))
+			_, err := conn.Write([]byte(test.request))
+			require.NoError(t, err)
+
+			// replies to pipelined commands can arrive in more than one read
+			got := make([]byte, len(test.expected))
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = io.ReadFull(conn, got)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(got))
+		})
+	}
+}
+
+func TestServer_Logging(t *testing.T) {
+	s := store.New(make(map[string]interface{}))
+	logger := &testutil.Logger{}
+	conn := testutil.Dial(t, NewServer(s, logger, testutil.Ready))
+
+	r := bufio.NewReader(conn)
+	for _, cmd := range []string{"set a 0 0 1\r\n1", "add a 0 0 1\r\n2", "incr a 2", "touch a 60", "delete a", "delete a"} {
+		_, err := conn.Write([]byte(cmd + "\r\n"))
+		require.NoError(t, err)
+		_, err = r.ReadString('\n')
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"put a 1", "put a 3", "put a 3", "delete a"}, logger.Ops())
+}
+
+func TestServer_NotReady(t *testing.T) {
+	notReady := func(context.Context) error { return errors.New("replaying the transaction log") }
+	conn := testutil.Dial(t, NewServer(store.New(make(map[string]interface{})), &testutil.Logger{}, notReady))
+
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("version\r\nset a 0 0 1\r\n1\r\nget a\r\nquit\r\n"))
+	require.NoError(t, err)
+
+	for _, expected := range []string{
+		"VERSION 1.6.0\r\n",
+		"SERVER_ERROR replaying the transaction log\r\n",
+		"SERVER_ERROR replaying the transaction log\r\n",
+	} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, expected, line)
+	}
+
+	// quit closes the connection
+	_, err = r.ReadString('\n')
+	assert.Error(t, err)
+}
+
+func TestServer_Flags(t *testing.T) {
+	s := store.New(make(map[string]interface{}))
+	conn := testutil.Dial(t, NewServer(s, &testutil.Logger{}, testutil.Ready))
+
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("set key 16 0 5\r\nvalue\r\n"))
+	require.NoError(t, err)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "STORED\r\n", line)
+
+	kv, err := s.GetKeyValue("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", kv.Value)
+	assert.Equal(t, uint32(16), kv.Flags)
+}
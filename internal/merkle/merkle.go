@@ -0,0 +1,120 @@
+// Package merkle summarises a keyspace in a Merkle tree, so two copies of it can be compared by exchanging a few
+// hashes. The keys are split by their position on the hash ring into 2^depth buckets, each a leaf of the tree, and
+// every node above hashes its two children. Two copies differ only in the buckets under nodes whose hashes differ.
+package merkle
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/warrenb95/cloud-native-go/internal/ring"
+)
+
+// MaxDepth caps the depth of a tree, at a million leaves.
+const MaxDepth = 20
+
+// Tree is a Merkle tree over the buckets of a keyspace. Entries are added and removed in any order, the hashes above
+// the changed leaves are computed when first read after a change. It isn't safe for concurrent use.
+type Tree struct {
+	depth int
+	// levels holds the hashes of each level from the root down, the leaves last.
+	levels [][]uint64
+	// dirty holds the leaves changed since the hashes above them were computed.
+	dirty map[int]bool
+}
+
+// New creates an empty tree with 2^depth leaves, depth is capped at MaxDepth.
+func New(depth int) *Tree {
+	if depth < 0 {
+		depth = 0
+	}
+	if depth > MaxDepth {
+		depth = MaxDepth
+	}
+
+	t := &Tree{depth: depth, levels: make([][]uint64, depth+1), dirty: make(map[int]bool)}
+	for level := range t.levels {
+		t.levels[level] = make([]uint64, 1<<level)
+	}
+	// every node is hashed up front, so one above leaves that are empty again matches one that never changed
+	for i := range t.levels[depth] {
+		t.dirty[i] = true
+	}
+	t.rehash()
+
+	return t
+}
+
+// Bucket returns the leaf the key falls in, in a tree of the depth. Each bucket is a contiguous range of the ring.
+func Bucket(key string, depth int) int {
+	return int(ring.Hash(key) >> (64 - depth))
+}
+
+// EntryHash hashes a key at a version, with its value or as deleted.
+func EntryHash(key string, version uint64, value string, deleted bool) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	var buf [9]byte
+	binary.BigEndian.PutUint64(buf[:8], version)
+	if deleted {
+		buf[8] = 1
+	}
+	h.Write(buf[:])
+	h.Write([]byte(value))
+
+	return h.Sum64()
+}
+
+// Depth returns the number of levels below the root.
+func (t *Tree) Depth() int {
+	return t.depth
+}
+
+// Add adds the entry, hashed by EntryHash, to the key's bucket. A bucket's hash doesn't depend on the order its
+// entries were added in.
+func (t *Tree) Add(key string, entry uint64) {
+	bucket := Bucket(key, t.depth)
+	t.levels[t.depth][bucket] ^= entry
+	t.dirty[bucket] = true
+}
+
+// Remove removes an entry added before, leaving the key's bucket as if it had never been added.
+func (t *Tree) Remove(key string, entry uint64) {
+	t.Add(key, entry)
+}
+
+// Hash returns the hash of the node at the index in the level, 0 being the root. Index i's children are 2i and
+// 2i+1 in the level below.
+func (t *Tree) Hash(level, index int) uint64 {
+	if len(t.dirty) > 0 {
+		t.rehash()
+	}
+	return t.levels[level][index]
+}
+
+// rehash recomputes the nodes above the dirty leaves.
+func (t *Tree) rehash() {
+	var buf [16]byte
+	changed := t.dirty
+	for level := t.depth - 1; level >= 0; level-- {
+		below := t.levels[level+1]
+		parents := make(map[int]bool, len(changed))
+		for child := range changed {
+			i := child / 2
+			if parents[i] {
+				continue
+			}
+			parents[i] = true
+
+			binary.BigEndian.PutUint64(buf[:8], below[2*i])
+			binary.BigEndian.PutUint64(buf[8:], below[2*i+1])
+
+			h := fnv.New64a()
+			h.Write(buf[:])
+			t.levels[level][i] = h.Sum64()
+		}
+		changed = parents
+	}
+	t.dirty = make(map[int]bool)
+}
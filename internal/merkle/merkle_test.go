@@ -0,0 +1,99 @@
+package merkle_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/warrenb95/cloud-native-go/internal/merkle"
+)
+
+func TestTree(t *testing.T) {
+	const depth = 6
+
+	tests := map[string]struct {
+		// change is applied to the second tree's entries
+		change   func(entries map[string]uint64)
+		differed []string
+	}{
+		"same entries": {
+			change: func(entries map[string]uint64) {},
+		},
+		"newer version": {
+			change: func(entries map[string]uint64) {
+				entries["user:7"] = merkle.EntryHash("user:7", 20, "changed", false)
+			},
+			differed: []string{"user:7"},
+		},
+		"deleted": {
+			change: func(entries map[string]uint64) {
+				entries["user:3"] = merkle.EntryHash("user:3", 20, "", true)
+			},
+			differed: []string{"user:3"},
+		},
+		"missing": {
+			change: func(entries map[string]uint64) {
+				delete(entries, "user:12")
+				delete(entries, "user:40")
+			},
+			differed: []string{"user:12", "user:40"},
+		},
+		"added and removed": {
+			change: func(entries map[string]uint64) {
+				entries["user:99"] = merkle.EntryHash("user:99", 99, "removed", false)
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, b := merkle.New(depth), merkle.New(depth)
+			entries := make(map[string]uint64)
+			for i := 0; i < 50; i++ {
+				key := "user:" + strconv.Itoa(i)
+				entry := merkle.EntryHash(key, uint64(i+1), "value of "+key, false)
+				a.Add(key, entry)
+				entries[key] = entry
+			}
+			test.change(entries)
+			// the order entries are added in doesn't matter
+			for i := 49; i >= 0; i-- {
+				key := "user:" + strconv.Itoa(i)
+				if entry, ok := entries[key]; ok {
+					b.Add(key, entry)
+				}
+			}
+			// an entry removed again leaves no trace, even once hashed
+			if entry, ok := entries["user:99"]; ok {
+				b.Add("user:99", entry)
+				b.Hash(0, 0)
+				b.Remove("user:99", entry)
+			}
+
+			// the nodes that differ are exactly those above the buckets of the changed keys
+			expected := make(map[int]bool)
+			for _, key := range test.differed {
+				expected[merkle.Bucket(key, depth)] = true
+			}
+			for level := depth; level >= 0; level-- {
+				for i := 0; i < 1<<level; i++ {
+					assert.Equal(t, expected[i], a.Hash(level, i) != b.Hash(level, i), "level %d node %d", level, i)
+				}
+
+				above := make(map[int]bool)
+				for i := range expected {
+					above[i/2] = true
+				}
+				expected = above
+			}
+		})
+	}
+}
+
+func TestBucket(t *testing.T) {
+	// a bucket splits into two at the next depth down
+	for i := 0; i < 1000; i++ {
+		key := "user:" + strconv.Itoa(i)
+		assert.Equal(t, merkle.Bucket(key, 8), merkle.Bucket(key, 9)/2, key)
+	}
+	assert.Equal(t, 0, merkle.Bucket("user:1", 0))
+}
@@ -0,0 +1,352 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram upper bounds, in seconds, suited to request latencies.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry holds metric families and writes them in the Prometheus text exposition format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]writer
+}
+
+type writer interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]writer)}
+}
+
+// NewCounter registers a counter, with one series for each combination of label values.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{values: values{family: newFamily(name, help, "counter", labels)}}
+	r.register(name, c)
+	return c
+}
+
+// NewGauge registers a gauge, with one series for each combination of label values.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{values: values{family: newFamily(name, help, "gauge", labels)}}
+	r.register(name, g)
+	return g
+}
+
+// NewCounterFunc registers a counter whose value is read from f when the metrics are written.
+func (r *Registry) NewCounterFunc(name, help string, f func() float64) {
+	r.register(name, &funcMetric{family: newFamily(name, help, "counter", nil), f: f})
+}
+
+// NewGaugeFunc registers a gauge whose value is read from f when the metrics are written.
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(name, &funcMetric{family: newFamily(name, help, "gauge", nil), f: f})
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, DefaultBuckets if nil.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{family: newFamily(name, help, "histogram", labels), buckets: buckets}
+	r.register(name, h)
+	return h
+}
+
+// register panics on a duplicate name, as registering a metric twice is a programming error.
+func (r *Registry) register(name string, w writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metrics: %s is already registered", name))
+	}
+	r.families[name] = w
+}
+
+// Write writes every metric, sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	families := make([]writer, len(names))
+	for i, name := range names {
+		families[i] = r.families[name]
+	}
+	r.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(buf)
+	}
+
+	return buf.Flush()
+}
+
+// ServeHTTP serves the metrics for Prometheus to scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	r.Write(w)
+}
+
+// family is the name, help and labels shared by every series of a metric.
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func newFamily(name, help, typ string, labels []string) family {
+	return family{name: name, help: help, typ: typ, labels: labels}
+}
+
+func (f family) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+}
+
+// key joins the label values into a map key, panicking if the number of values doesn't match the labels.
+func (f family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\x00")
+}
+
+// labelPairs formats the labels as {a="1",b="2"} with any extra pair appended, e.g. a histogram's le.
+func (f family) labelPairs(values []string, extra ...string) string {
+	if len(f.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range f.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, label, labelEscaper.Replace(values[i]))
+	}
+	if len(extra) == 2 {
+		if len(f.labels) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extra[0], extra[1])
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// series is one combination of label values and its value.
+type series struct {
+	values []string
+	value  float64
+}
+
+// values stores a float per combination of label values.
+type values struct {
+	family
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (v *values) add(delta float64, labelValues []string) {
+	key := v.key(labelValues)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.series == nil {
+		v.series = make(map[string]*series)
+	}
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{values: append([]string{}, labelValues...)}
+		v.series[key] = s
+	}
+	s.value += delta
+}
+
+func (v *values) set(value float64, labelValues []string) {
+	key := v.key(labelValues)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.series == nil {
+		v.series = make(map[string]*series)
+	}
+	v.series[key] = &series{values: append([]string{}, labelValues...), value: value}
+}
+
+func (v *values) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.writeHeader(w)
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(s.values), formatFloat(s.value))
+	}
+}
+
+// Counter only goes up.
+type Counter struct {
+	values
+}
+
+// Inc adds one to the series with the label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative, to the series with the label values.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: counter %s can't decrease", c.name))
+	}
+	c.add(delta, labelValues)
+}
+
+// Gauge can go up and down.
+type Gauge struct {
+	values
+}
+
+// Set sets the series with the label values.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues)
+}
+
+// Add adds delta, which may be negative, to the series with the label values.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues)
+}
+
+type funcMetric struct {
+	family
+	f func() float64
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	m.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(m.f()))
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	family
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	// counts holds the number of observations in each bucket, not cumulative, with +Inf last.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Observe records the value in the series with the label values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.series == nil {
+		h.series = make(map[string]*histogramSeries)
+	}
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			values: append([]string{}, labelValues...),
+			counts: make([]uint64, len(h.buckets)+1),
+		}
+		h.series[key] = s
+	}
+
+	s.counts[sort.SearchFloat64s(h.buckets, value)]++
+	s.sum += value
+	s.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.values), s.count)
+	}
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]*series:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*histogramSeries:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
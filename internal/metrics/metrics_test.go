@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	registry := NewRegistry()
+
+	requests := registry.NewCounter("requests_total", "Requests served.", "handler", "code")
+	requests.Inc("/v1/{key}", "200")
+	requests.Inc("/v1/{key}", "200")
+	requests.Add(3, "/", "404")
+
+	queue := registry.NewGauge("queue_depth", "Queued writes.")
+	queue.Set(4)
+	queue.Add(-1)
+
+	registry.NewGaugeFunc("size", "Help with a \\ and\nnewline.", func() float64 { return 7 })
+
+	latency := registry.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1}, "event")
+	latency.Observe(0.05, `"quoted"`)
+	latency.Observe(0.1, `"quoted"`)
+	latency.Observe(2, `"quoted"`)
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, contentType, rec.Header().Get("Content-Type"))
+	assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{event="\"quoted\"",le="0.1"} 2
+latency_seconds_bucket{event="\"quoted\"",le="1"} 2
+latency_seconds_bucket{event="\"quoted\"",le="+Inf"} 3
+latency_seconds_sum{event="\"quoted\""} 2.15
+latency_seconds_count{event="\"quoted\""} 3
+# HELP queue_depth Queued writes.
+# TYPE queue_depth gauge
+queue_depth 3
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{handler="/",code="404"} 3
+requests_total{handler="/v1/{key}",code="200"} 2
+# HELP size Help with a \\ and\nnewline.
+# TYPE size gauge
+size 7
+`, rec.Body.String())
+}
+
+func TestRegistry_Misuse(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounter("requests_total", "Requests served.", "code")
+
+	tests := map[string]func(){
+		"duplicate name":      func() { registry.NewGauge("requests_total", "Again.") },
+		"missing label value": func() { counter.Inc() },
+		"negative counter":    func() { counter.Add(-1, "200") },
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Panics(t, test)
+		})
+	}
+}
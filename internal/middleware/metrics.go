@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/warrenb95/cloud-native-go/internal/metrics"
+)
+
+// Metrics counts and times requests by route, method and status code.
+type Metrics struct {
+	requests *metrics.Counter
+	latency  *metrics.Histogram
+}
+
+func NewMetrics(registry *metrics.Registry) *Metrics {
+	return &Metrics{
+		requests: registry.NewCounter("kvs_http_requests_total",
+			"HTTP requests served.", "handler", "method", "code"),
+		latency: registry.NewHistogram("kvs_http_request_duration_seconds",
+			"Time taken to serve HTTP requests.", nil, "handler", "method", "code"),
+	}
+}
+
+// Instrument records every request, labelled by the matched route's path template so keys don't become labels.
+func (m *Metrics) Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		handler := "unknown"
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				handler = tmpl
+			}
+		}
+
+		code := strconv.Itoa(rec.code)
+		m.requests.Inc(handler, r.Method, code)
+		m.latency.Observe(time.Since(start).Seconds(), handler, r.Method, code)
+	})
+}
+
+// statusRecorder remembers the status code written by the handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.code = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush passes through to the wrapped writer so streamed responses aren't held back.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		r.wroteHeader = true
+		flusher.Flush()
+	}
+}
@@ -2,12 +2,16 @@ package middleware
 
 import (
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/warrenb95/cloud-native-go/internal/model"
 )
 
 type Throttle struct {
+	// allowed and rejected are accessed atomically.
+	allowed, rejected uint64
+
 	buckets map[string]*bucket
 	max     uint
 	refill  uint
@@ -40,6 +44,7 @@ func (th *Throttle) Throttle(next http.Handler) http.Handler {
 
 		UIDCookie, err := r.Cookie("UID")
 		if err != nil {
+			atomic.AddUint64(&th.rejected, 1)
 			http.Error(w, model.ErrInvalidArgument.Error(), http.StatusBadRequest)
 			return
 		}
@@ -48,6 +53,7 @@ func (th *Throttle) Throttle(next http.Handler) http.Handler {
 
 		if b == nil {
 			th.buckets[UIDCookie.Value] = &bucket{tokens: th.max - 1, time: time.Now().UTC()}
+			atomic.AddUint64(&th.allowed, 1)
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -57,6 +63,7 @@ func (th *Throttle) Throttle(next http.Handler) http.Handler {
 		currentTokens := b.tokens + tokensAdded
 
 		if currentTokens < 1 {
+			atomic.AddUint64(&th.rejected, 1)
 			http.Error(w, model.ErrTooManyRequests.Error(), http.StatusTooManyRequests)
 			return
 		}
@@ -73,6 +80,17 @@ func (th *Throttle) Throttle(next http.Handler) http.Handler {
 			b.tokens = currentTokens - 1
 		}
 
+		atomic.AddUint64(&th.allowed, 1)
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Allowed returns how many requests have been passed on.
+func (th *Throttle) Allowed() uint64 {
+	return atomic.LoadUint64(&th.allowed)
+}
+
+// Rejected returns how many requests have been turned away, either without a UID or for having no tokens left.
+func (th *Throttle) Rejected() uint64 {
+	return atomic.LoadUint64(&th.rejected)
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// OpType is the kind of write a batch operation makes.
+type OpType int
+
+const (
+	OpPut OpType = iota
+	OpDelete
+)
+
+// BatchOp is a single write in a batch, applied only if every operation's precondition holds.
+type BatchOp struct {
+	Type  OpType
+	Key   string
+	Value interface{}
+
+	// Expires is the deadline of a put key, zero if it never expires.
+	Expires time.Time
+
+	// Flags are kept with a put key's value, see KeyValue.
+	Flags uint32
+
+	// Precondition is checked against the key's version before any operation in the batch is applied.
+	Precondition Precondition
+}
@@ -0,0 +1,7 @@
+package model
+
+// Commit makes a write durable before it is applied, e.g. by logging it. It is called with the key values the write
+// leaves, a deleted key without a value or version, once their preconditions hold. The keys are locked against other
+// writes until the write is applied, so every write to a key is committed in version order. The write fails, leaving
+// the store unchanged, if it returns an error.
+type Commit func(kvs []*KeyValue) error
@@ -7,4 +7,7 @@ var (
     ErrInvalidArgument = errors.New("invalid arguments")
     ErrTooManyRequests = errors.New("user has made too many requests")
     ErrInternalError = errors.New("internal error")
+    ErrPreconditionFailed = errors.New("precondition failed")
+    ErrUnavailable = errors.New("service unavailable")
+    ErrReadOnly = errors.New("read-only replica")
 )
@@ -1,7 +1,24 @@
 package model
 
+import "time"
+
 // KeyValue is a simple global key/value data struct.
 type KeyValue struct {
 	Key   string
 	Value interface{}
+
+	// Version increases every time the key is written.
+	Version uint64
+
+	// Expires is the time after which the key is no longer visible, zero if it never expires.
+	Expires time.Time
+
+	// Flags are opaque to the store and kept with the value until the key is written again, e.g. memcached's item
+	// flags.
+	Flags uint32
+}
+
+// Expired reports whether the key value has passed its expiry deadline at the given time.
+func (kv *KeyValue) Expired(now time.Time) bool {
+	return !kv.Expires.IsZero() && !now.Before(kv.Expires)
 }
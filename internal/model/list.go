@@ -0,0 +1,13 @@
+package model
+
+// ListOptions selects the keys returned by a listing, in key order.
+type ListOptions struct {
+	// Prefix limits the listing to keys starting with it.
+	Prefix string
+	// Start is the first key that can be listed, inclusive.
+	Start string
+	// End stops the listing before this key, there is no upper bound if it is empty.
+	End string
+	// Limit caps the number of keys returned, there is no cap if it is zero.
+	Limit int
+}
@@ -0,0 +1,44 @@
+package model
+
+// Precondition describes the state a key must be in for a conditional write to go ahead.
+// The zero value always holds.
+type Precondition struct {
+	// IfMatch requires the key to exist at one of these versions.
+	IfMatch []uint64
+	// IfMatchAny requires the key to exist at any version.
+	IfMatchAny bool
+	// IfNoneMatch requires the key to not be at any of these versions.
+	IfNoneMatch []uint64
+	// IfNoneMatchAny requires the key to not exist.
+	IfNoneMatchAny bool
+}
+
+// Check will return ErrPreconditionFailed if the key's current version and existence don't satisfy the precondition.
+func (p Precondition) Check(version uint64, exists bool) error {
+	if p.IfMatchAny && !exists {
+		return ErrPreconditionFailed
+	}
+
+	if len(p.IfMatch) > 0 && !(exists && containsVersion(p.IfMatch, version)) {
+		return ErrPreconditionFailed
+	}
+
+	if p.IfNoneMatchAny && exists {
+		return ErrPreconditionFailed
+	}
+
+	if len(p.IfNoneMatch) > 0 && exists && containsVersion(p.IfNoneMatch, version) {
+		return ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+func containsVersion(versions []uint64, version uint64) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,751 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// maxAppendEntries caps how many entries are sent to a follower in one AppendEntries call.
+const maxAppendEntries = 256
+
+var (
+	// ErrLeadershipLost is returned when a proposal's leader stepped down before the entry was committed.
+	// The entry may still be committed by the new leader.
+	ErrLeadershipLost = errors.New("leadership lost before the entry was committed")
+	// ErrStopped is returned once the node has been stopped.
+	ErrStopped = errors.New("raft node stopped")
+)
+
+// NotLeaderError is returned when proposing to a node that isn't the leader.
+type NotLeaderError struct {
+	// LeaderID is the node this one last heard from as leader, empty if it doesn't know of one.
+	LeaderID string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.LeaderID == "" {
+		return "not the leader, no leader is known"
+	}
+	return fmt.Sprintf("not the leader, the leader is %s", e.LeaderID)
+}
+
+// Role is the part a node plays in the cluster.
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+func (r Role) String() string {
+	switch r {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return fmt.Sprintf("role(%d)", int(r))
+	}
+}
+
+// EntryType is the kind of entry in the log.
+type EntryType byte
+
+const (
+	// EntryCommand holds data proposed by the application.
+	EntryCommand EntryType = iota
+	// EntryNoop is appended by a new leader so the entries of earlier terms get committed.
+	EntryNoop
+)
+
+// Entry is a single entry in the replicated log.
+type Entry struct {
+	Index uint64    `json:"index"`
+	Term  uint64    `json:"term"`
+	Type  EntryType `json:"type"`
+	Data  []byte    `json:"data,omitempty"`
+}
+
+// Config configures a node.
+type Config struct {
+	// ID is this node's ID, it must be one of the peers.
+	ID string
+	// Peers maps the ID of every node in the cluster, this one included, to its transport address.
+	Peers map[string]string
+
+	// ElectionTimeout is how long a follower waits to hear from a leader before standing for election.
+	// Each wait is randomised between it and twice it so nodes rarely stand at the same time.
+	ElectionTimeout time.Duration
+	// HeartbeatInterval is how often the leader contacts idle followers, well below the election timeout.
+	HeartbeatInterval time.Duration
+
+	Storage   Storage
+	Transport Transport
+
+	// Apply is called with every committed command in log order, on every node. Its result is returned from the
+	// Propose call that proposed the command. It must be deterministic, and is never called concurrently.
+	Apply func(Entry) interface{}
+}
+
+// Status is a snapshot of a node's view of the cluster.
+type Status struct {
+	ID           string
+	Role         Role
+	Term         uint64
+	LeaderID     string
+	LastIndex    uint64
+	CommitIndex  uint64
+	AppliedIndex uint64
+}
+
+// proposal is the outcome of a proposed entry.
+type proposal struct {
+	result interface{}
+	err    error
+}
+
+// future waits on an entry proposed by this node as leader in term.
+type future struct {
+	term uint64
+	done chan proposal
+}
+
+// Node is a member of a Raft cluster. Commands proposed to the leader are appended to its log, replicated to the
+// followers and applied on every node once a majority have stored them.
+type Node struct {
+	config Config
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// wake has a channel per peer, signalled when the leader has something new for it.
+	wake map[string]chan struct{}
+
+	mu        sync.Mutex
+	applyCond *sync.Cond
+	stopped   bool
+	rand      *rand.Rand
+
+	role     Role
+	term     uint64
+	votedFor string
+	leaderID string
+	// log holds every entry, the entry with index i at log[i-1].
+	log         []Entry
+	commitIndex uint64
+	lastApplied uint64
+
+	// lastContact is when the election timer was last reset and electionTimeout how long until it runs out.
+	lastContact     time.Time
+	electionTimeout time.Duration
+
+	// nextIndex and matchIndex are only used by the leader, for every peer but itself.
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	pending    map[uint64]*future
+}
+
+// New creates a node, restoring its term, vote and log from storage. Start must be called to join the cluster.
+func New(config Config) (*Node, error) {
+	if _, ok := config.Peers[config.ID]; !ok {
+		return nil, fmt.Errorf("node %q is not one of the peers", config.ID)
+	}
+
+	term, votedFor, err := config.Storage.State()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raft state: %w", err)
+	}
+	entries, err := config.Storage.Entries()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raft log: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Node{
+		config:     config,
+		ctx:        ctx,
+		cancel:     cancel,
+		wake:       make(map[string]chan struct{}),
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		term:       term,
+		votedFor:   votedFor,
+		log:        entries,
+		nextIndex:  make(map[string]uint64),
+		matchIndex: make(map[string]uint64),
+		pending:    make(map[uint64]*future),
+	}
+	n.applyCond = sync.NewCond(&n.mu)
+	for id := range config.Peers {
+		if id != config.ID {
+			n.wake[id] = make(chan struct{}, 1)
+		}
+	}
+	n.resetElectionTimer()
+
+	return n, nil
+}
+
+// Start runs the node's election timer and applies committed entries in the background until Stop is called.
+func (n *Node) Start() {
+	n.wg.Add(2)
+	go n.tick()
+	go n.applyCommitted()
+}
+
+// Stop leaves the cluster, failing pending proposals with ErrStopped, and waits for the node's goroutines to exit.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	n.failPending(ErrStopped)
+	n.applyCond.Broadcast()
+	n.mu.Unlock()
+
+	n.cancel()
+	n.wg.Wait()
+}
+
+// Propose appends the command to the leader's log and waits for it to be committed and applied, returning the
+// result of applying it. A *NotLeaderError is returned if this node isn't the leader.
+func (n *Node) Propose(ctx context.Context, data []byte) (interface{}, error) {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return nil, ErrStopped
+	}
+	if n.role != Leader {
+		leader := n.leaderID
+		n.mu.Unlock()
+		return nil, &NotLeaderError{LeaderID: leader}
+	}
+
+	e := Entry{
+		Index: n.lastIndex() + 1,
+		Term:  n.term,
+		Type:  EntryCommand,
+		Data:  data,
+	}
+	if err := n.appendLog([]Entry{e}); err != nil {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("failed to append to raft log: %w", err)
+	}
+
+	f := &future{
+		term: n.term,
+		done: make(chan proposal, 1),
+	}
+	n.pending[e.Index] = f
+	n.advanceCommit()
+	n.wakeReplicators()
+	n.mu.Unlock()
+
+	select {
+	case p := <-f.done:
+		return p.result, p.err
+	case <-ctx.Done():
+		n.mu.Lock()
+		if n.pending[e.Index] == f {
+			delete(n.pending, e.Index)
+		}
+		n.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Status returns the node's current view of the cluster.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return Status{
+		ID:           n.config.ID,
+		Role:         n.role,
+		Term:         n.term,
+		LeaderID:     n.leaderID,
+		LastIndex:    n.lastIndex(),
+		CommitIndex:  n.commitIndex,
+		AppliedIndex: n.lastApplied,
+	}
+}
+
+// HandleRequestVote answers a candidate's request for this node's vote.
+func (n *Node) HandleRequestVote(req RequestVoteRequest) (RequestVoteResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return RequestVoteResponse{}, ErrStopped
+	}
+
+	if req.Term > n.term {
+		if err := n.stepDown(req.Term, ""); err != nil {
+			return RequestVoteResponse{}, err
+		}
+	}
+
+	resp := RequestVoteResponse{Term: n.term}
+	if req.Term < n.term || (n.votedFor != "" && n.votedFor != req.CandidateID) {
+		return resp, nil
+	}
+
+	// only vote for a candidate whose log has every entry this one does
+	lastIndex, lastTerm := n.lastIndex(), n.termAt(n.lastIndex())
+	if req.LastLogTerm < lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex < lastIndex) {
+		return resp, nil
+	}
+
+	if err := n.config.Storage.SetState(n.term, req.CandidateID); err != nil {
+		return RequestVoteResponse{}, fmt.Errorf("failed to save vote: %w", err)
+	}
+	n.votedFor = req.CandidateID
+	n.resetElectionTimer()
+	resp.VoteGranted = true
+
+	return resp, nil
+}
+
+// HandleAppendEntries stores the leader's entries once this node's log matches the leader's up to them.
+func (n *Node) HandleAppendEntries(req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return AppendEntriesResponse{}, ErrStopped
+	}
+
+	if req.Term < n.term {
+		return AppendEntriesResponse{Term: n.term, LastIndex: n.lastIndex()}, nil
+	}
+	if req.Term > n.term || n.role != Follower {
+		if err := n.stepDown(req.Term, req.LeaderID); err != nil {
+			return AppendEntriesResponse{}, err
+		}
+	}
+	n.leaderID = req.LeaderID
+	n.resetElectionTimer()
+
+	resp := AppendEntriesResponse{Term: n.term}
+	if req.PrevLogIndex > n.lastIndex() {
+		resp.LastIndex = n.lastIndex()
+		return resp, nil
+	}
+	if conflict := n.termAt(req.PrevLogIndex); conflict != req.PrevLogTerm {
+		// skip back over the whole conflicting term rather than one entry per call
+		index := req.PrevLogIndex - 1
+		for index > 0 && n.termAt(index) == conflict {
+			index--
+		}
+		resp.LastIndex = index
+		return resp, nil
+	}
+
+	for i, e := range req.Entries {
+		if e.Index <= n.lastIndex() {
+			if n.termAt(e.Index) == e.Term {
+				continue
+			}
+			if err := n.config.Storage.TruncateFrom(e.Index); err != nil {
+				return AppendEntriesResponse{}, fmt.Errorf("failed to truncate raft log: %w", err)
+			}
+			n.log = n.log[:e.Index-1]
+		}
+
+		if err := n.appendLog(req.Entries[i:]); err != nil {
+			return AppendEntriesResponse{}, fmt.Errorf("failed to append to raft log: %w", err)
+		}
+		break
+	}
+
+	if req.LeaderCommit > n.commitIndex {
+		commit := req.LeaderCommit
+		if last := req.PrevLogIndex + uint64(len(req.Entries)); last < commit {
+			commit = last
+		}
+		if commit > n.commitIndex {
+			n.commitIndex = commit
+			n.applyCond.Broadcast()
+		}
+	}
+
+	resp.Success = true
+	resp.LastIndex = n.lastIndex()
+
+	return resp, nil
+}
+
+// tick stands for election whenever the election timer runs out without hearing from a leader.
+func (n *Node) tick() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		if n.role != Leader && time.Since(n.lastContact) >= n.electionTimeout {
+			n.startElection()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// startElection votes for this node in a new term and asks every peer for theirs. The lock must be held.
+func (n *Node) startElection() {
+	n.resetElectionTimer()
+
+	term := n.term + 1
+	if err := n.config.Storage.SetState(term, n.config.ID); err != nil {
+		log.Printf("raft: failed to save state to stand for election: %v", err)
+		return
+	}
+	n.term = term
+	n.votedFor = n.config.ID
+	n.role = Candidate
+	n.leaderID = ""
+
+	votes := 1
+	if n.isMajority(votes) {
+		n.becomeLeader()
+		return
+	}
+
+	req := RequestVoteRequest{
+		Term:         term,
+		CandidateID:  n.config.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.termAt(n.lastIndex()),
+	}
+	for id, addr := range n.config.Peers {
+		if id == n.config.ID {
+			continue
+		}
+
+		n.wg.Add(1)
+		go func(addr string) {
+			defer n.wg.Done()
+
+			ctx, cancel := context.WithTimeout(n.ctx, n.config.ElectionTimeout)
+			defer cancel()
+
+			resp, err := n.config.Transport.RequestVote(ctx, addr, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			if n.stopped {
+				return
+			}
+			if resp.Term > n.term {
+				if err := n.stepDown(resp.Term, ""); err != nil {
+					log.Printf("raft: %v", err)
+				}
+				return
+			}
+			if n.role != Candidate || n.term != term || !resp.VoteGranted {
+				return
+			}
+
+			votes++
+			if n.isMajority(votes) {
+				n.becomeLeader()
+			}
+		}(addr)
+	}
+}
+
+// becomeLeader takes over as leader, appending a no-op entry so that committing it commits everything before it.
+// The lock must be held.
+func (n *Node) becomeLeader() {
+	n.role = Leader
+	n.leaderID = n.config.ID
+	for id := range n.wake {
+		n.nextIndex[id] = n.lastIndex() + 1
+		n.matchIndex[id] = 0
+	}
+
+	noop := Entry{
+		Index: n.lastIndex() + 1,
+		Term:  n.term,
+		Type:  EntryNoop,
+	}
+	if err := n.appendLog([]Entry{noop}); err != nil {
+		log.Printf("raft: failed to append to raft log as leader: %v", err)
+		if err := n.stepDown(n.term, ""); err != nil {
+			log.Printf("raft: %v", err)
+		}
+		return
+	}
+	n.advanceCommit()
+
+	for id, addr := range n.config.Peers {
+		if id == n.config.ID {
+			continue
+		}
+
+		n.wg.Add(1)
+		go n.replicate(id, addr, n.term)
+	}
+}
+
+// stepDown becomes a follower of leader, which may be unknown, moving to term if it is newer. Proposals waiting on
+// this node as leader fail with ErrLeadershipLost. The lock must be held.
+func (n *Node) stepDown(term uint64, leader string) error {
+	if term > n.term {
+		if err := n.config.Storage.SetState(term, ""); err != nil {
+			return fmt.Errorf("failed to save raft state: %w", err)
+		}
+		n.term = term
+		n.votedFor = ""
+	}
+
+	if n.role == Leader {
+		n.failPending(ErrLeadershipLost)
+	}
+	if n.role != Follower {
+		// give the new leader a full election timeout to make contact
+		n.resetElectionTimer()
+	}
+	n.role = Follower
+	n.leaderID = leader
+
+	return nil
+}
+
+// replicate keeps the peer's log in step with this node's for as long as it is leader in term.
+func (n *Node) replicate(id, addr string, term uint64) {
+	defer n.wg.Done()
+
+	// the first round goes out straight away to announce the new leader
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-n.wake[id]:
+		case <-timer.C:
+		}
+
+		for {
+			more, ok := n.sendAppendEntries(id, addr, term)
+			if !ok {
+				return
+			}
+			if !more {
+				break
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(n.config.HeartbeatInterval)
+	}
+}
+
+// sendAppendEntries sends the peer the entries it is missing, or a heartbeat if it has them all. more reports whether
+// it should be sent to again straight away, ok is false once this node is no longer leader in term.
+func (n *Node) sendAppendEntries(id, addr string, term uint64) (more, ok bool) {
+	n.mu.Lock()
+	if n.stopped || n.role != Leader || n.term != term {
+		n.mu.Unlock()
+		return false, false
+	}
+
+	prevIndex := n.nextIndex[id] - 1
+	end := n.lastIndex()
+	if end > prevIndex+maxAppendEntries {
+		end = prevIndex + maxAppendEntries
+	}
+	req := AppendEntriesRequest{
+		Term:         term,
+		LeaderID:     n.config.ID,
+		PrevLogIndex: prevIndex,
+		PrevLogTerm:  n.termAt(prevIndex),
+		Entries:      append([]Entry(nil), n.log[prevIndex:end]...),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(n.ctx, n.config.ElectionTimeout)
+	resp, err := n.config.Transport.AppendEntries(ctx, addr, req)
+	cancel()
+	if err != nil {
+		// an unreachable peer is retried on the next heartbeat
+		return false, true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return false, false
+	}
+	if resp.Term > n.term {
+		if err := n.stepDown(resp.Term, ""); err != nil {
+			log.Printf("raft: %v", err)
+		}
+		return false, false
+	}
+	if n.role != Leader || n.term != term {
+		return false, false
+	}
+
+	if !resp.Success {
+		// back up to just after the last entry the peer might have in common
+		next := resp.LastIndex + 1
+		if next > prevIndex {
+			next = prevIndex
+		}
+		if next < 1 {
+			next = 1
+		}
+		n.nextIndex[id] = next
+		return true, true
+	}
+
+	match := prevIndex + uint64(len(req.Entries))
+	if match > n.matchIndex[id] {
+		n.matchIndex[id] = match
+	}
+	n.nextIndex[id] = match + 1
+	n.advanceCommit()
+
+	return n.nextIndex[id] <= n.lastIndex(), true
+}
+
+// advanceCommit commits the newest entry of the current term stored by a majority, and everything before it.
+// Entries of earlier terms are only ever committed this way, by an entry that follows them. The lock must be held.
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.termAt(index) != n.term {
+			return
+		}
+
+		votes := 1
+		for _, match := range n.matchIndex {
+			if match >= index {
+				votes++
+			}
+		}
+		if n.isMajority(votes) {
+			n.commitIndex = index
+			n.applyCond.Broadcast()
+			// let the followers know so they can apply it too
+			n.wakeReplicators()
+			return
+		}
+	}
+}
+
+// applyCommitted passes every committed command to the Apply func in order and completes its proposal.
+func (n *Node) applyCommitted() {
+	defer n.wg.Done()
+
+	for {
+		n.mu.Lock()
+		for !n.stopped && n.lastApplied >= n.commitIndex {
+			n.applyCond.Wait()
+		}
+		if n.stopped {
+			n.mu.Unlock()
+			return
+		}
+		entries := append([]Entry(nil), n.log[n.lastApplied:n.commitIndex]...)
+		n.mu.Unlock()
+
+		for _, e := range entries {
+			var result interface{}
+			if e.Type == EntryCommand {
+				result = n.config.Apply(e)
+			}
+
+			n.mu.Lock()
+			n.lastApplied = e.Index
+			if f, ok := n.pending[e.Index]; ok {
+				delete(n.pending, e.Index)
+				if f.term == e.Term {
+					f.done <- proposal{result: result}
+				} else {
+					// the proposal was overwritten by another leader's entry
+					f.done <- proposal{err: ErrLeadershipLost}
+				}
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// appendLog stores the entries then adds them to the log. The lock must be held.
+func (n *Node) appendLog(entries []Entry) error {
+	if err := n.config.Storage.Append(entries); err != nil {
+		return err
+	}
+	n.log = append(n.log, entries...)
+
+	return nil
+}
+
+// failPending completes every waiting proposal with err. The lock must be held.
+func (n *Node) failPending(err error) {
+	for index, f := range n.pending {
+		f.done <- proposal{err: err}
+		delete(n.pending, index)
+	}
+}
+
+// wakeReplicators has every peer's replicator send to it without waiting for the next heartbeat.
+func (n *Node) wakeReplicators() {
+	for _, wake := range n.wake {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// resetElectionTimer restarts the election timer with a new random timeout. The lock must be held.
+func (n *Node) resetElectionTimer() {
+	n.lastContact = time.Now()
+	n.electionTimeout = n.config.ElectionTimeout + time.Duration(n.rand.Int63n(int64(n.config.ElectionTimeout)))
+}
+
+func (n *Node) isMajority(votes int) bool {
+	return votes*2 > len(n.config.Peers)
+}
+
+func (n *Node) lastIndex() uint64 {
+	return uint64(len(n.log))
+}
+
+// termAt returns the term of the entry at index, 0 for index 0 which comes before the first entry.
+func (n *Node) termAt(index uint64) uint64 {
+	if index == 0 {
+		return 0
+	}
+	return n.log[index-1].Term
+}
@@ -0,0 +1,364 @@
+package raft_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/raft"
+)
+
+const (
+	electionTimeout   = 150 * time.Millisecond
+	heartbeatInterval = 30 * time.Millisecond
+	waitTimeout       = 5 * time.Second
+	pollInterval      = 10 * time.Millisecond
+)
+
+// member is a node of a test cluster, served over loopback HTTP. Its server outlives the node so it can be restarted
+// at the same address.
+type member struct {
+	id      string
+	storage *raft.MemoryStorage
+	srv     *httptest.Server
+
+	mu      sync.Mutex
+	node    *raft.Node
+	applied []string
+}
+
+func (m *member) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	node := m.node
+	m.mu.Unlock()
+
+	if node == nil {
+		http.Error(w, "node is down", http.StatusServiceUnavailable)
+		return
+	}
+	node.Handler().ServeHTTP(w, r)
+}
+
+func (m *member) Node() *raft.Node {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.node
+}
+
+func (m *member) Applied() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string(nil), m.applied...)
+}
+
+// cluster runs nodes in process, cutting the links to isolated nodes.
+type cluster struct {
+	t       *testing.T
+	peers   map[string]string
+	members map[string]*member
+
+	mu       sync.Mutex
+	isolated map[string]bool
+}
+
+func newCluster(t *testing.T, size int) *cluster {
+	c := &cluster{
+		t:        t,
+		peers:    make(map[string]string),
+		members:  make(map[string]*member),
+		isolated: make(map[string]bool),
+	}
+
+	for i := 1; i <= size; i++ {
+		m := &member{
+			id:      "n" + strconv.Itoa(i),
+			storage: raft.NewMemoryStorage(),
+		}
+		m.srv = httptest.NewServer(m)
+		t.Cleanup(m.srv.Close)
+
+		c.members[m.id] = m
+		c.peers[m.id] = m.srv.Listener.Addr().String()
+	}
+
+	for id := range c.members {
+		c.start(id)
+	}
+	t.Cleanup(func() {
+		for id := range c.members {
+			c.stop(id)
+		}
+	})
+
+	return c
+}
+
+// start runs the member's node, applying the log from the start as a restarted process would.
+func (c *cluster) start(id string) {
+	m := c.members[id]
+
+	node, err := raft.New(raft.Config{
+		ID:                id,
+		Peers:             c.peers,
+		ElectionTimeout:   electionTimeout,
+		HeartbeatInterval: heartbeatInterval,
+		Storage:           m.storage,
+		Transport:         &partitionTransport{from: id, c: c, next: raft.NewHTTPTransport(nil)},
+		Apply: func(e raft.Entry) interface{} {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			m.applied = append(m.applied, string(e.Data))
+			return e.Index
+		},
+	})
+	require.NoError(c.t, err)
+
+	m.mu.Lock()
+	m.node = node
+	m.applied = nil
+	m.mu.Unlock()
+
+	node.Start()
+}
+
+func (c *cluster) stop(id string) {
+	m := c.members[id]
+
+	m.mu.Lock()
+	node := m.node
+	m.node = nil
+	m.mu.Unlock()
+
+	if node != nil {
+		node.Stop()
+	}
+}
+
+func (c *cluster) isolate(id string, isolated bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.isolated[id] = isolated
+}
+
+// cut reports whether the link between the node and the address is down.
+func (c *cluster) cut(from, addr string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isolated[from] {
+		return true
+	}
+	for id, peer := range c.peers {
+		if peer == addr {
+			return c.isolated[id]
+		}
+	}
+	return false
+}
+
+// leader waits for exactly one running, connected node to be leader with every other such node following it.
+func (c *cluster) leader() *member {
+	var leader *member
+	require.Eventually(c.t, func() bool {
+		leader = nil
+		var statuses []raft.Status
+		for id, m := range c.members {
+			node := m.Node()
+			if node == nil || c.cut(id, "") {
+				continue
+			}
+
+			status := node.Status()
+			statuses = append(statuses, status)
+			if status.Role == raft.Leader {
+				if leader != nil {
+					return false
+				}
+				leader = m
+			}
+		}
+		if leader == nil {
+			return false
+		}
+
+		for _, status := range statuses {
+			if status.LeaderID != leader.id {
+				return false
+			}
+		}
+		return true
+	}, waitTimeout, pollInterval)
+
+	return leader
+}
+
+func (c *cluster) propose(m *member, data string) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+
+	return m.Node().Propose(ctx, []byte(data))
+}
+
+// applied waits for every running node to have applied exactly the commands.
+func (c *cluster) applied(expected ...string) {
+	for id, m := range c.members {
+		if m.Node() == nil {
+			continue
+		}
+
+		require.Eventually(c.t, func() bool {
+			return assert.ObjectsAreEqual(expected, m.Applied())
+		}, waitTimeout, pollInterval, "node %s applied %v", id, m.Applied())
+	}
+}
+
+type partitionTransport struct {
+	from string
+	c    *cluster
+	next raft.Transport
+}
+
+var errCut = errors.New("link cut")
+
+func (t *partitionTransport) RequestVote(ctx context.Context, addr string, req raft.RequestVoteRequest) (raft.RequestVoteResponse, error) {
+	if t.c.cut(t.from, addr) {
+		return raft.RequestVoteResponse{}, errCut
+	}
+	return t.next.RequestVote(ctx, addr, req)
+}
+
+func (t *partitionTransport) AppendEntries(ctx context.Context, addr string, req raft.AppendEntriesRequest) (raft.AppendEntriesResponse, error) {
+	if t.c.cut(t.from, addr) {
+		return raft.AppendEntriesResponse{}, errCut
+	}
+	return t.next.AppendEntries(ctx, addr, req)
+}
+
+func TestNode_SingleNode(t *testing.T) {
+	c := newCluster(t, 1)
+	leader := c.leader()
+
+	// the leader's no-op is the first entry
+	result, err := c.propose(leader, "a")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), result)
+
+	c.applied("a")
+}
+
+func TestCluster_Replicates(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+
+	for _, data := range []string{"a", "b", "c"} {
+		_, err := c.propose(leader, data)
+		require.NoError(t, err)
+	}
+
+	c.applied("a", "b", "c")
+
+	status := leader.Node().Status()
+	assert.Equal(t, uint64(4), status.CommitIndex)
+	assert.Equal(t, uint64(4), status.AppliedIndex)
+}
+
+func TestCluster_ProposeToFollower(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+
+	for id, m := range c.members {
+		if id == leader.id {
+			continue
+		}
+
+		_, err := c.propose(m, "a")
+
+		var notLeader *raft.NotLeaderError
+		require.ErrorAs(t, err, &notLeader)
+		assert.Equal(t, leader.id, notLeader.LeaderID)
+	}
+}
+
+func TestCluster_LeaderFailover(t *testing.T) {
+	c := newCluster(t, 3)
+	old := c.leader()
+
+	_, err := c.propose(old, "a")
+	require.NoError(t, err)
+
+	c.stop(old.id)
+	leader := c.leader()
+	assert.NotEqual(t, old.id, leader.id)
+
+	_, err = c.propose(leader, "b")
+	require.NoError(t, err)
+	c.applied("a", "b")
+
+	// the old leader catches up from its stored log once it restarts
+	c.start(old.id)
+	assert.Equal(t, leader.id, c.leader().id)
+	c.applied("a", "b")
+}
+
+func TestCluster_Partition(t *testing.T) {
+	c := newCluster(t, 3)
+	old := c.leader()
+
+	_, err := c.propose(old, "a")
+	require.NoError(t, err)
+
+	// a leader cut off from the majority can't commit
+	c.isolate(old.id, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*electionTimeout)
+	defer cancel()
+	_, err = old.Node().Propose(ctx, []byte("lost"))
+	require.Error(t, err)
+
+	leader := c.leader()
+	assert.NotEqual(t, old.id, leader.id)
+
+	_, err = c.propose(leader, "b")
+	require.NoError(t, err)
+
+	// once healed the old leader steps down and its uncommitted entry is replaced
+	c.isolate(old.id, false)
+	assert.Equal(t, leader.id, c.leader().id)
+	c.applied("a", "b")
+}
+
+func TestNode_Stop(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+
+	// without a majority the proposal waits until the node is stopped
+	for id := range c.members {
+		if id != leader.id {
+			c.stop(id)
+		}
+	}
+
+	proposed := make(chan error, 1)
+	go func() {
+		_, err := c.propose(leader, "a")
+		proposed <- err
+	}()
+
+	require.Eventually(t, func() bool {
+		return leader.Node().Status().LastIndex == 2
+	}, waitTimeout, pollInterval)
+	c.stop(leader.id)
+
+	assert.ErrorIs(t, <-proposed, raft.ErrStopped)
+}
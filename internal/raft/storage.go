@@ -0,0 +1,360 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Storage persists a node's term, vote and log. Every method must be durable once it returns without error.
+type Storage interface {
+	// State returns the saved term and vote, zero values if nothing has been saved.
+	State() (term uint64, votedFor string, err error)
+	SetState(term uint64, votedFor string) error
+	// Entries returns the whole log in index order.
+	Entries() ([]Entry, error)
+	// Append adds entries that directly follow the last one stored.
+	Append(entries []Entry) error
+	// TruncateFrom removes the entry at index and every entry after it.
+	TruncateFrom(index uint64) error
+}
+
+// MemoryStorage keeps everything in memory, so a node using it loses its state when it stops.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	term     uint64
+	votedFor string
+	entries  []Entry
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) State() (uint64, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.term, s.votedFor, nil
+}
+
+func (s *MemoryStorage) SetState(term uint64, votedFor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.term, s.votedFor = term, votedFor
+	return nil
+}
+
+func (s *MemoryStorage) Entries() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Entry(nil), s.entries...), nil
+}
+
+func (s *MemoryStorage) Append(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func (s *MemoryStorage) TruncateFrom(index uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index <= uint64(len(s.entries)) {
+		s.entries = s.entries[:index-1]
+	}
+	return nil
+}
+
+const (
+	stateFilename = "state.json"
+	logFilename   = "log"
+
+	// entryHeaderSize is the length and CRC-32C of the payload framing every entry in the log file.
+	entryHeaderSize = 8
+	maxEntrySize    = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FileStorage keeps the term and vote in a small JSON file, replaced atomically, and the log in an append only file
+// of checksummed records. A record torn by a crash while appending is dropped when the log is next read.
+type FileStorage struct {
+	dir string
+
+	mu  sync.Mutex
+	log *os.File
+	// offsets holds where each entry starts in the log file, for truncating.
+	offsets []int64
+	size    int64
+}
+
+type fileState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for"`
+}
+
+// NewFileStorage opens the storage in dir, creating it if needed.
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, logFilename), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStorage{
+		dir: dir,
+		log: log,
+	}, nil
+}
+
+func (s *FileStorage) State() (uint64, string, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, stateFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	var state fileState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return 0, "", fmt.Errorf("invalid raft state: %w", err)
+	}
+
+	return state.Term, state.VotedFor, nil
+}
+
+func (s *FileStorage) SetState(term uint64, votedFor string) error {
+	data, err := json.Marshal(fileState{Term: term, VotedFor: votedFor})
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(s.dir, stateFilename)
+	tmp, err := os.OpenFile(filename+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		return err
+	}
+
+	return syncDir(s.dir)
+}
+
+// Entries reads the whole log, truncating a torn record from its end.
+func (s *FileStorage) Entries() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(s.log)
+
+	var (
+		entries []Entry
+		offset  int64
+	)
+	s.offsets = s.offsets[:0]
+	for {
+		e, n, err := readEntry(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, errTornEntry) {
+			if err := s.log.Truncate(offset); err != nil {
+				return nil, fmt.Errorf("failed to truncate torn raft log: %w", err)
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("raft log corrupt at offset %d: %w", offset, err)
+		}
+
+		if want := uint64(len(entries) + 1); e.Index != want {
+			return nil, fmt.Errorf("raft log has entry %d where %d was expected", e.Index, want)
+		}
+
+		entries = append(entries, e)
+		s.offsets = append(s.offsets, offset)
+		offset += int64(n)
+	}
+	s.size = offset
+
+	return entries, nil
+}
+
+func (s *FileStorage) Append(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf []byte
+	offsets := make([]int64, len(entries))
+	for i, e := range entries {
+		offsets[i] = s.size + int64(len(buf))
+		buf = append(buf, encodeEntry(e)...)
+	}
+
+	if _, err := s.log.WriteAt(buf, s.size); err != nil {
+		return err
+	}
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+
+	s.offsets = append(s.offsets, offsets...)
+	s.size += int64(len(buf))
+
+	return nil
+}
+
+func (s *FileStorage) TruncateFrom(index uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index > uint64(len(s.offsets)) {
+		return nil
+	}
+
+	offset := s.offsets[index-1]
+	if err := s.log.Truncate(offset); err != nil {
+		return err
+	}
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+
+	s.offsets = s.offsets[:index-1]
+	s.size = offset
+
+	return nil
+}
+
+// Close closes the log file.
+func (s *FileStorage) Close() error {
+	return s.log.Close()
+}
+
+var errTornEntry = errors.New("torn entry")
+
+// encodeEntry frames the entry as its payload's length and checksum followed by the payload, which is the index,
+// term and type followed by the data.
+func encodeEntry(e Entry) []byte {
+	payload := make([]byte, 0, 2*binary.MaxVarintLen64+1+len(e.Data))
+	payload = appendUvarint(payload, e.Index)
+	payload = appendUvarint(payload, e.Term)
+	payload = append(payload, byte(e.Type))
+	payload = append(payload, e.Data...)
+
+	record := make([]byte, entryHeaderSize, entryHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+
+	return append(record, payload...)
+}
+
+// readEntry reads the next entry and how many bytes it took. io.EOF is returned at a clean end of the log and
+// errTornEntry if it ends part way through an entry or the last entry fails its checksum.
+func readEntry(r *bufio.Reader) (Entry, int, error) {
+	var e Entry
+
+	header := make([]byte, entryHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if errors.Is(err, io.EOF) {
+		return e, 0, io.EOF
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return e, n, errTornEntry
+	}
+	if err != nil {
+		return e, n, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxEntrySize {
+		return e, n, fmt.Errorf("entry length %d exceeds maximum", length)
+	}
+
+	payload := make([]byte, length)
+	m, err := io.ReadFull(r, payload)
+	n += m
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return e, n, errTornEntry
+	}
+	if err != nil {
+		return e, n, err
+	}
+
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		// entries are synced one append at a time, so a bad checksum can only be a torn final write
+		if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+			return e, n, errTornEntry
+		}
+		return e, n, errors.New("checksum mismatch")
+	}
+
+	var size int
+	e.Index, size = binary.Uvarint(payload)
+	if size <= 0 {
+		return e, n, errors.New("invalid index")
+	}
+	payload = payload[size:]
+
+	e.Term, size = binary.Uvarint(payload)
+	if size <= 0 || len(payload) == size {
+		return e, n, errors.New("invalid term")
+	}
+	payload = payload[size:]
+
+	e.Type = EntryType(payload[0])
+	if len(payload) > 1 {
+		e.Data = payload[1:]
+	}
+
+	return e, n, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+// syncDir flushes directory entries such as renames to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,111 @@
+package raft
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorage(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewFileStorage(dir)
+	require.NoError(t, err)
+
+	term, votedFor, err := s.State()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), term)
+	assert.Equal(t, "", votedFor)
+
+	entries := []Entry{
+		{Index: 1, Term: 1, Type: EntryNoop},
+		{Index: 2, Term: 1, Type: EntryCommand, Data: []byte("a")},
+		{Index: 3, Term: 2, Type: EntryCommand, Data: []byte("b")},
+	}
+	require.NoError(t, s.SetState(2, "n1"))
+	require.NoError(t, s.Append(entries[:2]))
+	require.NoError(t, s.Append(entries[2:]))
+	require.NoError(t, s.Close())
+
+	s, err = NewFileStorage(dir)
+	require.NoError(t, err)
+	defer s.Close()
+
+	term, votedFor, err = s.State()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), term)
+	assert.Equal(t, "n1", votedFor)
+
+	read, err := s.Entries()
+	require.NoError(t, err)
+	assert.Equal(t, entries, read)
+
+	// a conflicting entry replaces the tail of the log
+	replacement := Entry{Index: 2, Term: 3, Type: EntryCommand, Data: []byte("c")}
+	require.NoError(t, s.TruncateFrom(2))
+	require.NoError(t, s.Append([]Entry{replacement}))
+
+	read, err = s.Entries()
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{entries[0], replacement}, read)
+}
+
+func TestFileStorage_TornTail(t *testing.T) {
+	tests := map[string]struct {
+		tear func(data []byte) []byte
+	}{
+		"part of a header": {
+			tear: func(data []byte) []byte { return append(data, 0, 0, 0) },
+		},
+		"part of a payload": {
+			tear: func(data []byte) []byte {
+				torn := encodeEntry(Entry{Index: 3, Term: 1, Data: []byte("torn")})
+				return append(data, torn[:len(torn)-2]...)
+			},
+		},
+		"bad checksum": {
+			tear: func(data []byte) []byte {
+				torn := encodeEntry(Entry{Index: 3, Term: 1, Data: []byte("torn")})
+				torn[len(torn)-1] ^= 0xff
+				return append(data, torn...)
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			entries := []Entry{
+				{Index: 1, Term: 1, Type: EntryNoop},
+				{Index: 2, Term: 1, Type: EntryCommand, Data: []byte("a")},
+			}
+
+			s, err := NewFileStorage(dir)
+			require.NoError(t, err)
+			require.NoError(t, s.Append(entries))
+			require.NoError(t, s.Close())
+
+			filename := filepath.Join(dir, logFilename)
+			data, err := os.ReadFile(filename)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filename, test.tear(data), 0644))
+
+			s, err = NewFileStorage(dir)
+			require.NoError(t, err)
+			defer s.Close()
+
+			read, err := s.Entries()
+			require.NoError(t, err)
+			assert.Equal(t, entries, read)
+
+			// appends carry on from the last whole entry
+			next := Entry{Index: 3, Term: 2, Type: EntryCommand, Data: []byte("b")}
+			require.NoError(t, s.Append([]Entry{next}))
+			read, err = s.Entries()
+			require.NoError(t, err)
+			assert.Equal(t, append(entries, next), read)
+		})
+	}
+}
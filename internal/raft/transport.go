@@ -0,0 +1,162 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	requestVotePath   = "/raft/request-vote"
+	appendEntriesPath = "/raft/append-entries"
+
+	// maxRPCSize caps the size of a request body read by the handler.
+	maxRPCSize = 256 << 20
+)
+
+// RequestVoteRequest asks a peer to vote for the candidate in the term.
+type RequestVoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidate_id"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type RequestVoteResponse struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+}
+
+// AppendEntriesRequest has a follower store the entries after the one at PrevLogIndex, if its log has that entry
+// at PrevLogTerm. An empty request is a heartbeat.
+type AppendEntriesRequest struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leader_id"`
+	PrevLogIndex uint64  `json:"prev_log_index"`
+	PrevLogTerm  uint64  `json:"prev_log_term"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leader_commit"`
+}
+
+// AppendEntriesResponse reports whether the entries were stored. LastIndex is the follower's last entry on success,
+// otherwise the last entry that may match the leader's, for the leader to retry from.
+type AppendEntriesResponse struct {
+	Term      uint64 `json:"term"`
+	Success   bool   `json:"success"`
+	LastIndex uint64 `json:"last_index"`
+}
+
+// Transport sends RPCs to the peer at an address.
+type Transport interface {
+	RequestVote(ctx context.Context, addr string, req RequestVoteRequest) (RequestVoteResponse, error)
+	AppendEntries(ctx context.Context, addr string, req AppendEntriesRequest) (AppendEntriesResponse, error)
+}
+
+// HTTPTransport sends RPCs as JSON over HTTP to the handler returned by Node.Handler.
+type HTTPTransport struct {
+	client *http.Client
+}
+
+// NewHTTPTransport creates a transport using client, or http.DefaultClient if it is nil.
+func NewHTTPTransport(client *http.Client) *HTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTPTransport{client: client}
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, addr string, req RequestVoteRequest) (RequestVoteResponse, error) {
+	var resp RequestVoteResponse
+	err := t.call(ctx, addr, requestVotePath, req, &resp)
+	return resp, err
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, addr string, req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	var resp AppendEntriesResponse
+	err := t.call(ctx, addr, appendEntriesPath, req, &resp)
+	return resp, err
+}
+
+func (t *HTTPTransport) call(ctx context.Context, addr, path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := t.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(httpResp.Body, 512))
+		return fmt.Errorf("raft rpc to %s failed with status %d: %s", addr, httpResp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// Handler serves the node's RPCs for an HTTPTransport.
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(requestVotePath, func(w http.ResponseWriter, r *http.Request) {
+		var req RequestVoteRequest
+		serveRPC(w, r, &req, func() (interface{}, error) {
+			return n.HandleRequestVote(req)
+		})
+	})
+	mux.HandleFunc(appendEntriesPath, func(w http.ResponseWriter, r *http.Request) {
+		var req AppendEntriesRequest
+		serveRPC(w, r, &req, func() (interface{}, error) {
+			return n.HandleAppendEntries(req)
+		})
+	})
+
+	return mux
+}
+
+// serveRPC decodes the request into req, then writes the response returned by handle as JSON.
+func serveRPC(w http.ResponseWriter, r *http.Request, req interface{}, handle func() (interface{}, error)) {
+	if r.Method != http.MethodPost {
+		http.Error(w,
+			"method not allowed",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRPCSize)).Decode(req); err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	resp, err := handle()
+	if errors.Is(err, ErrStopped) {
+		http.Error(w,
+			err.Error(),
+			http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,125 @@
+package raftkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
+)
+
+const (
+	proposePath = "/raftkv/propose"
+
+	// maxCommandSize caps the size of a forwarded command read by the handler.
+	maxCommandSize = 64 << 20
+)
+
+// forwardErrors names the store errors that keep their meaning when returned from the leader.
+var forwardErrors = map[string]error{
+	"key_not_found":       model.ErrKeyNotFound,
+	"invalid_argument":    model.ErrInvalidArgument,
+	"precondition_failed": model.ErrPreconditionFailed,
+	"unavailable":         model.ErrUnavailable,
+}
+
+// forwardResponse is the leader's result for a forwarded command.
+type forwardResponse struct {
+	KeyValues []*model.KeyValue `json:"key_values,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	// Kind is the name of the store error in forwardErrors the error wraps, if any.
+	Kind string `json:"kind,omitempty"`
+}
+
+// forwardedError is an error returned by the leader, wrapping the store error it was made from.
+type forwardedError struct {
+	msg string
+	err error
+}
+
+func (e *forwardedError) Error() string { return e.msg }
+
+func (e *forwardedError) Unwrap() error { return e.err }
+
+// Handler serves the Raft RPCs and commands forwarded from followers, on the address the node is known by to its
+// peers. It must only be reachable by the cluster.
+func (s *Store) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/raft/", s.node.Handler())
+	mux.HandleFunc(proposePath, s.proposeHandler)
+
+	return mux
+}
+
+// proposeHandler proposes a command forwarded by a follower, without forwarding it on if this node has stopped being
+// the leader.
+func (s *Store) proposeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w,
+			"method not allowed",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	var cmd command
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandSize)).Decode(&cmd); err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	var resp forwardResponse
+	kvs, err := s.propose(cmd, false)
+	if err != nil {
+		resp.Error = err.Error()
+		for kind, target := range forwardErrors {
+			if errors.Is(err, target) {
+				resp.Kind = kind
+				break
+			}
+		}
+	}
+	resp.KeyValues = kvs
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// forward sends the command to the leader to propose, returning its result.
+func (s *Store) forward(ctx context.Context, leader string, data []byte) ([]*model.KeyValue, error) {
+	addr, ok := s.peers[leader]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown raft leader %q", model.ErrUnavailable, leader)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+proposePath, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to forward to raft leader %s: %v", model.ErrUnavailable, leader, err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: raft leader %s returned status %d", model.ErrUnavailable, leader, httpResp.StatusCode)
+	}
+
+	var resp forwardResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("invalid response from raft leader %s: %w", leader, err)
+	}
+	if resp.Error != "" {
+		return nil, &forwardedError{msg: resp.Error, err: forwardErrors[resp.Kind]}
+	}
+
+	return resp.KeyValues, nil
+}
@@ -0,0 +1,76 @@
+package raftkv
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// Logger takes the place of the transaction logger for a replicated store. The Raft log is the store's transaction
+// log, so the write methods have nothing left to do.
+type Logger struct {
+	s    *Store
+	errs chan error
+}
+
+func (l *Logger) WritePut(key string, value string, version uint64, expires time.Time) error {
+	return nil
+}
+
+func (l *Logger) WriteDelete(key string) error {
+	return nil
+}
+
+// WriteExpire does nothing, expired keys are removed as commands are applied.
+func (l *Logger) WriteExpire(key string, deadline time.Time) error {
+	return nil
+}
+
+func (l *Logger) WriteBatch(ops []store.Event) error {
+	return nil
+}
+
+// Err never reports an error, a failing node drops out of the cluster instead.
+func (l *Logger) Err() <-chan error {
+	return l.errs
+}
+
+// OnCommit registers fn to be called with every write as it is applied, sequenced by its log index.
+// It must be called before Run.
+func (l *Logger) OnCommit(fn func(store.Event)) {
+	l.s.onCommit = fn
+}
+
+// LastSequence returns the log index of the last write applied.
+func (l *Logger) LastSequence() uint64 {
+	return atomic.LoadUint64(&l.s.lastSequence)
+}
+
+// QueueDepth returns how many writes are waiting to be committed.
+func (l *Logger) QueueDepth() int {
+	return int(atomic.LoadInt64(&l.s.proposals))
+}
+
+// ReadEvents has nothing to replay, the node applies its log once it learns from the leader how much is committed.
+func (l *Logger) ReadEvents() (<-chan store.Event, <-chan error) {
+	events := make(chan store.Event)
+	errs := make(chan error)
+	close(events)
+	close(errs)
+
+	return events, errs
+}
+
+// Run joins the node to the cluster.
+func (l *Logger) Run() {
+	l.s.node.Start()
+}
+
+// Close leaves the cluster, failing any writes still waiting to be committed.
+func (l *Logger) Close() error {
+	l.s.node.Stop()
+	close(l.errs)
+
+	return nil
+}
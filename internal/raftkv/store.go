@@ -0,0 +1,297 @@
+// Package raftkv replicates the key value store across a cluster with Raft. Every write is proposed to the leader
+// as a command and applied to each node's store once a majority has it in their log, so every node holds the same
+// keys at the same versions. Reads are served from the local store, so a follower may briefly lag the leader.
+//
+// Applying a command mustn't depend on the node applying it, so keys are expired by a clock kept from the times the
+// leader stamps on the commands rather than by each node's own. The Raft log has no snapshots, it is kept and replayed
+// in full.
+package raftkv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/raft"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// Config configures the node a Store replicates through.
+type Config struct {
+	// ID is this node's ID, it must be one of the peers.
+	ID string
+	// Peers maps the ID of every node in the cluster, this one included, to the address its Handler is served on.
+	Peers map[string]string
+
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	// CommitTimeout bounds how long a write waits to be committed, including forwarding it to the leader.
+	CommitTimeout time.Duration
+	// ReapInterval is how far the commands' clock advances between removing the keys expired by it.
+	ReapInterval time.Duration
+
+	Storage raft.Storage
+}
+
+// Store serves reads from the local store and replicates writes through the cluster. A write made on a follower is
+// forwarded to the leader, so any node can be written to.
+type Store struct {
+	// lastSequence is the log index of the last event committed, accessed atomically and kept first for 64 bit
+	// alignment.
+	lastSequence uint64
+	// proposals is how many writes are waiting to be committed, accessed atomically.
+	proposals int64
+
+	store        *store.Store
+	node         *raft.Node
+	peers        map[string]string
+	client       *http.Client
+	timeout      time.Duration
+	reapInterval time.Duration
+
+	// clock is the latest time stamped on a command applied, and reaped when expired keys were last removed by it.
+	// They are only used by apply.
+	clock  time.Time
+	reaped time.Time
+
+	onCommit func(store.Event)
+	logger   *Logger
+}
+
+// command is a replicated write, either a single put or delete or a batch of them.
+type command struct {
+	Ops []op `json:"ops"`
+	// Time is when the leader proposed the command, preconditions are checked against the keys that haven't expired
+	// by then.
+	Time time.Time `json:"time"`
+}
+
+type op struct {
+	Type         model.OpType       `json:"type"`
+	Key          string             `json:"key"`
+	Value        interface{}        `json:"value,omitempty"`
+	Expires      time.Time          `json:"expires,omitempty"`
+	Flags        uint32             `json:"flags,omitempty"`
+	Precondition model.Precondition `json:"precondition"`
+}
+
+// result is the outcome of applying a command, returned to whoever proposed it.
+type result struct {
+	kvs []*model.KeyValue
+	err error
+}
+
+// New creates a store replicating writes to s. Logger must be run to join the cluster.
+func New(config Config, s *store.Store) (*Store, error) {
+	client := &http.Client{Timeout: config.CommitTimeout}
+
+	kv := &Store{
+		store:        s,
+		peers:        config.Peers,
+		client:       client,
+		timeout:      config.CommitTimeout,
+		reapInterval: config.ReapInterval,
+	}
+	kv.logger = &Logger{
+		s:    kv,
+		errs: make(chan error),
+	}
+
+	node, err := raft.New(raft.Config{
+		ID:                config.ID,
+		Peers:             config.Peers,
+		ElectionTimeout:   config.ElectionTimeout,
+		HeartbeatInterval: config.HeartbeatInterval,
+		Storage:           config.Storage,
+		Transport:         raft.NewHTTPTransport(client),
+		Apply:             kv.apply,
+	})
+	if err != nil {
+		return nil, err
+	}
+	kv.node = node
+
+	return kv, nil
+}
+
+// PutIf will replicate the write if the precondition holds against the key's version once it is committed. The
+// write is committed to the Raft log, so commit is ignored.
+func (s *Store) PutIf(key string, value interface{}, expires time.Time, pre model.Precondition, commit model.Commit) (*model.KeyValue, error) {
+	kvs, err := s.propose(command{Ops: []op{{
+		Type:         model.OpPut,
+		Key:          key,
+		Value:        value,
+		Expires:      expires,
+		Precondition: pre,
+	}}}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return kvs[0], nil
+}
+
+// DeleteIf will replicate the delete if the precondition holds against the key's version once it is committed. Like
+// PutIf, commit is ignored.
+func (s *Store) DeleteIf(key string, pre model.Precondition, commit model.Commit) error {
+	_, err := s.propose(command{Ops: []op{{
+		Type:         model.OpDelete,
+		Key:          key,
+		Precondition: pre,
+	}}}, true)
+
+	return err
+}
+
+// Batch will replicate every operation as one command, applied on each node all together or not at all. Like PutIf,
+// commit is ignored.
+func (s *Store) Batch(ops []model.BatchOp, commit model.Commit) ([]*model.KeyValue, error) {
+	cmd := command{Ops: make([]op, len(ops))}
+	for i, o := range ops {
+		cmd.Ops[i] = op{
+			Type:         o.Type,
+			Key:          o.Key,
+			Value:        o.Value,
+			Expires:      o.Expires,
+			Flags:        o.Flags,
+			Precondition: o.Precondition,
+		}
+	}
+
+	return s.propose(cmd, true)
+}
+
+func (s *Store) GetKeyValue(key string) (*model.KeyValue, error) {
+	return s.store.GetKeyValue(key)
+}
+
+func (s *Store) List(opts model.ListOptions) ([]*model.KeyValue, string) {
+	return s.store.List(opts)
+}
+
+func (s *Store) Keys() []string {
+	return s.store.Keys()
+}
+
+// Status returns the node's view of the cluster.
+func (s *Store) Status() raft.Status {
+	return s.node.Status()
+}
+
+// Ready returns an error while the node doesn't know of a leader to commit writes.
+func (s *Store) Ready(ctx context.Context) error {
+	if s.node.Status().LeaderID == "" {
+		return errors.New("no raft leader is known")
+	}
+	return nil
+}
+
+// Logger returns the store's stand in for a transaction logger. Writes are already durable in the Raft log once they
+// return, so it logs nothing itself, but it runs the node and reports committed events.
+func (s *Store) Logger() *Logger {
+	return s.logger
+}
+
+// propose replicates the command and returns the result of applying it. A follower forwards the command to the
+// leader if forward is set, a command forwarded to this node is never forwarded again. The command is stamped with
+// the time it is proposed at, which the leader replaces when a follower forwards it.
+func (s *Store) propose(cmd command, forward bool) ([]*model.KeyValue, error) {
+	cmd.Time = time.Now().UTC()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&s.proposals, 1)
+	defer atomic.AddInt64(&s.proposals, -1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	res, err := s.node.Propose(ctx, data)
+	var notLeader *raft.NotLeaderError
+	if errors.As(err, &notLeader) && notLeader.LeaderID != "" && forward {
+		return s.forward(ctx, notLeader.LeaderID, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", model.ErrUnavailable, err)
+	}
+
+	r := res.(result)
+	return r.kvs, r.err
+}
+
+// apply writes a committed command to the store. It runs on every node in log order, so the stores' versions stay in
+// step, and reports the write as an event sequenced by its log index.
+func (s *Store) apply(e raft.Entry) interface{} {
+	var cmd command
+	if err := json.Unmarshal(e.Data, &cmd); err != nil {
+		return result{err: fmt.Errorf("invalid command at raft index %d: %w", e.Index, err)}
+	}
+
+	// the clock never goes backwards, e.g. when a leader with a slower clock takes over, so a key expired by it stays
+	// expired. Commands logged before they were stamped leave it as it is.
+	if cmd.Time.After(s.clock) {
+		s.clock = cmd.Time
+	}
+	if s.reapInterval > 0 && s.clock.Sub(s.reaped) >= s.reapInterval {
+		s.store.Reap(s.clock)
+		s.reaped = s.clock
+	}
+
+	kvs, err := s.write(cmd)
+	if err != nil {
+		return result{err: err}
+	}
+
+	event := store.Event{Sequence: e.Index, EventType: store.EventBatch}
+	for i, kv := range kvs {
+		op := store.Event{EventType: store.EventDelete, Key: kv.Key}
+		if cmd.Ops[i].Type == model.OpPut {
+			op = store.Event{
+				EventType: store.EventPut,
+				Key:       kv.Key,
+				Value:     fmt.Sprint(kv.Value),
+				Version:   kv.Version,
+				Expires:   kv.Expires,
+				Flags:     kv.Flags,
+			}
+		}
+		event.Ops = append(event.Ops, op)
+	}
+	if len(event.Ops) == 1 {
+		op := event.Ops[0]
+		op.Sequence = e.Index
+		event = op
+	}
+
+	atomic.StoreUint64(&s.lastSequence, e.Index)
+	if s.onCommit != nil {
+		s.onCommit(event)
+	}
+
+	return result{kvs: kvs}
+}
+
+// write applies the command's operations to the store at the commands' clock, a single operation keeps the store's
+// own errors.
+func (s *Store) write(cmd command) ([]*model.KeyValue, error) {
+	ops := make([]model.BatchOp, len(cmd.Ops))
+	for i, o := range cmd.Ops {
+		ops[i] = model.BatchOp{
+			Type:         o.Type,
+			Key:          o.Key,
+			Value:        o.Value,
+			Expires:      o.Expires,
+			Flags:        o.Flags,
+			Precondition: o.Precondition,
+		}
+	}
+
+	return s.store.WriteAt(ops, nil, s.clock)
+}
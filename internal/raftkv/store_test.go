@@ -0,0 +1,204 @@
+package raftkv_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/raft"
+	"github.com/warrenb95/cloud-native-go/internal/raftkv"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+const (
+	waitTimeout  = 5 * time.Second
+	pollInterval = 10 * time.Millisecond
+)
+
+type node struct {
+	kv    *raftkv.Store
+	store *store.Store
+
+	mu     sync.Mutex
+	events []store.Event
+}
+
+func (n *node) Events() []store.Event {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]store.Event(nil), n.events...)
+}
+
+// newCluster runs a cluster of replicated stores over loopback HTTP, returning the leader and followers once one
+// has been elected.
+func newCluster(t *testing.T, size int) (*node, []*node) {
+	peers := make(map[string]string)
+	servers := make(map[string]*httptest.Server)
+	for i := 1; i <= size; i++ {
+		id := "n" + strconv.Itoa(i)
+		srv := httptest.NewUnstartedServer(nil)
+		servers[id] = srv
+		peers[id] = srv.Listener.Addr().String()
+	}
+
+	nodes := make(map[string]*node)
+	for id, srv := range servers {
+		n := &node{store: store.New(map[string]interface{}{})}
+
+		kv, err := raftkv.New(raftkv.Config{
+			ID:                id,
+			Peers:             peers,
+			ElectionTimeout:   150 * time.Millisecond,
+			HeartbeatInterval: 30 * time.Millisecond,
+			CommitTimeout:     waitTimeout,
+			Storage:           raft.NewMemoryStorage(),
+		}, n.store)
+		require.NoError(t, err)
+		n.kv = kv
+
+		logger := kv.Logger()
+		logger.OnCommit(func(e store.Event) {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			n.events = append(n.events, e)
+		})
+
+		srv.Config.Handler = kv.Handler()
+		srv.Start()
+		t.Cleanup(srv.Close)
+
+		logger.Run()
+		t.Cleanup(func() { logger.Close() })
+
+		nodes[id] = n
+	}
+
+	var leader *node
+	require.Eventually(t, func() bool {
+		for _, n := range nodes {
+			status := n.kv.Status()
+			if status.Role == raft.Leader {
+				leader = n
+				break
+			}
+		}
+		if leader == nil {
+			return false
+		}
+		for _, n := range nodes {
+			if n.kv.Ready(context.Background()) != nil {
+				return false
+			}
+		}
+		return true
+	}, waitTimeout, pollInterval)
+
+	var followers []*node
+	for _, n := range nodes {
+		if n != leader {
+			followers = append(followers, n)
+		}
+	}
+
+	return leader, followers
+}
+
+func TestStore_Replicates(t *testing.T) {
+	leader, followers := newCluster(t, 3)
+	nodes := append([]*node{leader}, followers...)
+
+	// a write to a follower is forwarded to the leader
+	kv, err := followers[0].kv.PutIf("user:1", "a", time.Time{}, model.Precondition{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "user:1", kv.Key)
+	assert.Equal(t, "a", kv.Value)
+
+	_, err = leader.kv.Batch([]model.BatchOp{
+		{Type: model.OpPut, Key: "user:2", Value: "b"},
+		{Type: model.OpDelete, Key: "user:1", Precondition: model.Precondition{IfMatch: []uint64{kv.Version}}},
+	}, nil)
+	require.NoError(t, err)
+
+	kv, err = followers[1].kv.PutIf("user:3", "c", time.Time{}, model.Precondition{}, nil)
+	require.NoError(t, err)
+
+	// every node applies the same writes at the same versions
+	for _, n := range nodes {
+		require.Eventually(t, func() bool {
+			return len(n.Events()) == 3
+		}, waitTimeout, pollInterval)
+
+		assert.Equal(t, []string{"user:2", "user:3"}, n.kv.Keys())
+		got, err := n.kv.GetKeyValue("user:3")
+		require.NoError(t, err)
+		assert.Equal(t, kv.Version, got.Version)
+		assert.Equal(t, leader.Events(), n.Events())
+	}
+
+	events := leader.Events()
+	assert.Equal(t, store.EventPut, events[0].EventType)
+	assert.Equal(t, store.EventBatch, events[1].EventType)
+	assert.Len(t, events[1].Ops, 2)
+	assert.Equal(t, events[2].Sequence, leader.kv.Logger().LastSequence())
+	assert.Less(t, events[0].Sequence, events[1].Sequence)
+}
+
+func TestStore_Errors(t *testing.T) {
+	leader, followers := newCluster(t, 3)
+
+	_, err := leader.kv.PutIf("user:1", "a", time.Time{}, model.Precondition{}, nil)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		write       func(kv *raftkv.Store) error
+		expectedErr error
+	}{
+		"put precondition": {
+			write: func(kv *raftkv.Store) error {
+				_, err := kv.PutIf("user:1", "b", time.Time{}, model.Precondition{IfNoneMatchAny: true}, nil)
+				return err
+			},
+			expectedErr: model.ErrPreconditionFailed,
+		},
+		"delete precondition": {
+			write: func(kv *raftkv.Store) error {
+				return kv.DeleteIf("user:2", model.Precondition{IfMatchAny: true}, nil)
+			},
+			expectedErr: model.ErrPreconditionFailed,
+		},
+		"invalid batch": {
+			write: func(kv *raftkv.Store) error {
+				_, err := kv.Batch([]model.BatchOp{
+					{Type: model.OpPut, Key: "user:1", Value: "b"},
+					{Type: model.OpDelete, Key: "user:1"},
+				}, nil)
+				return err
+			},
+			expectedErr: model.ErrInvalidArgument,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// the error is the same whether written to the leader or forwarded from a follower
+			for _, n := range []*node{leader, followers[0]} {
+				assert.ErrorIs(t, test.write(n.kv), test.expectedErr)
+			}
+		})
+	}
+
+	// none of the failed writes were applied
+	require.Eventually(t, func() bool {
+		return len(followers[1].Events()) == 1
+	}, waitTimeout, pollInterval)
+	value, err := followers[1].store.Get("user:1")
+	require.NoError(t, err)
+	assert.Equal(t, "a", value)
+}
@@ -0,0 +1,182 @@
+package repair
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	hashesPath  = "/v1/_repair/hashes"
+	entriesPath = "/v1/_repair/entries"
+
+	// maxRequestSize caps the size of a request read by the handler.
+	maxRequestSize = 64 << 20
+	// maxBuckets caps how many buckets' entries are fetched in one request.
+	maxBuckets = 256
+)
+
+// hashesRequest asks for the hashes of nodes in a level of the tree.
+type hashesRequest struct {
+	// Depth is the depth of the requester's tree, which must match.
+	Depth int   `json:"depth"`
+	Level int   `json:"level"`
+	Nodes []int `json:"nodes"`
+}
+
+type hashesResponse struct {
+	Hashes []uint64 `json:"hashes"`
+}
+
+// entriesRequest asks for the entries in buckets of the tree.
+type entriesRequest struct {
+	Depth   int   `json:"depth"`
+	Buckets []int `json:"buckets"`
+}
+
+type entriesResponse struct {
+	Entries []entry `json:"entries"`
+}
+
+// Handler serves the tree and its entries to the peers repairing from this node.
+func (r *Repairer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(hashesPath, r.hashesHandler)
+	mux.HandleFunc(entriesPath, r.entriesHandler)
+
+	return mux
+}
+
+func (r *Repairer) hashesHandler(w http.ResponseWriter, req *http.Request) {
+	var body hashesRequest
+	if !decodeRequest(w, req, &body) || !r.checkDepth(w, body.Depth) {
+		return
+	}
+	if body.Level < 0 || body.Level > r.config.Depth {
+		http.Error(w,
+			fmt.Sprintf("level %d is not in the tree", body.Level),
+			http.StatusBadRequest)
+		return
+	}
+
+	for _, node := range body.Nodes {
+		if node < 0 || node >= 1<<body.Level {
+			http.Error(w,
+				fmt.Sprintf("node %d is not in level %d", node, body.Level),
+				http.StatusBadRequest)
+			return
+		}
+	}
+	resp := hashesResponse{Hashes: r.store.TreeHashes(body.Level, body.Nodes)}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (r *Repairer) entriesHandler(w http.ResponseWriter, req *http.Request) {
+	var body entriesRequest
+	if !decodeRequest(w, req, &body) || !r.checkDepth(w, body.Depth) {
+		return
+	}
+
+	for _, bucket := range body.Buckets {
+		if bucket < 0 || bucket >= 1<<r.config.Depth {
+			http.Error(w,
+				fmt.Sprintf("bucket %d is not in the tree", bucket),
+				http.StatusBadRequest)
+			return
+		}
+	}
+	resp := entriesResponse{Entries: r.entries(body.Buckets)}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// decodeRequest decodes the body of a POST, writing the error and returning false if it can't.
+func decodeRequest(w http.ResponseWriter, req *http.Request, body interface{}) bool {
+	if req.Method != http.MethodPost {
+		http.Error(w,
+			"method not allowed",
+			http.StatusMethodNotAllowed)
+		return false
+	}
+
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxRequestSize)).Decode(body); err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// checkDepth writes a conflict and returns false if the requester's tree has a different depth, as their buckets
+// wouldn't line up.
+func (r *Repairer) checkDepth(w http.ResponseWriter, depth int) bool {
+	if depth != r.config.Depth {
+		http.Error(w,
+			fmt.Sprintf("tree depth %d differs from this node's %d", depth, r.config.Depth),
+			http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// fetchHashes returns the peer's hashes of the nodes in the level of its tree.
+func (r *Repairer) fetchHashes(ctx context.Context, peer string, depth, level int, nodes []int) ([]uint64, error) {
+	var resp hashesResponse
+	if err := r.post(ctx, peer, hashesPath, hashesRequest{Depth: depth, Level: level, Nodes: nodes}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Hashes) != len(nodes) {
+		return nil, fmt.Errorf("peer %s returned %d hashes for %d nodes", peer, len(resp.Hashes), len(nodes))
+	}
+
+	return resp.Hashes, nil
+}
+
+// fetchEntries returns the peer's entries in the buckets.
+func (r *Repairer) fetchEntries(ctx context.Context, peer string, depth int, buckets []int) ([]entry, error) {
+	var resp entriesResponse
+	if err := r.post(ctx, peer, entriesPath, entriesRequest{Depth: depth, Buckets: buckets}, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Entries, nil
+}
+
+// post sends the body to the path on the peer as JSON and decodes its response into out.
+func (r *Repairer) post(ctx context.Context, peer, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(peer, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("peer %s returned status %d: %s", peer, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from peer %s: %w", peer, err)
+	}
+	return nil
+}
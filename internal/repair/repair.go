@@ -0,0 +1,292 @@
+// Package repair finds and fixes differences between nodes that should hold the same keys. Each node's store keeps a
+// Merkle tree of its keys, changed as writes are applied, and the repairer periodically compares it with each peer's
+// from the root down, only descending into the nodes whose hashes differ. The keys in the buckets that still differ are
+// fetched from the peer and the later version of each kept, so every pair of nodes converges once both have repaired
+// from the other.
+//
+// Deleted keys are kept in the tree as tombstones at the store's version when they were deleted, so a delete isn't
+// undone by a peer that missed it. Tombstones are only kept in memory, for the configured TTL.
+package repair
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/merkle"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// Config configures a Repairer.
+type Config struct {
+	// Peers are the base URLs of the REST APIs of the nodes that should hold the same keys.
+	Peers []string
+	// Interval is how often each peer is repaired from.
+	Interval time.Duration
+	// Depth is how many levels the tree has below its root, every peer must use the same.
+	Depth int
+	// TombstoneTTL is how long a deleted key is remembered, every peer must repair within it or the key comes back.
+	TombstoneTTL time.Duration
+	// Timeout bounds each repair from a peer.
+	Timeout time.Duration
+}
+
+// Repairer keeps a store in step with its peers.
+type Repairer struct {
+	// repaired counts the keys changed by a repair and failures the repairs that failed, both accessed atomically
+	// and kept first for 64 bit alignment.
+	repaired uint64
+	failures uint64
+
+	config   Config
+	store    *store.Store
+	logger   api.TransactionLogger
+	client   *http.Client
+	onRepair func(key string)
+}
+
+// entry is a key in the tree, either its value at a version or a tombstone.
+type entry struct {
+	Key     string    `json:"key"`
+	Value   string    `json:"value,omitempty"`
+	Version uint64    `json:"version"`
+	Expires time.Time `json:"expires,omitempty"`
+	Flags   uint32    `json:"flags,omitempty"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+func (e entry) hash() uint64 {
+	return merkle.EntryHash(e.Key, e.Version, e.Value, e.Deleted)
+}
+
+// New creates a repairer for s, logging the changes it makes to logger. s is summarised in a tree of the configured
+// depth, so New must be called before s is shared.
+func New(config Config, s *store.Store, logger api.TransactionLogger) *Repairer {
+	s.Summarise(config.Depth, config.TombstoneTTL)
+
+	return &Repairer{
+		config: config,
+		store:  s,
+		logger: logger,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// OnRepair registers fn to be called with every key a repair changes, e.g. to evict it from a cache. It must be
+// called before Run.
+func (r *Repairer) OnRepair(fn func(key string)) {
+	r.onRepair = fn
+}
+
+// Repaired returns how many keys have been changed by repairs.
+func (r *Repairer) Repaired() uint64 {
+	return atomic.LoadUint64(&r.repaired)
+}
+
+// Failures returns how many repairs have failed.
+func (r *Repairer) Failures() uint64 {
+	return atomic.LoadUint64(&r.failures)
+}
+
+// Run repairs from every peer in turn each interval until ctx is cancelled. It must be called once the log has been
+// replayed into the store, so the store isn't repaired from its peers' copies of keys it is yet to replay.
+func (r *Repairer) Run(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, peer := range r.config.Peers {
+					if _, err := r.Repair(ctx, peer); err != nil {
+						if ctx.Err() != nil {
+							return
+						}
+						atomic.AddUint64(&r.failures, 1)
+						log.Printf("repair from %s failed: %v", peer, err)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Repair compares the store with the peer's and keeps the later version of every key that differs, returning how
+// many keys it changed. Keys where this node has the later version are left for the peer to repair.
+func (r *Repairer) Repair(ctx context.Context, peer string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	depth := r.config.Depth
+
+	// descend from the root through the nodes whose hashes differ, down to the buckets
+	differing := []int{0}
+	for level := 0; level <= depth; level++ {
+		if level > 0 {
+			children := make([]int, 0, 2*len(differing))
+			for _, i := range differing {
+				children = append(children, 2*i, 2*i+1)
+			}
+			differing = children
+		}
+
+		hashes, err := r.fetchHashes(ctx, peer, depth, level, differing)
+		if err != nil {
+			return 0, err
+		}
+
+		ours := r.store.TreeHashes(level, differing)
+		nodes := differing[:0]
+		for i, node := range differing {
+			if ours[i] != hashes[i] {
+				nodes = append(nodes, node)
+			}
+		}
+		differing = nodes
+		if len(differing) == 0 {
+			return 0, nil
+		}
+	}
+
+	repaired := 0
+	for start := 0; start < len(differing); start += maxBuckets {
+		end := start + maxBuckets
+		if end > len(differing) {
+			end = len(differing)
+		}
+
+		entries, err := r.fetchEntries(ctx, peer, depth, differing[start:end])
+		if err != nil {
+			return repaired, err
+		}
+
+		n, err := r.reconcile(entries)
+		repaired += n
+		if err != nil {
+			return repaired, err
+		}
+	}
+
+	return repaired, nil
+}
+
+// reconcile applies the peer's entries that are later than this node's, returning how many keys changed.
+func (r *Repairer) reconcile(entries []entry) (int, error) {
+	repaired := 0
+	for _, theirs := range entries {
+		ours, ok := r.lookup(theirs.Key)
+		if theirs.Deleted && (!ok || ours.Deleted) {
+			// nothing to delete, but the later tombstone is kept to pass on to the other peers
+			r.store.Bury(theirs.Key, theirs.Version)
+		}
+
+		if !later(theirs, ours, ok) {
+			continue
+		}
+		err := r.apply(theirs, ours, ok)
+		if errors.Is(err, model.ErrPreconditionFailed) {
+			// written since it was looked up, the next repair compares the write instead
+			continue
+		}
+		if err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+
+	return repaired, nil
+}
+
+// later reports whether their entry for a key replaces ours. A tombstone replaces a value at the same version, and
+// differing values at the same version, written concurrently on different nodes, are settled by their hashes.
+func later(theirs, ours entry, ok bool) bool {
+	switch {
+	case !ok:
+		return !theirs.Deleted
+	case theirs.Deleted && ours.Deleted:
+		return false
+	case theirs.Deleted:
+		return theirs.Version >= ours.Version
+	case ours.Deleted:
+		return theirs.Version > ours.Version
+	case theirs.Version != ours.Version:
+		return theirs.Version > ours.Version
+	default:
+		return theirs.hash() > ours.hash()
+	}
+}
+
+// apply logs their entry then writes it to the store at its version, as long as the key is still at our entry. A
+// write made to the key since ours was looked up fails it with ErrPreconditionFailed.
+func (r *Repairer) apply(theirs, ours entry, ok bool) error {
+	pre := model.Precondition{IfNoneMatchAny: true}
+	if ok && !ours.Deleted {
+		pre = model.Precondition{IfMatch: []uint64{ours.Version}}
+	}
+
+	e := store.Event{EventType: store.EventPut, Key: theirs.Key, Value: theirs.Value, Version: theirs.Version, Expires: theirs.Expires, Flags: theirs.Flags}
+	commit := api.LogPut(r.logger)
+	if theirs.Deleted {
+		e = store.Event{EventType: store.EventDelete, Key: theirs.Key, Version: theirs.Version}
+		commit = api.LogDelete(r.logger)
+	}
+
+	// the store records a deleted entry's tombstone at its version
+	if err := r.store.ApplyIf(e, pre, commit); err != nil {
+		return err
+	}
+
+	atomic.AddUint64(&r.repaired, 1)
+	if r.onRepair != nil {
+		r.onRepair(theirs.Key)
+	}
+
+	return nil
+}
+
+// lookup returns the key's entry, its value if it exists otherwise its tombstone.
+func (r *Repairer) lookup(key string) (entry, bool) {
+	kv, err := r.store.GetKeyValue(key)
+	if err == nil {
+		return valueEntry(kv), true
+	}
+
+	if t, ok := r.store.Tombstone(key); ok {
+		return tombstoneEntry(t), true
+	}
+	return entry{}, false
+}
+
+// valueEntry returns the entry of a key's value.
+func valueEntry(kv *model.KeyValue) entry {
+	value, _ := kv.Value.(string)
+	return entry{Key: kv.Key, Value: value, Version: kv.Version, Expires: kv.Expires, Flags: kv.Flags}
+}
+
+// tombstoneEntry returns the entry of a deleted key.
+func tombstoneEntry(t store.Tombstone) entry {
+	return entry{Key: t.Key, Version: t.Version, Deleted: true}
+}
+
+// entries returns the entries of the keys in the tree's buckets.
+func (r *Repairer) entries(buckets []int) []entry {
+	kvs, tombstones := r.store.TreeEntries(buckets)
+
+	entries := make([]entry, 0, len(kvs)+len(tombstones))
+	for _, kv := range kvs {
+		entries = append(entries, valueEntry(kv))
+	}
+	for _, t := range tombstones {
+		entries = append(entries, tombstoneEntry(t))
+	}
+
+	return entries
+}
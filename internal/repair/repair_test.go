@@ -0,0 +1,257 @@
+package repair_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/merkle"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/repair"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/testutil"
+)
+
+type node struct {
+	store    *store.Store
+	logger   *testutil.Logger
+	repairer *repair.Repairer
+	addr     string
+
+	mu       sync.Mutex
+	repaired []string
+}
+
+func newNode(t *testing.T, depth int, tombstoneTTL time.Duration) *node {
+	n := &node{store: store.New(map[string]interface{}{}), logger: &testutil.Logger{}}
+	n.repairer = repair.New(repair.Config{
+		Interval:     time.Minute,
+		Depth:        depth,
+		TombstoneTTL: tombstoneTTL,
+		Timeout:      time.Second,
+	}, n.store, n.logger)
+	n.repairer.OnRepair(func(key string) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		n.repaired = append(n.repaired, key)
+	})
+
+	server := httptest.NewServer(n.repairer.Handler())
+	t.Cleanup(server.Close)
+	n.addr = server.URL
+
+	return n
+}
+
+func (n *node) put(t *testing.T, key, value string) {
+	kv, err := n.store.PutIf(key, value, time.Time{}, model.Precondition{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, n.logger.WritePut(key, value, kv.Version, kv.Expires))
+}
+
+func (n *node) delete(t *testing.T, key string) {
+	require.NoError(t, n.store.Delete(key))
+	require.NoError(t, n.logger.WriteDelete(key))
+}
+
+func (n *node) values() map[string]string {
+	values := make(map[string]string)
+	kvs, _ := n.store.List(model.ListOptions{})
+	for _, kv := range kvs {
+		values[kv.Key] = kv.Value.(string)
+	}
+	return values
+}
+
+// concurrent returns which of two values written to the key at the same version is kept.
+func concurrent(key string, version uint64, a, b string) string {
+	if merkle.EntryHash(key, version, a, false) > merkle.EntryHash(key, version, b, false) {
+		return a
+	}
+	return b
+}
+
+func TestRepairer_Repair(t *testing.T) {
+	tests := map[string]struct {
+		setup        func(t *testing.T, a, b *node)
+		tombstoneTTL time.Duration
+		want         map[string]string
+		wantRepaired []string
+	}{
+		"in sync": {
+			setup: func(t *testing.T, a, b *node) {
+				a.put(t, "k", "v")
+				b.put(t, "k", "v")
+			},
+			want: map[string]string{"k": "v"},
+		},
+		"missing keys": {
+			setup: func(t *testing.T, a, b *node) {
+				a.put(t, "k1", "v1")
+				b.put(t, "k2", "v2")
+			},
+			want:         map[string]string{"k1": "v1", "k2": "v2"},
+			wantRepaired: []string{"k1", "k2"},
+		},
+		"later version": {
+			setup: func(t *testing.T, a, b *node) {
+				a.put(t, "k", "old")
+				b.put(t, "other", "v")
+				b.put(t, "k", "new")
+			},
+			want:         map[string]string{"k": "new", "other": "v"},
+			wantRepaired: []string{"other", "k"},
+		},
+		"concurrent writes": {
+			setup: func(t *testing.T, a, b *node) {
+				a.put(t, "k", "a")
+				b.put(t, "k", "b")
+			},
+			want:         map[string]string{"k": concurrent("k", 1, "a", "b")},
+			wantRepaired: []string{"k"},
+		},
+		"deleted": {
+			setup: func(t *testing.T, a, b *node) {
+				a.put(t, "k", "v")
+				b.put(t, "k", "v")
+				a.delete(t, "k")
+			},
+			want:         map[string]string{},
+			wantRepaired: []string{"k"},
+		},
+		"written after delete": {
+			setup: func(t *testing.T, a, b *node) {
+				a.put(t, "k", "old")
+				a.delete(t, "k")
+				b.put(t, "other", "v")
+				b.put(t, "k", "new")
+			},
+			want:         map[string]string{"k": "new", "other": "v"},
+			wantRepaired: []string{"other", "k"},
+		},
+		"replayed": {
+			setup: func(t *testing.T, a, b *node) {
+				// the store changes its tree as events are applied, as well as written
+				require.NoError(t, a.store.Apply(store.Event{EventType: store.EventPut, Key: "k", Value: "v", Version: 1}))
+			},
+			want:         map[string]string{"k": "v"},
+			wantRepaired: []string{"k"},
+		},
+		"expired tombstone": {
+			setup: func(t *testing.T, a, b *node) {
+				a.put(t, "k", "v")
+				b.put(t, "k", "v")
+				a.delete(t, "k")
+			},
+			tombstoneTTL: time.Nanosecond,
+			// the delete is forgotten before it reaches b, so a gets the key back
+			want:         map[string]string{"k": "v"},
+			wantRepaired: []string{"k"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ttl := test.tombstoneTTL
+			if ttl == 0 {
+				ttl = time.Hour
+			}
+			a, b := newNode(t, 4, ttl), newNode(t, 4, ttl)
+			test.setup(t, a, b)
+
+			ctx := context.Background()
+			_, err := a.repairer.Repair(ctx, b.addr)
+			require.NoError(t, err)
+			_, err = b.repairer.Repair(ctx, a.addr)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.want, a.values())
+			assert.Equal(t, test.want, b.values())
+
+			var repaired []string
+			repaired = append(repaired, a.repaired...)
+			repaired = append(repaired, b.repaired...)
+			assert.ElementsMatch(t, test.wantRepaired, repaired)
+			assert.Equal(t, uint64(len(test.wantRepaired)), a.repairer.Repaired()+b.repairer.Repaired())
+
+			// once converged the trees match, so there is nothing left to repair
+			for _, pair := range [][2]*node{{a, b}, {b, a}} {
+				n, err := pair[0].repairer.Repair(ctx, pair[1].addr)
+				require.NoError(t, err)
+				assert.Zero(t, n)
+			}
+		})
+	}
+}
+
+func TestRepairer_Handler(t *testing.T) {
+	n := newNode(t, 4, time.Hour)
+
+	tests := map[string]struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		"hashes": {
+			method:     http.MethodPost,
+			path:       "/v1/_repair/hashes",
+			body:       `{"depth": 4, "level": 4, "nodes": [0, 15]}`,
+			wantStatus: http.StatusOK,
+		},
+		"entries": {
+			method:     http.MethodPost,
+			path:       "/v1/_repair/entries",
+			body:       `{"depth": 4, "buckets": [0, 15]}`,
+			wantStatus: http.StatusOK,
+		},
+		"different depth": {
+			method:     http.MethodPost,
+			path:       "/v1/_repair/hashes",
+			body:       `{"depth": 5, "level": 0, "nodes": [0]}`,
+			wantStatus: http.StatusConflict,
+		},
+		"level below the leaves": {
+			method:     http.MethodPost,
+			path:       "/v1/_repair/hashes",
+			body:       `{"depth": 4, "level": 5, "nodes": [0]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		"node outside the level": {
+			method:     http.MethodPost,
+			path:       "/v1/_repair/hashes",
+			body:       `{"depth": 4, "level": 1, "nodes": [2]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		"bucket outside the tree": {
+			method:     http.MethodPost,
+			path:       "/v1/_repair/entries",
+			body:       `{"depth": 4, "buckets": [16]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		"invalid body": {
+			method:     http.MethodPost,
+			path:       "/v1/_repair/entries",
+			body:       `{`,
+			wantStatus: http.StatusBadRequest,
+		},
+		"not a post": {
+			method:     http.MethodGet,
+			path:       "/v1/_repair/hashes",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			n.repairer.Handler().ServeHTTP(rec, httptest.NewRequest(test.method, test.path, bytes.NewBufferString(test.body)))
+			assert.Equal(t, test.wantStatus, rec.Code, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// FollowerConfig configures how a replica follows its primary.
+type FollowerConfig struct {
+	// Primary is the base URL of the primary's REST API.
+	Primary string
+	// Timeout is how long to wait to hear from the primary before reconnecting, it must be longer than the
+	// primary's heartbeat interval.
+	Timeout time.Duration
+	// RetryInterval is how long to wait before reconnecting once the stream has ended.
+	RetryInterval time.Duration
+}
+
+// Follower applies the primary's events to a replica's store. It resumes from the last sequence it applied whenever
+// it reconnects, starting from the beginning of the primary's log as its store is only held in memory.
+type Follower struct {
+	// applied is the sequence of the last event applied and primaryLast the primary's last sequence as last heard,
+	// both accessed atomically and kept first for 64 bit alignment.
+	applied     uint64
+	primaryLast uint64
+
+	store  *store.Store
+	config FollowerConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	connected bool
+	// synced is set once the store has first caught up with the primary.
+	synced bool
+	// caughtUp is when the store was last known to hold every event the primary had.
+	caughtUp time.Time
+
+	onCommit func(store.Event)
+	logger   *Logger
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewFollower creates a follower applying the primary's events to s. Logger must be run to start following.
+func NewFollower(config FollowerConfig, s *store.Store) *Follower {
+	f := &Follower{
+		store:  s,
+		config: config,
+		// no timeout, the stream is expected to stay open
+		client: &http.Client{},
+	}
+	f.logger = &Logger{
+		f:    f,
+		errs: make(chan error),
+	}
+
+	return f
+}
+
+// Lag returns how long it has been since the store last held every event the primary had, zero if it does now, and
+// how many sequences the store is behind the primary's last as last heard.
+func (f *Follower) Lag() (time.Duration, uint64) {
+	applied := atomic.LoadUint64(&f.applied)
+	var behind uint64
+	if last := atomic.LoadUint64(&f.primaryLast); last > applied {
+		behind = last - applied
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if behind == 0 && f.connected {
+		return 0, 0
+	}
+	return time.Since(f.caughtUp), behind
+}
+
+// Applied returns the sequence of the last event applied to the store.
+func (f *Follower) Applied() uint64 {
+	return atomic.LoadUint64(&f.applied)
+}
+
+// Connected reports whether the follower is streaming from the primary.
+func (f *Follower) Connected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.connected
+}
+
+// Ready returns an error until the store has caught up with the primary, and while it isn't connected to it.
+func (f *Follower) Ready(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.synced {
+		return errors.New("replica has not caught up with the primary")
+	}
+	if !f.connected {
+		return errors.New("replica is not connected to the primary")
+	}
+	return nil
+}
+
+// Logger returns the follower's stand in for a transaction logger. The primary's log is the replica's, so it logs
+// nothing itself, but it runs the follower and reports the events applied.
+func (f *Follower) Logger() *Logger {
+	return f.logger
+}
+
+// start follows the primary until stop is called.
+func (f *Follower) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	f.done = make(chan struct{})
+
+	f.mu.Lock()
+	f.caughtUp = time.Now()
+	f.mu.Unlock()
+
+	go func() {
+		defer close(f.done)
+
+		for {
+			err := f.follow(ctx)
+			f.setConnected(false)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("replication from %s stopped: %v", f.config.Primary, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(f.config.RetryInterval):
+			}
+		}
+	}()
+}
+
+func (f *Follower) stop() {
+	f.cancel()
+	<-f.done
+}
+
+// follow streams the events after the last one applied until the stream ends or the primary goes quiet.
+func (f *Follower) follow(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// quiet is set if the stream is cancelled for the primary not sending anything in time
+	var quiet int32
+	timer := time.AfterFunc(f.config.Timeout, func() {
+		atomic.StoreInt32(&quiet, 1)
+		cancel()
+	})
+	defer timer.Stop()
+
+	url := strings.TrimRight(f.config.Primary, "/") + "/v1/_replicate?after=" + strconv.FormatUint(f.Applied(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("primary returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	f.setConnected(true)
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var fr frame
+		if err := dec.Decode(&fr); err != nil {
+			if atomic.LoadInt32(&quiet) == 1 {
+				return fmt.Errorf("heard nothing from the primary for %s", f.config.Timeout)
+			}
+			return err
+		}
+		timer.Reset(f.config.Timeout)
+
+		if err := f.handle(fr); err != nil {
+			return err
+		}
+	}
+}
+
+// handle applies a frame of the stream to the store.
+func (f *Follower) handle(fr frame) error {
+	switch fr.Type {
+	case frameSnapshot:
+		if fr.Snapshot == nil {
+			return errors.New("snapshot frame without a snapshot")
+		}
+		f.store.Restore(fr.Snapshot)
+		atomic.StoreUint64(&f.applied, fr.Snapshot.Sequence)
+		// the primary may have started over since it was last heard from
+		f.observe(fr.Snapshot.Sequence)
+	case frameEvent:
+		if fr.Event == nil {
+			return errors.New("event frame without an event")
+		}
+		e := *fr.Event
+		if e.Sequence <= f.Applied() {
+			return nil
+		}
+		if err := f.store.Apply(e); err != nil {
+			return fmt.Errorf("failed to apply event %d: %w", e.Sequence, err)
+		}
+		atomic.StoreUint64(&f.applied, e.Sequence)
+		if f.onCommit != nil {
+			f.onCommit(e)
+		}
+		last := atomic.LoadUint64(&f.primaryLast)
+		if e.Sequence > last {
+			last = e.Sequence
+		}
+		f.observe(last)
+	case frameHeartbeat:
+		f.observe(fr.Sequence)
+	case frameError:
+		return fmt.Errorf("primary ended the stream: %s", fr.Error)
+	default:
+		return fmt.Errorf("unknown frame type %q", fr.Type)
+	}
+
+	return nil
+}
+
+// observe records the primary's last sequence, noting the time if the store has caught up with it.
+func (f *Follower) observe(primaryLast uint64) {
+	atomic.StoreUint64(&f.primaryLast, primaryLast)
+	if f.Applied() < primaryLast {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.synced = true
+	f.caughtUp = time.Now()
+}
+
+func (f *Follower) setConnected(connected bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.connected && !connected && f.Applied() >= atomic.LoadUint64(&f.primaryLast) {
+		// lag is measured from when the connection was lost
+		f.caughtUp = time.Now()
+	}
+	f.connected = connected
+}
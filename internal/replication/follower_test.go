@@ -0,0 +1,156 @@
+package replication_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/replication"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// replica is a store following a primary, recording the events applied to it.
+type replica struct {
+	store    *store.Store
+	follower *replication.Follower
+
+	mu     sync.Mutex
+	events []uint64
+}
+
+func newReplica(t *testing.T, p *primary) *replica {
+	r := &replica{store: store.New(map[string]interface{}{})}
+	r.follower = replication.NewFollower(replication.FollowerConfig{
+		Primary:       p.srv.URL,
+		Timeout:       200 * time.Millisecond,
+		RetryInterval: 20 * time.Millisecond,
+	}, r.store)
+
+	logger := r.follower.Logger()
+	logger.OnCommit(func(e store.Event) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.events = append(r.events, e.Sequence)
+	})
+	logger.Run()
+	t.Cleanup(func() { logger.Close() })
+
+	return r
+}
+
+func (r *replica) Events() []uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]uint64(nil), r.events...)
+}
+
+// waitFor waits until the replica has applied every event up to sequence and heard the primary has no more.
+func (r *replica) waitFor(t *testing.T, sequence uint64) {
+	require.Eventually(t, func() bool {
+		_, behind := r.follower.Lag()
+		return r.follower.Applied() == sequence && behind == 0 && r.follower.Ready(context.Background()) == nil
+	}, waitTimeout, pollInterval)
+}
+
+func TestFollower(t *testing.T) {
+	tests := map[string]struct {
+		compact        bool
+		expectedEvents []uint64
+	}{
+		"caught up from the log": {
+			expectedEvents: []uint64{1, 2, 3, 4, 5},
+		},
+		"caught up with a snapshot": {
+			compact: true,
+			// the snapshot replaces the store without being reported as events
+			expectedEvents: []uint64{4, 5},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := newPrimary(t, true)
+			p.put(t, "user:1", "a")
+			p.put(t, "user:2", "b")
+			p.delete(t, "user:1")
+			if test.compact {
+				require.NoError(t, p.logger.Compact(context.Background(), p.store, 3))
+			}
+
+			r := newReplica(t, p)
+			r.waitFor(t, 3)
+
+			// new writes are streamed once caught up
+			p.put(t, "user:3", "c")
+			kvs, err := p.store.Batch([]model.BatchOp{
+				{Type: model.OpPut, Key: "user:4", Value: "d"},
+				{Type: model.OpDelete, Key: "user:2"},
+			}, nil)
+			require.NoError(t, err)
+			require.NoError(t, p.logger.WriteBatch([]store.Event{
+				{EventType: store.EventPut, Key: "user:4", Value: "d", Version: kvs[0].Version},
+				{EventType: store.EventDelete, Key: "user:2"},
+			}))
+			r.waitFor(t, 5)
+
+			assert.Equal(t, test.expectedEvents, r.Events())
+			assert.Equal(t, p.store.Keys(), r.store.Keys())
+			for _, key := range p.store.Keys() {
+				expected, err := p.store.GetKeyValue(key)
+				require.NoError(t, err)
+				got, err := r.store.GetKeyValue(key)
+				require.NoError(t, err)
+				assert.Equal(t, expected.Value, got.Value)
+				assert.Equal(t, expected.Version, got.Version)
+			}
+
+			lag, behind := r.follower.Lag()
+			assert.Zero(t, lag)
+			assert.Zero(t, behind)
+		})
+	}
+}
+
+func TestFollower_Reconnects(t *testing.T) {
+	p := newPrimary(t, true)
+	p.put(t, "user:1", "a")
+
+	r := newReplica(t, p)
+	r.waitFor(t, 1)
+
+	// writes made while the replica is cut off are caught up from where it left off
+	p.srv.CloseClientConnections()
+	p.put(t, "user:2", "b")
+	p.put(t, "user:3", "c")
+	r.waitFor(t, 3)
+
+	assert.Equal(t, []uint64{1, 2, 3}, r.Events())
+	assert.Equal(t, []string{"user:1", "user:2", "user:3"}, r.store.Keys())
+}
+
+func TestFollower_Lag(t *testing.T) {
+	p := newPrimary(t, true)
+	p.put(t, "user:1", "a")
+
+	r := newReplica(t, p)
+	r.waitFor(t, 1)
+
+	// the replica falls behind while the primary is unreachable
+	p.hub.Close()
+	p.srv.Close()
+	require.Eventually(t, func() bool {
+		return r.follower.Ready(context.Background()) != nil
+	}, waitTimeout, pollInterval)
+
+	lag, _ := r.follower.Lag()
+	time.Sleep(50 * time.Millisecond)
+	later, behind := r.follower.Lag()
+	assert.Greater(t, later, lag)
+	assert.Zero(t, behind)
+	assert.False(t, r.follower.Connected())
+}
@@ -0,0 +1,76 @@
+package replication
+
+import (
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// Logger takes the place of the transaction logger for a replica. The replica's store is only written by the
+// primary's events, which are already in the primary's log, so there is nothing for it to write.
+type Logger struct {
+	f    *Follower
+	errs chan error
+}
+
+func (l *Logger) WritePut(key string, value string, version uint64, expires time.Time) error {
+	return model.ErrReadOnly
+}
+
+func (l *Logger) WriteDelete(key string) error {
+	return model.ErrReadOnly
+}
+
+// WriteExpire does nothing, the replica expires keys by its own clock until the primary's expiry arrives.
+func (l *Logger) WriteExpire(key string, deadline time.Time) error {
+	return nil
+}
+
+func (l *Logger) WriteBatch(ops []store.Event) error {
+	return model.ErrReadOnly
+}
+
+// Err never reports an error, the follower reconnects to the primary instead.
+func (l *Logger) Err() <-chan error {
+	return l.errs
+}
+
+// OnCommit registers fn to be called with every event as it is applied, sequenced as it was on the primary.
+// Snapshots replacing the store aren't reported. It must be called before Run.
+func (l *Logger) OnCommit(fn func(store.Event)) {
+	l.f.onCommit = fn
+}
+
+// LastSequence returns the sequence of the last event applied.
+func (l *Logger) LastSequence() uint64 {
+	return l.f.Applied()
+}
+
+// QueueDepth is always zero, there are no writes to wait on.
+func (l *Logger) QueueDepth() int {
+	return 0
+}
+
+// ReadEvents has nothing to replay, the follower fetches every event from the primary.
+func (l *Logger) ReadEvents() (<-chan store.Event, <-chan error) {
+	events := make(chan store.Event)
+	errs := make(chan error)
+	close(events)
+	close(errs)
+
+	return events, errs
+}
+
+// Run starts following the primary.
+func (l *Logger) Run() {
+	l.f.start()
+}
+
+// Close stops following the primary.
+func (l *Logger) Close() error {
+	l.f.stop()
+	close(l.errs)
+
+	return nil
+}
@@ -0,0 +1,188 @@
+// Package replication ships the store's committed events from a primary to read replicas. A replica streams the
+// events after the last sequence it applied, the primary catching it up from the transaction log, or with a snapshot
+// of its store once the log has been compacted, before sending new events as they are committed. Replicas apply the
+// events asynchronously, so a read from one may lag the primary.
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/watch"
+)
+
+// Log reads back the events committed to the primary's transaction log.
+type Log interface {
+	// ReadEventsAfter streams the events after sequence while the log is running, returning store.ErrCompacted if
+	// it no longer goes back that far.
+	ReadEventsAfter(ctx context.Context, sequence uint64) (<-chan store.Event, <-chan error)
+}
+
+// Frame types in the replication stream.
+const (
+	frameSnapshot  = "snapshot"
+	frameEvent     = "event"
+	frameHeartbeat = "heartbeat"
+	frameError     = "error"
+)
+
+// frame is a line of the replication stream.
+type frame struct {
+	Type     string          `json:"type"`
+	Snapshot *store.Snapshot `json:"snapshot,omitempty"`
+	Event    *store.Event    `json:"event,omitempty"`
+	// Sequence is the primary's last sequence in a heartbeat.
+	Sequence uint64 `json:"sequence,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Handler streams the primary's events to replicas.
+type Handler struct {
+	hub       *watch.Hub
+	store     *store.Store
+	log       Log
+	heartbeat time.Duration
+}
+
+// NewHandler creates a handler streaming the events published to the hub, catching replicas up from the log.
+// A nil log catches every replica up with a snapshot of the store. A heartbeat is sent every interval.
+func NewHandler(hub *watch.Hub, s *store.Store, log Log, heartbeat time.Duration) *Handler {
+	return &Handler{
+		hub:       hub,
+		store:     s,
+		log:       log,
+		heartbeat: heartbeat,
+	}
+}
+
+// ServeHTTP expects path "/v1/_replicate" and streams every event after the sequence in the after query parameter
+// as newline delimited JSON frames. The events a replica is missing are sent first, or a snapshot replacing its store
+// if they are no longer in the log or it is ahead of the primary, followed by new events as they are committed.
+// Heartbeats carry the primary's last sequence so the replica can tell how far behind it is.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w,
+			"streaming is not supported",
+			http.StatusInternalServerError)
+		return
+	}
+
+	var after uint64
+	if raw := r.URL.Query().Get("after"); raw != "" {
+		var err error
+		if after, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			http.Error(w,
+				fmt.Sprintf("invalid sequence %q", raw),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Watch before catching up, every event up to last is in the log and applied to the store, and the watcher has
+	// the rest.
+	last := h.store.Applied(h.hub.Last)
+	watcher, err := h.hub.Watch("", last)
+	if err != nil {
+		http.Error(w,
+			err.Error(),
+			http.StatusServiceUnavailable)
+		return
+	}
+	defer watcher.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	if after != last {
+		if err := h.catchUp(r.Context(), enc, after, last); err != nil {
+			enc.Encode(frame{Type: frameError, Error: err.Error()})
+			return
+		}
+	}
+	if err := enc.Encode(frame{Type: frameHeartbeat, Sequence: h.hub.Last()}); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if err := enc.Encode(frame{Type: frameHeartbeat, Sequence: h.hub.Last()}); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-watcher.Ready():
+			events, err := watcher.Next()
+			for i := range events {
+				if err := enc.Encode(frame{Type: frameEvent, Event: &events[i]}); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				// the replica reconnects from the last event it was sent
+				enc.Encode(frame{Type: frameError, Error: err.Error()})
+			}
+			flusher.Flush()
+
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// catchUp sends the events after the replica's sequence up to last, or a snapshot of the store as of last if the
+// log can't provide them.
+func (h *Handler) catchUp(ctx context.Context, enc *json.Encoder, after, last uint64) error {
+	if h.log != nil && after < last {
+		sent, err := h.sendLog(ctx, enc, after, last)
+		if err == nil && sent == last {
+			return nil
+		}
+		if err != nil && !errors.Is(err, store.ErrCompacted) {
+			return err
+		}
+	}
+
+	// The store holds every event up to last, any later ones it has too are sent again by the watcher.
+	return enc.Encode(frame{Type: frameSnapshot, Snapshot: h.store.Snapshot(last)})
+}
+
+// sendLog sends the logged events after the sequence up to last, returning the sequence of the last one sent.
+func (h *Handler) sendLog(ctx context.Context, enc *json.Encoder, after, last uint64) (uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, errs := h.log.ReadEventsAfter(ctx, after)
+
+	sent := after
+	for e := range events {
+		if e.Sequence > last {
+			// the watcher has it
+			break
+		}
+		if err := enc.Encode(frame{Type: frameEvent, Event: &e}); err != nil {
+			return sent, err
+		}
+		sent = e.Sequence
+	}
+	if sent == last {
+		return sent, nil
+	}
+
+	return sent, <-errs
+}
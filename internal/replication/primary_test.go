@@ -0,0 +1,218 @@
+package replication_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/replication"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/watch"
+)
+
+const (
+	waitTimeout  = 5 * time.Second
+	pollInterval = 10 * time.Millisecond
+)
+
+// primary is a store logging its writes to a file transaction logger, with its events streamed by a handler.
+type primary struct {
+	store  *store.Store
+	logger *store.FileTransactionLogger
+	hub    *watch.Hub
+	srv    *httptest.Server
+}
+
+func newPrimary(t *testing.T, withLog bool) *primary {
+	logger, err := store.NewFileTransactionLogger(store.FileConfig{Dir: t.TempDir(), MaxSegmentSize: 128})
+	require.NoError(t, err)
+
+	p := &primary{
+		store:  store.New(map[string]interface{}{}),
+		logger: logger,
+		hub:    watch.NewHub(100),
+	}
+	p.hub.Start(0)
+	logger.OnCommit(p.hub.Publish)
+	logger.Run()
+	t.Cleanup(func() { logger.Close() })
+
+	var log replication.Log
+	if withLog {
+		log = logger
+	}
+	p.srv = httptest.NewServer(replication.NewHandler(p.hub, p.store, log, 20*time.Millisecond))
+	t.Cleanup(p.srv.Close)
+	// end the streams before the server waits for them
+	t.Cleanup(p.hub.Close)
+
+	return p
+}
+
+func (p *primary) put(t *testing.T, key, value string) {
+	kv, err := p.store.PutIf(key, value, time.Time{}, model.Precondition{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, p.logger.WritePut(key, value, kv.Version, time.Time{}))
+}
+
+func (p *primary) delete(t *testing.T, key string) {
+	require.NoError(t, p.store.DeleteIf(key, model.Precondition{}, nil))
+	require.NoError(t, p.logger.WriteDelete(key))
+}
+
+// streamFrame is a line of the replication stream.
+type streamFrame struct {
+	Type     string          `json:"type"`
+	Snapshot *store.Snapshot `json:"snapshot"`
+	Event    *store.Event    `json:"event"`
+	Sequence uint64          `json:"sequence"`
+}
+
+func TestHandler(t *testing.T) {
+	tests := map[string]struct {
+		after             string
+		withoutLog        bool
+		compact           bool
+		expectedStatus    int
+		expectedSnapshot  bool
+		expectedSequences []uint64
+	}{
+		"from the start": {
+			expectedStatus:    http.StatusOK,
+			expectedSequences: []uint64{1, 2, 3, 4},
+		},
+		"from a sequence": {
+			after:             "2",
+			expectedStatus:    http.StatusOK,
+			expectedSequences: []uint64{3, 4},
+		},
+		"up to date": {
+			after:          "4",
+			expectedStatus: http.StatusOK,
+		},
+		"compacted": {
+			after:            "1",
+			compact:          true,
+			expectedStatus:   http.StatusOK,
+			expectedSnapshot: true,
+		},
+		"ahead of the primary": {
+			after:            "10",
+			expectedStatus:   http.StatusOK,
+			expectedSnapshot: true,
+		},
+		"without a log": {
+			withoutLog:       true,
+			expectedStatus:   http.StatusOK,
+			expectedSnapshot: true,
+		},
+		"invalid sequence": {
+			after:          "last",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := newPrimary(t, !test.withoutLog)
+			p.put(t, "user:1", "a")
+			p.put(t, "user:2", "b")
+			p.delete(t, "user:1")
+			p.put(t, "user:3", "c")
+			if test.compact {
+				require.NoError(t, p.logger.Compact(context.Background(), p.store, 4))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.srv.URL+"/v1/_replicate?after="+test.after, nil)
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, test.expectedStatus, resp.StatusCode)
+			if test.expectedStatus != http.StatusOK {
+				return
+			}
+
+			// everything up to the first heartbeat is catching up
+			var (
+				snapshot  *store.Snapshot
+				sequences []uint64
+				heartbeat uint64
+			)
+			scanner := bufio.NewScanner(resp.Body)
+			for heartbeat == 0 && scanner.Scan() {
+				var f streamFrame
+				require.NoError(t, json.Unmarshal(scanner.Bytes(), &f))
+				switch f.Type {
+				case "snapshot":
+					snapshot = f.Snapshot
+				case "event":
+					sequences = append(sequences, f.Event.Sequence)
+				case "heartbeat":
+					heartbeat = f.Sequence
+				}
+			}
+			require.NoError(t, scanner.Err())
+
+			assert.Equal(t, uint64(4), heartbeat)
+			assert.Equal(t, test.expectedSequences, sequences)
+			if !test.expectedSnapshot {
+				assert.Nil(t, snapshot)
+				return
+			}
+			require.NotNil(t, snapshot)
+			assert.Equal(t, uint64(4), snapshot.Sequence)
+
+			var keys []string
+			for _, kv := range snapshot.KeyValues {
+				keys = append(keys, kv.Key)
+			}
+			assert.Equal(t, []string{"user:2", "user:3"}, keys)
+		})
+	}
+}
+
+func TestHandler_Live(t *testing.T) {
+	p := newPrimary(t, true)
+	p.put(t, "user:1", "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.srv.URL+"/v1/_replicate?after=1", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	next := func() streamFrame {
+		require.True(t, scanner.Scan(), scanner.Err())
+		var f streamFrame
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &f))
+		return f
+	}
+
+	assert.Equal(t, streamFrame{Type: "heartbeat", Sequence: 1}, next())
+
+	// events committed once the stream has started follow it
+	for i := 2; i <= 3; i++ {
+		p.put(t, "user:"+strconv.Itoa(i), "b")
+	}
+	var sequences []uint64
+	for len(sequences) < 2 {
+		if f := next(); f.Type == "event" {
+			sequences = append(sequences, f.Event.Sequence)
+		}
+	}
+	assert.Equal(t, []uint64{2, 3}, sequences)
+}
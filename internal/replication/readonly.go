@@ -0,0 +1,43 @@
+package replication
+
+import (
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// ReadOnlyStore serves reads from a replica's store and fails every write with model.ErrReadOnly, writes must be
+// made on the primary.
+type ReadOnlyStore struct {
+	store *store.Store
+}
+
+// NewReadOnlyStore creates a read only view of s.
+func NewReadOnlyStore(s *store.Store) *ReadOnlyStore {
+	return &ReadOnlyStore{store: s}
+}
+
+func (s *ReadOnlyStore) PutIf(key string, value interface{}, expires time.Time, pre model.Precondition, commit model.Commit) (*model.KeyValue, error) {
+	return nil, model.ErrReadOnly
+}
+
+func (s *ReadOnlyStore) DeleteIf(key string, pre model.Precondition, commit model.Commit) error {
+	return model.ErrReadOnly
+}
+
+func (s *ReadOnlyStore) Batch(ops []model.BatchOp, commit model.Commit) ([]*model.KeyValue, error) {
+	return nil, model.ErrReadOnly
+}
+
+func (s *ReadOnlyStore) GetKeyValue(key string) (*model.KeyValue, error) {
+	return s.store.GetKeyValue(key)
+}
+
+func (s *ReadOnlyStore) List(opts model.ListOptions) ([]*model.KeyValue, string) {
+	return s.store.List(opts)
+}
+
+func (s *ReadOnlyStore) Keys() []string {
+	return s.store.Keys()
+}
@@ -0,0 +1,71 @@
+package replication_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/replication"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+func TestReadOnlyStore(t *testing.T) {
+	s := store.New(map[string]interface{}{})
+	require.NoError(t, s.Put("user:1", "a"))
+
+	follower := replication.NewFollower(replication.FollowerConfig{}, s)
+	server := api.New(replication.NewReadOnlyStore(s), follower.Logger())
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/_batch", server.BatchHandler).Methods("POST")
+	r.HandleFunc("/v1/{key}", server.PutKeyValueHandler).Methods("PUT")
+	r.HandleFunc("/v1/{key}", server.GetKeyValueHandler).Methods("GET")
+	r.HandleFunc("/v1/{key}", server.DeleteKeyValueHandler).Methods("DELETE")
+
+	tests := map[string]struct {
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		"get": {
+			method:         http.MethodGet,
+			path:           "/v1/user:1",
+			expectedStatus: http.StatusOK,
+		},
+		"put": {
+			method:         http.MethodPut,
+			path:           "/v1/user:1",
+			body:           "b",
+			expectedStatus: http.StatusForbidden,
+		},
+		"delete": {
+			method:         http.MethodDelete,
+			path:           "/v1/user:1",
+			expectedStatus: http.StatusForbidden,
+		},
+		"batch": {
+			method:         http.MethodPost,
+			path:           "/v1/_batch",
+			body:           `{"ops":[{"op":"put","key":"user:2","value":"b"}]}`,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+
+			assert.Equal(t, test.expectedStatus, rec.Code, rec.Body.String())
+		})
+	}
+
+	value, err := s.Get("user:1")
+	require.NoError(t, err)
+	assert.Equal(t, "a", value)
+}
@@ -0,0 +1,31 @@
+package resp
+
+// maxCursors is how many SCAN cursors a connection remembers, a scan resumed from an older one fails.
+const maxCursors = 64
+
+// cursors remembers the key each SCAN cursor handed out on a connection resumes from, so concurrent clients don't
+// forget each other's. Redis clients expect a cursor to be a number that fits in 64 bits, so the key can't be handed
+// out itself. Cursors are numbered from 1, 0 starts and ends a scan. They are only used by the connection's goroutine.
+type cursors struct {
+	last uint64
+	keys map[uint64]string
+}
+
+func newCursors() *cursors {
+	return &cursors{keys: make(map[uint64]string)}
+}
+
+// add returns a new cursor resuming from the key, forgetting the oldest once there are maxCursors.
+func (c *cursors) add(key string) uint64 {
+	c.last++
+	c.keys[c.last] = key
+	delete(c.keys, c.last-maxCursors)
+
+	return c.last
+}
+
+// get returns the key the cursor resumes from, false if it was never handed out or has been forgotten.
+func (c *cursors) get(cursor uint64) (string, bool) {
+	key, ok := c.keys[cursor]
+	return key, ok
+}
@@ -0,0 +1,34 @@
+package resp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		expected     bool
+	}{
+		{"*", "anything/at/all", true},
+		{"user/*", "user/1", true},
+		{"user/*", "order/1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbc", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, match(test.pattern, test.key), "%s %s", test.pattern, test.key)
+	}
+}
@@ -0,0 +1,150 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxBulkLength caps the size of a single argument.
+	maxBulkLength = 64 << 20
+	// maxArrayLength caps the number of arguments in a command.
+	maxArrayLength = 1 << 20
+	// maxInlineLength caps the length of an inline command line.
+	maxInlineLength = 64 << 10
+)
+
+// errProtocol is returned for malformed input, after which the connection is closed as it can't be resynchronised.
+var errProtocol = errors.New("Protocol error")
+
+// readCommand reads a command sent either as a RESP array of bulk strings, as client libraries do,
+// or as an inline space separated line typed into telnet.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	prefix, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix[0] != '*' {
+		return readInline(r)
+	}
+
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArrayLength {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		arg, err := readBulk(r)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+func readBulk(r *bufio.Reader) (string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return "", err
+	}
+
+	if len(line) == 0 || line[0] != '$' {
+		return "", fmt.Errorf("%w: expected '$', got '%s'", errProtocol, firstByte(line))
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxBulkLength {
+		return "", fmt.Errorf("%w: invalid bulk length", errProtocol)
+	}
+
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return "", fmt.Errorf("%w: bulk string not terminated by CRLF", errProtocol)
+	}
+
+	return string(buf[:n]), nil
+}
+
+func readInline(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(line), nil
+}
+
+// readLine reads up to the next newline, dropping the CRLF or bare LF.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+
+		line = append(line, chunk...)
+		if len(line) > maxInlineLength {
+			return "", fmt.Errorf("%w: too big inline request", errProtocol)
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+func firstByte(s string) string {
+	if s == "" {
+		return ""
+	}
+	return s[:1]
+}
+
+// writer encodes replies, buffering them until flushed.
+type writer struct {
+	*bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+// error writes an error reply, msg should start with an upper case code such as ERR.
+func (w writer) error(msg string) {
+	// a newline would end the reply early
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	w.WriteString("-" + msg + "\r\n")
+}
+
+func (w writer) integer(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w writer) bulk(s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (w writer) null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w writer) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
@@ -0,0 +1,384 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+	"github.com/warrenb95/cloud-native-go/internal/tcpserver"
+)
+
+// Store is the store the commands are translated onto, the same one used by the REST API plus key listing.
+type Store interface {
+	api.Store
+	Keys() []string
+}
+
+// Server speaks enough of the Redis protocol for redis-cli and client libraries to get, set and scan keys.
+type Server struct {
+	*tcpserver.Server
+
+	store  Store
+	logger api.TransactionLogger
+	// ready rejects data commands while it returns an error, e.g. during log replay.
+	ready func(context.Context) error
+}
+
+func NewServer(store Store, logger api.TransactionLogger, ready func(context.Context) error) *Server {
+	s := &Server{
+		store:  store,
+		logger: logger,
+		ready:  ready,
+	}
+	s.Server = tcpserver.New(s.serveConn)
+
+	return s
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := writer{bufio.NewWriter(conn)}
+	cursors := newCursors()
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				w.error("ERR " + err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := s.execute(w, cursors, args)
+
+		// pipelined commands are answered together
+		if r.Buffered() == 0 || quit {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit || s.Closing() {
+			w.Flush()
+			return
+		}
+	}
+}
+
+type command struct {
+	// arity is the number of arguments including the command name, negative for at least that many.
+	arity int
+	// data commands are rejected until the server is ready.
+	data bool
+	// run is nil for quit and scan, which execute handles itself.
+	run func(s *Server, w writer, args []string)
+}
+
+var commands = map[string]command{
+	"ping":    {arity: -1, run: (*Server).ping},
+	"quit":    {arity: 1},
+	"command": {arity: -1, run: (*Server).command},
+	"select":  {arity: 2, run: (*Server).selectDB},
+	"get":     {arity: 2, data: true, run: (*Server).get},
+	"set":     {arity: -3, data: true, run: (*Server).set},
+	"del":     {arity: -2, data: true, run: (*Server).del},
+	"exists":  {arity: -2, data: true, run: (*Server).exists},
+	"mget":    {arity: -2, data: true, run: (*Server).mget},
+	"mset":    {arity: -3, data: true, run: (*Server).mset},
+	"keys":    {arity: 2, data: true, run: (*Server).keys},
+	"scan":    {arity: -2, data: true},
+}
+
+// execute runs the command with the connection's SCAN cursors, reporting whether the client asked to close the
+// connection.
+func (s *Server) execute(w writer, cursors *cursors, args []string) bool {
+	name := strings.ToLower(args[0])
+
+	cmd, ok := commands[name]
+	if !ok {
+		w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+
+	if name == "quit" {
+		w.simple("OK")
+		return true
+	}
+
+	if cmd.data {
+		if err := s.ready(context.Background()); err != nil {
+			w.error("LOADING " + err.Error())
+			return false
+		}
+	}
+
+	if name == "scan" {
+		s.scan(w, cursors, args)
+		return false
+	}
+	cmd.run(s, w, args)
+
+	return false
+}
+
+func (s *Server) ping(w writer, args []string) {
+	switch len(args) {
+	case 1:
+		w.simple("PONG")
+	case 2:
+		w.bulk(args[1])
+	default:
+		w.error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+// command answers the COMMAND introspection redis-cli sends on connect with an empty list.
+func (s *Server) command(w writer, args []string) {
+	w.array(0)
+}
+
+// selectDB only accepts database 0, there is one keyspace.
+func (s *Server) selectDB(w writer, args []string) {
+	if args[1] != "0" {
+		w.error("ERR DB index is out of range")
+		return
+	}
+	w.simple("OK")
+}
+
+func (s *Server) get(w writer, args []string) {
+	kv, err := s.store.GetKeyValue(args[1])
+	if err != nil {
+		if errors.Is(err, model.ErrKeyNotFound) {
+			w.null()
+			return
+		}
+		w.error("ERR " + err.Error())
+		return
+	}
+
+	w.bulk(api.ValueString(kv))
+}
+
+// set supports SET key value [EX seconds | PX milliseconds] [NX | XX].
+func (s *Server) set(w writer, args []string) {
+	key, value := args[1], args[2]
+
+	var (
+		ttl time.Duration
+		pre model.Precondition
+	)
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case (opt == "EX" || opt == "PX") && ttl == 0 && i+1 < len(args):
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				w.error("ERR value is not an integer or out of range")
+				return
+			}
+			if n <= 0 || n > int64(math.MaxInt64/unit) {
+				w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			ttl = time.Duration(n) * unit
+			i++
+		case opt == "NX" && !pre.IfMatchAny:
+			pre.IfNoneMatchAny = true
+		case opt == "XX" && !pre.IfNoneMatchAny:
+			pre.IfMatchAny = true
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).UTC()
+	}
+
+	_, err := s.store.PutIf(key, value, expires, pre, api.LogPut(s.logger))
+	if err != nil {
+		if errors.Is(err, model.ErrPreconditionFailed) {
+			// NX or XX didn't hold
+			w.null()
+			return
+		}
+		w.error("ERR " + err.Error())
+		return
+	}
+
+	w.simple("OK")
+}
+
+// del replies with the number of keys that existed and were deleted.
+func (s *Server) del(w writer, args []string) {
+	var deleted int64
+	for _, key := range args[1:] {
+		err := s.store.DeleteIf(key, model.Precondition{IfMatchAny: true}, api.LogDelete(s.logger))
+		if errors.Is(err, model.ErrPreconditionFailed) {
+			continue
+		}
+		if err != nil {
+			w.error("ERR " + err.Error())
+			return
+		}
+		deleted++
+	}
+
+	w.integer(deleted)
+}
+
+// exists counts the keys that exist, a key given more than once is counted each time.
+func (s *Server) exists(w writer, args []string) {
+	var n int64
+	for _, key := range args[1:] {
+		if _, err := s.store.GetKeyValue(key); err == nil {
+			n++
+		}
+	}
+
+	w.integer(n)
+}
+
+func (s *Server) mget(w writer, args []string) {
+	w.array(len(args) - 1)
+	for _, key := range args[1:] {
+		kv, err := s.store.GetKeyValue(key)
+		if err != nil {
+			w.null()
+			continue
+		}
+		w.bulk(api.ValueString(kv))
+	}
+}
+
+// mset sets every pair as one batch, so other clients see all of them set or none.
+func (s *Server) mset(w writer, args []string) {
+	if len(args)%2 != 1 {
+		w.error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+
+	// like Redis the last value of a key given more than once is kept, a batch takes each key once
+	var ops []model.BatchOp
+	index := make(map[string]int)
+	for i := 1; i < len(args); i += 2 {
+		op := model.BatchOp{Type: model.OpPut, Key: args[i], Value: args[i+1]}
+		if j, ok := index[op.Key]; ok {
+			ops[j] = op
+			continue
+		}
+		index[op.Key] = len(ops)
+		ops = append(ops, op)
+	}
+
+	if _, err := s.store.Batch(ops, api.LogBatch(s.logger)); err != nil {
+		w.error("ERR " + err.Error())
+		return
+	}
+
+	w.simple("OK")
+}
+
+func (s *Server) keys(w writer, args []string) {
+	var matched []string
+	for _, key := range s.store.Keys() {
+		if match(args[1], key) {
+			matched = append(matched, key)
+		}
+	}
+
+	w.array(len(matched))
+	for _, key := range matched {
+		w.bulk(key)
+	}
+}
+
+// scan supports SCAN cursor [MATCH pattern] [COUNT count]. Keys are scanned in sorted order and the cursor resumes
+// from the key after the last one returned, so every key that exists throughout a scan is returned exactly once. A
+// cursor is only valid on the connection it was handed out on.
+func (s *Server) scan(w writer, cursors *cursors, args []string) {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		w.error("ERR invalid cursor")
+		return
+	}
+
+	var start string
+	if cursor != 0 {
+		var ok bool
+		if start, ok = cursors.get(cursor); !ok {
+			w.error("ERR invalid cursor")
+			return
+		}
+	}
+
+	pattern, count := "*", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.error("ERR syntax error")
+			return
+		}
+
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil {
+				w.error("ERR value is not an integer or out of range")
+				return
+			}
+			if count < 1 {
+				w.error("ERR syntax error")
+				return
+			}
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	kvs, nextKey := s.store.List(model.ListOptions{Start: start, Limit: count})
+
+	var matched []string
+	for _, kv := range kvs {
+		if match(pattern, kv.Key) {
+			matched = append(matched, kv.Key)
+		}
+	}
+
+	next := uint64(0)
+	if nextKey != "" {
+		next = cursors.add(nextKey)
+	}
+
+	w.array(2)
+	w.bulk(strconv.FormatUint(next, 10))
+	w.array(len(matched))
+	for _, key := range matched {
+		w.bulk(key)
+	}
+}
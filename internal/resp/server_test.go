@@ -0,0 +1,229 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/testutil"
+)
+
+func TestServer_Commands(t *testing.T) {
+	tests := map[string]struct {
+		setup    []string
+		request  string
+		expected string
+	}{
+		"ping": {
+			request:  "*1\r\n$4\r\nPING\r\n",
+			expected: "+PONG\r\n",
+		},
+		"inline ping with message": {
+			request:  "ping hello\r\n",
+			expected: "$5\r\nhello\r\n",
+		},
+		"get missing": {
+			request:  "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n",
+			expected: "$-1\r\n",
+		},
+		"set then get binary value": {
+			request:  "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$4\r\na\r\nb\r\n*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n",
+			expected: "+OK\r\n$4\r\na\r\nb\r\n",
+		},
+		"set nx on existing key": {
+			setup:    []string{"key"},
+			request:  "SET key value NX\r\n",
+			expected: "$-1\r\n",
+		},
+		"set xx on missing key": {
+			request:  "SET key value XX\r\n",
+			expected: "$-1\r\n",
+		},
+		"set with ex and px": {
+			request:  "SET key value EX 1 PX 100\r\n",
+			expected: "-ERR syntax error\r\n",
+		},
+		"set with invalid expire": {
+			request:  "SET key value EX 0\r\n",
+			expected: "-ERR invalid expire time in 'set' command\r\n",
+		},
+		"set with px": {
+			request:  "SET key value PX 60000\r\nGET key\r\n",
+			expected: "+OK\r\n$5\r\nvalue\r\n",
+		},
+		"del counts existing keys": {
+			setup:    []string{"a", "b"},
+			request:  "DEL a b c\r\n",
+			expected: ":2\r\n",
+		},
+		"exists counts repeats": {
+			setup:    []string{"a"},
+			request:  "EXISTS a a b\r\n",
+			expected: ":2\r\n",
+		},
+		"mget": {
+			setup:    []string{"a"},
+			request:  "MGET a b\r\n",
+			expected: "*2\r\n$5\r\nvalue\r\n$-1\r\n",
+		},
+		"mset": {
+			request:  "MSET a 1 b 2\r\nMGET a b\r\n",
+			expected: "+OK\r\n*2\r\n$1\r\n1\r\n$1\r\n2\r\n",
+		},
+		"mset repeated key": {
+			request:  "MSET a 1 b 2 a 3\r\nMGET a b\r\n",
+			expected: "+OK\r\n*2\r\n$1\r\n3\r\n$1\r\n2\r\n",
+		},
+		"mset odd arguments": {
+			request:  "MSET a 1 b\r\n",
+			expected: "-ERR wrong number of arguments for 'mset' command\r\n",
+		},
+		"keys": {
+			setup:    []string{"user/1", "user/2", "order/1"},
+			request:  "KEYS user/*\r\n",
+			expected: "*2\r\n$6\r\nuser/1\r\n$6\r\nuser/2\r\n",
+		},
+		"scan in pages": {
+			setup:    []string{"a", "b", "c"},
+			request:  "SCAN 0 COUNT 2\r\nSCAN 1 COUNT 2\r\n",
+			expected: "*2\r\n$1\r\n1\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n*2\r\n$1\r\n0\r\n*1\r\n$1\r\nc\r\n",
+		},
+		"scan unknown cursor": {
+			request:  "SCAN 7\r\n",
+			expected: "-ERR invalid cursor\r\n",
+		},
+		"scan resumes after deleted key": {
+			setup:    []string{"a", "b", "c", "d"},
+			request:  "SCAN 0 COUNT 2\r\nDEL a c\r\nSCAN 1 COUNT 2\r\n",
+			expected: "*2\r\n$1\r\n1\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n:2\r\n*2\r\n$1\r\n0\r\n*1\r\n$1\r\nd\r\n",
+		},
+		"scan with match": {
+			setup:    []string{"a", "b", "c"},
+			request:  "SCAN 0 MATCH [ac]\r\n",
+			expected: "*2\r\n$1\r\n0\r\n*2\r\n$1\r\na\r\n$1\r\nc\r\n",
+		},
+		"wrong arity": {
+			request:  "GET\r\n",
+			expected: "-ERR wrong number of arguments for 'get' command\r\n",
+		},
+		"unknown command": {
+			request:  "FLUSHALL\r\n",
+			expected: "-ERR unknown command 'FLUSHALL'\r\n",
+		},
+		"protocol error": {
+			request:  "*1\r\n+PING\r\n",
+			expected: "-ERR Protocol error: expected '$', got '+'\r\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := store.New(make(map[string]interface{}))
+			for _, key := range test.setup {
+				require.NoError(t, s.Put(key, "value"))
+			}
+
+			conn := testutil.Dial(t, NewServer(s, &testutil.Logger{}, testutil.Ready))
+			_, err := conn.Write([]byte(test.request))
+			require.NoError(t, err)
+
+			// replies to pipelined commands can arrive in more than one read
+			got := make([]byte, len(test.expected))
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = io.ReadFull(conn, got)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(got))
+		})
+	}
+}
+
+func TestServer_Logging(t *testing.T) {
+	s := store.New(make(map[string]interface{}))
+	logger := &testutil.Logger{}
+	conn := testutil.Dial(t, NewServer(s, logger, testutil.Ready))
+
+	r := bufio.NewReader(conn)
+	for _, cmd := range []string{"SET a 1", "SET a 2 NX", "DEL a b", "MSET b 1 c 2"} {
+		_, err := conn.Write([]byte(cmd + "\r\n"))
+		require.NoError(t, err)
+		_, err = r.ReadString('\n')
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"put a 1", "delete a", "put b 1", "put c 2"}, logger.Ops())
+	assert.Equal(t, []string{"b", "c"}, s.Keys())
+}
+
+func TestServer_NotReady(t *testing.T) {
+	notReady := func(context.Context) error { return errors.New("replaying the transaction log") }
+	conn := testutil.Dial(t, NewServer(store.New(make(map[string]interface{})), &testutil.Logger{}, notReady))
+
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("PING\r\nGET a\r\nQUIT\r\n"))
+	require.NoError(t, err)
+
+	for _, expected := range []string{"+PONG\r\n", "-LOADING replaying the transaction log\r\n", "+OK\r\n"} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, expected, line)
+	}
+
+	// QUIT closes the connection
+	_, err = r.ReadString('\n')
+	assert.Error(t, err)
+}
+
+func TestServer_ScanCursors(t *testing.T) {
+	s := store.New(make(map[string]interface{}))
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, s.Put(key, "value"))
+	}
+
+	first := testutil.Dial(t, NewServer(s, &testutil.Logger{}, testutil.Ready))
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", first.RemoteAddr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	// with a key a page, every reply is six lines: the cursor third and the key last
+	scan := func(conn net.Conn, r *bufio.Reader, cursor string) []string {
+		_, err := conn.Write([]byte("SCAN " + cursor + " COUNT 1\r\n"))
+		require.NoError(t, err)
+
+		var lines []string
+		for len(lines) < 6 {
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			lines = append(lines, line)
+			if strings.HasPrefix(line, "-") {
+				break
+			}
+		}
+		return lines
+	}
+
+	firstReader := bufio.NewReader(first)
+	require.Equal(t, "1\r\n", scan(first, firstReader, "0")[2])
+
+	// another client scanning more than a connection remembers doesn't make the first forget its cursor
+	second := dial()
+	secondReader := bufio.NewReader(second)
+	for i := 0; i <= maxCursors; i++ {
+		scan(second, secondReader, "0")
+	}
+	assert.Equal(t, "b\r\n", scan(first, firstReader, "1")[5])
+
+	// a cursor is only valid on the connection it was handed out on
+	third := dial()
+	assert.Equal(t, []string{"-ERR invalid cursor\r\n"}, scan(third, bufio.NewReader(third), "1"))
+}
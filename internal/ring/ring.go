@@ -0,0 +1,156 @@
+// Package ring places keys on nodes with a consistent hash ring. Each node is hashed onto the ring at a number of
+// virtual points and owns the hashes from the point before each of them up to it, so adding or removing a node only
+// moves the keys in the ranges next to its points.
+package ring
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Ring maps keys to the nodes that own them. It is never changed once created, a change in membership is a new ring.
+type Ring struct {
+	points []point
+	nodes  []string
+}
+
+// point is a node's virtual position on the ring.
+type point struct {
+	hash uint64
+	node string
+}
+
+// Range is the hashes after Start up to and including End, wrapping past the top of the ring if End is below Start.
+type Range struct {
+	Start uint64
+	End   uint64
+}
+
+// Move is a range of hashes changing owner.
+type Move struct {
+	Range
+	From string
+	To   string
+}
+
+// New creates a ring placing every node at virtualNodes points.
+func New(nodes []string, virtualNodes int) *Ring {
+	r := &Ring{nodes: append([]string(nil), nodes...)}
+	sort.Strings(r.nodes)
+
+	for _, node := range r.nodes {
+		for i := 0; i < virtualNodes; i++ {
+			r.points = append(r.points, point{hash: Hash(node + "#" + strconv.Itoa(i)), node: node})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			// a collision goes the same way on every node
+			return r.points[i].node < r.points[j].node
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+
+	return r
+}
+
+// Hash returns the position of the key on the ring.
+func Hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	// FNV spreads similar keys poorly, so the bits are mixed with the splitmix64 finalizer
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
+
+// Nodes returns the ring's nodes in order.
+func (r *Ring) Nodes() []string {
+	return append([]string(nil), r.nodes...)
+}
+
+// Owner returns the node owning the key, or an empty string if the ring has no nodes.
+func (r *Ring) Owner(key string) string {
+	return r.ownerOf(Hash(key))
+}
+
+// ownerOf returns the node of the first point at or after the hash, wrapping around to the first point.
+func (r *Ring) ownerOf(hash uint64) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.points[i].node
+}
+
+// Moves returns the ranges of hashes owned by a different node in next, adjacent ranges moving between the same
+// nodes are merged. Nothing moves to or from a ring without nodes.
+func (r *Ring) Moves(next *Ring) []Move {
+	if len(r.points) == 0 || len(next.points) == 0 {
+		return nil
+	}
+
+	// No point of either ring falls between two neighbouring boundaries, so each node owns the whole range up to
+	// the next boundary in both rings.
+	boundaries := make([]uint64, 0, len(r.points)+len(next.points))
+	for _, p := range r.points {
+		boundaries = append(boundaries, p.hash)
+	}
+	for _, p := range next.points {
+		boundaries = append(boundaries, p.hash)
+	}
+	sort.Slice(boundaries, func(i, j int) bool { return boundaries[i] < boundaries[j] })
+	unique := boundaries[:1]
+	for _, b := range boundaries[1:] {
+		if b != unique[len(unique)-1] {
+			unique = append(unique, b)
+		}
+	}
+
+	var moves []Move
+	start := unique[len(unique)-1]
+	for _, end := range unique {
+		from, to := r.ownerOf(end), next.ownerOf(end)
+		if from != to {
+			last := len(moves) - 1
+			if last >= 0 && moves[last].End == start && moves[last].From == from && moves[last].To == to {
+				moves[last].End = end
+			} else {
+				moves = append(moves, Move{Range: Range{Start: start, End: end}, From: from, To: to})
+			}
+		}
+		start = end
+	}
+
+	// the first range starts where the last one ends, so they are one range if they move between the same nodes
+	if last := len(moves) - 1; last > 0 && moves[last].End == moves[0].Start &&
+		moves[last].From == moves[0].From && moves[last].To == moves[0].To {
+		moves[0].Start = moves[last].Start
+		moves = moves[:last]
+	}
+
+	return moves
+}
+
+// Contains reports whether the hash is in the range.
+func (rg Range) Contains(hash uint64) bool {
+	if rg.Start < rg.End {
+		return hash > rg.Start && hash <= rg.End
+	}
+	// wraps past the top of the ring, or covers all of it if Start equals End
+	return hash > rg.Start || hash <= rg.End
+}
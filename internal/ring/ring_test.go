@@ -0,0 +1,131 @@
+package ring_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/ring"
+)
+
+const virtualNodes = 128
+
+func TestRing_Owner(t *testing.T) {
+	r := ring.New([]string{"n3", "n1", "n2"}, virtualNodes)
+	assert.Equal(t, []string{"n1", "n2", "n3"}, r.Nodes())
+
+	// every node owns a share of the keys, the same whichever order the ring was given its nodes in
+	same := ring.New([]string{"n1", "n2", "n3"}, virtualNodes)
+	owned := make(map[string]int)
+	for i := 0; i < 30000; i++ {
+		key := "user:" + strconv.Itoa(i)
+		owner := r.Owner(key)
+		owned[owner]++
+		require.Equal(t, owner, same.Owner(key))
+	}
+
+	require.Len(t, owned, 3)
+	for node, n := range owned {
+		assert.InDelta(t, 10000, n, 2000, "keys owned by %s", node)
+	}
+
+	assert.Equal(t, "", ring.New(nil, virtualNodes).Owner("user:1"))
+}
+
+func TestRing_Moves(t *testing.T) {
+	tests := map[string]struct {
+		from []string
+		to   []string
+		// only is the node every key moves to or from
+		only      string
+		unchanged bool
+	}{
+		"node added": {
+			from: []string{"n1", "n2", "n3"},
+			to:   []string{"n1", "n2", "n3", "n4"},
+			only: "n4",
+		},
+		"node removed": {
+			from: []string{"n1", "n2", "n3"},
+			to:   []string{"n1", "n3"},
+			only: "n2",
+		},
+		"node replaced": {
+			from: []string{"n1", "n2"},
+			to:   []string{"n1", "n3"},
+		},
+		"unchanged": {
+			from:      []string{"n1", "n2"},
+			to:        []string{"n2", "n1"},
+			unchanged: true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			from := ring.New(test.from, virtualNodes)
+			to := ring.New(test.to, virtualNodes)
+			moves := from.Moves(to)
+
+			moved := 0
+			for i := 0; i < 10000; i++ {
+				key := "user:" + strconv.Itoa(i)
+				hash := ring.Hash(key)
+				oldOwner, newOwner := from.Owner(key), to.Owner(key)
+
+				// a key changes owner exactly when it falls in a move between those owners
+				var containing []ring.Move
+				for _, m := range moves {
+					if m.Contains(hash) {
+						containing = append(containing, m)
+					}
+				}
+				if oldOwner == newOwner {
+					require.Empty(t, containing, key)
+					continue
+				}
+				require.Len(t, containing, 1, key)
+				assert.Equal(t, oldOwner, containing[0].From)
+				assert.Equal(t, newOwner, containing[0].To)
+
+				if test.only != "" {
+					assert.True(t, oldOwner == test.only || newOwner == test.only, key)
+				}
+				moved++
+			}
+
+			if test.only != "" {
+				// roughly the share of the node joining or leaving
+				share := 10000 / len(test.from)
+				if len(test.to) > len(test.from) {
+					share = 10000 / len(test.to)
+				}
+				assert.InDelta(t, share, moved, float64(share)/4)
+			}
+			if test.unchanged {
+				assert.Empty(t, moves)
+			}
+		})
+	}
+}
+
+func TestRange_Contains(t *testing.T) {
+	tests := map[string]struct {
+		r        ring.Range
+		hash     uint64
+		expected bool
+	}{
+		"inside":          {r: ring.Range{Start: 10, End: 20}, hash: 15, expected: true},
+		"end":             {r: ring.Range{Start: 10, End: 20}, hash: 20, expected: true},
+		"start":           {r: ring.Range{Start: 10, End: 20}, hash: 10, expected: false},
+		"wrapped top":     {r: ring.Range{Start: 20, End: 10}, hash: 30, expected: true},
+		"wrapped bottom":  {r: ring.Range{Start: 20, End: 10}, hash: 5, expected: true},
+		"wrapped outside": {r: ring.Range{Start: 20, End: 10}, hash: 15, expected: false},
+		"whole ring":      {r: ring.Range{Start: 10, End: 10}, hash: 15, expected: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.r.Contains(test.hash))
+		})
+	}
+}
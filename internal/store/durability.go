@@ -0,0 +1,27 @@
+package store
+
+import "fmt"
+
+// Durability controls when a FileTransactionLogger flushes written events to stable storage.
+type Durability string
+
+const (
+	// DurabilityNone leaves flushing to the operating system, events are acknowledged once written to the file.
+	DurabilityNone Durability = "none"
+	// DurabilityInterval flushes every SyncInterval, events are acknowledged once written to the file.
+	// Anything acknowledged since the last flush can be lost on power failure.
+	DurabilityInterval Durability = "interval"
+	// DurabilityEveryWrite flushes every batch of events before acknowledging them.
+	DurabilityEveryWrite Durability = "every-write"
+)
+
+// ParseDurability returns the durability mode named by s.
+func ParseDurability(s string) (Durability, error) {
+	switch d := Durability(s); d {
+	case DurabilityNone, DurabilityInterval, DurabilityEveryWrite:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown durability %q, expected one of %q, %q or %q",
+			s, DurabilityNone, DurabilityInterval, DurabilityEveryWrite)
+	}
+}
@@ -0,0 +1,50 @@
+package store
+
+import "fmt"
+
+// Engine selects the data structure a Store keeps its keys in.
+type Engine string
+
+const (
+	// EngineMap keeps keys in a hash map, so reads and writes are O(1) but every listing sorts the keys after its start.
+	EngineMap Engine = "map"
+	// EngineSkipList keeps keys in a skip list, so reads, writes and seeking to the start of a range are O(log n).
+	EngineSkipList Engine = "skiplist"
+)
+
+// ParseEngine returns the engine named by s.
+func ParseEngine(s string) (Engine, error) {
+	switch e := Engine(s); e {
+	case EngineMap, EngineSkipList:
+		return e, nil
+	default:
+		return "", fmt.Errorf("unknown engine %q, expected %q or %q", s, EngineMap, EngineSkipList)
+	}
+}
+
+// engine holds a store's key values. The store's lock guards every call, so reads can run concurrently under the
+// read lock but never alongside a write.
+type engine interface {
+	get(key string) (item, bool)
+	// put adds the key or replaces its item.
+	put(key string, it item)
+	delete(key string)
+	len() int
+	// ascend calls fn with each key at or after start in key order, stopping early if fn returns false.
+	// fn must not modify the engine.
+	ascend(start string, fn func(key string, it item) bool)
+}
+
+// item is what an engine keeps for each key, the store tracks expiry deadlines itself for the reaper.
+type item struct {
+	value   interface{}
+	version uint64
+	flags   uint32
+}
+
+func newEngine(e Engine) engine {
+	if e == EngineSkipList {
+		return newSkipList()
+	}
+	return newMapEngine()
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+)
+
+var engines = []Engine{EngineMap, EngineSkipList}
+
+func TestParseEngine(t *testing.T) {
+	for _, e := range engines {
+		parsed, err := ParseEngine(string(e))
+		require.NoError(t, err)
+		assert.Equal(t, e, parsed)
+	}
+
+	_, err := ParseEngine("btree")
+	assert.EqualError(t, err, `unknown engine "btree", expected "map" or "skiplist"`)
+}
+
+// TestEngines applies the same random operations to each engine and a plain map, checking they always agree.
+func TestEngines(t *testing.T) {
+	for _, e := range engines {
+		t.Run(string(e), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			data := newEngine(e)
+			expected := make(map[string]item)
+
+			for i := 0; i < 5000; i++ {
+				key := fmt.Sprintf("key%03d", rng.Intn(500))
+				switch rng.Intn(3) {
+				case 0, 1:
+					it := item{value: i, version: uint64(i)}
+					data.put(key, it)
+					expected[key] = it
+				case 2:
+					data.delete(key)
+					delete(expected, key)
+				}
+
+				got, ok := data.get(key)
+				want, exists := expected[key]
+				require.Equal(t, exists, ok)
+				require.Equal(t, want, got)
+			}
+
+			require.Equal(t, len(expected), data.len())
+
+			keys := make([]string, 0, len(expected))
+			for key := range expected {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			var ascended []string
+			data.ascend("", func(key string, it item) bool {
+				assert.Equal(t, expected[key], it)
+				ascended = append(ascended, key)
+				return true
+			})
+			assert.Equal(t, keys, ascended)
+
+			// seeking starts at the first key at or after start and stops when asked to
+			start := keys[len(keys)/2]
+			ascended = nil
+			data.ascend(start[:len(start)-1], func(key string, _ item) bool {
+				ascended = append(ascended, key)
+				return len(ascended) < 3
+			})
+			i := sort.SearchStrings(keys, start[:len(start)-1])
+			assert.Equal(t, keys[i:i+3], ascended)
+		})
+	}
+}
+
+func TestStore_ListEngines(t *testing.T) {
+	for _, e := range engines {
+		t.Run(string(e), func(t *testing.T) {
+			s := NewWithEngine(e)
+			for _, key := range []string{"user:2", "a", "user:1", "user:3", "z"} {
+				require.NoError(t, s.Put(key, "value"))
+			}
+			require.NoError(t, s.Delete("user:2"))
+
+			kvs, next := s.List(model.ListOptions{Prefix: "user:", Limit: 1})
+			require.Len(t, kvs, 1)
+			assert.Equal(t, "user:1", kvs[0].Key)
+			assert.Equal(t, "user:3", next)
+
+			assert.Equal(t, []string{"a", "user:1", "user:3", "z"}, s.Keys())
+		})
+	}
+}
+
+// benchmarkStore runs the benchmark against a store with n keys for each engine.
+func benchmarkStore(b *testing.B, fn func(b *testing.B, s *Store, keys []string)) {
+	for _, n := range []int{1000, 100000} {
+		keys := make([]string, n)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key%08d", i)
+		}
+		shuffled := append([]string(nil), keys...)
+		rand.New(rand.NewSource(1)).Shuffle(n, func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+		for _, e := range engines {
+			b.Run(fmt.Sprintf("%s/%d", e, n), func(b *testing.B) {
+				s := NewWithEngine(e)
+				for _, key := range keys {
+					if err := s.Put(key, "value"); err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				fn(b, s, shuffled)
+			})
+		}
+	}
+}
+
+func BenchmarkStore_Get(b *testing.B) {
+	benchmarkStore(b, func(b *testing.B, s *Store, keys []string) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.GetKeyValue(keys[i%len(keys)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkStore_GetParallel(b *testing.B) {
+	benchmarkStore(b, func(b *testing.B, s *Store, keys []string) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				if _, err := s.GetKeyValue(keys[i%len(keys)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+// BenchmarkStore_Update overwrites existing keys, which doesn't change the order.
+func BenchmarkStore_Update(b *testing.B) {
+	benchmarkStore(b, func(b *testing.B, s *Store, keys []string) {
+		for i := 0; i < b.N; i++ {
+			if err := s.Put(keys[i%len(keys)], "updated"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkStore_DeleteInsert removes a key and adds it back, changing the order each time.
+func BenchmarkStore_DeleteInsert(b *testing.B) {
+	benchmarkStore(b, func(b *testing.B, s *Store, keys []string) {
+		for i := 0; i < b.N; i++ {
+			key := keys[i%len(keys)]
+			if err := s.Delete(key); err != nil {
+				b.Fatal(err)
+			}
+			if err := s.Put(key, "value"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkStore_List pages through 100 keys from a random start.
+func BenchmarkStore_List(b *testing.B) {
+	benchmarkStore(b, func(b *testing.B, s *Store, keys []string) {
+		for i := 0; i < b.N; i++ {
+			kvs, _ := s.List(model.ListOptions{Start: keys[i%len(keys)], Limit: 100})
+			if len(kvs) == 0 {
+				b.Fatal("nothing listed")
+			}
+		}
+	})
+}
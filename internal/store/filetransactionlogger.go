@@ -2,96 +2,797 @@ package store
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
 )
 
+// FileConfig configures where a FileTransactionLogger keeps its segments and when it rotates them.
+type FileConfig struct {
+	// Dir holds the log's segment files, manifest and snapshots.
+	Dir string
+	// LegacyFilename is a log written as a single file by earlier versions.
+	// If Dir doesn't exist yet the file is migrated into it as the first segment.
+	LegacyFilename string
+	// MaxSegmentSize rotates to a new segment once the active one reaches this many bytes, zero disables it.
+	MaxSegmentSize int64
+	// MaxSegmentAge rotates to a new segment once the active one is this old, zero disables it.
+	MaxSegmentAge time.Duration
+	// Durability controls when written events are flushed to stable storage, empty means DurabilityNone.
+	Durability Durability
+	// SyncInterval is how often events are flushed under DurabilityInterval.
+	SyncInterval time.Duration
+}
+
+// maxBatchSize caps how many queued events are group committed with a single write.
+const maxBatchSize = 128
+
 type FileTransactionLogger struct {
-	events       chan<- Event
-	errors       <-chan error
+	// lastSequence is read by the compaction goroutine so must be accessed atomically once Run is called.
 	lastSequence uint64
-	file         *os.File
+
+	queue       *eventQueue
+	errors      <-chan error
+	compactions chan compaction
+	config      FileConfig
+	// done is closed once the writer goroutine has exited, closeErr is set before it is.
+	done     chan struct{}
+	closeErr error
+
+	// manifest, active, activeSize, dirty and failed are owned by the writer goroutine once Run is called.
+	// A manifest is never changed once set, the writer replaces it while holding manifestMu so it can be read by
+	// ReadEventsAfter.
+	manifest   *manifest
+	manifestMu sync.Mutex
+	active     *os.File
+	activeSize int64
+	// dirty is set when the active segment has writes that haven't been flushed.
+	dirty bool
+	// failed is set once a write or flush fails, every event after that is failed with it.
+	failed error
+
+	// snapshotSequence is the sequence covered by the snapshot loaded at startup.
+	snapshotSequence uint64
+
+	// onCommit is called by the writer goroutine with every event once it has been written.
+	onCommit func(Event)
 }
 
-func NewFileTransactionLogger(filename string) (*FileTransactionLogger, error) {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0755)
+// compaction asks the writer goroutine to drop segments covered by a snapshot up to and including sequence.
+type compaction struct {
+	sequence uint64
+	done     chan error
+}
+
+// NewFileTransactionLogger opens the segmented transaction log in config.Dir, creating it if needed.
+func NewFileTransactionLogger(config FileConfig) (*FileTransactionLogger, error) {
+	if config.Durability == "" {
+		config.Durability = DurabilityNone
+	}
+	if _, err := ParseDurability(string(config.Durability)); err != nil {
+		return nil, err
+	}
+	if config.Durability == DurabilityInterval && config.SyncInterval <= 0 {
+		return nil, errors.New("sync interval must be positive for interval durability")
+	}
+
+	if _, err := os.Stat(config.Dir); errors.Is(err, os.ErrNotExist) && config.LegacyFilename != "" {
+		if _, err := os.Stat(config.LegacyFilename); err == nil {
+			if err := migrateSingleFileLog(config.LegacyFilename, config.Dir); err != nil {
+				return nil, fmt.Errorf("failed to migrate transaction log %s: %w", config.LegacyFilename, err)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create transaction log directory: %w", err)
+	}
+
+	m, err := readManifest(config.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		m = &manifest{
+			Segments: []segmentInfo{{ID: 1, FirstSequence: 1, Created: time.Now().UTC()}},
+		}
+		err = writeManifest(config.Dir, m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot load transaction log manifest: %w", err)
+	}
+
+	if err := removeOrphanSegments(config.Dir, m); err != nil {
+		return nil, fmt.Errorf("cannot clean up transaction log segments: %w", err)
+	}
+
+	active, err := openSegment(segmentFilename(config.Dir, m.active().ID))
 	if err != nil {
-		return nil, fmt.Errorf("cannot open transaction log filr: %w", err)
+		return nil, fmt.Errorf("cannot open transaction log segment: %w", err)
 	}
 
 	return &FileTransactionLogger{
-		file: file,
+		compactions: make(chan compaction),
+		config:      config,
+		manifest:    m,
+		active:      active,
 	}, nil
 }
 
 func (l *FileTransactionLogger) Run() {
-	// Can't just assign the chan to the struct as the channels in the struct are one way.
-	// i.e. can't do f.events = make(chan<- Event, 16) because we can't then recieve on this chan below.
-	events := make(chan Event, 16)
-	l.events = events
+	l.queue = newEventQueue(16)
+	l.done = make(chan struct{})
 	errors := make(chan error, 1)
 	l.errors = errors
 
 	go func() {
-		defer close(events)
+		defer close(l.done)
 		defer close(errors)
-		defer l.file.Close()
 
-		for e := range events {
-			l.lastSequence++
-			_, err := fmt.Fprintf(l.file, "%d\t%d\t%s\t%s\n",
-				l.lastSequence, e.EventType, e.Key, e.Value)
+		events := l.queue.events
 
-			if err != nil {
-				errors <- err
-				return
+		info, err := l.active.Stat()
+		if err != nil {
+			l.fail(err, errors)
+		} else {
+			l.activeSize = info.Size()
+		}
+
+		var syncTick <-chan time.Time
+		if l.config.Durability == DurabilityInterval {
+			ticker := time.NewTicker(l.config.SyncInterval)
+			defer ticker.Stop()
+			syncTick = ticker.C
+		}
+
+		for {
+			select {
+			case p, ok := <-events:
+				if !ok {
+					l.closeErr = l.shutdown()
+					return
+				}
+				batch := []pendingEvent{p}
+
+				// Group commit whatever else queued up while the last batch was being written.
+			fill:
+				for len(batch) < maxBatchSize {
+					select {
+					case p, ok := <-events:
+						if !ok {
+							break fill
+						}
+						batch = append(batch, p)
+					default:
+						break fill
+					}
+				}
+
+				if l.failed == nil {
+					if err := l.commit(batch); err != nil {
+						l.fail(err, errors)
+					}
+				}
+
+				for _, p := range batch {
+					p.done <- l.failed
+				}
+			case <-syncTick:
+				if l.failed == nil {
+					if err := l.sync(); err != nil {
+						l.fail(err, errors)
+					}
+				}
+			case c := <-l.compactions:
+				if l.failed != nil {
+					c.done <- l.failed
+					continue
+				}
+				c.done <- l.dropSegments(c.sequence)
 			}
 		}
 	}()
 }
 
+// ReadEvents streams the events in every segment in sequence order.
+// A record torn by a crash part way through writing is truncated from the end of the active segment rather than
+// failing the read.
 func (l *FileTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
-	scanner := bufio.NewScanner(l.file)
 	outEvent := make(chan Event)
 	outError := make(chan error, 1)
 
 	go func() {
-		var e Event // Use value and not pointer so we dont't hace to recreate in for loop.
-
 		defer close(outEvent)
 		defer close(outError)
 
-		for scanner.Scan() {
-			line := scanner.Text()
+		for i, seg := range l.manifest.Segments {
+			var err error
+			if i == len(l.manifest.Segments)-1 {
+				err = l.readSegment(l.active, true, outEvent)
+			} else {
+				err = l.readSealedSegment(segmentFilename(l.config.Dir, seg.ID), outEvent)
+			}
 
-			if _, err := fmt.Sscanf(line, "%d\t%d\t%s\t%s", &e.Sequence, &e.EventType, &e.Key, &e.Value); err != nil {
-				outError <- fmt.Errorf("input parse error: %w", err)
+			if err != nil {
+				outError <- fmt.Errorf("segment %d: %w", seg.ID, err)
 				return
 			}
+		}
+	}()
 
-			if l.lastSequence >= e.Sequence {
-				outError <- fmt.Errorf("transaction out of sequence order")
+	return outEvent, outError
+}
+
+// ReadEventsAfter streams the events logged after sequence. It reads the segment files alongside the writer so can
+// be called while the logger is running, and ends at the last event written when it reached the active segment.
+// ErrCompacted is returned if the log no longer goes back to the event after sequence.
+func (l *FileTransactionLogger) ReadEventsAfter(ctx context.Context, sequence uint64) (<-chan Event, <-chan error) {
+	outEvent := make(chan Event)
+	outError := make(chan error, 1)
+
+	l.manifestMu.Lock()
+	segments := l.manifest.Segments
+	l.manifestMu.Unlock()
+
+	go func() {
+		defer close(outEvent)
+		defer close(outError)
+
+		if segments[0].FirstSequence > sequence+1 {
+			outError <- ErrCompacted
+			return
+		}
+
+		for i, seg := range segments {
+			active := i == len(segments)-1
+			if !active && seg.LastSequence <= sequence {
+				continue
+			}
+
+			err := readSegmentAfter(ctx, segmentFilename(l.config.Dir, seg.ID), sequence, active, outEvent)
+			if errors.Is(err, os.ErrNotExist) {
+				// dropped by a compaction since the manifest was read
+				err = ErrCompacted
+			}
+			if err != nil {
+				outError <- fmt.Errorf("segment %d: %w", seg.ID, err)
 				return
 			}
-			l.lastSequence = e.Sequence
-			outEvent <- e
-		}
-		if err := scanner.Err(); err != nil {
-			outError <- fmt.Errorf("transaction log read failure: %w", err)
 		}
 	}()
 
 	return outEvent, outError
 }
 
-func (l *FileTransactionLogger) WritePut(key string, value string) {
-	l.events <- Event{EventType: EventPut, Key: key, Value: value}
+// WritePut logs a put event, returning once it is durable under the configured policy.
+func (l *FileTransactionLogger) WritePut(key string, value string, version uint64, expires time.Time) error {
+	return l.write(Event{EventType: EventPut, Key: key, Value: value, Version: version, Expires: expires})
+}
+
+// WriteDelete logs a delete event, returning once it is durable under the configured policy.
+func (l *FileTransactionLogger) WriteDelete(key string) error {
+	return l.write(Event{EventType: EventDelete, Key: key})
+}
+
+// WriteExpire logs an expire event, returning once it is durable under the configured policy.
+func (l *FileTransactionLogger) WriteExpire(key string, deadline time.Time) error {
+	return l.write(Event{EventType: EventExpire, Key: key, Expires: deadline})
 }
 
-func (l *FileTransactionLogger) WriteDelete(key string) {
-	l.events <- Event{EventType: EventDelete, Key: key}
+// WriteBatch logs the put and delete events as a single batch record, returning once it is durable under the
+// configured policy. The batch is replayed all together, or not at all if the record was torn.
+func (l *FileTransactionLogger) WriteBatch(ops []Event) error {
+	return l.write(Event{EventType: EventBatch, Ops: ops})
 }
 
+// write queues the event, refusing one whose record would be too large to read back.
+func (l *FileTransactionLogger) write(e Event) error {
+	if size := maxPayloadSize(e); size > maxRecordSize {
+		return fmt.Errorf("%w: a record of up to %d bytes exceeds the maximum of %d", model.ErrInvalidArgument, size, maxRecordSize)
+	}
+
+	return l.queue.write(e)
+}
+
+// OnCommit registers fn to be called with every event, sequence included, once it has been written under the
+// configured durability policy. It must be called before Run. fn is called in sequence order on the writer goroutine,
+// so it must not block.
+func (l *FileTransactionLogger) OnCommit(fn func(Event)) {
+	l.onCommit = fn
+}
+
+// LastSequence returns the sequence of the last event read back or written.
+func (l *FileTransactionLogger) LastSequence() uint64 {
+	return atomic.LoadUint64(&l.lastSequence)
+}
+
+// QueueDepth returns how many writes are waiting to be committed.
+func (l *FileTransactionLogger) QueueDepth() int {
+	return l.queue.depth()
+}
+
+// Err reports the first write failure, after which every write fails with ErrLoggerUnhealthy.
 func (l *FileTransactionLogger) Err() <-chan error {
 	return l.errors
 }
+
+// Close stops accepting events, writes and flushes everything already queued and closes the active segment.
+func (l *FileTransactionLogger) Close() error {
+	if l.queue == nil {
+		// never run
+		return l.active.Close()
+	}
+
+	l.queue.close()
+	<-l.done
+
+	return l.closeErr
+}
+
+// LoadSnapshot will restore the newest valid snapshot into the store, so ReadEvents only replays the log after it.
+// It must be called before ReadEvents.
+func (l *FileTransactionLogger) LoadSnapshot(s *Store) error {
+	files, err := snapshotFiles(l.snapshotPrefix())
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		snap, err := readSnapshot(file)
+		if err != nil {
+			log.Printf("skipping invalid snapshot %s: %v", file, err)
+			continue
+		}
+
+		s.Restore(snap)
+		l.snapshotSequence = snap.Sequence
+		l.lastSequence = snap.Sequence
+		return nil
+	}
+
+	return nil
+}
+
+// RunCompaction will snapshot the store every interval and drop the segments the snapshot covers.
+// It must be called after Run.
+func (l *FileTransactionLogger) RunCompaction(ctx context.Context, interval time.Duration, s *Store) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := l.snapshotSequence
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.done:
+				return
+			case <-ticker.C:
+				// a write logged but not yet applied can't be in the snapshot, so it mustn't be compacted away
+				sequence := s.Applied(func() uint64 { return atomic.LoadUint64(&l.lastSequence) })
+				if sequence == last {
+					continue
+				}
+
+				if err := l.Compact(ctx, s, sequence); errors.Is(err, ErrLoggerClosed) {
+					return
+				} else if err != nil {
+					log.Printf("transaction log compaction failed: %v", err)
+					continue
+				}
+				last = sequence
+			}
+		}
+	}()
+}
+
+// Compact will snapshot the store as covering the log up to sequence, then drop the segments it covers.
+// Every event up to sequence must already have been applied to the store.
+func (l *FileTransactionLogger) Compact(ctx context.Context, s *Store, sequence uint64) error {
+	snapName := snapshotFilename(l.snapshotPrefix(), sequence)
+	if err := writeSnapshot(snapName, s.Snapshot(sequence)); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	c := compaction{sequence: sequence, done: make(chan error, 1)}
+	select {
+	case l.compactions <- c:
+	case <-l.done:
+		return ErrLoggerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := <-c.done; err != nil {
+		return fmt.Errorf("failed to drop covered segments: %w", err)
+	}
+
+	// older snapshots can't be used now that the log no longer goes back to them
+	files, err := snapshotFiles(l.snapshotPrefix())
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file != snapName {
+			os.Remove(file)
+		}
+	}
+
+	return nil
+}
+
+// fail marks the logger as unhealthy and reports the cause, it runs on the writer goroutine.
+func (l *FileTransactionLogger) fail(err error, errors chan<- error) {
+	l.failed = fmt.Errorf("%w: %v", ErrLoggerUnhealthy, err)
+	errors <- err
+}
+
+// shutdown flushes and closes the active segment once the queue has been drained, it runs on the writer goroutine.
+func (l *FileTransactionLogger) shutdown() error {
+	var err error
+	if l.failed == nil {
+		err = l.sync()
+	}
+
+	if closeErr := l.active.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// commit writes the batch of events to the active segment in a single write, flushing it if every write must be
+// durable. It runs on the writer goroutine.
+func (l *FileTransactionLogger) commit(batch []pendingEvent) error {
+	if l.shouldRotate() {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate transaction log segment: %w", err)
+		}
+	}
+
+	var buf []byte
+	sequence := l.lastSequence
+	for i := range batch {
+		sequence++
+		batch[i].Sequence = sequence
+		buf = append(buf, encodeRecord(batch[i].Event)...)
+	}
+
+	// A single write per batch so a crash can only ever tear the tail of the log.
+	if _, err := l.active.Write(buf); err != nil {
+		return err
+	}
+	l.activeSize += int64(len(buf))
+	l.dirty = true
+
+	if l.config.Durability == DurabilityEveryWrite {
+		if err := l.sync(); err != nil {
+			return err
+		}
+	}
+
+	atomic.StoreUint64(&l.lastSequence, sequence)
+
+	if l.onCommit != nil {
+		for _, p := range batch {
+			l.onCommit(p.Event)
+		}
+	}
+
+	return nil
+}
+
+// sync flushes the active segment if it has unflushed writes, it runs on the writer goroutine.
+func (l *FileTransactionLogger) sync() error {
+	if !l.dirty {
+		return nil
+	}
+
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+
+	return nil
+}
+
+// shouldRotate reports whether the active segment has outgrown the configured size or age.
+// Empty segments are never rotated. It runs on the writer goroutine.
+func (l *FileTransactionLogger) shouldRotate() bool {
+	if l.activeSize <= int64(logHeaderSize) {
+		return false
+	}
+
+	if l.config.MaxSegmentSize > 0 && l.activeSize >= l.config.MaxSegmentSize {
+		return true
+	}
+
+	return l.config.MaxSegmentAge > 0 && time.Since(l.manifest.active().Created) >= l.config.MaxSegmentAge
+}
+
+// rotate seals the active segment and starts appending to a new one, it runs on the writer goroutine.
+func (l *FileTransactionLogger) rotate() error {
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+
+	current := l.manifest.active()
+	next := segmentInfo{
+		ID:            current.ID + 1,
+		FirstSequence: l.lastSequence + 1,
+		Created:       time.Now().UTC(),
+	}
+
+	// The new segment exists before the manifest mentions it, a crash in between leaves an orphan that is removed
+	// on startup while the old segment carries on as the active one.
+	file, err := createSegment(segmentFilename(l.config.Dir, next.ID))
+	if err != nil {
+		return err
+	}
+
+	m := &manifest{Segments: append([]segmentInfo{}, l.manifest.Segments...)}
+	m.active().LastSequence = l.lastSequence
+	m.Segments = append(m.Segments, next)
+
+	if err := writeManifest(l.config.Dir, m); err != nil {
+		file.Close()
+		return err
+	}
+
+	l.active.Close()
+	l.setManifest(m)
+	l.active = file
+	l.activeSize = int64(logHeaderSize)
+
+	return nil
+}
+
+// dropSegments deletes the sealed segments whose records are all at or before sequence, rotating first if the
+// active segment is covered too. It runs on the writer goroutine.
+func (l *FileTransactionLogger) dropSegments(sequence uint64) error {
+	if l.lastSequence <= sequence && l.activeSize > int64(logHeaderSize) {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	m := &manifest{}
+	var dropped []segmentInfo
+	for i, seg := range l.manifest.Segments {
+		if i < len(l.manifest.Segments)-1 && seg.LastSequence <= sequence {
+			dropped = append(dropped, seg)
+			continue
+		}
+		m.Segments = append(m.Segments, seg)
+	}
+
+	if len(dropped) == 0 {
+		return nil
+	}
+
+	// The manifest stops referring to the segments before they are deleted, so a crash in between only leaves
+	// orphans behind.
+	if err := writeManifest(l.config.Dir, m); err != nil {
+		return err
+	}
+	l.setManifest(m)
+
+	for _, seg := range dropped {
+		if err := os.Remove(segmentFilename(l.config.Dir, seg.ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readSealedSegment streams the events in a segment that is no longer being written to.
+func (l *FileTransactionLogger) readSealedSegment(filename string, out chan<- Event) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ok, err := readLogHeader(file)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("missing segment header")
+	}
+
+	return l.readSegment(file, false, out)
+}
+
+// readSegment streams the events in the segment, which must be positioned after its header.
+// If the segment is the active one a torn record at its end is truncated, anywhere else it is an error.
+func (l *FileTransactionLogger) readSegment(file *os.File, active bool, out chan<- Event) error {
+	offset := int64(logHeaderSize)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+
+	for {
+		e, n, err := readRecord(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if errors.Is(err, errTornRecord) || errors.Is(err, errCorruptRecord) {
+			torn, tornErr := isTornTail(file, offset, int64(n))
+			if tornErr != nil {
+				return tornErr
+			}
+			if !torn || !active {
+				return fmt.Errorf("transaction log corrupt at offset %d: %w", offset, err)
+			}
+
+			if err := file.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to truncate torn transaction log: %w", err)
+			}
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		offset += int64(n)
+
+		if e.Sequence <= l.snapshotSequence {
+			// already covered by the snapshot, left behind by a compaction that didn't get to run
+			continue
+		}
+
+		if e.Sequence != l.lastSequence+1 {
+			if l.lastSequence >= e.Sequence {
+				return fmt.Errorf("transaction out of sequence order")
+			}
+			return fmt.Errorf("transaction log is missing events %d to %d", l.lastSequence+1, e.Sequence-1)
+		}
+		l.lastSequence = e.Sequence
+		out <- e
+	}
+}
+
+// readSegmentAfter streams the events after sequence in the segment without changing it. A torn record at the end of
+// the active segment is one still being written, so ends the read.
+func readSegmentAfter(ctx context.Context, filename string, sequence uint64, active bool, out chan<- Event) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	ok, err := readLogHeader(reader)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("missing segment header")
+	}
+
+	for {
+		e, _, err := readRecord(reader)
+		if errors.Is(err, io.EOF) || (active && errors.Is(err, errTornRecord)) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if e.Sequence <= sequence {
+			continue
+		}
+
+		select {
+		case out <- e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setManifest replaces the manifest, it runs on the writer goroutine.
+func (l *FileTransactionLogger) setManifest(m *manifest) {
+	l.manifestMu.Lock()
+	defer l.manifestMu.Unlock()
+
+	l.manifest = m
+}
+
+// snapshotPrefix returns the path snapshot sequence numbers are appended to.
+func (l *FileTransactionLogger) snapshotPrefix() string {
+	return filepath.Join(l.config.Dir, snapshotPrefix)
+}
+
+// isTornTail reports whether a bad record of length n at offset is the result of a torn write.
+// That is the case when it runs to the end of the file or is only followed by zeroed space.
+func isTornTail(file *os.File, offset, n int64) (bool, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if offset+n >= info.Size() {
+		return true, nil
+	}
+
+	rest := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(rest, offset); err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+
+	return len(bytes.Trim(rest, "\x00")) == 0, nil
+}
+
+// openLogFile opens the single file binary log at filename, writing the header to a new log and migrating a
+// text log.
+func openLogFile(filename string) (*os.File, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if info.Size() < int64(logHeaderSize) {
+		// a new log, or one whose header was torn while being created
+		existing := make([]byte, info.Size())
+		if _, err := file.ReadAt(existing, 0); err != nil && !errors.Is(err, io.EOF) {
+			file.Close()
+			return nil, err
+		}
+
+		if bytes.HasPrefix(logHeader(), existing) {
+			if err := writeLogHeader(file); err != nil {
+				file.Close()
+				return nil, err
+			}
+			return file, nil
+		}
+	}
+
+	ok, err := readLogHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if ok {
+		return file, nil
+	}
+
+	file.Close()
+	if err := migrateTextLog(filename); err != nil {
+		return nil, fmt.Errorf("failed to migrate text transaction log: %w", err)
+	}
+
+	return os.OpenFile(filename, os.O_APPEND|os.O_RDWR, 0755)
+}
+
+// writeLogHeader replaces the contents of the file with the binary log header.
+func writeLogHeader(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := file.Write(logHeader()); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
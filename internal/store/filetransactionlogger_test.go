@@ -0,0 +1,604 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+)
+
+func TestFileTransactionLogger_ReadEvents(t *testing.T) {
+	events := []Event{
+		{Sequence: 1, EventType: EventPut, Key: "key1", Value: "value with spaces\tand\ttabs\n", Version: 1},
+		{Sequence: 2, EventType: EventPut, Key: "key2", Value: "", Version: 2, Expires: time.Unix(0, 1700000000000000000).UTC()},
+		{Sequence: 3, EventType: EventDelete, Key: "key1"},
+	}
+
+	var valid []byte
+	valid = append(valid, logHeader()...)
+	for _, e := range events {
+		valid = append(valid, encodeRecord(e)...)
+	}
+
+	next := encodeRecord(Event{Sequence: 4, EventType: EventPut, Key: "key3", Value: "value3", Version: 3})
+	corrupt := append([]byte{}, next...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	batch := Event{Sequence: 4, EventType: EventBatch, Ops: []Event{
+		{EventType: EventPut, Key: "key3", Value: "value3", Version: 3, Expires: time.Unix(0, 1700000000000000000).UTC()},
+		{EventType: EventDelete, Key: "key2"},
+	}}
+	batchRecord := encodeRecord(batch)
+
+	tests := map[string]struct {
+		sealed         []byte
+		active         []byte
+		expectedEvents []Event
+		expectedSize   int
+		errContains    string
+	}{
+		"clean log": {
+			active:         valid,
+			expectedEvents: events,
+			expectedSize:   len(valid),
+		},
+		"torn record header": {
+			active:         append(append([]byte{}, valid...), next[:5]...),
+			expectedEvents: events,
+			expectedSize:   len(valid),
+		},
+		"torn record payload": {
+			active:         append(append([]byte{}, valid...), next[:len(next)-2]...),
+			expectedEvents: events,
+			expectedSize:   len(valid),
+		},
+		"corrupt last record": {
+			active:         append(append([]byte{}, valid...), corrupt...),
+			expectedEvents: events,
+			expectedSize:   len(valid),
+		},
+		"zeroed tail": {
+			active:         append(append([]byte{}, valid...), make([]byte, 64)...),
+			expectedEvents: events,
+			expectedSize:   len(valid),
+		},
+		"batch": {
+			active:         append(append([]byte{}, valid...), batchRecord...),
+			expectedEvents: append(append([]Event{}, events...), batch),
+			expectedSize:   len(valid) + len(batchRecord),
+		},
+		"torn batch": {
+			active:         append(append([]byte{}, valid...), batchRecord[:len(batchRecord)-10]...),
+			expectedEvents: events,
+			expectedSize:   len(valid),
+		},
+		"corrupt record followed by data": {
+			active:         append(append(append([]byte{}, valid...), corrupt...), next...),
+			expectedEvents: events,
+			errContains:    "corrupt",
+		},
+		"across segments": {
+			sealed:         valid,
+			active:         append(logHeader(), next...),
+			expectedEvents: append(append([]Event{}, events...), Event{Sequence: 4, EventType: EventPut, Key: "key3", Value: "value3", Version: 3}),
+			expectedSize:   logHeaderSize + len(next),
+		},
+		"torn sealed segment": {
+			sealed:         append(append([]byte{}, valid...), next[:5]...),
+			active:         logHeader(),
+			expectedEvents: events,
+			errContains:    "corrupt",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			m := &manifest{}
+			if test.sealed != nil {
+				m.Segments = append(m.Segments, segmentInfo{ID: 1, FirstSequence: 1, LastSequence: 3})
+				require.NoError(t, os.WriteFile(segmentFilename(dir, 1), test.sealed, 0644))
+			}
+			m.Segments = append(m.Segments, segmentInfo{ID: 2})
+			require.NoError(t, os.WriteFile(segmentFilename(dir, 2), test.active, 0644))
+			require.NoError(t, writeManifest(dir, m))
+
+			logger, err := NewFileTransactionLogger(FileConfig{Dir: dir})
+			require.NoError(t, err)
+			defer logger.Close()
+
+			got, err := readAll(logger)
+			assert.Equal(t, test.expectedEvents, got)
+			if test.errContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.errContains)
+				return
+			}
+			require.NoError(t, err)
+
+			info, err := os.Stat(segmentFilename(dir, 2))
+			require.NoError(t, err)
+			assert.Equal(t, int64(test.expectedSize), info.Size())
+		})
+	}
+}
+
+func TestNewFileTransactionLogger_MigratesTextLog(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "transaction.log")
+
+	text := "1\t2\tkey1\tvalue1\n" +
+		"2\t2\tkey2\tvalue2\t0\t2\n" +
+		"3\t1\tkey1\t\t0\t0\n" +
+		"4\t2\tke"
+	require.NoError(t, os.WriteFile(filename, []byte(text), 0644))
+
+	logger, err := NewFileTransactionLogger(FileConfig{Dir: filepath.Join(dir, "log"), LegacyFilename: filename})
+	require.NoError(t, err)
+	defer logger.Close()
+
+	got, err := readAll(logger)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{
+		{Sequence: 1, EventType: EventPut, Key: "key1", Value: "value1"},
+		{Sequence: 2, EventType: EventPut, Key: "key2", Value: "value2", Version: 2},
+		{Sequence: 3, EventType: EventDelete, Key: "key1"},
+	}, got)
+
+	backup, err := os.ReadFile(filename + textLogBackupSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(backup))
+
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err), "legacy log should be removed once migrated")
+}
+
+func TestFileTransactionLogger_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	config := FileConfig{Dir: dir, MaxSegmentSize: 64}
+
+	logger, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	logger.Run()
+
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, logger.WritePut(fmt.Sprintf("key%d", i), "value", uint64(i), time.Time{}))
+	}
+
+	m, err := readManifest(dir)
+	require.NoError(t, err)
+	require.Greater(t, len(m.Segments), 1)
+
+	for i, seg := range m.Segments[:len(m.Segments)-1] {
+		assert.Equal(t, seg.LastSequence+1, m.Segments[i+1].FirstSequence)
+	}
+
+	restarted, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	defer restarted.Close()
+
+	got, err := readAll(restarted)
+	require.NoError(t, err)
+	require.Len(t, got, 10)
+	for i, e := range got {
+		assert.Equal(t, uint64(i+1), e.Sequence)
+	}
+}
+
+func TestFileTransactionLogger_GroupCommit(t *testing.T) {
+	tests := map[string]struct {
+		durability   Durability
+		syncInterval time.Duration
+	}{
+		"none": {
+			durability: DurabilityNone,
+		},
+		"interval": {
+			durability:   DurabilityInterval,
+			syncInterval: time.Millisecond,
+		},
+		"every write": {
+			durability: DurabilityEveryWrite,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := FileConfig{Dir: t.TempDir(), Durability: test.durability, SyncInterval: test.syncInterval}
+
+			logger, err := NewFileTransactionLogger(config)
+			require.NoError(t, err)
+			logger.Run()
+
+			const writers = 50
+			var wg sync.WaitGroup
+			errs := make(chan error, writers)
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- logger.WritePut(fmt.Sprintf("key%d", i), "value", uint64(i+1), time.Time{})
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				require.NoError(t, err)
+			}
+
+			// every acknowledged write is already on disk
+			restarted, err := NewFileTransactionLogger(config)
+			require.NoError(t, err)
+			defer restarted.Close()
+
+			got, err := readAll(restarted)
+			require.NoError(t, err)
+			assert.Len(t, got, writers)
+		})
+	}
+}
+
+func TestFileTransactionLogger_WriteBatch(t *testing.T) {
+	config := FileConfig{Dir: t.TempDir()}
+
+	logger, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	logger.Run()
+
+	ops := []Event{
+		{EventType: EventPut, Key: "key1", Value: "value1", Version: 2, Flags: 16},
+		{EventType: EventDelete, Key: "key2"},
+	}
+	require.NoError(t, logger.WritePut("key2", "value2", 1, time.Time{}))
+	require.NoError(t, logger.WriteBatch(ops))
+	require.NoError(t, logger.Close())
+
+	restarted, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	defer restarted.Close()
+
+	// the batch takes a single sequence number
+	got, err := readAll(restarted)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, Event{Sequence: 2, EventType: EventBatch, Ops: ops}, got[1])
+}
+
+func TestFileTransactionLogger_MaxRecordSize(t *testing.T) {
+	config := FileConfig{Dir: t.TempDir()}
+
+	logger, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	logger.Run()
+
+	tooLarge := strings.Repeat("x", maxRecordSize)
+	tests := map[string]func() error{
+		"put": func() error {
+			return logger.WritePut("key", tooLarge, 1, time.Time{})
+		},
+		"batch": func() error {
+			half := tooLarge[:maxRecordSize/2]
+			return logger.WriteBatch([]Event{
+				{EventType: EventPut, Key: "key1", Value: half, Version: 1},
+				{EventType: EventPut, Key: "key2", Value: half, Version: 2},
+			})
+		},
+	}
+	for name, write := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.ErrorIs(t, write(), model.ErrInvalidArgument)
+		})
+	}
+
+	// the refused writes leave the logger healthy and aren't logged
+	require.NoError(t, logger.WritePut("key", "value", 3, time.Time{}))
+	require.NoError(t, logger.Close())
+
+	restarted, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	defer restarted.Close()
+
+	got, err := readAll(restarted)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{{Sequence: 1, EventType: EventPut, Key: "key", Value: "value", Version: 3}}, got)
+}
+
+func TestFileTransactionLogger_OnCommit(t *testing.T) {
+	config := FileConfig{Dir: t.TempDir()}
+
+	logger, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	logger.Run()
+	require.NoError(t, logger.WritePut("key1", "value1", 1, time.Time{}))
+	require.NoError(t, logger.Close())
+
+	restarted, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	_, err = readAll(restarted)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), restarted.LastSequence())
+
+	// the writer has called back by the time each write returns
+	var committed []Event
+	restarted.OnCommit(func(e Event) {
+		committed = append(committed, e)
+	})
+	restarted.Run()
+	defer restarted.Close()
+
+	ops := []Event{{EventType: EventDelete, Key: "key1"}}
+	require.NoError(t, restarted.WritePut("key2", "value2", 2, time.Time{}))
+	require.NoError(t, restarted.WriteBatch(ops))
+
+	assert.Equal(t, []Event{
+		{Sequence: 2, EventType: EventPut, Key: "key2", Value: "value2", Version: 2},
+		{Sequence: 3, EventType: EventBatch, Ops: ops},
+	}, committed)
+	assert.Equal(t, uint64(3), restarted.LastSequence())
+}
+
+func TestFileTransactionLogger_Close(t *testing.T) {
+	config := FileConfig{Dir: t.TempDir(), Durability: DurabilityInterval, SyncInterval: time.Hour}
+
+	logger, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	logger.Run()
+
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, logger.WritePut(fmt.Sprintf("key%d", i), "value", uint64(i+1), time.Time{}))
+		}(i)
+	}
+	wg.Wait()
+
+	require.NoError(t, logger.Close())
+
+	err = logger.WritePut("key", "value", writers+1, time.Time{})
+	assert.ErrorIs(t, err, ErrLoggerClosed)
+
+	restarted, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	defer restarted.Close()
+
+	got, err := readAll(restarted)
+	require.NoError(t, err)
+	assert.Len(t, got, writers)
+}
+
+func TestFileTransactionLogger_Unhealthy(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := NewFileTransactionLogger(FileConfig{Dir: dir, MaxSegmentSize: 1})
+	require.NoError(t, err)
+	logger.Run()
+	defer logger.Close()
+
+	require.NoError(t, logger.WritePut("key1", "value", 1, time.Time{}))
+
+	// the next write has to rotate, which fails without the directory
+	require.NoError(t, os.RemoveAll(dir))
+
+	err = logger.WritePut("key2", "value", 2, time.Time{})
+	assert.ErrorIs(t, err, ErrLoggerUnhealthy)
+
+	select {
+	case err := <-logger.Err():
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("expected the failure to be reported on Err")
+	}
+
+	err = logger.WriteDelete("key1")
+	assert.ErrorIs(t, err, ErrLoggerUnhealthy)
+}
+
+func TestNewFileTransactionLogger_InvalidDurability(t *testing.T) {
+	tests := map[string]struct {
+		config      FileConfig
+		errContains string
+	}{
+		"unknown mode": {
+			config:      FileConfig{Durability: "sometimes"},
+			errContains: "unknown durability",
+		},
+		"interval without sync interval": {
+			config:      FileConfig{Durability: DurabilityInterval},
+			errContains: "sync interval",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test.config.Dir = t.TempDir()
+
+			_, err := NewFileTransactionLogger(test.config)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.errContains)
+		})
+	}
+}
+
+func TestFileTransactionLogger_Compact(t *testing.T) {
+	dir := t.TempDir()
+	config := FileConfig{Dir: dir, MaxSegmentSize: 64}
+
+	logger, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	logger.Run()
+
+	s := New(make(map[string]interface{}))
+	put := func(key, value string) {
+		kv, err := s.PutIf(key, value, time.Time{}, model.Precondition{}, nil)
+		require.NoError(t, err)
+		require.NoError(t, logger.WritePut(key, value, kv.Version, time.Time{}))
+	}
+
+	put("key1", "value1")
+	put("key2", "value2")
+	require.NoError(t, s.Delete("key1"))
+	require.NoError(t, logger.WriteDelete("key1"))
+
+	require.NoError(t, logger.Compact(context.Background(), s, 3))
+
+	put("key3", "value3")
+
+	snapshots, err := snapshotFiles(logger.snapshotPrefix())
+	require.NoError(t, err)
+	assert.Equal(t, []string{snapshotFilename(logger.snapshotPrefix(), 3)}, snapshots)
+
+	m, err := readManifest(dir)
+	require.NoError(t, err)
+	require.Len(t, m.Segments, 1, "every segment covered by the snapshot should be dropped")
+	assert.Equal(t, uint64(4), m.Segments[0].FirstSequence)
+
+	// restart from the snapshot and the remaining segments
+	restarted, err := NewFileTransactionLogger(config)
+	require.NoError(t, err)
+	defer restarted.Close()
+
+	restored := New(make(map[string]interface{}))
+	require.NoError(t, restarted.LoadSnapshot(restored))
+
+	got, err := readAll(restarted)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, uint64(4), got[0].Sequence)
+
+	for _, e := range got {
+		require.NoError(t, restored.Apply(e))
+	}
+	assert.Equal(t, map[string]interface{}{"key2": "value2", "key3": "value3"}, restored.values())
+	assert.Equal(t, s.version, restored.version)
+}
+
+func TestFileTransactionLogger_ReadEventsAfter(t *testing.T) {
+	tests := map[string]struct {
+		after             uint64
+		compact           uint64
+		expectedSequences []uint64
+		expectedErr       error
+	}{
+		"everything": {
+			expectedSequences: []uint64{1, 2, 3, 4, 5, 6},
+		},
+		"across segments": {
+			after:             2,
+			expectedSequences: []uint64{3, 4, 5, 6},
+		},
+		"up to date": {
+			after: 6,
+		},
+		"after compaction": {
+			after:             4,
+			compact:           4,
+			expectedSequences: []uint64{5, 6},
+		},
+		"compacted": {
+			after:       2,
+			compact:     4,
+			expectedErr: ErrCompacted,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			logger, err := NewFileTransactionLogger(FileConfig{Dir: t.TempDir(), MaxSegmentSize: 64})
+			require.NoError(t, err)
+			logger.Run()
+			defer logger.Close()
+
+			s := New(make(map[string]interface{}))
+			for i := 1; i <= 6; i++ {
+				if i == int(test.compact)+1 {
+					require.NoError(t, logger.Compact(context.Background(), s, test.compact))
+				}
+
+				key := fmt.Sprintf("key%d", i)
+				kv, err := s.PutIf(key, "value", time.Time{}, model.Precondition{}, nil)
+				require.NoError(t, err)
+				require.NoError(t, logger.WritePut(key, "value", kv.Version, time.Time{}))
+			}
+
+			// read while the logger is still running
+			var got []uint64
+			events, errs := logger.ReadEventsAfter(context.Background(), test.after)
+			for e := range events {
+				got = append(got, e.Sequence)
+			}
+
+			assert.ErrorIs(t, <-errs, test.expectedErr)
+			assert.Equal(t, test.expectedSequences, got)
+			assert.Equal(t, uint64(6), logger.LastSequence())
+		})
+	}
+}
+
+func TestFileTransactionLogger_LoadSnapshot(t *testing.T) {
+	tests := map[string]struct {
+		corruptNewest    bool
+		expectedSequence uint64
+	}{
+		"newest snapshot": {
+			expectedSequence: 2,
+		},
+		"newest snapshot corrupt": {
+			corruptNewest:    true,
+			expectedSequence: 1,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			logger, err := NewFileTransactionLogger(FileConfig{Dir: t.TempDir()})
+			require.NoError(t, err)
+			defer logger.Close()
+
+			for seq := uint64(1); seq <= 2; seq++ {
+				snap := &Snapshot{
+					Sequence:  seq,
+					Version:   seq,
+					KeyValues: []*model.KeyValue{{Key: "key", Value: "value", Version: seq, Flags: uint32(seq)}},
+				}
+				require.NoError(t, writeSnapshot(snapshotFilename(logger.snapshotPrefix(), seq), snap))
+			}
+
+			if test.corruptNewest {
+				newest := snapshotFilename(logger.snapshotPrefix(), 2)
+				data, err := os.ReadFile(newest)
+				require.NoError(t, err)
+				data[len(data)/2] ^= 0xff
+				require.NoError(t, os.WriteFile(newest, data, 0644))
+			}
+
+			s := New(make(map[string]interface{}))
+			require.NoError(t, logger.LoadSnapshot(s))
+
+			assert.Equal(t, test.expectedSequence, logger.snapshotSequence)
+
+			kv, err := s.GetKeyValue("key")
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedSequence, kv.Version)
+			assert.Equal(t, uint32(test.expectedSequence), kv.Flags)
+		})
+	}
+}
+
+func readAll(logger *FileTransactionLogger) ([]Event, error) {
+	var got []Event
+
+	events, errs := logger.ReadEvents()
+	for e := range events {
+		got = append(got, e)
+	}
+
+	return got, <-errs
+}
@@ -0,0 +1,65 @@
+package store
+
+import "container/heap"
+
+// mapEngine is a hash map of the keys. It keeps no order, so writes never move other keys, and a listing heaps the
+// keys after its start to pop them in order, only paying to order the keys it visits.
+type mapEngine struct {
+	m map[string]item
+}
+
+func newMapEngine() *mapEngine {
+	return &mapEngine{
+		m: make(map[string]item),
+	}
+}
+
+func (e *mapEngine) get(key string) (item, bool) {
+	it, ok := e.m[key]
+	return it, ok
+}
+
+func (e *mapEngine) put(key string, it item) {
+	e.m[key] = it
+}
+
+func (e *mapEngine) delete(key string) {
+	delete(e.m, key)
+}
+
+func (e *mapEngine) len() int {
+	return len(e.m)
+}
+
+func (e *mapEngine) ascend(start string, fn func(key string, it item) bool) {
+	keys := make(keyHeap, 0, len(e.m))
+	for key := range e.m {
+		if key >= start {
+			keys = append(keys, key)
+		}
+	}
+	heap.Init(&keys)
+
+	for keys.Len() > 0 {
+		key := heap.Pop(&keys).(string)
+		if !fn(key, e.m[key]) {
+			return
+		}
+	}
+}
+
+// keyHeap is a min-heap of keys.
+type keyHeap []string
+
+func (h keyHeap) Len() int           { return len(h) }
+func (h keyHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h keyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *keyHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	key := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return key
+}
@@ -1,51 +1,540 @@
 package store
 
 import (
+	"context"
+	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/warrenb95/cloud-native-go/internal/model"
 )
 
+// keyLockStripes is how many locks the keys being written are spread over.
+const keyLockStripes = 1024
+
 type Store struct {
-	sync.RWMutex
-	m map[string]interface{}
+	// version is the last version handed out, shared by all keys so a deleted and recreated key never reuses one.
+	// It is accessed atomically and kept first for 64 bit alignment.
+	version uint64
+
+	engine Engine
+	shards []*shard
+	// keyLocks order the writes to each key from checking their preconditions until they are applied, so a write is
+	// committed while its keys' shards are still open to readers.
+	keyLocks []sync.Mutex
+	// applying is held shared by every write from logging it until it is applied, see Applied.
+	applying sync.RWMutex
+	// summary is the tree of the store's keys kept for repairs, nil unless it is summarised.
+	summary *summary
 }
 
+// New creates a store using the map engine, holding the key values in m without versions.
 func New(m map[string]interface{}) *Store {
-	return &Store{
-		m: m,
+	s := NewWithEngine(EngineMap)
+	for key, value := range m {
+		s.shardFor(key).data.put(key, item{value: value})
+	}
+
+	return s
+}
+
+// NewWithEngine creates an empty store that keeps its keys in the given engine.
+func NewWithEngine(e Engine) *Store {
+	return NewSharded(e, 1)
+}
+
+// NewSharded creates an empty store that spreads its keys over n independently locked shards,
+// so writes to keys in different shards don't wait on each other.
+func NewSharded(e Engine, n int) *Store {
+	if n < 1 {
+		n = 1
+	}
+
+	s := &Store{
+		engine:   e,
+		shards:   make([]*shard, n),
+		keyLocks: make([]sync.Mutex, keyLockStripes),
+	}
+	for i := range s.shards {
+		s.shards[i] = newShard(e)
 	}
+
+	return s
 }
 
 // Put will overite the key value if the key exists.
 func (s *Store) Put(key string, value interface{}) error {
-	s.Lock()
-	defer s.Unlock()
+	return s.PutWithExpiry(key, value, time.Time{})
+}
 
-	s.m[key] = value
+// PutWithExpiry will overwrite the key value if the key exists and expire it at the provided deadline.
+// A zero deadline means the key never expires.
+func (s *Store) PutWithExpiry(key string, value interface{}, expires time.Time) error {
+	_, err := s.PutIf(key, value, expires, model.Precondition{}, nil)
+	return err
+}
 
-	return nil
+// PutIf will write the key value if the precondition holds against the key's current version and commit, if not
+// nil, accepts it. The stored key value is returned with its new version.
+func (s *Store) PutIf(key string, value interface{}, expires time.Time, pre model.Precondition, commit model.Commit) (*model.KeyValue, error) {
+	kvs, _, err := s.write([]model.BatchOp{{
+		Type:         model.OpPut,
+		Key:          key,
+		Value:        value,
+		Expires:      expires,
+		Precondition: pre,
+	}}, commit, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return kvs[0], nil
 }
 
 // Get will get the value of the key if it exists.
 func (s *Store) Get(key string) (interface{}, error) {
-	s.RLock()
-	defer s.RUnlock()
+	kv, err := s.GetKeyValue(key)
+	if err != nil {
+		return "", err
+	}
 
-	value, ok := s.m[key]
+	return kv.Value, nil
+}
+
+// GetKeyValue will get the value of the key along with its metadata if it exists.
+func (s *Store) GetKeyValue(key string) (*model.KeyValue, error) {
+	sh := s.shardFor(key)
+	sh.RLock()
+	defer sh.RUnlock()
+
+	it, ok := sh.data.get(key)
 	if !ok {
-		return "", model.ErrKeyNotFound
+		return nil, model.ErrKeyNotFound
+	}
+
+	kv := &model.KeyValue{
+		Key:     key,
+		Value:   it.value,
+		Version: it.version,
+		Expires: sh.expires[key],
+		Flags:   it.flags,
+	}
+	if kv.Expired(time.Now()) {
+		return nil, model.ErrKeyNotFound
+	}
+
+	return kv, nil
+}
+
+// Keys returns every key that hasn't expired, in sorted order.
+func (s *Store) Keys() []string {
+	var keys []string
+	for _, sh := range s.shards {
+		keys = sh.appendKeys(keys, time.Now())
+	}
+
+	if len(s.shards) > 1 {
+		sort.Strings(keys)
+	}
+
+	return keys
+}
+
+// List returns the key values selected by the options in key order, skipping expired keys.
+// next is the key the following page starts from, it is empty once there are no more keys to list.
+// Each shard is read in turn, so with more than one shard a listing can see some concurrent writes and not others.
+func (s *Store) List(opts model.ListOptions) (kvs []*model.KeyValue, next string) {
+	// a shard can hold the whole page, so each is asked for one more than the limit to find the next key
+	shardOpts := opts
+	if opts.Limit > 0 {
+		shardOpts.Limit = opts.Limit + 1
+	}
+
+	for _, sh := range s.shards {
+		kvs = sh.appendList(kvs, shardOpts, time.Now())
 	}
 
-	return value, nil
+	if len(s.shards) > 1 {
+		sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	}
+
+	if opts.Limit > 0 && len(kvs) > opts.Limit {
+		return kvs[:opts.Limit], kvs[opts.Limit].Key
+	}
+
+	return kvs, ""
 }
 
 // Delete will delete the key value pair from the store.
 func (s *Store) Delete(key string) error {
-	s.Lock()
-	defer s.Unlock()
+	return s.DeleteIf(key, model.Precondition{}, nil)
+}
+
+// DeleteIf will delete the key value pair if the precondition holds against the key's current version and commit,
+// if not nil, accepts it.
+func (s *Store) DeleteIf(key string, pre model.Precondition, commit model.Commit) error {
+	_, _, err := s.write([]model.BatchOp{{
+		Type:         model.OpDelete,
+		Key:          key,
+		Precondition: pre,
+	}}, commit, time.Now())
+
+	return err
+}
+
+// Expire will delete the key if it is set to expire at or before the deadline.
+// Keys that have since been overwritten with a later or no deadline are left alone.
+func (s *Store) Expire(key string, deadline time.Time) error {
+	sh := s.shardFor(key)
+	sh.Lock()
+	defer sh.Unlock()
+
+	sh.expire(key, deadline)
+
+	return nil
+}
+
+// Batch will apply every operation if all of their preconditions hold against the keys' current versions and
+// commit, if not nil, accepts them, or none of them. Each key may only appear once. The written key values are
+// returned in the order of the operations, a deleted key is returned without a value or version.
+func (s *Store) Batch(ops []model.BatchOp, commit model.Commit) ([]*model.KeyValue, error) {
+	return s.batch(ops, commit, time.Now())
+}
+
+// WriteAt applies the operations like Batch, but checks their preconditions against the keys' versions at now rather
+// than the current time, so a write replayed on another node at another time is applied the same way there. The error
+// of a single operation is returned as it is, like PutIf and DeleteIf.
+func (s *Store) WriteAt(ops []model.BatchOp, commit model.Commit, now time.Time) ([]*model.KeyValue, error) {
+	if len(ops) == 1 {
+		kvs, _, err := s.write(ops, commit, now)
+		return kvs, err
+	}
+	return s.batch(ops, commit, now)
+}
+
+func (s *Store) batch(ops []model.BatchOp, commit model.Commit, now time.Time) ([]*model.KeyValue, error) {
+	seen := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		if seen[op.Key] {
+			return nil, fmt.Errorf("%w: key %q appears more than once in the batch", model.ErrInvalidArgument, op.Key)
+		}
+		seen[op.Key] = true
+	}
+
+	kvs, failed, err := s.write(ops, commit, now)
+	if err != nil && failed >= 0 {
+		return nil, fmt.Errorf("operation %d on key %q: %w", failed, ops[failed].Key, err)
+	}
+
+	return kvs, err
+}
+
+// write applies the operations if all of their preconditions hold against the keys' versions at now and commit
+// accepts them, returning the written key values in the order of the operations. failed is the position of the
+// operation whose precondition didn't hold, or -1.
+func (s *Store) write(ops []model.BatchOp, commit model.Commit, now time.Time) (kvs []*model.KeyValue, failed int, err error) {
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = op.Key
+	}
+
+	// the key locks keep the versions checked here until the operations are applied
+	unlockKeys := s.lockKeys(keys)
+	defer unlockKeys()
+
+	for i, op := range ops {
+		sh := s.shardFor(op.Key)
+		sh.RLock()
+		err := op.Precondition.Check(sh.current(op.Key, now))
+		sh.RUnlock()
+		if err != nil {
+			return nil, i, err
+		}
+	}
+
+	kvs = make([]*model.KeyValue, len(ops))
+	for i, op := range ops {
+		if op.Type == model.OpDelete {
+			kvs[i] = &model.KeyValue{Key: op.Key}
+			continue
+		}
+
+		kvs[i] = &model.KeyValue{
+			Key:     op.Key,
+			Value:   op.Value,
+			Version: atomic.AddUint64(&s.version, 1),
+			Expires: op.Expires,
+			Flags:   op.Flags,
+		}
+	}
+
+	s.applying.RLock()
+	defer s.applying.RUnlock()
+
+	if commit != nil {
+		if err := commit(kvs); err != nil {
+			return nil, -1, err
+		}
+	}
+
+	unlockShards := s.lockShards(keys)
+	defer unlockShards()
 
-	delete(s.m, key)
+	for i, op := range ops {
+		sh := s.shardFor(op.Key)
+		if op.Type == model.OpDelete {
+			sh.remove(op.Key)
+			sh.bury(op.Key, s.Version())
+			continue
+		}
+		sh.set(op.Key, op.Value, kvs[i].Version, op.Expires, op.Flags)
+	}
+
+	return kvs, -1, nil
+}
+
+// Apply will apply an event read back from a transaction log, keeping the version it was logged with.
+// A batch event has all of its operations applied together.
+func (s *Store) Apply(e Event) error {
+	if e.EventType == EventBatch {
+		keys := make([]string, len(e.Ops))
+		for i, op := range e.Ops {
+			keys[i] = op.Key
+		}
+
+		unlock := s.lockShards(keys)
+		defer unlock()
+
+		for _, op := range e.Ops {
+			s.apply(s.shardFor(op.Key), op)
+		}
+
+		return nil
+	}
+
+	sh := s.shardFor(e.Key)
+	sh.Lock()
+	defer sh.Unlock()
+
+	s.apply(sh, e)
+
+	return nil
+}
+
+// ApplyIf will apply the put or delete event, keeping its version, if the precondition holds against the key's current
+// version and commit, if not nil, accepts it. Like a write the key stays locked from the check until the event is
+// applied, so a write to it in between either comes first and fails the precondition or waits.
+func (s *Store) ApplyIf(e Event, pre model.Precondition, commit model.Commit) error {
+	unlockKeys := s.lockKeys([]string{e.Key})
+	defer unlockKeys()
+
+	sh := s.shardFor(e.Key)
+	sh.RLock()
+	err := pre.Check(sh.current(e.Key, time.Now()))
+	sh.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	s.applying.RLock()
+	defer s.applying.RUnlock()
+
+	if commit != nil {
+		kv := &model.KeyValue{Key: e.Key}
+		if e.EventType == EventPut {
+			kv.Value, kv.Version, kv.Expires, kv.Flags = e.Value, e.Version, e.Expires, e.Flags
+		}
+		if err := commit([]*model.KeyValue{kv}); err != nil {
+			return err
+		}
+	}
+
+	sh.Lock()
+	defer sh.Unlock()
+
+	s.apply(sh, e)
 
 	return nil
 }
+
+// ApplyCommitted will apply the event once commit, e.g. logging it, succeeds. Like a write it is covered by Applied
+// from the commit until it is applied.
+func (s *Store) ApplyCommitted(e Event, commit func() error) error {
+	s.applying.RLock()
+	defer s.applying.RUnlock()
+
+	if err := commit(); err != nil {
+		return err
+	}
+
+	return s.Apply(e)
+}
+
+// Applied returns the log's sequence, read by sequence once every write already logged has been applied to the store,
+// so a snapshot taken afterwards covers the log up to it. Writes are held off until it is read.
+func (s *Store) Applied(sequence func() uint64) uint64 {
+	s.applying.Lock()
+	defer s.applying.Unlock()
+
+	return sequence()
+}
+
+// apply applies a single logged event to the key's shard, the caller must hold its lock.
+func (s *Store) apply(sh *shard, e Event) {
+	switch e.EventType {
+	case EventPut:
+		// Don't resurrect keys that expired while the server was down.
+		if !e.Expires.IsZero() && !e.Expires.After(time.Now()) {
+			sh.remove(e.Key)
+			return
+		}
+
+		version := e.Version
+		if version == 0 {
+			// logged before versions were recorded
+			version = atomic.AddUint64(&s.version, 1)
+		}
+		s.advanceVersion(version)
+
+		// a put that lost a race with a later write to the key, e.g. a repair, mustn't take the key back
+		if it, ok := sh.data.get(e.Key); ok && it.version > version {
+			return
+		}
+
+		sh.set(e.Key, e.Value, version, e.Expires, e.Flags)
+	case EventDelete:
+		// a delete carrying a version, e.g. one repaired from a peer, is ordered after every version before it
+		s.advanceVersion(e.Version)
+		sh.remove(e.Key)
+
+		version := e.Version
+		if version == 0 {
+			version = s.Version()
+		}
+		sh.bury(e.Key, version)
+	case EventExpire:
+		sh.expire(e.Key, e.Expires)
+	}
+}
+
+// Reap will delete every key that has expired by now and return them with their deadlines.
+func (s *Store) Reap(now time.Time) map[string]time.Time {
+	reaped := make(map[string]time.Time)
+	for _, sh := range s.shards {
+		sh.reap(now, reaped)
+	}
+
+	return reaped
+}
+
+// RunReaper will purge expired keys every interval until the context is cancelled.
+// onExpire is called outside of the store lock for every key that was purged.
+func (s *Store) RunReaper(ctx context.Context, interval time.Duration, onExpire func(key string, expires time.Time)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				for key, expires := range s.Reap(now) {
+					if onExpire != nil {
+						onExpire(key, expires)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// shardFor returns the shard holding the key.
+func (s *Store) shardFor(key string) *shard {
+	return s.shards[s.shardIndex(key)]
+}
+
+// shardIndex returns the position of the shard holding the key.
+func (s *Store) shardIndex(key string) int {
+	if len(s.shards) == 1 {
+		return 0
+	}
+
+	return int(keyHash(key) % uint32(len(s.shards)))
+}
+
+// keyHash returns the FNV-1a hash of the key.
+func keyHash(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+
+	return hash
+}
+
+// lockKeys locks the stripes of the key locks the keys fall in and returns a func that unlocks them.
+// Stripes are always locked in the same order so concurrent writes can't deadlock.
+func (s *Store) lockKeys(keys []string) func() {
+	var stripes []int
+	used := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		i := int(keyHash(key) % keyLockStripes)
+		if !used[i] {
+			used[i] = true
+			stripes = append(stripes, i)
+		}
+	}
+	sort.Ints(stripes)
+
+	for _, i := range stripes {
+		s.keyLocks[i].Lock()
+	}
+
+	return func() {
+		for _, i := range stripes {
+			s.keyLocks[i].Unlock()
+		}
+	}
+}
+
+// lockShards write locks every shard holding one of the keys and returns a func that unlocks them.
+// Shards are always locked in the same order so concurrent batches can't deadlock.
+func (s *Store) lockShards(keys []string) func() {
+	used := make([]bool, len(s.shards))
+	for _, key := range keys {
+		used[s.shardIndex(key)] = true
+	}
+
+	var locked []*shard
+	for i, sh := range s.shards {
+		if used[i] {
+			sh.Lock()
+			locked = append(locked, sh)
+		}
+	}
+
+	return func() {
+		for _, sh := range locked {
+			sh.Unlock()
+		}
+	}
+}
+
+// Version returns the last version handed out.
+func (s *Store) Version() uint64 {
+	return atomic.LoadUint64(&s.version)
+}
+
+// advanceVersion raises the last handed out version to at least version.
+func (s *Store) advanceVersion(version uint64) {
+	for {
+		current := atomic.LoadUint64(&s.version)
+		if version <= current || atomic.CompareAndSwapUint64(&s.version, current, version) {
+			return
+		}
+	}
+}
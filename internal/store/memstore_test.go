@@ -1,13 +1,28 @@
 package store
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/warrenb95/cloud-native-go/internal/model"
 )
 
+// values returns the value of every key held by the store's engine, including expired keys.
+func (s *Store) values() map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, sh := range s.shards {
+		sh.data.ascend("", func(key string, it item) bool {
+			values[key] = it.value
+			return true
+		})
+	}
+	return values
+}
+
 func TestStore_Put(t *testing.T) {
 	type args struct {
 		key   string
@@ -35,7 +50,7 @@ func TestStore_Put(t *testing.T) {
 			}
 			require.NoError(t, err)
 
-			if value, ok := test.s.m[test.args.key]; !ok {
+			if value, ok := test.s.values()[test.args.key]; !ok {
 				t.Fatal("value not found in store")
 			} else {
 				assert.Equal(t, test.args.value, value)
@@ -76,7 +91,7 @@ func TestStore_Get(t *testing.T) {
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			for k, v := range test.storedValue {
-				test.s.m[k] = v
+				test.s.shardFor(k).data.put(k, item{value: v})
 			}
 
 			got, err := test.s.Get(test.args.key)
@@ -116,7 +131,7 @@ func TestStore_Delete(t *testing.T) {
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			for k, v := range test.storedValues {
-				test.s.m[k] = v
+				test.s.shardFor(k).data.put(k, item{value: v})
 			}
 
 			err := test.s.Delete(test.args.key)
@@ -126,9 +141,615 @@ func TestStore_Delete(t *testing.T) {
 			}
 			require.NoError(t, err)
 
-			if _, ok := test.s.m[test.args.key]; ok {
+			if _, ok := test.s.values()[test.args.key]; ok {
 				t.Fatalf("key %s should have been deleted", test.args.key)
 			}
 		})
 	}
 }
+
+func TestStore_PutWithExpiry(t *testing.T) {
+	type args struct {
+		key     string
+		value   string
+		expires time.Time
+	}
+	tests := map[string]struct {
+		s           *Store
+		args        args
+		expectedErr error
+	}{
+		"no expiry": {
+			s: New(make(map[string]interface{})),
+			args: args{
+				key:   "key",
+				value: "value",
+			},
+		},
+		"future expiry": {
+			s: New(make(map[string]interface{})),
+			args: args{
+				key:     "key",
+				value:   "value",
+				expires: time.Now().Add(time.Hour),
+			},
+		},
+		"already expired": {
+			s: New(make(map[string]interface{})),
+			args: args{
+				key:     "key",
+				value:   "value",
+				expires: time.Now().Add(-time.Second),
+			},
+			expectedErr: model.ErrKeyNotFound,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.s.PutWithExpiry(test.args.key, test.args.value, test.args.expires)
+			require.NoError(t, err)
+
+			kv, err := test.s.GetKeyValue(test.args.key)
+			if test.expectedErr != nil {
+				require.EqualError(t, err, test.expectedErr.Error())
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, test.args.value, kv.Value)
+			assert.Equal(t, test.args.expires, kv.Expires)
+		})
+	}
+}
+
+func TestStore_Expire(t *testing.T) {
+	deadline := time.Now()
+
+	tests := map[string]struct {
+		expires      time.Time
+		shouldExpire bool
+	}{
+		"due": {
+			expires:      deadline,
+			shouldExpire: true,
+		},
+		"overwritten with later deadline": {
+			expires: deadline.Add(time.Hour),
+		},
+		"overwritten without deadline": {},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(make(map[string]interface{}))
+			require.NoError(t, s.PutWithExpiry("key", "value", test.expires))
+
+			err := s.Expire("key", deadline)
+			require.NoError(t, err)
+
+			_, ok := s.values()["key"]
+			assert.Equal(t, !test.shouldExpire, ok)
+		})
+	}
+}
+
+func TestStore_Reap(t *testing.T) {
+	now := time.Now()
+
+	s := New(make(map[string]interface{}))
+	require.NoError(t, s.PutWithExpiry("expired", "value", now.Add(-time.Second)))
+	require.NoError(t, s.PutWithExpiry("live", "value", now.Add(time.Hour)))
+	require.NoError(t, s.Put("forever", "value"))
+
+	reaped := s.Reap(now)
+	assert.Equal(t, map[string]time.Time{"expired": now.Add(-time.Second)}, reaped)
+
+	assert.NotContains(t, s.values(), "expired")
+	assert.Contains(t, s.values(), "live")
+	assert.Contains(t, s.values(), "forever")
+}
+
+func TestStore_Keys(t *testing.T) {
+	s := New(make(map[string]interface{}))
+	require.NoError(t, s.Put("b", "value"))
+	require.NoError(t, s.Put("a", "value"))
+	require.NoError(t, s.PutWithExpiry("expired", "value", time.Now().Add(-time.Second)))
+	require.NoError(t, s.PutWithExpiry("c", "value", time.Now().Add(time.Hour)))
+
+	assert.Equal(t, []string{"a", "b", "c"}, s.Keys())
+}
+
+func TestStore_List(t *testing.T) {
+	tests := map[string]struct {
+		opts         model.ListOptions
+		expectedKeys []string
+		expectedNext string
+	}{
+		"everything": {
+			expectedKeys: []string{"a", "user/1", "user/2", "user/3", "z"},
+		},
+		"prefix": {
+			opts:         model.ListOptions{Prefix: "user/"},
+			expectedKeys: []string{"user/1", "user/2", "user/3"},
+		},
+		"first page": {
+			opts:         model.ListOptions{Prefix: "user/", Limit: 2},
+			expectedKeys: []string{"user/1", "user/2"},
+			expectedNext: "user/3",
+		},
+		"last page": {
+			opts:         model.ListOptions{Prefix: "user/", Start: "user/3", Limit: 2},
+			expectedKeys: []string{"user/3"},
+		},
+		"exact last page": {
+			opts:         model.ListOptions{Prefix: "user/", Start: "user/2", Limit: 2},
+			expectedKeys: []string{"user/2", "user/3"},
+		},
+		"range": {
+			opts:         model.ListOptions{Start: "b", End: "user/3"},
+			expectedKeys: []string{"user/1", "user/2"},
+		},
+		"start before prefix": {
+			opts:         model.ListOptions{Prefix: "user/", Start: "a"},
+			expectedKeys: []string{"user/1", "user/2", "user/3"},
+		},
+		"no match": {
+			opts: model.ListOptions{Prefix: "order/"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(make(map[string]interface{}))
+			for _, key := range []string{"z", "user/3", "a", "user/1", "user/2"} {
+				require.NoError(t, s.Put(key, "value"))
+			}
+			require.NoError(t, s.PutWithExpiry("user/0", "value", time.Now().Add(-time.Second)))
+			require.NoError(t, s.Put("deleted", "value"))
+			require.NoError(t, s.Delete("deleted"))
+
+			kvs, next := s.List(test.opts)
+
+			var keys []string
+			for _, kv := range kvs {
+				keys = append(keys, kv.Key)
+				assert.Equal(t, "value", kv.Value)
+			}
+			assert.Equal(t, test.expectedKeys, keys)
+			assert.Equal(t, test.expectedNext, next)
+		})
+	}
+}
+
+func TestStore_PutIf(t *testing.T) {
+	tests := map[string]struct {
+		initValues      []string
+		pre             model.Precondition
+		expectedVersion uint64
+		expectedErr     error
+	}{
+		"unconditional": {
+			initValues:      []string{"value1"},
+			expectedVersion: 2,
+		},
+		"if match current version": {
+			initValues:      []string{"value1", "value2"},
+			pre:             model.Precondition{IfMatch: []uint64{2}},
+			expectedVersion: 3,
+		},
+		"if match stale version": {
+			initValues:  []string{"value1", "value2"},
+			pre:         model.Precondition{IfMatch: []uint64{1}},
+			expectedErr: model.ErrPreconditionFailed,
+		},
+		"if match any missing key": {
+			pre:         model.Precondition{IfMatchAny: true},
+			expectedErr: model.ErrPreconditionFailed,
+		},
+		"if none match any missing key": {
+			pre:             model.Precondition{IfNoneMatchAny: true},
+			expectedVersion: 1,
+		},
+		"if none match any existing key": {
+			initValues:  []string{"value1"},
+			pre:         model.Precondition{IfNoneMatchAny: true},
+			expectedErr: model.ErrPreconditionFailed,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(make(map[string]interface{}))
+			for _, v := range test.initValues {
+				require.NoError(t, s.Put("key", v))
+			}
+
+			kv, err := s.PutIf("key", "value", time.Time{}, test.pre, nil)
+			if test.expectedErr != nil {
+				require.EqualError(t, err, test.expectedErr.Error())
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expectedVersion, kv.Version)
+
+			got, err := s.GetKeyValue("key")
+			require.NoError(t, err)
+			assert.Equal(t, kv, got)
+		})
+	}
+}
+
+func TestStore_DeleteIf(t *testing.T) {
+	tests := map[string]struct {
+		pre         model.Precondition
+		expectedErr error
+	}{
+		"unconditional": {},
+		"if match current version": {
+			pre: model.Precondition{IfMatch: []uint64{1}},
+		},
+		"if match stale version": {
+			pre:         model.Precondition{IfMatch: []uint64{2}},
+			expectedErr: model.ErrPreconditionFailed,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(make(map[string]interface{}))
+			require.NoError(t, s.Put("key", "value"))
+
+			err := s.DeleteIf("key", test.pre, nil)
+			if test.expectedErr != nil {
+				require.EqualError(t, err, test.expectedErr.Error())
+				assert.Contains(t, s.values(), "key")
+				return
+			}
+			require.NoError(t, err)
+
+			assert.NotContains(t, s.values(), "key")
+		})
+	}
+}
+
+func TestStore_Batch(t *testing.T) {
+	tests := map[string]struct {
+		ops              []model.BatchOp
+		expectedVersions []uint64
+		expectedValues   map[string]interface{}
+		expectedErr      error
+	}{
+		"puts and deletes": {
+			ops: []model.BatchOp{
+				{Type: model.OpPut, Key: "key1", Value: "updated", Precondition: model.Precondition{IfMatch: []uint64{1}}},
+				{Type: model.OpDelete, Key: "key2"},
+				{Type: model.OpPut, Key: "key3", Value: "new", Precondition: model.Precondition{IfNoneMatchAny: true}},
+			},
+			expectedVersions: []uint64{3, 0, 4},
+			expectedValues:   map[string]interface{}{"key1": "updated", "key3": "new"},
+		},
+		"one failed precondition": {
+			ops: []model.BatchOp{
+				{Type: model.OpPut, Key: "key1", Value: "updated"},
+				{Type: model.OpDelete, Key: "key2", Precondition: model.Precondition{IfMatch: []uint64{1}}},
+			},
+			expectedValues: map[string]interface{}{"key1": "value1", "key2": "value2"},
+			expectedErr:    model.ErrPreconditionFailed,
+		},
+		"repeated key": {
+			ops: []model.BatchOp{
+				{Type: model.OpPut, Key: "key1", Value: "updated"},
+				{Type: model.OpDelete, Key: "key1"},
+			},
+			expectedValues: map[string]interface{}{"key1": "value1", "key2": "value2"},
+			expectedErr:    model.ErrInvalidArgument,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := NewSharded(EngineMap, 4)
+			require.NoError(t, s.Put("key1", "value1"))
+			require.NoError(t, s.Put("key2", "value2"))
+
+			kvs, err := s.Batch(test.ops, nil)
+			assert.Equal(t, test.expectedValues, s.values())
+			if test.expectedErr != nil {
+				require.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Len(t, kvs, len(test.ops))
+			for i, kv := range kvs {
+				assert.Equal(t, test.ops[i].Key, kv.Key)
+				assert.Equal(t, test.expectedVersions[i], kv.Version)
+			}
+		})
+	}
+}
+
+func TestStore_WriteAt(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+
+	tests := map[string]struct {
+		now         time.Time
+		expectedErr error
+	}{
+		"before the key expires": {
+			now:         expires.Add(-time.Minute),
+			expectedErr: model.ErrPreconditionFailed,
+		},
+		"after the key expires": {
+			now: expires,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(make(map[string]interface{}))
+			_, err := s.PutIf("key", "value", expires, model.Precondition{}, nil)
+			require.NoError(t, err)
+
+			ops := []model.BatchOp{{Type: model.OpPut, Key: "key", Value: "new", Precondition: model.Precondition{IfNoneMatchAny: true}}}
+			_, err = s.WriteAt(ops, nil, test.now)
+			if test.expectedErr != nil {
+				require.EqualError(t, err, test.expectedErr.Error())
+				assert.Equal(t, "value", s.values()["key"])
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, "new", s.values()["key"])
+		})
+	}
+}
+
+func TestStore_Apply(t *testing.T) {
+	s := New(make(map[string]interface{}))
+
+	events := []Event{
+		{Sequence: 1, EventType: EventPut, Key: "key", Value: "value1", Version: 4},
+		{Sequence: 2, EventType: EventPut, Key: "expired", Value: "value", Version: 5, Expires: time.Now().Add(-time.Second)},
+		{Sequence: 3, EventType: EventPut, Key: "deleted", Value: "value", Version: 6},
+		{Sequence: 4, EventType: EventDelete, Key: "deleted"},
+		{Sequence: 5, EventType: EventBatch, Ops: []Event{
+			{EventType: EventPut, Key: "batched", Value: "value", Version: 7},
+			{EventType: EventDelete, Key: "key"},
+			{EventType: EventPut, Key: "key", Value: "value1", Version: 8},
+		}},
+	}
+	for _, e := range events {
+		require.NoError(t, s.Apply(e))
+	}
+
+	kv, err := s.GetKeyValue("key")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), kv.Version)
+
+	kv, err = s.GetKeyValue("batched")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), kv.Version)
+
+	assert.NotContains(t, s.values(), "expired")
+	assert.NotContains(t, s.values(), "deleted")
+
+	// new writes carry on from the highest replayed version
+	kv, err = s.PutIf("key", "value2", time.Time{}, model.Precondition{IfMatch: []uint64{8}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(9), kv.Version)
+	assert.Equal(t, uint64(9), s.Version())
+
+	// and from a delete's version
+	require.NoError(t, s.Apply(Event{EventType: EventDelete, Key: "batched", Version: 12}))
+	assert.NotContains(t, s.values(), "batched")
+	kv, err = s.PutIf("key", "value3", time.Time{}, model.Precondition{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(13), kv.Version)
+
+	// a put older than the key's version, e.g. one that lost a race with a repair, doesn't take the key back
+	require.NoError(t, s.Apply(Event{EventType: EventPut, Key: "key", Value: "stale", Version: 10}))
+	kv, err = s.GetKeyValue("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value3", kv.Value)
+}
+
+func TestStore_ApplyIf(t *testing.T) {
+	tests := map[string]struct {
+		event          Event
+		pre            model.Precondition
+		expectedValues map[string]interface{}
+		expectedErr    error
+	}{
+		"put at current version": {
+			event:          Event{EventType: EventPut, Key: "key", Value: "repaired", Version: 5},
+			pre:            model.Precondition{IfMatch: []uint64{1}},
+			expectedValues: map[string]interface{}{"key": "repaired"},
+		},
+		"put at stale version": {
+			event:          Event{EventType: EventPut, Key: "key", Value: "repaired", Version: 5},
+			pre:            model.Precondition{IfMatch: []uint64{2}},
+			expectedValues: map[string]interface{}{"key": "value"},
+			expectedErr:    model.ErrPreconditionFailed,
+		},
+		"delete": {
+			event:          Event{EventType: EventDelete, Key: "key", Version: 5},
+			pre:            model.Precondition{IfMatch: []uint64{1}},
+			expectedValues: map[string]interface{}{},
+		},
+		"put if missing": {
+			event:          Event{EventType: EventPut, Key: "key", Value: "repaired", Version: 5},
+			pre:            model.Precondition{IfNoneMatchAny: true},
+			expectedValues: map[string]interface{}{"key": "value"},
+			expectedErr:    model.ErrPreconditionFailed,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(make(map[string]interface{}))
+			require.NoError(t, s.Put("key", "value"))
+
+			var committed []*model.KeyValue
+			err := s.ApplyIf(test.event, test.pre, func(kvs []*model.KeyValue) error {
+				committed = kvs
+				return nil
+			})
+			assert.Equal(t, test.expectedValues, s.values())
+			if test.expectedErr != nil {
+				require.EqualError(t, err, test.expectedErr.Error())
+				assert.Nil(t, committed)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Len(t, committed, 1)
+			if test.event.EventType == EventPut {
+				assert.Equal(t, test.event.Version, committed[0].Version)
+				kv, err := s.GetKeyValue("key")
+				require.NoError(t, err)
+				assert.Equal(t, test.event.Version, kv.Version)
+			}
+		})
+	}
+}
+
+func TestStore_Commit(t *testing.T) {
+	tests := map[string]struct {
+		write          func(s *Store, commit model.Commit) error
+		commitErr      error
+		expectedKVs    []*model.KeyValue
+		expectedValues map[string]interface{}
+	}{
+		"put": {
+			write: func(s *Store, commit model.Commit) error {
+				_, err := s.PutIf("key1", "updated", time.Time{}, model.Precondition{}, commit)
+				return err
+			},
+			expectedKVs:    []*model.KeyValue{{Key: "key1", Value: "updated", Version: 3}},
+			expectedValues: map[string]interface{}{"key1": "updated", "key2": "value2"},
+		},
+		"put not committed": {
+			write: func(s *Store, commit model.Commit) error {
+				_, err := s.PutIf("key1", "updated", time.Time{}, model.Precondition{}, commit)
+				return err
+			},
+			commitErr:      model.ErrUnavailable,
+			expectedKVs:    []*model.KeyValue{{Key: "key1", Value: "updated", Version: 3}},
+			expectedValues: map[string]interface{}{"key1": "value1", "key2": "value2"},
+		},
+		"failed precondition isn't committed": {
+			write: func(s *Store, commit model.Commit) error {
+				_, err := s.PutIf("key1", "updated", time.Time{}, model.Precondition{IfNoneMatchAny: true}, commit)
+				return err
+			},
+			expectedValues: map[string]interface{}{"key1": "value1", "key2": "value2"},
+		},
+		"delete not committed": {
+			write: func(s *Store, commit model.Commit) error {
+				return s.DeleteIf("key1", model.Precondition{}, commit)
+			},
+			commitErr:      model.ErrUnavailable,
+			expectedKVs:    []*model.KeyValue{{Key: "key1"}},
+			expectedValues: map[string]interface{}{"key1": "value1", "key2": "value2"},
+		},
+		"batch not committed": {
+			write: func(s *Store, commit model.Commit) error {
+				_, err := s.Batch([]model.BatchOp{
+					{Type: model.OpPut, Key: "key1", Value: "updated"},
+					{Type: model.OpDelete, Key: "key2"},
+				}, commit)
+				return err
+			},
+			commitErr:      model.ErrUnavailable,
+			expectedKVs:    []*model.KeyValue{{Key: "key1", Value: "updated", Version: 3}, {Key: "key2"}},
+			expectedValues: map[string]interface{}{"key1": "value1", "key2": "value2"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := NewSharded(EngineMap, 4)
+			require.NoError(t, s.Put("key1", "value1"))
+			require.NoError(t, s.Put("key2", "value2"))
+
+			var committed []*model.KeyValue
+			err := test.write(s, func(kvs []*model.KeyValue) error {
+				committed = kvs
+				return test.commitErr
+			})
+			if test.commitErr != nil {
+				require.ErrorIs(t, err, test.commitErr)
+			}
+
+			assert.Equal(t, test.expectedKVs, committed)
+			assert.Equal(t, test.expectedValues, s.values())
+		})
+	}
+}
+
+func TestStore_CommitOrder(t *testing.T) {
+	s := NewSharded(EngineMap, 4)
+
+	const writers, writes = 8, 100
+
+	var mu sync.Mutex
+	var versions []uint64
+	commit := func(kvs []*model.KeyValue) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		versions = append(versions, kvs[0].Version)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < writes; i++ {
+				_, err := s.PutIf("key", "value", time.Time{}, model.Precondition{}, commit)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every write to the key is committed in the order of its versions, and the last committed is the one kept
+	require.Len(t, versions, writers*writes)
+	for i := 1; i < len(versions); i++ {
+		require.Less(t, versions[i-1], versions[i])
+	}
+	kv, err := s.GetKeyValue("key")
+	require.NoError(t, err)
+	assert.Equal(t, versions[len(versions)-1], kv.Version)
+}
+
+func TestStore_Applied(t *testing.T) {
+	s := New(make(map[string]interface{}))
+
+	// the write is logged, as sequence 1, but not yet applied
+	var sequence uint64
+	committed, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		_, err := s.PutIf("key", "value", time.Time{}, model.Precondition{}, func([]*model.KeyValue) error {
+			atomic.StoreUint64(&sequence, 1)
+			close(committed)
+			<-release
+			return nil
+		})
+		assert.NoError(t, err)
+	}()
+	<-committed
+
+	applied := make(chan uint64)
+	go func() {
+		applied <- s.Applied(func() uint64 { return atomic.LoadUint64(&sequence) })
+	}()
+
+	select {
+	case <-applied:
+		t.Fatal("the sequence was read before the logged write was applied")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, uint64(1), <-applied)
+	assert.Contains(t, s.Snapshot(1).KeyValues, &model.KeyValue{Key: "key", Value: "value", Version: 1})
+}
@@ -1,16 +1,27 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
 type PostgresTransactionLogger struct {
-	events chan<- Event
+	// lastSequence is the sequence of the last event read or inserted, accessed atomically.
+	lastSequence uint64
+
+	queue  *eventQueue
 	errors <-chan error
+	done   chan struct{}
 	db     *sql.DB
+
+	// onCommit is called by the writer goroutine with every event once it has been committed.
+	onCommit func(Event)
 }
 
 type PostgresConfig struct {
@@ -50,41 +61,103 @@ func NewPostgresTransactionLogger(params PostgresConfig) (*PostgresTransactionLo
 		}
 	}
 
+	if err = logger.migrateTable(); err != nil {
+		return nil, fmt.Errorf("failed to migrate table: %w", err)
+	}
+
 	return logger, nil
 }
 
 func (l *PostgresTransactionLogger) Run() {
-	events := make(chan Event, 16)
-	l.events = events
+	l.queue = newEventQueue(16)
+	l.done = make(chan struct{})
 
 	errors := make(chan error, 1)
 	l.errors = errors
 
 	go func() {
-		query := `INSERT INTO transactions
-		(event_type, key, value)
-		VALUES($1, $2, $3)`
-
-		for e := range events {
-			tx, err := l.db.Begin()
-			if err != nil {
-				continue
+		defer close(l.done)
+		defer close(errors)
+
+		for p := range l.queue.events {
+			sequence, err := l.insert(p.Event)
+			if err == nil {
+				atomic.StoreUint64(&l.lastSequence, sequence)
+				if l.onCommit != nil {
+					p.Sequence = sequence
+					l.onCommit(p.Event)
+				}
 			}
-			defer tx.Rollback()
+			p.done <- err
 
-			_, err = tx.Exec(
-				query,
-				e.EventType, e.Key, e.Value)
 			if err != nil {
-				errors <- err
+				// only the first failure needs reporting, don't block the writer on the rest
+				select {
+				case errors <- err:
+				default:
+				}
 			}
-
-			tx.Commit()
 		}
 	}()
 }
 
+// insert writes the event in its own transaction and returns the sequence it was given.
+// It is durable once this returns without error.
+func (l *PostgresTransactionLogger) insert(e Event) (uint64, error) {
+	query := `INSERT INTO transactions
+		(event_type, key, value, expires, version)
+		VALUES($1, $2, $3, $4, $5)
+		RETURNING sequence`
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	value := e.Value
+	if e.EventType == EventBatch {
+		if value, err = encodeBatchOps(e.Ops); err != nil {
+			return 0, err
+		}
+	}
+
+	var sequence uint64
+	err = tx.QueryRow(
+		query,
+		e.EventType, e.Key, value, unixNano(e.Expires), e.Version).Scan(&sequence)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return sequence, nil
+}
+
 func (l *PostgresTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
+	query := `SELECT sequence, event_type, key, value, expires, version FROM transactions
+		ORDER BY sequence`
+
+	return l.readEvents(context.Background(), func(e Event) {
+		atomic.StoreUint64(&l.lastSequence, e.Sequence)
+	}, query)
+}
+
+// ReadEventsAfter streams the events inserted after sequence, it can be called while the logger is running.
+// Rows are never deleted, so the table always goes back to the first event.
+func (l *PostgresTransactionLogger) ReadEventsAfter(ctx context.Context, sequence uint64) (<-chan Event, <-chan error) {
+	query := `SELECT sequence, event_type, key, value, expires, version FROM transactions
+		WHERE sequence > $1
+		ORDER BY sequence`
+
+	return l.readEvents(ctx, func(Event) {}, query, sequence)
+}
+
+// readEvents streams the events selected by the query, calling read with each before it is sent.
+func (l *PostgresTransactionLogger) readEvents(ctx context.Context, read func(Event), query string, args ...interface{}) (<-chan Event, <-chan error) {
 	outEvent := make(chan Event)
 	outError := make(chan error, 1)
 
@@ -92,10 +165,7 @@ func (l *PostgresTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
 		defer close(outError)
 		defer close(outEvent)
 
-		query := `SELECT sequence, event_type, key, value FROM transactions
-		ORDER BY sequence`
-
-		rows, err := l.db.Query(query)
+		rows, err := l.db.QueryContext(ctx, query, args...)
 		if err != nil {
 			outError <- err
 			return
@@ -104,14 +174,36 @@ func (l *PostgresTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
 		defer rows.Close()
 		e := Event{}
 		for rows.Next() {
+			var expires int64
 			err = rows.Scan(
-				&e.Sequence, &e.EventType, &e.Key, &e.Value,
+				&e.Sequence, &e.EventType, &e.Key, &e.Value, &expires, &e.Version,
 			)
 			if err != nil {
 				outError <- err
+				return
+			}
+
+			e.Expires = time.Time{}
+			if expires != 0 {
+				e.Expires = time.Unix(0, expires).UTC()
+			}
+
+			read(e)
+
+			e.Ops = nil
+			if e.EventType == EventBatch {
+				if e.Ops, err = decodeBatchOps(e.Value); err != nil {
+					outError <- fmt.Errorf("batch at sequence %d: %w", e.Sequence, err)
+					return
+				}
+				e.Value = ""
 			}
 
-			outEvent <- e
+			select {
+			case outEvent <- e:
+			case <-ctx.Done():
+				return
+			}
 		}
 
 		if rows.Err() != nil {
@@ -122,6 +214,56 @@ func (l *PostgresTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
 	return outEvent, outError
 }
 
+// batchOp is how a batch event's operations are stored as JSON in its row's value.
+type batchOp struct {
+	EventType EventType `json:"type"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value,omitempty"`
+	Version   uint64    `json:"version,omitempty"`
+	Expires   int64     `json:"expires,omitempty"`
+	Flags     uint32    `json:"flags,omitempty"`
+}
+
+func encodeBatchOps(ops []Event) (string, error) {
+	encoded := make([]batchOp, len(ops))
+	for i, op := range ops {
+		encoded[i] = batchOp{
+			EventType: op.EventType,
+			Key:       op.Key,
+			Value:     op.Value,
+			Version:   op.Version,
+			Expires:   unixNano(op.Expires),
+			Flags:     op.Flags,
+		}
+	}
+
+	b, err := json.Marshal(encoded)
+	return string(b), err
+}
+
+func decodeBatchOps(value string) ([]Event, error) {
+	var encoded []batchOp
+	if err := json.Unmarshal([]byte(value), &encoded); err != nil {
+		return nil, err
+	}
+
+	ops := make([]Event, len(encoded))
+	for i, op := range encoded {
+		ops[i] = Event{
+			EventType: op.EventType,
+			Key:       op.Key,
+			Value:     op.Value,
+			Version:   op.Version,
+			Flags:     op.Flags,
+		}
+		if op.Expires != 0 {
+			ops[i].Expires = time.Unix(0, op.Expires).UTC()
+		}
+	}
+
+	return ops, nil
+}
+
 func (l *PostgresTransactionLogger) tableExist() (bool, error) {
 	const table = "transactions"
 
@@ -145,7 +287,9 @@ func (l *PostgresTransactionLogger) createTable() error {
 		sequence      BIGSERIAL PRIMARY KEY,
 		event_type    SMALLINT,
 		key 		  TEXT,
-		value         TEXT
+		value         TEXT,
+		expires       BIGINT NOT NULL DEFAULT 0,
+		version       BIGINT NOT NULL DEFAULT 0
 		);`
 
 	_, err := l.db.Exec(query)
@@ -156,12 +300,65 @@ func (l *PostgresTransactionLogger) createTable() error {
 	return nil
 }
 
-func (l *PostgresTransactionLogger) WritePut(key string, value string) {
-	l.events <- Event{EventType: EventPut, Key: key, Value: value}
+// migrateTable adds columns introduced after the transactions table was first created.
+func (l *PostgresTransactionLogger) migrateTable() error {
+	query := `ALTER TABLE transactions
+		ADD COLUMN IF NOT EXISTS expires BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;`
+
+	_, err := l.db.Exec(query)
+	return err
+}
+
+// WritePut logs a put event, returning once it is committed.
+func (l *PostgresTransactionLogger) WritePut(key string, value string, version uint64, expires time.Time) error {
+	return l.queue.write(Event{EventType: EventPut, Key: key, Value: value, Version: version, Expires: expires})
+}
+
+// WriteDelete logs a delete event, returning once it is committed.
+func (l *PostgresTransactionLogger) WriteDelete(key string) error {
+	return l.queue.write(Event{EventType: EventDelete, Key: key})
+}
+
+// WriteExpire logs an expire event, returning once it is committed.
+func (l *PostgresTransactionLogger) WriteExpire(key string, deadline time.Time) error {
+	return l.queue.write(Event{EventType: EventExpire, Key: key, Expires: deadline})
+}
+
+// WriteBatch logs the put and delete events as a single batch row, returning once it is committed.
+func (l *PostgresTransactionLogger) WriteBatch(ops []Event) error {
+	return l.queue.write(Event{EventType: EventBatch, Ops: ops})
+}
+
+// Close stops accepting events, commits everything already queued and closes the database.
+func (l *PostgresTransactionLogger) Close() error {
+	if l.queue != nil {
+		l.queue.close()
+		<-l.done
+	}
+
+	return l.db.Close()
+}
+
+// Ping checks the database connection is still usable.
+func (l *PostgresTransactionLogger) Ping(ctx context.Context) error {
+	return l.db.PingContext(ctx)
+}
+
+// OnCommit registers fn to be called with every event, sequence included, once it has been committed. It must be
+// called before Run. fn is called in sequence order on the writer goroutine, so it must not block.
+func (l *PostgresTransactionLogger) OnCommit(fn func(Event)) {
+	l.onCommit = fn
+}
+
+// LastSequence returns the sequence of the last event read back or committed.
+func (l *PostgresTransactionLogger) LastSequence() uint64 {
+	return atomic.LoadUint64(&l.lastSequence)
 }
 
-func (l *PostgresTransactionLogger) WriteDelete(key string) {
-	l.events <- Event{EventType: EventDelete, Key: key}
+// QueueDepth returns how many writes are waiting to be committed.
+func (l *PostgresTransactionLogger) QueueDepth() int {
+	return l.queue.depth()
 }
 
 func (l *PostgresTransactionLogger) Err() <-chan error {
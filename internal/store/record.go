@@ -0,0 +1,308 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// The binary log starts with a header of logMagic followed by the big endian format version.
+// Every record after it is framed as:
+//
+//	length  uint32 big endian, size of the payload
+//	crc     uint32 big endian, CRC-32C of the payload
+//	payload the encoded event
+//
+// A batch event's payload is followed by the number of operations and each operation encoded in the same way without
+// a sequence, so the whole batch is checksummed and torn together. An event with flags sets eventFlagged in its type
+// and has them after its value, so the records of events without any are the same as before flags were kept.
+const (
+	logMagic         = "KVSLOG"
+	logFormatVersion = 1
+	logHeaderSize    = len(logMagic) + 2
+	recordHeaderSize = 8
+
+	// eventFlagged marks an event type followed by flags.
+	eventFlagged = 0x80
+
+	// maxRecordSize guards against allocating a huge buffer for a corrupted length, so larger events aren't logged.
+	maxRecordSize = 64 << 20
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// errTornRecord is returned when the log ends part way through a record.
+	errTornRecord = errors.New("torn record")
+	// errCorruptRecord is returned when a complete record fails its checksum.
+	errCorruptRecord = errors.New("corrupt record")
+)
+
+// logHeader returns the header written at the start of every binary log.
+func logHeader() []byte {
+	header := make([]byte, logHeaderSize)
+	copy(header, logMagic)
+	binary.BigEndian.PutUint16(header[len(logMagic):], logFormatVersion)
+	return header
+}
+
+// readLogHeader checks the header at the start of a binary log.
+// It returns false without an error if the data is not a binary log.
+func readLogHeader(r io.Reader) (bool, error) {
+	header := make([]byte, logHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if !bytes.Equal(header[:len(logMagic)], []byte(logMagic)) {
+		return false, nil
+	}
+
+	if version := binary.BigEndian.Uint16(header[len(logMagic):]); version != logFormatVersion {
+		return false, fmt.Errorf("unsupported log format version %d", version)
+	}
+
+	return true, nil
+}
+
+// encodeRecord frames the event as a length prefixed, checksummed record.
+func encodeRecord(e Event) []byte {
+	payload := make([]byte, 0, 3*binary.MaxVarintLen64+len(e.Key)+len(e.Value)+16)
+	payload = appendUvarint(payload, e.Sequence)
+	payload = appendEvent(payload, e)
+	if e.EventType == EventBatch {
+		payload = appendUvarint(payload, uint64(len(e.Ops)))
+		for _, op := range e.Ops {
+			payload = appendEvent(payload, op)
+		}
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+
+	return append(record, payload...)
+}
+
+// maxPayloadSize returns the most bytes the event's record payload can take, whatever its sequence and fields.
+func maxPayloadSize(e Event) int {
+	size := binary.MaxVarintLen64 + maxEventSize(e)
+	if e.EventType == EventBatch {
+		size += binary.MaxVarintLen64
+		for _, op := range e.Ops {
+			size += maxEventSize(op)
+		}
+	}
+	return size
+}
+
+// maxEventSize returns the most bytes appendEvent can add for the event.
+func maxEventSize(e Event) int {
+	return 1 + 5*binary.MaxVarintLen64 + len(e.Key) + len(e.Value)
+}
+
+// readRecord reads the next record, returning the event and the number of bytes consumed.
+// io.EOF is returned at a clean end of the log, errTornRecord if it ends part way through a record
+// and errCorruptRecord if the record fails its checksum.
+func readRecord(r *bufio.Reader) (Event, int, error) {
+	var e Event
+
+	header := make([]byte, recordHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return e, 0, io.EOF
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return e, n, errTornRecord
+		}
+		return e, n, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return e, n, fmt.Errorf("%w: length %d exceeds maximum", errCorruptRecord, length)
+	}
+
+	payload := make([]byte, length)
+	m, err := io.ReadFull(r, payload)
+	n += m
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return e, n, errTornRecord
+		}
+		return e, n, err
+	}
+
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return e, n, fmt.Errorf("%w: checksum mismatch", errCorruptRecord)
+	}
+
+	e, err = decodePayload(payload)
+	if err != nil {
+		return e, n, fmt.Errorf("%w: %v", errCorruptRecord, err)
+	}
+
+	return e, n, nil
+}
+
+// decodePayload decodes an event from a record payload that has passed its checksum.
+func decodePayload(payload []byte) (Event, error) {
+	var (
+		e   Event
+		err error
+	)
+
+	d := decoder{buf: payload}
+	sequence := d.uvarint()
+	e = d.event()
+	e.Sequence = sequence
+	if e.EventType == EventBatch {
+		n := d.uvarint()
+		if n > uint64(len(d.buf)) {
+			// every operation takes at least a byte, so this can only be corruption
+			return e, fmt.Errorf("batch of %d operations in %d bytes", n, len(d.buf))
+		}
+
+		e.Ops = make([]Event, n)
+		for i := range e.Ops {
+			e.Ops[i] = d.event()
+		}
+	}
+
+	if d.err != nil {
+		err = d.err
+	} else if len(d.buf) != 0 {
+		err = fmt.Errorf("%d trailing bytes", len(d.buf))
+	}
+
+	return e, err
+}
+
+// appendEvent appends every field of the event but its sequence and batch operations.
+func appendEvent(buf []byte, e Event) []byte {
+	eventType := byte(e.EventType)
+	if e.Flags != 0 {
+		eventType |= eventFlagged
+	}
+
+	buf = append(buf, eventType)
+	buf = appendUvarint(buf, e.Version)
+	buf = appendVarint(buf, unixNano(e.Expires))
+	buf = appendString(buf, e.Key)
+	buf = appendString(buf, e.Value)
+	if e.Flags != 0 {
+		buf = appendUvarint(buf, uint64(e.Flags))
+	}
+	return buf
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decoder reads fields from a payload, remembering the first error so callers can check once at the end.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+// event reads the fields written by appendEvent.
+func (d *decoder) event() Event {
+	var e Event
+	eventType := d.byte()
+	e.EventType = EventType(eventType &^ eventFlagged)
+	e.Version = d.uvarint()
+	if expires := d.varint(); expires != 0 {
+		e.Expires = time.Unix(0, expires).UTC()
+	}
+	e.Key = d.string()
+	e.Value = d.string()
+	if eventType&eventFlagged != 0 {
+		e.Flags = uint32(d.uvarint())
+	}
+
+	return e
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("invalid uvarint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("invalid varint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+
+	if len(d.buf) < 1 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+
+	return b
+}
+
+func (d *decoder) string() string {
+	length := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+
+	if uint64(len(d.buf)) < length {
+		d.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	s := string(d.buf[:length])
+	d.buf = d.buf[length:]
+
+	return s
+}
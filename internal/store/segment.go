@@ -0,0 +1,246 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	manifestFilename = "MANIFEST"
+	segmentPrefix    = "segment-"
+	segmentSuffix    = ".log"
+	snapshotPrefix   = "snapshot"
+)
+
+// manifest records which segments make up the log, oldest first. The last segment is the active one being appended to.
+type manifest struct {
+	Segments []segmentInfo `json:"segments"`
+}
+
+// segmentInfo describes a single segment file and the range of sequences it holds.
+type segmentInfo struct {
+	ID            uint64 `json:"id"`
+	FirstSequence uint64 `json:"first_sequence"`
+	// LastSequence is zero while the segment is still active.
+	LastSequence uint64    `json:"last_sequence"`
+	Created      time.Time `json:"created"`
+}
+
+// active returns the segment currently being appended to.
+func (m *manifest) active() *segmentInfo {
+	return &m.Segments[len(m.Segments)-1]
+}
+
+// segmentFilename returns the name of the segment with the given id.
+func segmentFilename(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d%s", segmentPrefix, id, segmentSuffix))
+}
+
+// readManifest reads the manifest in dir, returning os.ErrNotExist if there isn't one.
+func readManifest(dir string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	if len(m.Segments) == 0 {
+		return nil, errors.New("invalid manifest: no segments")
+	}
+
+	return &m, nil
+}
+
+// writeManifest durably replaces the manifest in dir.
+func writeManifest(dir string, m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(dir, manifestFilename)
+	tmpName := filename + ".tmp"
+
+	tmp, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// removeOrphanSegments deletes segment files in dir that the manifest doesn't know about.
+// They are left behind by a crash part way through rotating or dropping segments.
+func removeOrphanSegments(dir string, m *manifest) error {
+	known := make(map[string]bool, len(m.Segments))
+	for _, seg := range m.Segments {
+		known[segmentFilename(dir, seg.ID)] = true
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"+segmentSuffix))
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		if !known[match] {
+			if err := os.Remove(match); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// createSegment creates a new, empty segment file ready for appending.
+func createSegment(filename string) (*os.File, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_RDWR, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeLogHeader(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// openSegment opens an existing segment for appending, rewriting the header if it was torn while being created.
+func openSegment(filename string) (*os.File, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if info.Size() < int64(logHeaderSize) {
+		if err := writeLogHeader(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return file, nil
+	}
+
+	ok, err := readLogHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !ok {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a transaction log segment", filename)
+	}
+
+	return file, nil
+}
+
+// migrateSingleFileLog moves a log written as a single file, and its snapshots, into dir as its first segment.
+// The new directory is built alongside and renamed into place, so a crash part way through leaves the old log intact.
+func migrateSingleFileLog(filename, dir string) error {
+	file, err := openLogFile(filename)
+	if err != nil {
+		return err
+	}
+
+	first, err := firstSequence(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	tmpDir := dir + ".migrating"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+
+	if err := os.Link(filename, segmentFilename(tmpDir, 1)); err != nil {
+		return err
+	}
+
+	snapshots, err := snapshotFiles(filename)
+	if err != nil {
+		return err
+	}
+	for _, snap := range snapshots {
+		seq := strings.TrimSuffix(strings.TrimPrefix(snap, filename+"."), snapshotSuffix)
+		if err := os.Link(snap, filepath.Join(tmpDir, snapshotPrefix+"."+seq+snapshotSuffix)); err != nil {
+			return err
+		}
+	}
+
+	m := &manifest{
+		Segments: []segmentInfo{{ID: 1, FirstSequence: first, Created: time.Now().UTC()}},
+	}
+	if err := writeManifest(tmpDir, m); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(dir)); err != nil {
+		return err
+	}
+
+	for _, snap := range snapshots {
+		os.Remove(snap)
+	}
+
+	return os.Remove(filename)
+}
+
+// firstSequence returns the sequence of the first record in a binary log, or 1 if it is empty.
+func firstSequence(file *os.File) (uint64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(file, int64(logHeaderSize), info.Size()-int64(logHeaderSize)))
+	e, _, err := readRecord(reader)
+	if err != nil {
+		// empty, or a torn first record that will be truncated on replay
+		return 1, nil
+	}
+
+	return e.Sequence, nil
+}
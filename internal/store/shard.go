@@ -0,0 +1,148 @@
+package store
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
+)
+
+// shard holds part of a store's keys under its own lock.
+type shard struct {
+	sync.RWMutex
+	data engine
+	// expires holds the deadline of every key that expires, so the reaper doesn't have to visit every key.
+	expires map[string]time.Time
+	// summary is the store's tree, nil unless it is summarised.
+	summary *summary
+}
+
+func newShard(e Engine) *shard {
+	return &shard{
+		data:    newEngine(e),
+		expires: make(map[string]time.Time),
+	}
+}
+
+// appendKeys appends the shard's keys that haven't expired by now in sorted order.
+func (sh *shard) appendKeys(keys []string, now time.Time) []string {
+	sh.RLock()
+	defer sh.RUnlock()
+
+	sh.data.ascend("", func(key string, _ item) bool {
+		if expires, ok := sh.expires[key]; !ok || now.Before(expires) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	return keys
+}
+
+// appendList appends the shard's key values selected by the options in key order, skipping those expired by now.
+func (sh *shard) appendList(kvs []*model.KeyValue, opts model.ListOptions, now time.Time) []*model.KeyValue {
+	sh.RLock()
+	defer sh.RUnlock()
+
+	start := opts.Start
+	if opts.Prefix > start {
+		start = opts.Prefix
+	}
+
+	listed := 0
+	sh.data.ascend(start, func(key string, it item) bool {
+		// the keys with the prefix are contiguous, so the first without it ends the listing
+		if !strings.HasPrefix(key, opts.Prefix) || (opts.End != "" && key >= opts.End) {
+			return false
+		}
+
+		if expires, ok := sh.expires[key]; ok && !now.Before(expires) {
+			return true
+		}
+
+		kvs = append(kvs, &model.KeyValue{
+			Key:     key,
+			Value:   it.value,
+			Version: it.version,
+			Expires: sh.expires[key],
+			Flags:   it.flags,
+		})
+		listed++
+
+		return opts.Limit == 0 || listed < opts.Limit
+	})
+
+	return kvs
+}
+
+// reap removes every key that has expired by now, adding them to reaped with their deadlines.
+func (sh *shard) reap(now time.Time, reaped map[string]time.Time) {
+	sh.Lock()
+	defer sh.Unlock()
+
+	for key, expires := range sh.expires {
+		if now.Before(expires) {
+			continue
+		}
+
+		sh.remove(key)
+		reaped[key] = expires
+	}
+}
+
+// current returns the key's version and whether it exists at now, the caller must hold the lock.
+func (sh *shard) current(key string, now time.Time) (uint64, bool) {
+	it, ok := sh.data.get(key)
+	if !ok {
+		return 0, false
+	}
+
+	if expires, ok := sh.expires[key]; ok && !now.Before(expires) {
+		return 0, false
+	}
+
+	return it.version, true
+}
+
+// set writes the key value and its metadata, the caller must hold the lock.
+func (sh *shard) set(key string, value interface{}, version uint64, expires time.Time, flags uint32) {
+	sh.data.put(key, item{value: value, version: version, flags: flags})
+
+	if expires.IsZero() {
+		delete(sh.expires, key)
+	} else {
+		sh.expires[key] = expires
+	}
+
+	if sh.summary != nil {
+		sh.summary.set(key, value, version)
+	}
+}
+
+// remove deletes the key value and its metadata, the caller must hold the lock.
+func (sh *shard) remove(key string) {
+	sh.data.delete(key)
+	delete(sh.expires, key)
+
+	if sh.summary != nil {
+		sh.summary.remove(key)
+	}
+}
+
+// bury records the removed key as deleted at the version in the store's tree, the caller must hold the lock.
+func (sh *shard) bury(key string, version uint64) {
+	if sh.summary != nil {
+		sh.summary.bury(key, version, time.Now())
+	}
+}
+
+// expire removes the key if it is due by the deadline, the caller must hold the lock.
+func (sh *shard) expire(key string, deadline time.Time) {
+	expires, ok := sh.expires[key]
+	if !ok || expires.After(deadline) {
+		return
+	}
+
+	sh.remove(key)
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+)
+
+func TestStore_Sharded(t *testing.T) {
+	for _, e := range engines {
+		t.Run(string(e), func(t *testing.T) {
+			s := NewSharded(e, 8)
+
+			var expected []string
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key%03d", i)
+				require.NoError(t, s.Put(key, "value"))
+				expected = append(expected, key)
+			}
+			require.NoError(t, s.PutWithExpiry("key050", "value", time.Now().Add(-time.Second)))
+			expected = append(expected[:50], expected[51:]...)
+
+			used := 0
+			for _, sh := range s.shards {
+				if sh.data.len() > 0 {
+					used++
+				}
+			}
+			assert.Greater(t, used, 1, "keys should be spread over the shards")
+
+			assert.Equal(t, expected, s.Keys())
+
+			// pages are merged across the shards in key order
+			var listed []string
+			start := ""
+			for {
+				kvs, next := s.List(model.ListOptions{Prefix: "key", Start: start, Limit: 7})
+				for _, kv := range kvs {
+					listed = append(listed, kv.Key)
+				}
+				if next == "" {
+					break
+				}
+				start = next
+			}
+			assert.Equal(t, expected, listed)
+
+			kvs, next := s.List(model.ListOptions{Start: "key010", End: "key013"})
+			require.Len(t, kvs, 3)
+			assert.Equal(t, "key012", kvs[2].Key)
+			assert.Empty(t, next)
+		})
+	}
+}
+
+func TestStore_ShardedVersions(t *testing.T) {
+	s := NewSharded(EngineMap, 16)
+
+	const writers, writes = 8, 100
+	versions := make(chan uint64, writers*writes)
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				kv, err := s.PutIf(fmt.Sprintf("key%d-%d", w, i%10), "value", time.Time{}, model.Precondition{}, nil)
+				require.NoError(t, err)
+				versions <- kv.Version
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(versions)
+
+	// every write gets its own version even when they land on different shards
+	seen := make(map[uint64]bool)
+	for v := range versions {
+		assert.False(t, seen[v], "version %d handed out twice", v)
+		seen[v] = true
+	}
+	assert.Len(t, seen, writers*writes)
+
+	restored := NewSharded(EngineSkipList, 4)
+	restored.Restore(s.Snapshot(1))
+	assert.Equal(t, s.values(), restored.values())
+
+	kv, err := restored.PutIf("new", "value", time.Time{}, model.Precondition{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(writers*writes+1), kv.Version)
+}
+
+func TestStore_ShardedBatch(t *testing.T) {
+	s := NewSharded(EngineMap, 16)
+
+	keys := make([]string, 32)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%02d", i)
+	}
+
+	// writers touch the same keys in opposite orders, so they would deadlock if shards weren't locked in order
+	const writers, writes = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				ops := make([]model.BatchOp, len(keys))
+				for j, key := range keys {
+					ops[j] = model.BatchOp{Type: model.OpPut, Key: key, Value: w}
+				}
+				if w%2 == 1 {
+					for j, k := 0, len(ops)-1; j < k; j, k = j+1, k-1 {
+						ops[j], ops[k] = ops[k], ops[j]
+					}
+				}
+
+				_, err := s.Batch(ops, nil)
+				require.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// every key was written by the same, last, batch
+	values := s.values()
+	require.Len(t, values, len(keys))
+	for _, key := range keys {
+		assert.Equal(t, values[keys[0]], values[key])
+	}
+}
+
+// BenchmarkStore_PutParallel writes distinct keys from parallel writers with increasing numbers of shards.
+func BenchmarkStore_PutParallel(b *testing.B) {
+	for _, e := range engines {
+		for _, shards := range []int{1, 4, 16, 64} {
+			b.Run(fmt.Sprintf("%s/shards=%d", e, shards), func(b *testing.B) {
+				s := NewSharded(e, shards)
+
+				// a fixed keyspace so the engines don't grow without bound as b.N increases
+				keys := make([]string, 1<<16)
+				for i := range keys {
+					keys[i] = fmt.Sprintf("key%08d", i)
+				}
+
+				var counter uint64
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						i := atomic.AddUint64(&counter, 1)
+						if err := s.Put(keys[i%uint64(len(keys))], "value"); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkStore_MixedParallel has parallel clients doing one write for every four reads.
+func BenchmarkStore_MixedParallel(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			s := NewSharded(EngineMap, shards)
+
+			keys := make([]string, 1<<16)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key%08d", i)
+				if err := s.Put(keys[i], "value"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			sort.Strings(keys)
+
+			var counter uint64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddUint64(&counter, 1)
+					key := keys[(i*7919)%uint64(len(keys))]
+					if i%5 == 0 {
+						if err := s.Put(key, "value"); err != nil {
+							b.Fatal(err)
+						}
+						continue
+					}
+					if _, err := s.GetKeyValue(key); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
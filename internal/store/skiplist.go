@@ -0,0 +1,125 @@
+package store
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// skipListMaxLevel is enough levels for billions of keys with skipListP of 1/4.
+	skipListMaxLevel = 16
+	// skipListP is the chance of a node also being linked on the level above.
+	skipListP = 0.25
+)
+
+// skipList is a sorted linked list with sparser lists layered above it, so a search skips most nodes by starting on
+// the top level and dropping down a level each time it would overshoot. See Pugh, "Skip Lists: A Probabilistic
+// Alternative to Balanced Trees".
+type skipList struct {
+	// head is a sentinel linked on every level.
+	head *skipNode
+	// level is the number of levels in use.
+	level  int
+	length int
+	rand   *rand.Rand
+}
+
+type skipNode struct {
+	key  string
+	item item
+	// next links the node on each of its levels.
+	next []*skipNode
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipNode{next: make([]*skipNode, skipListMaxLevel)},
+		level: 1,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// seek returns the first node at or after key, or nil if there isn't one.
+// If update is set it is filled with the last node before key on each level, which is where a new node is linked in.
+func (l *skipList) seek(key string, update []*skipNode) *skipNode {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+
+	return x.next[0]
+}
+
+func (l *skipList) get(key string) (item, bool) {
+	x := l.seek(key, nil)
+	if x == nil || x.key != key {
+		return item{}, false
+	}
+	return x.item, true
+}
+
+func (l *skipList) put(key string, it item) {
+	var update [skipListMaxLevel]*skipNode
+	x := l.seek(key, update[:])
+	if x != nil && x.key == key {
+		x.item = it
+		return
+	}
+
+	level := l.randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+		}
+		l.level = level
+	}
+
+	node := &skipNode{key: key, item: it, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	l.length++
+}
+
+func (l *skipList) delete(key string) {
+	var update [skipListMaxLevel]*skipNode
+	x := l.seek(key, update[:])
+	if x == nil || x.key != key {
+		return
+	}
+
+	for i := range x.next {
+		update[i].next[i] = x.next[i]
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.length--
+}
+
+func (l *skipList) len() int {
+	return l.length
+}
+
+func (l *skipList) ascend(start string, fn func(key string, it item) bool) {
+	for x := l.seek(start, nil); x != nil; x = x.next[0] {
+		if !fn(x.key, x.item) {
+			return
+		}
+	}
+}
+
+// randomLevel picks how many levels a new node is linked on, each level being skipListP as likely as the one below.
+func (l *skipList) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && l.rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
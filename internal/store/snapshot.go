@@ -0,0 +1,244 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/model"
+)
+
+// A snapshot file is laid out as:
+//
+//	header   snapshotMagic followed by the big endian format version
+//	body     uvarint sequence, uvarint store version, uvarint count, then count key values
+//	trailer  uint32 big endian CRC-32C of the header and body
+//
+// Format version 2 adds each key value's flags after its expiry, version 1 snapshots are still read.
+//
+// It is written to a temporary file and renamed into place, so a snapshot that exists is complete unless the disk
+// has corrupted it, which the trailer catches.
+const (
+	snapshotMagic         = "KVSSNP"
+	snapshotFormatVersion = 2
+	snapshotSuffix        = ".snap"
+)
+
+// Snapshot is a point in time copy of the store that covers the transaction log up to Sequence.
+type Snapshot struct {
+	Sequence uint64
+	// Version is the store's last handed out version.
+	Version   uint64
+	KeyValues []*model.KeyValue
+}
+
+// Snapshot will copy the contents of the store, which must include every logged event up to the sequence.
+// Shards are copied in turn, so writes made during the copy may or may not be included.
+func (s *Store) Snapshot(sequence uint64) *Snapshot {
+	snap := &Snapshot{
+		Sequence: sequence,
+	}
+
+	for _, sh := range s.shards {
+		sh.RLock()
+		sh.data.ascend("", func(key string, it item) bool {
+			snap.KeyValues = append(snap.KeyValues, &model.KeyValue{
+				Key:     key,
+				Value:   it.value,
+				Version: it.version,
+				Expires: sh.expires[key],
+				Flags:   it.flags,
+			})
+			return true
+		})
+		sh.RUnlock()
+	}
+
+	// read after the copy so it covers every version in it
+	snap.Version = atomic.LoadUint64(&s.version)
+
+	return snap
+}
+
+// Restore will replace the contents of the store with the snapshot.
+func (s *Store) Restore(snap *Snapshot) {
+	for _, sh := range s.shards {
+		sh.Lock()
+		defer sh.Unlock()
+
+		sh.data = newEngine(s.engine)
+		sh.expires = make(map[string]time.Time)
+	}
+	if s.summary != nil {
+		s.summary.reset()
+	}
+
+	now := time.Now()
+	for _, kv := range snap.KeyValues {
+		if kv.Expired(now) {
+			continue
+		}
+		s.shardFor(kv.Key).set(kv.Key, kv.Value, kv.Version, kv.Expires, kv.Flags)
+	}
+
+	atomic.StoreUint64(&s.version, snap.Version)
+}
+
+// writeSnapshot durably writes the snapshot to filename.
+func writeSnapshot(filename string, snap *Snapshot) error {
+	tmpName := filename + ".tmp"
+	tmp, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+
+	hash := crc32.New(crcTable)
+	writer := bufio.NewWriter(io.MultiWriter(tmp, hash))
+
+	header := make([]byte, len(snapshotMagic)+2)
+	copy(header, snapshotMagic)
+	binary.BigEndian.PutUint16(header[len(snapshotMagic):], snapshotFormatVersion)
+	writer.Write(header)
+
+	var buf []byte
+	buf = appendUvarint(buf, snap.Sequence)
+	buf = appendUvarint(buf, snap.Version)
+	buf = appendUvarint(buf, uint64(len(snap.KeyValues)))
+	writer.Write(buf)
+
+	for _, kv := range snap.KeyValues {
+		buf = buf[:0]
+		buf = appendString(buf, kv.Key)
+		buf = appendString(buf, valueString(kv.Value))
+		buf = appendUvarint(buf, kv.Version)
+		buf = appendVarint(buf, unixNano(kv.Expires))
+		buf = appendUvarint(buf, uint64(kv.Flags))
+		writer.Write(buf)
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	trailer := make([]byte, 4)
+	binary.BigEndian.PutUint32(trailer, hash.Sum32())
+	if _, err := tmp.Write(trailer); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(filename))
+}
+
+// readSnapshot reads and validates the snapshot at filename.
+func readSnapshot(filename string) (*Snapshot, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize := len(snapshotMagic) + 2
+	if len(data) < headerSize+4 {
+		return nil, errors.New("snapshot too short")
+	}
+
+	body, trailer := data[:len(data)-4], data[len(data)-4:]
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(trailer) {
+		return nil, errors.New("snapshot checksum mismatch")
+	}
+
+	if string(body[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("not a snapshot")
+	}
+	version := binary.BigEndian.Uint16(body[len(snapshotMagic):headerSize])
+	if version < 1 || version > snapshotFormatVersion {
+		return nil, fmt.Errorf("unsupported snapshot format version %d", version)
+	}
+
+	d := decoder{buf: body[headerSize:]}
+	snap := &Snapshot{
+		Sequence: d.uvarint(),
+		Version:  d.uvarint(),
+	}
+
+	count := d.uvarint()
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		kv := &model.KeyValue{
+			Key:     d.string(),
+			Value:   d.string(),
+			Version: d.uvarint(),
+		}
+		if expires := d.varint(); expires != 0 {
+			kv.Expires = time.Unix(0, expires).UTC()
+		}
+		if version >= 2 {
+			kv.Flags = uint32(d.uvarint())
+		}
+		snap.KeyValues = append(snap.KeyValues, kv)
+	}
+
+	if d.err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", d.err)
+	}
+
+	return snap, nil
+}
+
+// snapshotFilename returns the name of the snapshot of the log at filename covering up to sequence.
+func snapshotFilename(filename string, sequence uint64) string {
+	return fmt.Sprintf("%s.%020d%s", filename, sequence, snapshotSuffix)
+}
+
+// snapshotFiles returns the snapshots of the log at filename, newest first.
+func snapshotFiles(filename string) ([]string, error) {
+	matches, err := filepath.Glob(filename + ".*" + snapshotSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, match := range matches {
+		seq := strings.TrimSuffix(strings.TrimPrefix(match, filename+"."), snapshotSuffix)
+		if _, err := strconv.ParseUint(seq, 10, 64); err != nil {
+			continue
+		}
+		files = append(files, match)
+	}
+
+	// the sequence is zero padded, so a reverse lexical sort is newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files, nil
+}
+
+// valueString returns the stored value as it is written to disk.
+func valueString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
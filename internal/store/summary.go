@@ -0,0 +1,226 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/merkle"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+)
+
+// Tombstone is a deleted key, kept in the store's tree at the version it was deleted at so a repair from a peer that
+// missed the delete doesn't bring the key back.
+type Tombstone struct {
+	Key     string
+	Version uint64
+	Deleted time.Time
+}
+
+// summary keeps a Merkle tree of a store's keys and tombstones. It is changed as writes are applied, by the shard
+// holding the key under its lock, so it always matches the store.
+type summary struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	tree *merkle.Tree
+	// leaves holds the hash each key was added to the tree with, so it can be removed once the key changes.
+	leaves map[string]uint64
+	// buckets indexes the keys in the tree by their bucket.
+	buckets    map[int]map[string]bool
+	tombstones map[string]Tombstone
+}
+
+func newSummary(depth int, ttl time.Duration) *summary {
+	return &summary{
+		ttl:        ttl,
+		tree:       merkle.New(depth),
+		leaves:     make(map[string]uint64),
+		buckets:    make(map[int]map[string]bool),
+		tombstones: make(map[string]Tombstone),
+	}
+}
+
+// Summarise keeps a Merkle tree of the store's keys from now on, split into 2^depth buckets, along with tombstones of
+// the keys deleted within the ttl. It must be called before the store is shared.
+func (s *Store) Summarise(depth int, ttl time.Duration) {
+	s.summary = newSummary(depth, ttl)
+	for _, sh := range s.shards {
+		sh.Lock()
+		sh.summary = s.summary
+		sh.data.ascend("", func(key string, it item) bool {
+			sh.summary.set(key, it.value, it.version)
+			return true
+		})
+		sh.Unlock()
+	}
+}
+
+// TreeHashes returns the hashes of the nodes in the level of the store's tree, once the expired tombstones are
+// forgotten. The store must be summarised.
+func (s *Store) TreeHashes(level int, nodes []int) []uint64 {
+	return s.summary.hashes(level, nodes, time.Now())
+}
+
+// TreeEntries returns the key values and tombstones in the buckets of the store's tree. The keys are looked up once
+// the summary's lock is released, so writes aren't held up. The store must be summarised.
+func (s *Store) TreeEntries(buckets []int) ([]*model.KeyValue, []Tombstone) {
+	var (
+		kvs        []*model.KeyValue
+		tombstones []Tombstone
+	)
+	for _, key := range s.summary.keys(buckets, time.Now()) {
+		if kv, err := s.GetKeyValue(key); err == nil {
+			kvs = append(kvs, kv)
+			continue
+		}
+		// an expired key not yet reaped has no entry
+		if t, ok := s.Tombstone(key); ok {
+			tombstones = append(tombstones, t)
+		}
+	}
+
+	return kvs, tombstones
+}
+
+// Tombstone returns the key's tombstone, if it was deleted within the ttl and hasn't been written since. The store
+// must be summarised.
+func (s *Store) Tombstone(key string) (Tombstone, bool) {
+	s.summary.mu.Lock()
+	defer s.summary.mu.Unlock()
+
+	t, ok := s.summary.tombstones[key]
+	return t, ok
+}
+
+// Bury records the key as deleted at the version, e.g. one a peer deleted, unless it exists or already has a later
+// tombstone. The store must be summarised.
+func (s *Store) Bury(key string, version uint64) {
+	sh := s.shardFor(key)
+	sh.Lock()
+	defer sh.Unlock()
+
+	if _, ok := sh.current(key, time.Now()); ok {
+		return
+	}
+	s.advanceVersion(version)
+	sh.bury(key, version)
+}
+
+// set makes the key's value at the version its entry, forgetting its tombstone.
+func (sm *summary) set(key string, value interface{}, version uint64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	s, _ := value.(string)
+	delete(sm.tombstones, key)
+	sm.replace(key, merkle.EntryHash(key, version, s, false))
+}
+
+// bury makes a tombstone at the version the key's entry, unless it already has a later one.
+func (sm *summary) bury(key string, version uint64, now time.Time) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if t, ok := sm.tombstones[key]; ok && t.Version >= version {
+		return
+	}
+	sm.tombstones[key] = Tombstone{Key: key, Version: version, Deleted: now}
+	sm.replace(key, merkle.EntryHash(key, version, "", true))
+}
+
+// remove removes the value of a key that has gone without being deleted, e.g. expired, from the tree.
+func (sm *summary) remove(key string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, ok := sm.tombstones[key]; !ok {
+		sm.forget(key)
+	}
+}
+
+// replace replaces the key's entry in the tree with the hash, the caller must hold mu.
+func (sm *summary) replace(key string, hash uint64) {
+	sm.forget(key)
+
+	sm.tree.Add(key, hash)
+	sm.leaves[key] = hash
+
+	bucket := merkle.Bucket(key, sm.tree.Depth())
+	if sm.buckets[bucket] == nil {
+		sm.buckets[bucket] = make(map[string]bool)
+	}
+	sm.buckets[bucket][key] = true
+}
+
+// forget removes the key's entry from the tree, the caller must hold mu.
+func (sm *summary) forget(key string) {
+	hash, ok := sm.leaves[key]
+	if !ok {
+		return
+	}
+
+	sm.tree.Remove(key, hash)
+	delete(sm.leaves, key)
+
+	bucket := merkle.Bucket(key, sm.tree.Depth())
+	delete(sm.buckets[bucket], key)
+	if len(sm.buckets[bucket]) == 0 {
+		delete(sm.buckets, bucket)
+	}
+}
+
+// sweep forgets the tombstones older than the ttl, the caller must hold mu.
+func (sm *summary) sweep(now time.Time) {
+	for key, t := range sm.tombstones {
+		if now.Sub(t.Deleted) > sm.ttl {
+			delete(sm.tombstones, key)
+			sm.forget(key)
+		}
+	}
+}
+
+// hashes returns the hashes of the nodes in the level of the tree, once the tombstones expired by now are forgotten.
+func (sm *summary) hashes(level int, nodes []int, now time.Time) []uint64 {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.sweep(now)
+	hashes := make([]uint64, len(nodes))
+	for i, node := range nodes {
+		hashes[i] = sm.tree.Hash(level, node)
+	}
+
+	return hashes
+}
+
+// keys returns the keys in the buckets of the tree, once the tombstones expired by now are forgotten.
+func (sm *summary) keys(buckets []int, now time.Time) []string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.sweep(now)
+	var keys []string
+	seen := make(map[int]bool, len(buckets))
+	for _, bucket := range buckets {
+		if seen[bucket] {
+			continue
+		}
+		seen[bucket] = true
+
+		for key := range sm.buckets[bucket] {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// reset empties the tree, e.g. before the store is restored from a snapshot.
+func (sm *summary) reset() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.tree = merkle.New(sm.tree.Depth())
+	sm.leaves = make(map[string]uint64)
+	sm.buckets = make(map[int]map[string]bool)
+	sm.tombstones = make(map[string]Tombstone)
+}
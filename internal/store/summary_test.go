@@ -0,0 +1,137 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/model"
+)
+
+func TestStore_Summarise(t *testing.T) {
+	tests := map[string]struct {
+		ttl time.Duration
+		// write changes the store, want gives the other store the same tree
+		write func(t *testing.T, s *Store)
+		want  func(t *testing.T, s *Store)
+	}{
+		"overwritten": {
+			write: func(t *testing.T, s *Store) {
+				require.NoError(t, s.Put("k", "old"))
+				require.NoError(t, s.Put("k", "new"))
+			},
+			want: func(t *testing.T, s *Store) {
+				require.NoError(t, s.Apply(Event{EventType: EventPut, Key: "k", Value: "new", Version: 2}))
+			},
+		},
+		"deleted": {
+			write: func(t *testing.T, s *Store) {
+				require.NoError(t, s.Put("k", "v"))
+				require.NoError(t, s.Delete("k"))
+			},
+			want: func(t *testing.T, s *Store) {
+				s.Bury("k", 1)
+			},
+		},
+		"deleted in a batch": {
+			write: func(t *testing.T, s *Store) {
+				require.NoError(t, s.Put("k1", "v"))
+				_, err := s.Batch([]model.BatchOp{
+					{Type: model.OpPut, Key: "k2", Value: "v"},
+					{Type: model.OpDelete, Key: "k1"},
+				}, nil)
+				require.NoError(t, err)
+			},
+			want: func(t *testing.T, s *Store) {
+				require.NoError(t, s.Apply(Event{EventType: EventPut, Key: "k2", Value: "v", Version: 2}))
+				require.NoError(t, s.Apply(Event{EventType: EventDelete, Key: "k1", Version: 2}))
+			},
+		},
+		"written after delete": {
+			write: func(t *testing.T, s *Store) {
+				require.NoError(t, s.Put("k", "old"))
+				require.NoError(t, s.Delete("k"))
+				require.NoError(t, s.Put("k", "new"))
+			},
+			want: func(t *testing.T, s *Store) {
+				require.NoError(t, s.Apply(Event{EventType: EventPut, Key: "k", Value: "new", Version: 2}))
+			},
+		},
+		"reaped": {
+			write: func(t *testing.T, s *Store) {
+				require.NoError(t, s.PutWithExpiry("k", "v", time.Now().Add(time.Millisecond)))
+				s.Reap(time.Now().Add(time.Second))
+			},
+			want: func(t *testing.T, s *Store) {},
+		},
+		"tombstone expired": {
+			ttl: time.Nanosecond,
+			write: func(t *testing.T, s *Store) {
+				require.NoError(t, s.Put("k", "v"))
+				require.NoError(t, s.Delete("k"))
+				time.Sleep(time.Millisecond)
+			},
+			want: func(t *testing.T, s *Store) {},
+		},
+		"restored": {
+			write: func(t *testing.T, s *Store) {
+				require.NoError(t, s.Put("old", "v"))
+				s.Restore(&Snapshot{Version: 1, KeyValues: []*model.KeyValue{{Key: "k", Value: "v", Version: 1}}})
+			},
+			want: func(t *testing.T, s *Store) {
+				require.NoError(t, s.Put("k", "v"))
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ttl := test.ttl
+			if ttl == 0 {
+				ttl = time.Hour
+			}
+
+			got, want := NewSharded(EngineMap, 4), New(make(map[string]interface{}))
+			got.Summarise(4, ttl)
+			want.Summarise(4, ttl)
+
+			test.write(t, got)
+			test.want(t, want)
+
+			leaves := make([]int, 16)
+			for i := range leaves {
+				leaves[i] = i
+			}
+			assert.Equal(t, want.TreeHashes(0, []int{0}), got.TreeHashes(0, []int{0}))
+			assert.Equal(t, want.TreeHashes(4, leaves), got.TreeHashes(4, leaves))
+		})
+	}
+}
+
+func TestStore_TreeEntries(t *testing.T) {
+	s := New(map[string]interface{}{"existing": "v"})
+	s.Summarise(4, time.Hour)
+
+	require.NoError(t, s.Put("k1", "v1"))
+	require.NoError(t, s.Put("k2", "v2"))
+	require.NoError(t, s.Delete("k2"))
+	require.NoError(t, s.PutWithExpiry("expired", "v", time.Now().Add(-time.Second)))
+
+	tombstone, ok := s.Tombstone("k2")
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), tombstone.Version)
+
+	buckets := make([]int, 16)
+	for i := range buckets {
+		buckets[i] = i
+	}
+	kvs, tombstones := s.TreeEntries(buckets)
+
+	var keys []string
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	assert.ElementsMatch(t, []string{"existing", "k1"}, keys)
+	require.Len(t, tombstones, 1)
+	assert.Equal(t, "k2", tombstones[0].Key)
+}
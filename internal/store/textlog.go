@@ -0,0 +1,161 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// textLogBackupSuffix is appended to a text log's filename once it has been migrated to the binary format.
+const textLogBackupSuffix = ".text.bak"
+
+// migrateTextLog rewrites the tab separated text log at filename in the binary format.
+// The new log is written alongside and renamed into place, so a crash part way through leaves the text log intact.
+func migrateTextLog(filename string) error {
+	events, err := readTextLog(filename)
+	if err != nil {
+		return err
+	}
+
+	tmpName := filename + ".migrating"
+	tmp, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+
+	writer := bufio.NewWriter(tmp)
+	writer.Write(logHeader())
+	for _, e := range events {
+		writer.Write(encodeRecord(e))
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(filename, filename+textLogBackupSuffix); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(filename))
+}
+
+// readTextLog reads every event from a text log.
+// An unparsable final line is assumed to be torn and is dropped.
+func readTextLog(filename string) ([]Event, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		events       []Event
+		lastSequence uint64
+		parseErr     error
+	)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if parseErr != nil {
+			// the bad line wasn't the last one
+			return nil, parseErr
+		}
+
+		e, err := parseEvent(scanner.Text())
+		if err != nil {
+			parseErr = fmt.Errorf("input parse error: %w", err)
+			continue
+		}
+
+		if lastSequence >= e.Sequence {
+			return nil, fmt.Errorf("transaction out of sequence order")
+		}
+		lastSequence = e.Sequence
+
+		events = append(events, e)
+	}
+
+	return events, scanner.Err()
+}
+
+// parseEvent parses a single log line of "sequence\ttype\tkey\tvalue\texpires\tversion".
+// Lines written before expiry or versions were recorded are missing the trailing fields.
+func parseEvent(line string) (Event, error) {
+	var e Event
+
+	fields := strings.Split(line, "\t")
+	if len(fields) < 4 {
+		return e, fmt.Errorf("expected at least 4 fields, got %d", len(fields))
+	}
+
+	seq, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return e, fmt.Errorf("invalid sequence: %w", err)
+	}
+
+	eventType, err := strconv.ParseUint(fields[1], 10, 8)
+	if err != nil {
+		return e, fmt.Errorf("invalid event type: %w", err)
+	}
+
+	e.Sequence = seq
+	e.EventType = EventType(eventType)
+	e.Key = fields[2]
+
+	var trailing []string
+	switch {
+	case len(fields) == 4:
+	case len(fields) == 5:
+		trailing = fields[4:]
+	default:
+		trailing = fields[len(fields)-2:]
+	}
+	e.Value = strings.Join(fields[3:len(fields)-len(trailing)], "\t")
+
+	if len(trailing) > 0 {
+		expires, err := strconv.ParseInt(trailing[0], 10, 64)
+		if err != nil {
+			return e, fmt.Errorf("invalid expiry: %w", err)
+		}
+		if expires != 0 {
+			e.Expires = time.Unix(0, expires).UTC()
+		}
+	}
+
+	if len(trailing) > 1 {
+		e.Version, err = strconv.ParseUint(trailing[1], 10, 64)
+		if err != nil {
+			return e, fmt.Errorf("invalid version: %w", err)
+		}
+	}
+
+	return e, nil
+}
+
+// syncDir flushes directory entries such as renames to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -1,11 +1,29 @@
 package store
 
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrLoggerClosed is returned when writing to a transaction logger that has been closed.
+	ErrLoggerClosed = errors.New("transaction logger closed")
+	// ErrLoggerUnhealthy is returned for every write once a transaction logger has failed to write an event.
+	ErrLoggerUnhealthy = errors.New("transaction logger unhealthy")
+	// ErrCompacted is returned when reading events after a sequence the transaction log no longer goes back to.
+	ErrCompacted = errors.New("transaction log compacted")
+)
+
 type EventType byte
 
 const (
 	_                     = iota
 	EventDelete EventType = iota
 	EventPut
+	EventExpire
+	EventBatch
 )
 
 type Event struct {
@@ -13,4 +31,86 @@ type Event struct {
 	EventType EventType
 	Key       string
 	Value     string
+
+	// Version is the version the store gave a put key.
+	Version uint64
+
+	// Expires is the deadline of a put key, or the deadline that was reached for an expire event.
+	Expires time.Time
+
+	// Flags are the flags kept with a put key's value.
+	Flags uint32
+
+	// Ops are the put and delete events of a batch event, which is applied all together or not at all.
+	Ops []Event
+}
+
+// pendingEvent is an event waiting to be written by a transaction logger.
+// done receives the result once the event is durable under the logger's policy.
+type pendingEvent struct {
+	Event
+	done chan error
+}
+
+// eventQueue hands pending events to a transaction logger's writer goroutine.
+// It can be closed while writers are still sending, after which writes fail with ErrLoggerClosed.
+type eventQueue struct {
+	// pending counts writes waiting to be committed, accessed atomically.
+	pending int64
+
+	mu     sync.RWMutex
+	events chan pendingEvent
+	closed bool
+}
+
+func newEventQueue(size int) *eventQueue {
+	return &eventQueue{
+		events: make(chan pendingEvent, size),
+	}
+}
+
+// write queues the event and blocks until the writer goroutine reports the result.
+func (q *eventQueue) write(e Event) error {
+	// a buffered done channel so the writer never blocks acknowledging the event
+	p := pendingEvent{Event: e, done: make(chan error, 1)}
+
+	atomic.AddInt64(&q.pending, 1)
+	defer atomic.AddInt64(&q.pending, -1)
+
+	q.mu.RLock()
+	if q.closed {
+		q.mu.RUnlock()
+		return ErrLoggerClosed
+	}
+	q.events <- p
+	q.mu.RUnlock()
+
+	return <-p.done
+}
+
+// depth returns how many writes are waiting to be committed, whether queued or blocked on a full queue.
+func (q *eventQueue) depth() int {
+	if q == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&q.pending))
+}
+
+// close stops accepting events, the writer goroutine sees the channel close once it has drained what was queued.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+}
+
+// unixNano returns t as nanoseconds since the epoch, or 0 for the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
 }
@@ -0,0 +1,101 @@
+// Package testutil holds helpers shared by the tests of the other packages.
+package testutil
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+// Logger is a transaction logger that records the events written to it, failing every write with WriteErr if it is set.
+// Only the write methods and Close are implemented.
+type Logger struct {
+	api.TransactionLogger
+
+	WriteErr error
+	// OnWrite is called with every event written without an error, if set, e.g. to observe it as committed.
+	OnWrite func(store.Event)
+
+	mu     sync.Mutex
+	events []store.Event
+}
+
+func (l *Logger) WritePut(key string, value string, version uint64, expires time.Time) error {
+	return l.WriteBatch([]store.Event{{EventType: store.EventPut, Key: key, Value: value, Version: version, Expires: expires}})
+}
+
+func (l *Logger) WriteDelete(key string) error {
+	return l.WriteBatch([]store.Event{{EventType: store.EventDelete, Key: key}})
+}
+
+func (l *Logger) WriteBatch(ops []store.Event) error {
+	l.mu.Lock()
+	l.events = append(l.events, ops...)
+	l.mu.Unlock()
+
+	if l.WriteErr != nil {
+		return l.WriteErr
+	}
+
+	if l.OnWrite != nil {
+		for _, op := range ops {
+			l.OnWrite(op)
+		}
+	}
+	return nil
+}
+
+func (l *Logger) Close() error {
+	return nil
+}
+
+// Keys returns the key of every event written, in order.
+func (l *Logger) Keys() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var keys []string
+	for _, e := range l.events {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+// Ops returns every event written in order, as "put <key> <value>" or "delete <key>".
+func (l *Logger) Ops() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var ops []string
+	for _, e := range l.events {
+		if e.EventType == store.EventDelete {
+			ops = append(ops, "delete "+e.Key)
+			continue
+		}
+		ops = append(ops, "put "+e.Key+" "+e.Value)
+	}
+	return ops
+}
+
+// Live replays the events written, returning the keys they leave in sorted order.
+func (l *Logger) Live() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	live := make(map[string]bool)
+	for _, e := range l.events {
+		live[e.Key] = e.EventType == store.EventPut
+	}
+
+	var keys []string
+	for key, ok := range live {
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
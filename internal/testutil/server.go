@@ -0,0 +1,33 @@
+package testutil
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ready is a readiness check that always passes.
+func Ready(context.Context) error { return nil }
+
+// Server serves the connections accepted from a listener until it is shut down, like a tcpserver.Server.
+type Server interface {
+	Serve(listener net.Listener) error
+	Shutdown(ctx context.Context) error
+}
+
+// Dial serves the server on a loopback port until the test ends, returning a connection to it.
+func Dial(t *testing.T, server Server) net.Conn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
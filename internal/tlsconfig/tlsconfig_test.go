@@ -0,0 +1,303 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t, "ca")
+	certFile, keyFile := ca.issue(t, dir, "server", 1)
+	caFile := ca.writeCert(t, dir)
+
+	tests := map[string]struct {
+		config      Config
+		errContains string
+	}{
+		"defaults": {
+			config: Config{CertFile: certFile, KeyFile: keyFile},
+		},
+		"mutual tls": {
+			config: Config{
+				CertFile:     certFile,
+				KeyFile:      keyFile,
+				ClientCAFile: caFile,
+				ClientAuth:   ClientAuthRequire,
+				MinVersion:   "1.3",
+				CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			},
+		},
+		"missing key file": {
+			config:      Config{CertFile: certFile},
+			errContains: "cert file and key file are required",
+		},
+		"cert file doesn't exist": {
+			config:      Config{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: keyFile},
+			errContains: "no such file",
+		},
+		"unsupported min version": {
+			config:      Config{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.0"},
+			errContains: "unsupported minimum version",
+		},
+		"insecure cipher suite": {
+			config:      Config{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			errContains: "unknown or insecure cipher suite",
+		},
+		"client auth without CA": {
+			config:      Config{CertFile: certFile, KeyFile: keyFile, ClientAuth: ClientAuthRequire},
+			errContains: "needs a client CA file",
+		},
+		"unknown client auth": {
+			config:      Config{CertFile: certFile, KeyFile: keyFile, ClientAuth: "sometimes"},
+			errContains: "unknown client auth",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewReloader(test.config)
+			if test.errContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.errContains)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestReloader_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t, "ca")
+	certFile, keyFile := ca.issue(t, dir, "server", 1)
+
+	r, err := NewReloader(Config{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+
+	addr := serve(t, r.TLSConfig())
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	state, err := handshake(addr, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), state.PeerCertificates[0].SerialNumber.Int64())
+	assert.False(t, r.changed())
+
+	// a half written key pair keeps the current certificate
+	require.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0600))
+	require.True(t, r.changed())
+	require.Error(t, r.Reload())
+
+	ca.issue(t, dir, "server", 2)
+	require.True(t, r.changed())
+	require.NoError(t, r.Reload())
+	assert.False(t, r.changed())
+
+	state, err = handshake(addr, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), state.PeerCertificates[0].SerialNumber.Int64())
+}
+
+func TestReloader_ClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	serverCA := newCA(t, "server-ca")
+	certFile, keyFile := serverCA.issue(t, dir, "server", 1)
+
+	clientCA := newCA(t, "client-ca")
+	caFile := clientCA.writeCert(t, dir)
+	clientCert := clientCA.keyPair(t, "client", 2)
+	otherCert := newCA(t, "other-ca").keyPair(t, "client", 3)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+
+	tests := map[string]struct {
+		clientAuth ClientAuth
+		clientCert *tls.Certificate
+		expectErr  bool
+	}{
+		"required and presented": {
+			clientAuth: ClientAuthRequire,
+			clientCert: &clientCert,
+		},
+		"required but missing": {
+			clientAuth: ClientAuthRequire,
+			expectErr:  true,
+		},
+		"required but signed by another CA": {
+			clientAuth: ClientAuthRequire,
+			clientCert: &otherCert,
+			expectErr:  true,
+		},
+		"requested but missing": {
+			clientAuth: ClientAuthRequest,
+		},
+		"requested but signed by another CA": {
+			clientAuth: ClientAuthRequest,
+			clientCert: &otherCert,
+			expectErr:  true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewReloader(Config{
+				CertFile:     certFile,
+				KeyFile:      keyFile,
+				ClientCAFile: caFile,
+				ClientAuth:   test.clientAuth,
+			})
+			require.NoError(t, err)
+
+			addr := serve(t, r.TLSConfig())
+			_, err = handshake(addr, &tls.Config{
+				RootCAs:    roots,
+				ServerName: "localhost",
+				// always present the certificate, even when it isn't signed by a CA the server accepts
+				GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					if test.clientCert == nil {
+						return &tls.Certificate{}, nil
+					}
+					return test.clientCert, nil
+				},
+			})
+			if test.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key}
+}
+
+// sign creates a leaf certificate valid for localhost, returning the PEM encoded certificate and key.
+func (ca *testCA) sign(t *testing.T, name string, serial int64) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// issue writes a signed key pair into dir, overwriting any earlier pair with the same name.
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64) (string, string) {
+	certPEM, keyPEM := ca.sign(t, name, serial)
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+
+	return certFile, keyFile
+}
+
+func (ca *testCA) keyPair(t *testing.T, name string, serial int64) tls.Certificate {
+	cert, err := tls.X509KeyPair(ca.sign(t, name, serial))
+	require.NoError(t, err)
+
+	return cert
+}
+
+func (ca *testCA) writeCert(t *testing.T, dir string) string {
+	filename := filepath.Join(dir, ca.cert.Subject.CommonName+".pem")
+	require.NoError(t, os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600))
+
+	return filename
+}
+
+// serve accepts TLS connections, completing the handshake and closing them.
+func serve(t *testing.T, config *tls.Config) string {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// handshake connects to addr, reading from the connection so a rejected client certificate is reported under TLS 1.3.
+func handshake(addr string, config *tls.Config) (tls.ConnectionState, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr, config)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) {
+		return tls.ConnectionState{}, err
+	}
+
+	return conn.ConnectionState(), nil
+}
@@ -0,0 +1,259 @@
+package watch
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/warrenb95/cloud-native-go/internal/store"
+)
+
+var (
+	// ErrCompacted is returned when resuming from a sequence older than the hub's history.
+	ErrCompacted = errors.New("sequence is no longer in the watch history")
+	// ErrFutureSequence is returned when resuming from a sequence the log hasn't reached.
+	ErrFutureSequence = errors.New("sequence is ahead of the transaction log")
+	// ErrFellBehind is returned to a watcher that was dropped for not keeping up with the events.
+	ErrFellBehind = errors.New("watcher fell behind")
+	// ErrClosed is returned to every watcher once the hub is closed.
+	ErrClosed = errors.New("watch hub closed")
+)
+
+// Hub fans committed transaction log events out to watchers. It keeps the most recent events so a watcher can resume
+// from the last sequence it saw.
+type Hub struct {
+	mu sync.Mutex
+	// history is a ring of the most recent events, the oldest at first.
+	history []store.Event
+	first   int
+	size    int
+	// last is the sequence of the last event published, or of the last event already in the log when started.
+	last     uint64
+	watchers map[*Watcher]struct{}
+	closed   bool
+}
+
+// NewHub creates a hub that keeps the last history events for watchers to resume from.
+func NewHub(history int) *Hub {
+	if history < 1 {
+		history = 1
+	}
+
+	return &Hub{
+		history:  make([]store.Event, history),
+		watchers: make(map[*Watcher]struct{}),
+	}
+}
+
+// Start records the sequence of the last event already in the log, which is as far back as watchers can resume
+// until events are published. It must be called before Publish.
+func (h *Hub) Start(sequence uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.last = sequence
+}
+
+// Publish adds the event to the history and queues it for every watcher of a matching key.
+// It never blocks, a watcher that has fallen a whole history behind is dropped with ErrFellBehind.
+func (h *Hub) Publish(e store.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	if h.size < len(h.history) {
+		h.history[(h.first+h.size)%len(h.history)] = e
+		h.size++
+	} else {
+		h.history[h.first] = e
+		h.first = (h.first + 1) % len(h.history)
+	}
+	h.last = e.Sequence
+
+	for w := range h.watchers {
+		matched, ok := filter(e, w.prefix)
+		if !ok {
+			continue
+		}
+
+		if !w.push(matched, len(h.history)) {
+			delete(h.watchers, w)
+		}
+	}
+}
+
+// Last returns the sequence of the last event published, watching after it gives only the events that follow.
+func (h *Hub) Last() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.last
+}
+
+// Watch returns a watcher for the keys with the prefix, given every event after the sequence.
+func (h *Hub) Watch(prefix string, after uint64) (*Watcher, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrClosed
+	}
+
+	if after > h.last {
+		return nil, ErrFutureSequence
+	}
+
+	oldest := h.last + 1
+	if h.size > 0 {
+		oldest = h.history[h.first].Sequence
+	}
+	if after+1 < oldest {
+		return nil, ErrCompacted
+	}
+
+	w := &Watcher{
+		hub:    h,
+		prefix: prefix,
+		notify: make(chan struct{}, 1),
+	}
+	for i := 0; i < h.size; i++ {
+		e := h.history[(h.first+i)%len(h.history)]
+		if e.Sequence <= after {
+			continue
+		}
+		if matched, ok := filter(e, prefix); ok {
+			w.pending = append(w.pending, matched)
+		}
+	}
+	if len(w.pending) > 0 {
+		w.notify <- struct{}{}
+	}
+
+	h.watchers[w] = struct{}{}
+
+	return w, nil
+}
+
+// Watchers returns how many watchers are connected.
+func (h *Hub) Watchers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.watchers)
+}
+
+// Close drops every watcher with ErrClosed, no more watchers can be added after it is called.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for w := range h.watchers {
+		w.drop(ErrClosed)
+		delete(h.watchers, w)
+	}
+}
+
+// Watcher receives the events for keys with its prefix in sequence order.
+type Watcher struct {
+	hub    *Hub
+	prefix string
+
+	mu      sync.Mutex
+	pending []store.Event
+	// err is set once the watcher has been dropped, it is returned after the pending events.
+	err error
+	// notify has a value whenever there are pending events or an error.
+	notify chan struct{}
+}
+
+// Ready receives a value when Next has events or an error to return.
+func (w *Watcher) Ready() <-chan struct{} {
+	return w.notify
+}
+
+// Next returns the pending events, or why the watcher was dropped once there are none left.
+func (w *Watcher) Next() ([]store.Event, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) == 0 {
+		return nil, w.err
+	}
+
+	events := w.pending
+	w.pending = nil
+	if w.err != nil {
+		// keep Ready firing until the error has been seen
+		w.signal()
+	}
+
+	return events, nil
+}
+
+// Close stops the watcher receiving events.
+func (w *Watcher) Close() {
+	w.hub.mu.Lock()
+	defer w.hub.mu.Unlock()
+
+	delete(w.hub.watchers, w)
+}
+
+// push queues the event, reporting false and dropping the watcher if it already has limit events pending.
+// The hub's lock must be held.
+func (w *Watcher) push(e store.Event, limit int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) >= limit {
+		w.err = ErrFellBehind
+		w.signal()
+		return false
+	}
+
+	w.pending = append(w.pending, e)
+	w.signal()
+
+	return true
+}
+
+// drop ends the watcher with err once its pending events have been read.
+func (w *Watcher) drop(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.err = err
+	w.signal()
+}
+
+// signal wakes Ready without blocking, the watcher's lock must be held.
+func (w *Watcher) signal() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// filter returns the event if it is for a key with the prefix. A batch is returned with only its matching operations,
+// or not at all if none match.
+func filter(e store.Event, prefix string) (store.Event, bool) {
+	if e.EventType != store.EventBatch {
+		return e, strings.HasPrefix(e.Key, prefix)
+	}
+
+	var ops []store.Event
+	for _, op := range e.Ops {
+		if strings.HasPrefix(op.Key, prefix) {
+			ops = append(ops, op)
+		}
+	}
+	if len(ops) == 0 {
+		return e, false
+	}
+
+	e.Ops = ops
+	return e, true
+}
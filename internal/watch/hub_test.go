@@ -0,0 +1,142 @@
+package watch_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/watch"
+)
+
+func put(sequence uint64, key string) store.Event {
+	return store.Event{Sequence: sequence, EventType: store.EventPut, Key: key, Value: "value", Version: sequence}
+}
+
+// keys returns the key of every event, or of every operation for a batch.
+func keys(events []store.Event) []string {
+	var ks []string
+	for _, e := range events {
+		if e.EventType == store.EventBatch {
+			ks = append(ks, keys(e.Ops)...)
+			continue
+		}
+		ks = append(ks, e.Key)
+	}
+	return ks
+}
+
+func TestHub_Watch(t *testing.T) {
+	tests := map[string]struct {
+		prefix       string
+		after        uint64
+		expectedKeys []string
+		expectedErr  error
+	}{
+		"resume from start of history": {
+			after:        10,
+			expectedKeys: []string{"order:1", "user:2", "order:3", "user:3", "user:4", "order:2"},
+		},
+		"resume with prefix": {
+			prefix:       "user:",
+			after:        11,
+			expectedKeys: []string{"user:2", "user:3", "user:4"},
+		},
+		"batches filtered by prefix": {
+			prefix:       "order:",
+			after:        12,
+			expectedKeys: []string{"order:3", "order:2"},
+		},
+		"resume before history": {
+			after:       9,
+			expectedErr: watch.ErrCompacted,
+		},
+		"resume from last": {
+			after: 14,
+		},
+		"resume from future": {
+			after:       15,
+			expectedErr: watch.ErrFutureSequence,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// the history holds 4 events so the first is dropped
+			hub := watch.NewHub(4)
+			hub.Start(9)
+			hub.Publish(put(10, "user:1"))
+			hub.Publish(put(11, "order:1"))
+			hub.Publish(put(12, "user:2"))
+			hub.Publish(store.Event{Sequence: 13, EventType: store.EventBatch, Ops: []store.Event{
+				put(0, "order:3"), put(0, "user:3"),
+			}})
+			hub.Publish(store.Event{Sequence: 14, EventType: store.EventBatch, Ops: []store.Event{
+				put(0, "user:4"), put(0, "order:2"),
+			}})
+
+			w, err := hub.Watch(test.prefix, test.after)
+			if test.expectedErr != nil {
+				require.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			defer w.Close()
+
+			events, err := w.Next()
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedKeys, keys(events))
+		})
+	}
+}
+
+func TestHub_Publish(t *testing.T) {
+	hub := watch.NewHub(2)
+	hub.Start(0)
+
+	w, err := hub.Watch("user:", hub.Last())
+	require.NoError(t, err)
+	defer w.Close()
+
+	hub.Publish(put(1, "user:1"))
+	hub.Publish(put(2, "order:1"))
+	hub.Publish(put(3, "user:2"))
+
+	<-w.Ready()
+	events, err := w.Next()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user:1", "user:2"}, keys(events))
+	assert.Equal(t, uint64(3), events[1].Sequence)
+
+	// a watcher that falls a whole history behind is dropped once it has read what it was sent
+	hub.Publish(put(4, "user:3"))
+	hub.Publish(put(5, "user:4"))
+	hub.Publish(put(6, "user:5"))
+	assert.Equal(t, 0, hub.Watchers())
+
+	<-w.Ready()
+	events, err = w.Next()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user:3", "user:4"}, keys(events))
+
+	<-w.Ready()
+	_, err = w.Next()
+	assert.ErrorIs(t, err, watch.ErrFellBehind)
+}
+
+func TestHub_Close(t *testing.T) {
+	hub := watch.NewHub(2)
+
+	w, err := hub.Watch("", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, hub.Watchers())
+
+	hub.Close()
+	assert.Equal(t, 0, hub.Watchers())
+
+	<-w.Ready()
+	_, err = w.Next()
+	assert.ErrorIs(t, err, watch.ErrClosed)
+
+	_, err = hub.Watch("", 0)
+	assert.ErrorIs(t, err, watch.ErrClosed)
+}
@@ -1,69 +1,629 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/warrenb95/cloud-native-go/internal/api"
+	"github.com/warrenb95/cloud-native-go/internal/api/pb"
 	"github.com/warrenb95/cloud-native-go/internal/cache"
+	"github.com/warrenb95/cloud-native-go/internal/clusterkv"
+	"github.com/warrenb95/cloud-native-go/internal/config"
+	"github.com/warrenb95/cloud-native-go/internal/gossip"
+	"github.com/warrenb95/cloud-native-go/internal/health"
+	"github.com/warrenb95/cloud-native-go/internal/memcache"
+	"github.com/warrenb95/cloud-native-go/internal/metrics"
 	"github.com/warrenb95/cloud-native-go/internal/middleware"
+	"github.com/warrenb95/cloud-native-go/internal/raft"
+	"github.com/warrenb95/cloud-native-go/internal/raftkv"
+	"github.com/warrenb95/cloud-native-go/internal/repair"
+	"github.com/warrenb95/cloud-native-go/internal/replication"
+	"github.com/warrenb95/cloud-native-go/internal/resp"
 	"github.com/warrenb95/cloud-native-go/internal/store"
+	"github.com/warrenb95/cloud-native-go/internal/tlsconfig"
+	"github.com/warrenb95/cloud-native-go/internal/watch"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
+// healthCheckTimeout bounds how long a probe waits on the readiness checks.
+const healthCheckTimeout = 2 * time.Second
+
 func main() {
-	r := mux.NewRouter()
-	memStore := store.New(make(map[string]interface{}))
+	configFile := flag.String("config", os.Getenv("KVS_CONFIG"), "path to the YAML config file, defaults are used if empty")
+	flag.Parse()
 
-	cache, err := cache.NewLRUCache(25, memStore)
+	conf, err := config.Load(*configFile)
 	if err != nil {
-		log.Fatalf("cannot create cache: %v", err)
+		log.Fatal(err)
 	}
 
-	logger, err := initTransactionLogger(cache)
+	// Background work gets its own context so it keeps running until in-flight requests have finished.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	bgCtx, cancelBackground := context.WithCancel(context.Background())
+	defer cancelBackground()
+
+	registry := metrics.NewRegistry()
+	memStore := store.NewSharded(store.Engine(conf.Store.Engine), conf.Store.Shards)
+
+	cache, err := cache.NewLRUCache(conf.Cache.Capacity, memStore)
 	if err != nil {
+		log.Fatalf("cannot create cache: %v", err)
+	}
+
+	// every API serves the cache, the replicated store whose writes go through the cluster, a replica's store that
+	// only the primary's events change, or the partitioned store whose keys are spread over the cluster
+	var (
+		served     resp.Store = cache
+		replicated *raftkv.Store
+		follower   *replication.Follower
+		clustered  *clusterkv.Store
+		logger     api.TransactionLogger
+	)
+	if conf.RaftEnabled() {
+		replicated, err = newReplicatedStore(conf, memStore)
+		if err != nil {
+			log.Fatalf("cannot create replicated store: %v", err)
+		}
+		served = replicated
+		logger = replicated.Logger()
+	} else if conf.ReplicaEnabled() {
+		follower = replication.NewFollower(replication.FollowerConfig{
+			Primary:       conf.Replication.Primary,
+			Timeout:       conf.Replication.Timeout,
+			RetryInterval: conf.Replication.RetryInterval,
+		}, memStore)
+		served = replication.NewReadOnlyStore(memStore)
+		logger = follower.Logger()
+	} else {
+		logger, err = newTransactionLogger(conf, memStore)
+		if err != nil {
+			log.Fatalf("cannot create transaction logger: %v", err)
+		}
+	}
+
+	// readiness fails until the log has been replayed, and afterwards if the logger reports a failure
+	readiness := health.New(healthCheckTimeout)
+	replayed := health.NewFlag(errors.New("replaying the transaction log"))
+	readiness.Add("replay", replayed.Check)
+	loggerHealth := health.NewFlag(nil)
+	readiness.Add("transaction_log", loggerHealth.Check)
+	if db, ok := logger.(interface{ Ping(context.Context) error }); ok {
+		readiness.Add("postgres", db.Ping)
+	}
+	if replicated != nil {
+		readiness.Add("raft", replicated.Ready)
+		registerRaftMetrics(registry, replicated)
+	}
+	if follower != nil {
+		readiness.Add("replication", follower.Ready)
+		registerReplicationMetrics(registry, follower)
+	}
+
+	instrumented := api.InstrumentLogger(logger, registry)
+	if conf.ClusterEnabled() {
+		// the keys a node owns are logged by it as they are written, so the APIs are given a logger with nothing to do
+		clustered, err = newPartitionedStore(conf, memStore, instrumented)
+		if err != nil {
+			log.Fatalf("cannot create partitioned store: %v", err)
+		}
+		served = clustered
+		instrumented = clustered.Logger()
+		registerClusterMetrics(registry, clustered)
+	}
+	server := api.New(served, instrumented)
+
+	var repairer *repair.Repairer
+	if conf.RepairEnabled() {
+		repairer = newRepairer(conf, memStore, instrumented)
+		repairer.OnRepair(cache.Evict)
+		registerRepairMetrics(registry, repairer)
+	}
+
+	// watchers are fed every event once the logger has committed it. A commit also clears a failure the logger
+	// reported earlier, e.g. a Postgres insert that failed while the database was briefly unreachable.
+	hub := watch.NewHub(conf.Watch.History)
+	logger.OnCommit(func(e store.Event) {
+		loggerHealth.Set(nil)
+		hub.Publish(e)
+	})
+	registry.NewGaugeFunc("kvs_watchers", "Clients watching for changes.",
+		func() float64 { return float64(hub.Watchers()) })
+
+	throttle := middleware.NewThrottle(conf.Throttle.Max, conf.Throttle.Refill, conf.Throttle.Interval)
+	registerMetrics(registry, cache, throttle)
+
+	var members *gossip.Memberlist
+	if conf.GossipEnabled() {
+		members, err = newMemberlist(conf)
+		if err != nil {
+			log.Fatalf("cannot create memberlist: %v", err)
+		}
+		registerGossipMetrics(registry, members)
+		if clustered != nil {
+			// nodes joining, dying or leaving change the cluster's members, once the log has been replayed
+			clustered.Follow(members)
+		}
+	}
+
+	r := mux.NewRouter()
+	r.Use(middleware.NewMetrics(registry).Instrument)
+
+	// probes and scrapes don't carry a UID so are kept out of the throttle
+	r.Handle("/healthz", health.New(healthCheckTimeout)).Methods("GET")
+	r.Handle("/readyz", readiness).Methods("GET")
+	r.Handle("/metrics", registry).Methods("GET")
+	if members != nil {
+		r.Handle("/v1/_members", members.Handler()).Methods("GET")
+	}
+	// peers repair from each other on their own schedule, so are kept out of the throttle too
+	if repairer != nil {
+		r.PathPrefix("/v1/_repair/").Handler(replayed.Require(repairer.Handler()))
+	}
+
+	// replicas stream from the primary for as long as they run, so are kept out of the throttle too
+	if follower == nil {
+		// the log catches replicas up if it can be read while running, otherwise they are sent a snapshot
+		source, _ := logger.(replication.Log)
+		r.Handle("/v1/_replicate", replayed.Require(
+			replication.NewHandler(hub, memStore, source, conf.Replication.HeartbeatInterval))).Methods("GET")
+	}
+
+	v1 := r.NewRoute().Subrouter()
+	v1.Use(replayed.Require, throttle.Throttle)
+
+	v1.HandleFunc("/", server.IndexHandler)
+	v1.HandleFunc("/v1", server.ListHandler).Methods("GET")
+	v1.HandleFunc("/v1/_batch", server.BatchHandler).Methods("POST")
+	v1.Handle("/v1/_watch", api.NewWatchHandler(hub, conf.Watch.KeepAlive)).Methods("GET")
+	v1.HandleFunc("/v1/{key}", server.PutKeyValueHandler).Methods("PUT")
+	v1.HandleFunc("/v1/{key}", server.GetKeyValueHandler).Methods("GET")
+	v1.HandleFunc("/v1/{key}", server.DeleteKeyValueHandler).Methods("DELETE")
+
+	srv := &http.Server{
+		Addr:    conf.Listener.Addr,
+		Handler: r,
+	}
+	// watch streams never go idle, so they are ended for the server to shut down
+	srv.RegisterOnShutdown(hub.Close)
+
+	var reloader *tlsconfig.Reloader
+	if conf.TLSEnabled() {
+		reloader, err = tlsconfig.NewReloader(conf.TLSConfig())
+		if err != nil {
+			log.Fatalf("cannot configure tls: %v", err)
+		}
+		reloader.Run(bgCtx, conf.TLS.ReloadInterval)
+		srv.TLSConfig = reloader.TLSConfig("h2", "http/1.1")
+	}
+
+	serveErr := make(chan error, 5)
+	go func() {
+		if reloader != nil {
+			// the certificates come from the reloader
+			serveErr <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var grpcServer *grpc.Server
+	if conf.Listener.GRPCAddr != "" {
+		opts := []grpc.ServerOption{
+			grpc.ChainUnaryInterceptor(api.RequireReadyUnary(replayed.Check)),
+			grpc.ChainStreamInterceptor(api.RequireReadyStream(replayed.Check)),
+		}
+		if reloader != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(reloader.TLSConfig("h2"))))
+		}
+
+		grpcServer = grpc.NewServer(opts...)
+		pb.RegisterKeyValueServiceServer(grpcServer, api.NewGRPCServer(served, instrumented))
+
+		listener, err := net.Listen("tcp", conf.Listener.GRPCAddr)
+		if err != nil {
+			log.Fatalf("cannot listen for grpc: %v", err)
+		}
+		go func() {
+			serveErr <- grpcServer.Serve(listener)
+		}()
+	}
+
+	var respServer *resp.Server
+	if conf.Listener.RedisAddr != "" {
+		respServer = resp.NewServer(served, instrumented, replayed.Check)
+
+		listener, err := net.Listen("tcp", conf.Listener.RedisAddr)
+		if err != nil {
+			log.Fatalf("cannot listen for redis: %v", err)
+		}
+		if reloader != nil {
+			listener = tls.NewListener(listener, reloader.TLSConfig())
+		}
+		go func() {
+			serveErr <- respServer.Serve(listener)
+		}()
+	}
+
+	var memcacheServer *memcache.Server
+	if conf.Listener.MemcachedAddr != "" {
+		memcacheServer = memcache.NewServer(served, instrumented, replayed.Check)
+
+		listener, err := net.Listen("tcp", conf.Listener.MemcachedAddr)
+		if err != nil {
+			log.Fatalf("cannot listen for memcached: %v", err)
+		}
+		if reloader != nil {
+			listener = tls.NewListener(listener, reloader.TLSConfig())
+		}
+		go func() {
+			serveErr <- memcacheServer.Serve(listener)
+		}()
+	}
+
+	// the Raft or cluster peers talk to the node on its own listener
+	var peerServer *http.Server
+	if replicated != nil {
+		// peers must reach the node before it runs, to answer its first election
+		peerServer = &http.Server{
+			Addr:    conf.Raft.Addr,
+			Handler: replicated.Handler(),
+		}
+	} else if clustered != nil {
+		// keys moved here can't be applied until the log has been replayed
+		peerServer = &http.Server{
+			Addr:    conf.Cluster.Addr,
+			Handler: replayed.Require(clustered.Handler()),
+		}
+	}
+	if peerServer != nil {
+		go func() {
+			serveErr <- peerServer.ListenAndServe()
+		}()
+	}
+
+	if members != nil {
+		members.Join()
+	}
+
+	// the server is already answering probes while the log is replayed
+	if err := replay(logger, memStore); err != nil {
 		log.Fatalf("cannot load from transaction logger: %v", err)
 	}
-	server := api.New(cache, logger)
+	hub.Start(logger.LastSequence())
+	logger.Run()
+	if clustered != nil {
+		clustered.Run()
+	}
+	if repairer != nil {
+		repairer.Run(bgCtx)
+	}
+	if file, ok := logger.(*store.FileTransactionLogger); ok {
+		// only the file logger keeps snapshots to compact behind
+		file.RunCompaction(bgCtx, conf.Logger.File.CompactionInterval, memStore)
+	}
 
-	throttle := middleware.NewThrottle(20, 1, time.Second)
-	r.Use(throttle.Throttle)
+	go func() {
+		for err := range logger.Err() {
+			log.Printf("transaction logger failed: %v", err)
+			loggerHealth.Set(err)
+		}
+	}()
 
-	r.HandleFunc("/", server.IndexHandler)
-	r.HandleFunc("/v1/{key}", server.PutKeyValueHandler).Methods("PUT")
-	r.HandleFunc("/v1/{key}", server.GetKeyValueHandler).Methods("GET")
-	r.HandleFunc("/v1/{key}", server.DeleteKeyValueHandler).Methods("DELETE")
+	// a replicated store removes expired keys as it applies commands, so every node removes the same ones
+	if replicated == nil {
+		memStore.RunReaper(bgCtx, conf.Store.ReapInterval, func(key string, expires time.Time) {
+			cache.Evict(key)
+			if err := instrumented.WriteExpire(key, expires); err != nil {
+				log.Printf("failed to log expiry of %s: %v", key, err)
+			}
+		})
+	}
 
-	// log.Fatal(http.ListenAndServeTLS(":8080", "localhost.pem", "localhost.key", r)) // not working :(
-	log.Fatal(http.ListenAndServe(":8080", r))
+	replayed.Set(nil)
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		log.Printf("server stopped: %v", err)
+		exitCode = 1
+	case <-ctx.Done():
+		log.Print("shutting down")
+	}
+	stop()
+
+	if members != nil {
+		// the other members hear this node is leaving rather than suspecting it once it stops answering
+		members.Leave()
+	}
+
+	if code := shutdown(srv, grpcServer, respServer, memcacheServer, peerServer, conf.Listener.ShutdownTimeout, cancelBackground, instrumented); code != 0 {
+		exitCode = code
+	}
+
+	os.Exit(exitCode)
+}
+
+// shutdown stops accepting connections, waits for in-flight requests, then drains the transaction logger.
+// The gRPC, Redis, memcached and peer servers are optional, the peer server is closed once the node has left the
+// cluster. It returns the status code the process should exit with.
+func shutdown(srv *http.Server, grpcServer *grpc.Server, respServer *resp.Server, memcacheServer *memcache.Server, peerServer *http.Server, timeout time.Duration, cancelBackground context.CancelFunc, logger api.TransactionLogger) int {
+	exitCode := 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("failed to wait for in-flight requests: %v", err)
+		exitCode = 1
+	}
+
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			log.Print("failed to wait for in-flight grpc calls")
+			grpcServer.Stop()
+			exitCode = 1
+		}
+	}
+
+	if respServer != nil {
+		if err := respServer.Shutdown(ctx); err != nil {
+			log.Printf("failed to wait for in-flight redis commands: %v", err)
+			exitCode = 1
+		}
+	}
+
+	if memcacheServer != nil {
+		if err := memcacheServer.Shutdown(ctx); err != nil {
+			log.Printf("failed to wait for in-flight memcached commands: %v", err)
+			exitCode = 1
+		}
+	}
+
+	cancelBackground()
+
+	if err := logger.Close(); err != nil {
+		log.Printf("failed to close transaction logger: %v", err)
+		exitCode = 1
+	}
+
+	if peerServer != nil {
+		if err := peerServer.Close(); err != nil {
+			log.Printf("failed to close peer server: %v", err)
+			exitCode = 1
+		}
+	}
+
+	return exitCode
+}
+
+// registerMetrics exposes the cache and throttle counters, which are read from them on each scrape.
+func registerMetrics(registry *metrics.Registry, c interface{ Stats() cache.Stats }, throttle *middleware.Throttle) {
+	registry.NewCounterFunc("kvs_cache_hits_total", "Cache lookups served from the cache.",
+		func() float64 { return float64(c.Stats().Hits) })
+	registry.NewCounterFunc("kvs_cache_misses_total", "Cache lookups that fell through to the store.",
+		func() float64 { return float64(c.Stats().Misses) })
+	registry.NewCounterFunc("kvs_cache_evictions_total", "Entries evicted to make room in the cache.",
+		func() float64 { return float64(c.Stats().Evictions) })
+	registry.NewGaugeFunc("kvs_cache_size", "Entries held in the cache.",
+		func() float64 { return float64(c.Stats().Size) })
+	registry.NewGaugeFunc("kvs_cache_capacity", "Entries the cache can hold.",
+		func() float64 { return float64(c.Stats().Capacity) })
+
+	registry.NewCounterFunc("kvs_throttle_allowed_total", "Requests let through by the throttle.",
+		func() float64 { return float64(throttle.Allowed()) })
+	registry.NewCounterFunc("kvs_throttle_rejected_total", "Requests rejected by the throttle.",
+		func() float64 { return float64(throttle.Rejected()) })
+}
+
+// registerRaftMetrics exposes the node's view of the cluster, which is read from it on each scrape.
+func registerRaftMetrics(registry *metrics.Registry, replicated *raftkv.Store) {
+	registry.NewGaugeFunc("kvs_raft_term", "The node's current Raft term.",
+		func() float64 { return float64(replicated.Status().Term) })
+	registry.NewGaugeFunc("kvs_raft_leader", "1 if the node is the Raft leader, otherwise 0.",
+		func() float64 {
+			if replicated.Status().Role == raft.Leader {
+				return 1
+			}
+			return 0
+		})
+	registry.NewGaugeFunc("kvs_raft_commit_index", "The index of the last entry committed by the cluster.",
+		func() float64 { return float64(replicated.Status().CommitIndex) })
+	registry.NewGaugeFunc("kvs_raft_applied_index", "The index of the last entry applied to the node's store.",
+		func() float64 { return float64(replicated.Status().AppliedIndex) })
+}
+
+// registerReplicationMetrics exposes how far the replica is behind its primary, which is read on each scrape.
+func registerReplicationMetrics(registry *metrics.Registry, follower *replication.Follower) {
+	registry.NewGaugeFunc("kvs_replication_lag_seconds", "Seconds since the replica last held every change the primary had.",
+		func() float64 {
+			lag, _ := follower.Lag()
+			return lag.Seconds()
+		})
+	registry.NewGaugeFunc("kvs_replication_lag_sequences", "Changes the primary has that the replica hasn't applied.",
+		func() float64 {
+			_, behind := follower.Lag()
+			return float64(behind)
+		})
+	registry.NewGaugeFunc("kvs_replication_applied_sequence", "The sequence of the last change applied to the replica.",
+		func() float64 { return float64(follower.Applied()) })
+	registry.NewGaugeFunc("kvs_replication_connected", "1 if the replica is streaming from its primary, otherwise 0.",
+		func() float64 {
+			if follower.Connected() {
+				return 1
+			}
+			return 0
+		})
+}
+
+// registerClusterMetrics exposes the cluster's size and the requests and keys sent to other nodes, which are read on
+// each scrape.
+func registerClusterMetrics(registry *metrics.Registry, clustered *clusterkv.Store) {
+	registry.NewGaugeFunc("kvs_cluster_members", "Nodes the keys are partitioned across.",
+		func() float64 { return float64(len(clustered.Members())) })
+	registry.NewCounterFunc("kvs_cluster_forwarded_total", "Requests forwarded to other nodes.",
+		func() float64 { return float64(clustered.Forwarded()) })
+	registry.NewCounterFunc("kvs_cluster_transferred_keys_total", "Keys moved to their new owner after a membership change.",
+		func() float64 { return float64(clustered.Transferred()) })
+}
+
+// registerGossipMetrics counts the members in each state, updated as their states change. It must be called before
+// the memberlist joins.
+func registerGossipMetrics(registry *metrics.Registry, members *gossip.Memberlist) {
+	gauge := registry.NewGauge("kvs_gossip_members", "Cluster members known to this node by state.", "state")
+	count := func() {
+		counts := make(map[gossip.State]int)
+		for _, member := range members.Members() {
+			counts[member.State]++
+		}
+		for _, state := range []gossip.State{gossip.Alive, gossip.Suspect, gossip.Dead, gossip.Left} {
+			gauge.Set(float64(counts[state]), state.String())
+		}
+	}
+
+	count()
+	members.OnChange(func(gossip.Member) { count() })
+}
+
+// registerRepairMetrics reports the repairer's progress, read on each scrape.
+func registerRepairMetrics(registry *metrics.Registry, repairer *repair.Repairer) {
+	registry.NewCounterFunc("kvs_repair_keys_total", "Keys changed by repairs from peers.",
+		func() float64 { return float64(repairer.Repaired()) })
+	registry.NewCounterFunc("kvs_repair_failures_total", "Repairs from peers that failed.",
+		func() float64 { return float64(repairer.Failures()) })
+}
+
+// newRepairer creates a repairer comparing memStore with the configured peers, logging the keys it changes to
+// logger.
+func newRepairer(conf config.Config, memStore *store.Store, logger api.TransactionLogger) *repair.Repairer {
+	return repair.New(repair.Config{
+		Peers:        conf.Repair.Peers,
+		Interval:     conf.Repair.Interval,
+		Depth:        conf.Repair.Depth,
+		TombstoneTTL: conf.Repair.TombstoneTTL,
+		Timeout:      conf.Repair.Timeout,
+	}, memStore, logger)
+}
+
+// newMemberlist listens for gossip, advertising the configured tags.
+func newMemberlist(conf config.Config) (*gossip.Memberlist, error) {
+	tags, err := conf.GossipTags()
+	if err != nil {
+		return nil, err
+	}
+
+	return gossip.New(gossip.Config{
+		Name:             conf.Gossip.NodeName,
+		Addr:             conf.Gossip.Addr,
+		AdvertiseAddr:    conf.GossipAdvertiseAddr(),
+		Seeds:            conf.Gossip.Seeds,
+		Tags:             tags,
+		ProbeInterval:    conf.Gossip.ProbeInterval,
+		ProbeTimeout:     conf.Gossip.ProbeTimeout,
+		IndirectProbes:   conf.Gossip.IndirectProbes,
+		SuspicionTimeout: conf.Gossip.SuspicionTimeout,
+		SyncInterval:     conf.Gossip.SyncInterval,
+	})
+}
+
+// newPartitionedStore creates a store partitioning the keys across the configured cluster, logging the writes to
+// the keys this node owns to logger.
+func newPartitionedStore(conf config.Config, memStore *store.Store, logger api.TransactionLogger) (*clusterkv.Store, error) {
+	peers, err := conf.ClusterPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	return clusterkv.New(clusterkv.Config{
+		ID:             conf.Cluster.NodeID,
+		Peers:          peers,
+		VirtualNodes:   conf.Cluster.VirtualNodes,
+		ForwardTimeout: conf.Cluster.ForwardTimeout,
+	}, memStore, logger)
 }
 
-func initTransactionLogger(cacheStore cache.Store) (api.TransactionLogger, error) {
-	// logger, err := store.NewPostgresTransactionLogger(store.PostgresConfig{DBName: "testdb", Host: "localhost", Port: "5432", User: "postgres", Password: "password"})
-	logger, err := store.NewFileTransactionLogger("transaction.log")
+// newReplicatedStore creates a store replicating writes through the configured Raft cluster, its log and vote kept
+// in the Raft dir.
+func newReplicatedStore(conf config.Config, memStore *store.Store) (*raftkv.Store, error) {
+	peers, err := conf.RaftPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := raft.NewFileStorage(conf.Raft.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft storage: %w", err)
+	}
+
+	return raftkv.New(raftkv.Config{
+		ID:                conf.Raft.NodeID,
+		Peers:             peers,
+		ElectionTimeout:   conf.Raft.ElectionTimeout,
+		HeartbeatInterval: conf.Raft.HeartbeatInterval,
+		CommitTimeout:     conf.Raft.CommitTimeout,
+		ReapInterval:      conf.Store.ReapInterval,
+		Storage:           storage,
+	}, memStore)
+}
+
+// newTransactionLogger creates the configured logger, restoring the file logger's latest snapshot into the store.
+func newTransactionLogger(conf config.Config, memStore *store.Store) (api.TransactionLogger, error) {
+	if conf.Logger.Backend == config.BackendPostgres {
+		logger, err := store.NewPostgresTransactionLogger(conf.PostgresConfig())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create event logger: %w", err)
+		}
+		return logger, nil
+	}
+
+	logger, err := store.NewFileTransactionLogger(conf.FileConfig())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create event logger: %w", err)
 	}
 
+	if err := logger.LoadSnapshot(memStore); err != nil {
+		return nil, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+
+	return logger, nil
+}
+
+// replay applies every logged event to the store, it must finish before the logger is run.
+func replay(logger api.TransactionLogger, memStore *store.Store) error {
 	events, errors := logger.ReadEvents()
 	e, ok := store.Event{}, true
 
+	var err error
 	for ok && err == nil {
 		select {
 		case err, ok = <-errors:
 		case e, ok = <-events:
-			switch e.EventType {
-			case store.EventDelete:
-				err = cacheStore.Delete(e.Key)
-			case store.EventPut:
-				err = cacheStore.Put(e.Key, string(e.Value))
+			if ok {
+				err = memStore.Apply(e)
 			}
 		}
 	}
 
-	logger.Run()
-
-	return logger, err
+	return err
 }